@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
+)
+
+// aiProvider é o modelo de linguagem usado para ler fotos de notas e categorizar itens.
+var aiProvider llm.AIProvider
+
+// InitAIProvider configura o provedor de IA a partir das variáveis de ambiente.
+// AI_PROVIDER=gemini (padrão) usa GEMINI_API_KEY e GEMINI_MODEL; openai usa qualquer API
+// compatível com a da OpenAI (OPENAI_BASE_URL, OPENAI_API_KEY, OPENAI_MODEL); ollama usa um
+// servidor local (OLLAMA_BASE_URL, OLLAMA_MODEL); fake responde sempre AI_FAKE_RESPONSE, sem rede.
+func InitAIProvider() error {
+	logger := GetLogger("ai")
+
+	switch name := strings.ToLower(os.Getenv("AI_PROVIDER")); name {
+	case "", "gemini":
+		aiProvider = llm.NewGeminiProvider(os.Getenv("GEMINI_API_KEY"), os.Getenv("GEMINI_MODEL"), os.Getenv("GEMINI_BASE_URL"))
+		if os.Getenv("GEMINI_API_KEY") == "" {
+			logger.WarnF("⚠️  GEMINI_API_KEY não configurada: as chamadas de IA vão falhar")
+		}
+	case "openai":
+		if os.Getenv("OPENAI_MODEL") == "" {
+			return fmt.Errorf("OPENAI_MODEL is required when AI_PROVIDER=openai")
+		}
+		aiProvider = llm.NewOpenAIProvider(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_MODEL"))
+	case "ollama":
+		if os.Getenv("OLLAMA_MODEL") == "" {
+			return fmt.Errorf("OLLAMA_MODEL is required when AI_PROVIDER=ollama")
+		}
+		aiProvider = llm.NewOllamaProvider(os.Getenv("OLLAMA_BASE_URL"), os.Getenv("OLLAMA_MODEL"))
+	case "fake":
+		aiProvider = llm.NewFake(os.Getenv("AI_FAKE_RESPONSE"))
+	default:
+		return fmt.Errorf("unknown AI_PROVIDER %q (use gemini, openai, ollama or fake)", name)
+	}
+
+	logger.InfoF("🤖 AI provider: %s (model %s)", aiProvider.Name(), aiProvider.Model())
+	return nil
+}
+
+// GetAIProvider retorna o provedor de IA configurado por InitAIProvider.
+func GetAIProvider() llm.AIProvider {
+	return aiProvider
+}
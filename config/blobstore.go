@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/storage"
+)
+
+// blobStore guarda as fotos das notas e as miniaturas fora do banco de dados.
+var blobStore storage.BlobStore
+
+// InitBlobStore configura o armazenamento de arquivos a partir das variáveis de ambiente.
+// BLOB_STORE=local (padrão) grava em BLOB_LOCAL_DIR; BLOB_STORE=s3 usa um bucket compatível
+// com S3 (AWS, MinIO...) configurado em S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY,
+// S3_SECRET_KEY e S3_PATH_STYLE.
+func InitBlobStore() error {
+	logger := GetLogger("storage")
+
+	switch backend := strings.ToLower(os.Getenv("BLOB_STORE")); backend {
+	case "", "local":
+		dir := os.Getenv("BLOB_LOCAL_DIR")
+		if dir == "" {
+			dir = "./data/blobs"
+		}
+		store, err := storage.NewLocalStore(dir)
+		if err != nil {
+			return err
+		}
+		blobStore = store
+		logger.InfoF("🗂️  Blob store: local (%s)", dir)
+	case "s3":
+		store, err := storage.NewS3Store(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") != "false",
+		})
+		if err != nil {
+			return err
+		}
+		blobStore = store
+		logger.InfoF("🗂️  Blob store: S3 (%s, bucket %s)", os.Getenv("S3_ENDPOINT"), os.Getenv("S3_BUCKET"))
+	default:
+		return fmt.Errorf("unknown BLOB_STORE %q (use local or s3)", backend)
+	}
+	return nil
+}
+
+// GetBlobStore retorna o armazenamento de arquivos configurado por InitBlobStore.
+func GetBlobStore() storage.BlobStore {
+	return blobStore
+}
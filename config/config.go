@@ -24,12 +24,21 @@ var (
 func Init() error {
 	var err error
 
+	// O armazenamento de arquivos vem antes do banco: a migração das imagens em base64 usa ele
+	if err := InitBlobStore(); err != nil {
+		return fmt.Errorf("error initializing blob store: %v", err)
+	}
+
 	//initialize PostgreSQL
 	db, err = InitializePostgreSQL()
 	if err != nil {
 		return fmt.Errorf("erro initializing postgresql %v: ", err)
 	}
 
+	if err := InitAIProvider(); err != nil {
+		return fmt.Errorf("error initializing AI provider: %v", err)
+	}
+
 	// Inicializar AI Worker Pool
 	maxWorkers := getEnvAsInt("MAX_AI_WORKERS", 3) // 3 workers para Gemini 2.5 Flash Free (10 RPM)
 	queueSize := getEnvAsInt("AI_QUEUE_SIZE", 50)  // Fila de 50 jobs
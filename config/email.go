@@ -893,3 +893,147 @@ func (e *EmailService) SendEmailChangeConfirmation(toEmail, userName, confirmati
 
 	return e.sendEmail(toEmail, subject, body.String())
 }
+
+// SendPendingReceiptResultEmail avisa o usuário do resultado de uma nota que estava na fila de
+// nova consulta (portal fora do ar ou nota em contingência ainda não autorizada).
+// imported indica se a nota foi importada; detail traz o motivo quando não foi.
+func (e *EmailService) SendPendingReceiptResultEmail(toEmail, userName, storeName, accessKey string, imported bool, detail string) error {
+	subject := "✅ Sua nota fiscal pendente foi importada"
+	title := "Nota Importada"
+	if !imported {
+		subject = "⚠️ Não foi possível importar sua nota fiscal pendente"
+		title = "Nota Não Importada"
+	}
+
+	htmlTemplate := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif;
+            line-height: 1.6;
+            color: #2d3748;
+            max-width: 600px;
+            margin: 0 auto;
+            padding: 0;
+            background-color: #f7fafc;
+        }
+        .container {
+            background-color: #ffffff;
+            margin: 20px;
+            border-radius: 12px;
+            overflow: hidden;
+            box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1);
+        }
+        .header {
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            color: white;
+            padding: 40px 30px;
+            text-align: center;
+        }
+        .header h1 {
+            margin: 0;
+            font-size: 28px;
+            font-weight: 600;
+        }
+        .content {
+            padding: 40px 30px;
+            background-color: #ffffff;
+        }
+        .greeting {
+            font-size: 18px;
+            margin-bottom: 20px;
+            color: #1a202c;
+        }
+        .key-box {
+            background: linear-gradient(135deg, #f6f8fb 0%, #edf2f7 100%);
+            border: 2px solid #667eea;
+            padding: 20px;
+            text-align: center;
+            margin: 30px 0;
+            border-radius: 10px;
+            font-family: 'Courier New', monospace;
+            font-size: 14px;
+            word-break: break-all;
+        }
+        .info-box {
+            background-color: #fef5e7;
+            border-left: 4px solid #f39c12;
+            padding: 15px 20px;
+            margin: 20px 0;
+            border-radius: 4px;
+        }
+        .info-box p {
+            margin: 0;
+            color: #856404;
+            font-size: 14px;
+        }
+        .footer {
+            background-color: #edf2f7;
+            padding: 25px 30px;
+            text-align: center;
+            font-size: 13px;
+            color: #718096;
+        }
+        .footer p {
+            margin: 5px 0;
+        }
+        strong {
+            color: #667eea;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>{{.Title}}</h1>
+        </div>
+        <div class="content">
+            <p class="greeting">Olá, <strong>{{.UserName}}</strong>!</p>
+            {{if .Imported}}
+            <p>A nota fiscal que estava aguardando o portal da SEFAZ{{if .StoreName}} (<strong>{{.StoreName}}</strong>){{end}} foi importada e já está na sua conta.</p>
+            {{else}}
+            <p>Não conseguimos importar a nota fiscal que estava aguardando o portal da SEFAZ.</p>
+            {{if .Detail}}
+            <div class="info-box">
+                <p><strong>Motivo:</strong> {{.Detail}}</p>
+            </div>
+            {{end}}
+            <p>Você pode tentar escanear o QR Code novamente ou cadastrar a nota manualmente.</p>
+            {{end}}
+            {{if .AccessKey}}
+            <div class="key-box">{{.AccessKey}}</div>
+            {{end}}
+        </div>
+        <div class="footer">
+            <p>Este é um email automático, por favor não responda.</p>
+            <p>&copy; 2025 Sistema de Notas Fiscais. Todos os direitos reservados.</p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	tmpl, err := template.New("pendingReceiptResult").Parse(htmlTemplate)
+	if err != nil {
+		return fmt.Errorf("erro ao processar template: %v", err)
+	}
+
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, map[string]interface{}{
+		"Title":     title,
+		"UserName":  userName,
+		"StoreName": storeName,
+		"AccessKey": accessKey,
+		"Imported":  imported,
+		"Detail":    detail,
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao executar template: %v", err)
+	}
+
+	return e.sendEmail(toEmail, subject, body.String())
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"gorm.io/gorm"
+)
+
+// loadExchangeRatesFile carrega as cotações globais do CSV em EXCHANGE_RATES_FILE (date,from,to,rate).
+// O arquivo é a fonte das cotações globais: elas são substituídas a cada inicialização.
+// Sem a variável, as cotações globais já gravadas são mantidas.
+func loadExchangeRatesFile(db *gorm.DB) error {
+	path := strings.TrimSpace(os.Getenv("EXCHANGE_RATES_FILE"))
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rates, err := currency.ParseCSV(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	rows := make([]schemas.ExchangeRate, 0, len(rates))
+	seen := make(map[string]int, len(rates))
+	for _, rate := range rates {
+		row := schemas.ExchangeRate{
+			Date:         rate.Date,
+			FromCurrency: rate.From,
+			ToCurrency:   rate.To,
+			Rate:         rate.Value,
+			Source:       schemas.ExchangeRateSourceFile,
+		}
+		// Linhas repetidas no arquivo: vale a última
+		key := fmt.Sprintf("%s/%s/%s", rate.Date.Format("2006-01-02"), rate.From, rate.To)
+		if i, ok := seen[key]; ok {
+			rows[i] = row
+			continue
+		}
+		seen[key] = len(rows)
+		rows = append(rows, row)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = 0").Delete(&schemas.ExchangeRate{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, 500).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	GetLogger("migrations").InfoF("💱 %d cotações de câmbio carregadas de %s", len(rows), path)
+	return nil
+}
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/Pmmvito/Golang-Api-Exemple/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// legacyReceiptNotesRegex reconhece o texto gravado em Notes antes das colunas fiscais existirem:
+// "NFC-e #<número> - Chave: <chave>".
+var legacyReceiptNotesRegex = regexp.MustCompile(`^NFC-e #(\S*) - Chave: ([\d ]+)$`)
+
+// backfillReceiptFiscalData preenche as colunas fiscais dos recibos antigos a partir de Notes
+// e move a URL do QR Code, que era gravada em ImageBase64, para a coluna QRCodeURL.
+// É idempotente: só toca recibos que ainda não têm chave de acesso ou o cNF dela.
+func backfillReceiptFiscalData(db *gorm.DB) error {
+	logger := GetLogger("migrations")
+
+	// 1. URL do QR Code gravada em image_base64 (coluna removida por migrateReceiptImagesToBlobStore)
+	if db.Migrator().HasColumn("receipts", "image_base64") {
+		moved := db.Table("receipts").
+			Where("(qr_code_url IS NULL OR qr_code_url = '') AND image_base64 LIKE ?", "http%").
+			UpdateColumns(map[string]interface{}{
+				"qr_code_url":  gorm.Expr("image_base64"),
+				"image_base64": "",
+			})
+		if moved.Error != nil {
+			return moved.Error
+		}
+		if moved.RowsAffected > 0 {
+			logger.InfoF("🔗 %d recibos tiveram a URL do QR Code movida para qr_code_url", moved.RowsAffected)
+		}
+	}
+
+	// 2. Chave de acesso gravada em notes
+	var receipts []schemas.Receipt
+	filled, invalid, duplicated := 0, 0, 0
+	result := db.Unscoped().
+		Select("id", "user_id", "notes", "deleted_at").
+		Where("(access_key IS NULL OR access_key = '') AND notes LIKE ?", "NFC-e #% - Chave: %").
+		FindInBatches(&receipts, 200, func(_ *gorm.DB, batch int) error {
+			for _, receipt := range receipts {
+				matches := legacyReceiptNotesRegex.FindStringSubmatch(strings.TrimSpace(receipt.Notes))
+				if len(matches) < 3 {
+					continue
+				}
+
+				key, err := nfce.ParseAccessKey(matches[2])
+				if err != nil {
+					// Mantém o texto em Notes para não perder a informação original
+					logger.WarnF("Recibo %d: chave de acesso legada inválida: %v", receipt.ID, err)
+					invalid++
+					continue
+				}
+
+				// Notas duplicadas (confirmadas duas vezes) violariam o índice único por usuário
+				if !receipt.DeletedAt.Valid {
+					var count int64
+					if err := db.Model(&schemas.Receipt{}).Where("user_id = ? AND access_key = ?", receipt.UserID, key.Key).Count(&count).Error; err != nil {
+						return err
+					}
+					if count > 0 {
+						logger.WarnF("Recibo %d: chave %s já pertence a outro recibo do usuário %d, mantida em notes", receipt.ID, key.Key, receipt.UserID)
+						duplicated++
+						continue
+					}
+				}
+
+				receipt.ApplyAccessKey(key)
+				if err := db.Model(&schemas.Receipt{}).Unscoped().Where("id = ?", receipt.ID).UpdateColumns(map[string]interface{}{
+					"access_key":     receipt.AccessKey,
+					"fiscal_model":   receipt.FiscalModel,
+					"fiscal_series":  receipt.FiscalSeries,
+					"fiscal_number":  receipt.FiscalNumber,
+					"issuer_cnpj":    receipt.IssuerCNPJ,
+					"issuer_uf":      receipt.IssuerUF,
+					"emission_type":  receipt.EmissionType,
+					"fiscal_code":    receipt.FiscalCode,
+					"emission_year":  receipt.EmissionYear,
+					"emission_month": receipt.EmissionMonth,
+					"notes":          "",
+				}).Error; err != nil {
+					return err
+				}
+				filled++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if filled > 0 || invalid > 0 || duplicated > 0 {
+		logger.InfoF("🧾 Backfill fiscal: %d recibos preenchidos, %d com chave inválida e %d duplicados mantidos em notes", filled, invalid, duplicated)
+	}
+
+	// 3. Código numérico (cNF) e ano/mês de emissão dos recibos gravados antes dessas colunas.
+	// A chave já foi validada ao ser gravada: cNF fica nas posições 36-43 e AAMM nas posições 3-6.
+	codes := db.Table("receipts").
+		Where("access_key <> '' AND (fiscal_code IS NULL OR fiscal_code = '')").
+		UpdateColumns(map[string]interface{}{
+			"fiscal_code":    gorm.Expr("SUBSTRING(access_key FROM 36 FOR 8)"),
+			"emission_year":  gorm.Expr("2000 + CAST(SUBSTRING(access_key FROM 3 FOR 2) AS INTEGER)"),
+			"emission_month": gorm.Expr("CAST(SUBSTRING(access_key FROM 5 FOR 2) AS INTEGER)"),
+		})
+	if codes.Error != nil {
+		return codes.Error
+	}
+	if codes.RowsAffected > 0 {
+		logger.InfoF("🧾 %d recibos tiveram cNF e ano/mês de emissão lidos da chave de acesso", codes.RowsAffected)
+	}
+	return nil
+}
+
+// legacyReceiptImage é uma linha de receipts com a coluna legada image_base64.
+type legacyReceiptImage struct {
+	ID          uint
+	UserID      uint
+	ImageBase64 string
+}
+
+// decodeLegacyImage decodifica uma imagem gravada em base64, com ou sem o prefixo "data:<tipo>;base64,".
+func decodeLegacyImage(value string) ([]byte, string, error) {
+	value = strings.TrimSpace(value)
+	contentType := ""
+	if strings.HasPrefix(value, "data:") {
+		header, data, found := strings.Cut(value, ",")
+		if !found {
+			return nil, "", fmt.Errorf("invalid data URI")
+		}
+		contentType = strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+		value = data
+	}
+
+	content, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if content, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+			return nil, "", err
+		}
+	}
+	if detected := http.DetectContentType(content); detected != "application/octet-stream" || contentType == "" {
+		contentType = detected
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("content is not an image (%s)", contentType)
+	}
+	return content, contentType, nil
+}
+
+// migrateReceiptImagesToBlobStore copia as imagens em base64 da coluna legada receipts.image_base64
+// para o BlobStore (com miniatura), registrando-as em receipt_images. É idempotente: só copia recibos
+// que ainda não têm foto em receipt_images. A coluna e seu conteúdo são mantidos; ela só é removida
+// por dropLegacyReceiptImageColumn quando RECEIPT_IMAGES_DROP_LEGACY_COLUMN=true.
+func migrateReceiptImagesToBlobStore(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("receipts", "image_base64") {
+		return nil
+	}
+	logger := GetLogger("migrations")
+	store := GetBlobStore()
+	if store == nil {
+		return fmt.Errorf("blob store not initialized")
+	}
+
+	ctx := context.Background()
+	var lastID uint
+	copied, invalid := 0, 0
+	for {
+		var rows []legacyReceiptImage
+		if err := db.Table("receipts").Select("id", "user_id", "image_base64").
+			Where("id > ? AND image_base64 IS NOT NULL AND image_base64 <> '' AND image_base64 NOT LIKE ?", lastID, "http%").
+			Where("NOT EXISTS (SELECT 1 FROM receipt_images ri WHERE ri.receipt_id = receipts.id AND ri.deleted_at IS NULL)").
+			Order("id").Limit(50).Scan(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+			content, contentType, err := decodeLegacyImage(row.ImageBase64)
+			if err != nil {
+				logger.WarnF("Recibo %d: image_base64 não é uma imagem válida, mantida na coluna: %v", row.ID, err)
+				invalid++
+				continue
+			}
+
+			stored, err := storage.PutImage(ctx, store, fmt.Sprintf("receipts/%d", row.UserID), content, contentType)
+			if err != nil {
+				return fmt.Errorf("error storing image of receipt %d: %w", row.ID, err)
+			}
+			receiptID := row.ID
+			if err := db.Create(&schemas.ReceiptImage{
+				UserID:       row.UserID,
+				ReceiptID:    &receiptID,
+				StorageKey:   stored.Key,
+				ContentType:  stored.ContentType,
+				Size:         stored.Size,
+				Width:        stored.Width,
+				Height:       stored.Height,
+				ThumbnailKey: stored.ThumbnailKey,
+			}).Error; err != nil {
+				return fmt.Errorf("error saving image of receipt %d: %w", row.ID, err)
+			}
+			copied++
+		}
+	}
+
+	if copied > 0 || invalid > 0 {
+		logger.InfoF("🖼️  %d imagens de recibos copiadas para o armazenamento de arquivos (%d inválidas ignoradas)", copied, invalid)
+	}
+
+	if os.Getenv("RECEIPT_IMAGES_DROP_LEGACY_COLUMN") != "true" {
+		return nil
+	}
+	return dropLegacyReceiptImageColumn(db, store)
+}
+
+// dropLegacyReceiptImageColumn remove a coluna receipts.image_base64 depois de conferir, com Get,
+// que cada imagem dela foi copiada para o BlobStore com o mesmo conteúdo. Se alguma não confere
+// (valor inválido, cópia ausente ou diferente), a coluna é mantida e o motivo vai para o log.
+func dropLegacyReceiptImageColumn(db *gorm.DB, store storage.BlobStore) error {
+	logger := GetLogger("migrations")
+	ctx := context.Background()
+
+	var lastID uint
+	pending := 0
+	for {
+		var rows []legacyReceiptImage
+		if err := db.Table("receipts").Select("id", "user_id", "image_base64").
+			Where("id > ? AND image_base64 IS NOT NULL AND image_base64 <> '' AND image_base64 NOT LIKE ?", lastID, "http%").
+			Order("id").Limit(50).Scan(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+			if err := verifyLegacyImageCopy(ctx, db, store, row); err != nil {
+				logger.WarnF("Recibo %d: imagem legada não conferida no armazenamento de arquivos: %v", row.ID, err)
+				pending++
+			}
+		}
+	}
+
+	if pending > 0 {
+		logger.WarnF("⚠️  Coluna receipts.image_base64 mantida: %d imagens não conferidas no armazenamento de arquivos", pending)
+		return nil
+	}
+
+	// URLs que sobraram já estão em qr_code_url (passo 1 de backfillReceiptFiscalData)
+	if err := db.Migrator().DropColumn("receipts", "image_base64"); err != nil {
+		return err
+	}
+	logger.Info("🗑️  Coluna receipts.image_base64 removida")
+	return nil
+}
+
+// verifyLegacyImageCopy lê do BlobStore a foto registrada para o recibo e compara com a imagem legada.
+func verifyLegacyImageCopy(ctx context.Context, db *gorm.DB, store storage.BlobStore, row legacyReceiptImage) error {
+	content, _, err := decodeLegacyImage(row.ImageBase64)
+	if err != nil {
+		return err
+	}
+
+	var image schemas.ReceiptImage
+	if err := db.Where("receipt_id = ?", row.ID).Order("position, id").First(&image).Error; err != nil {
+		return fmt.Errorf("no receipt image: %w", err)
+	}
+
+	reader, _, err := store.Get(ctx, image.StorageKey)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", image.StorageKey, err)
+	}
+	defer reader.Close()
+	stored, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", image.StorageKey, err)
+	}
+	if !bytes.Equal(stored, content) {
+		return fmt.Errorf("content of %s differs from image_base64", image.StorageKey)
+	}
+	return nil
+}
+
+// backfillReceiptStores cria as lojas (Store) a partir do CNPJ do emitente dos recibos
+// que ainda não estão vinculados e preenche receipts.store_id. Usa o nome gravado no
+// recibo mais recente como razão social; o endereço é completado nas próximas importações.
+func backfillReceiptStores(db *gorm.DB) error {
+	logger := GetLogger("migrations")
+
+	var cnpjs []string
+	if err := db.Model(&schemas.Receipt{}).Unscoped().
+		Where("store_id IS NULL AND issuer_cnpj IS NOT NULL AND issuer_cnpj <> ''").
+		Distinct().Pluck("issuer_cnpj", &cnpjs).Error; err != nil {
+		return err
+	}
+
+	linked := int64(0)
+	for _, cnpj := range cnpjs {
+		var latest schemas.Receipt
+		if err := db.Unscoped().Select("store_name").Where("issuer_cnpj = ?", cnpj).Order("date DESC, id DESC").First(&latest).Error; err != nil {
+			return err
+		}
+
+		store := schemas.Store{CNPJ: cnpj, LegalName: latest.StoreName}
+		if err := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "cnpj"}}, DoNothing: true}).Create(&store).Error; err != nil {
+			return err
+		}
+		if store.ID == 0 {
+			if err := db.Where("cnpj = ?", cnpj).First(&store).Error; err != nil {
+				return err
+			}
+		}
+
+		result := db.Model(&schemas.Receipt{}).Unscoped().
+			Where("store_id IS NULL AND issuer_cnpj = ?", cnpj).
+			UpdateColumn("store_id", store.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		linked += result.RowsAffected
+	}
+
+	if linked > 0 {
+		logger.InfoF("🏪 Backfill de lojas: %d recibos vinculados a %d lojas", linked, len(cnpjs))
+	}
+	return nil
+}
+
+// backfillReceiptItemDiscounts preenche o desconto e o valor líquido dos itens gravados antes
+// dessas colunas existirem, rateando o desconto da nota proporcionalmente ao total dos itens.
+// É idempotente: só toca itens com net_total ainda nulo.
+func backfillReceiptItemDiscounts(db *gorm.DB) error {
+	logger := GetLogger("migrations")
+
+	var receiptIDs []uint
+	if err := db.Model(&schemas.ReceiptItem{}).Where("net_total IS NULL").
+		Distinct().Pluck("receipt_id", &receiptIDs).Error; err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, receiptID := range receiptIDs {
+		var receipt schemas.Receipt
+		if err := db.Unscoped().Select("id", "discount").First(&receipt, receiptID).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		var items []schemas.ReceiptItem
+		if err := db.Where("receipt_id = ?", receiptID).Order("id").Find(&items).Error; err != nil {
+			return err
+		}
+
+		schemas.AllocateItemDiscounts(items, receipt.Discount)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, item := range items {
+				if err := tx.Model(&schemas.ReceiptItem{}).Where("id = ?", item.ID).UpdateColumns(map[string]interface{}{
+					"discount":  item.Discount,
+					"net_total": item.NetTotal,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		updated += len(items)
+	}
+
+	// Itens excluídos ficam sem rateio
+	if err := db.Unscoped().Model(&schemas.ReceiptItem{}).Where("net_total IS NULL").
+		UpdateColumn("net_total", gorm.Expr("total - discount")).Error; err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		logger.InfoF("🏷️  Backfill de descontos: %d itens de %d recibos atualizados", updated, len(receiptIDs))
+	}
+	return nil
+}
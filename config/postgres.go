@@ -36,16 +36,48 @@ func InitializePostgreSQL() (*gorm.DB, error) {
 		&schemas.PasswordReset{},  // 5. Tokens de recuperação de senha (depende de User)
 		&schemas.Category{},       // 6. Categorias (independente)
 		&schemas.Product{},        // 7. Produtos (depende de Category)
-		&schemas.Receipt{},        // 8. Notas fiscais (depende de User)
-		&schemas.ReceiptItem{},    // 9. Itens de nota (depende de Receipt e Product)
-		&schemas.ShoppingList{},   // 10. Listas de compras (depende de User)
-		&schemas.ListItem{},       // 11. Itens de lista (depende de ShoppingList e Product)
+		&schemas.Store{},          // 8. Lojas por CNPJ (independente)
+		&schemas.Receipt{},        // 9. Notas fiscais (depende de User e Store)
+		&schemas.ReceiptItem{},    // 10. Itens de nota (depende de Receipt e Product)
+		&schemas.ShoppingList{},   // 11. Listas de compras (depende de User)
+		&schemas.ListItem{},       // 12. Itens de lista (depende de ShoppingList e Product)
+		&schemas.ImportJob{},      // 13. Jobs de importação de notas (depende de User e Receipt)
+		&schemas.ReceiptDraft{},   // 14. Rascunhos de preview (depende de User)
+		&schemas.ReceiptPayment{}, // 15. Formas de pagamento das notas (depende de Receipt)
+		&schemas.ProductCode{},    // 16. Códigos internos de produto por loja (depende de Product e Store)
+		&schemas.ImportBatch{},    // 17. Lotes de importação de QR Codes (depende de User)
+		&schemas.PendingScan{},    // 18. Notas aguardando o portal da SEFAZ voltar (depende de User)
+		&schemas.ReceiptImage{},   // 19. Fotos das notas no BlobStore (depende de User e Receipt)
+		&schemas.ExchangeRate{},   // 20. Cotações de câmbio (globais ou por usuário)
+		&schemas.CategoryRule{},   // 21. Regras de categorização do usuário (depende de User e Category)
 	)
 	if err != nil {
 		logger.ErrorF("Erro na automigração do PostgreSQL: %v", err)
 		return nil, err
 	}
 
+	// Migrações de dados (idempotentes)
+	if err := backfillReceiptFiscalData(db); err != nil {
+		logger.ErrorF("Erro no backfill dos dados fiscais dos recibos: %v", err)
+		return nil, err
+	}
+	if err := migrateReceiptImagesToBlobStore(db); err != nil {
+		logger.ErrorF("Erro ao mover as imagens dos recibos para o armazenamento de arquivos: %v", err)
+		return nil, err
+	}
+	if err := backfillReceiptStores(db); err != nil {
+		logger.ErrorF("Erro no backfill das lojas dos recibos: %v", err)
+		return nil, err
+	}
+	if err := backfillReceiptItemDiscounts(db); err != nil {
+		logger.ErrorF("Erro no backfill dos descontos dos itens: %v", err)
+		return nil, err
+	}
+	if err := loadExchangeRatesFile(db); err != nil {
+		logger.ErrorF("Erro ao carregar o arquivo de cotações de câmbio: %v", err)
+		return nil, err
+	}
+
 	// Não cria mais categorias padrão globais aqui
 	// As categorias serão criadas individualmente para cada usuário no registro
 
@@ -0,0 +1,73 @@
+package currency
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseCSV lê cotações no formato "date,from,to,rate" (ex: 2024-07-01,USD,BRL,5.43).
+// Aceita ";" como separador (planilhas em pt-BR, com vírgula decimal), datas em YYYY-MM-DD ou
+// DD/MM/YYYY e uma linha de cabeçalho opcional. Linhas em branco são ignoradas.
+func ParseCSV(r io.Reader) ([]Rate, error) {
+	reader := bufio.NewReader(r)
+	first, err := reader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+
+	parser := csv.NewReader(reader)
+	if line, _, _ := strings.Cut(string(first), "\n"); strings.Contains(line, ";") {
+		parser.Comma = ';'
+	}
+	parser.FieldsPerRecord = -1
+	parser.TrimLeadingSpace = true
+
+	var rates []Rate
+	for line := 1; ; line++ {
+		record, err := parser.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("linha %d: %w", line, err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) != 4 {
+			return nil, fmt.Errorf("linha %d: esperadas 4 colunas (date, from, to, rate), encontradas %d", line, len(record))
+		}
+
+		date, err := ParseDate(record[0])
+		if err != nil {
+			// A primeira linha pode ser o cabeçalho
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("linha %d: %w", line, err)
+		}
+		from, err := Normalize(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("linha %d: %w", line, err)
+		}
+		to, err := Normalize(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("linha %d: %w", line, err)
+		}
+		if from == to {
+			return nil, fmt.Errorf("linha %d: moedas de origem e destino iguais (%s)", line, from)
+		}
+		value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(record[3]), ",", "."), 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("linha %d: cotação inválida %q", line, record[3])
+		}
+
+		rates = append(rates, Rate{Date: date, From: from, To: to, Value: value})
+	}
+	return rates, nil
+}
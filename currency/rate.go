@@ -0,0 +1,138 @@
+package currency
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rate diz quanto 1 unidade de From vale em To na data informada (ex: 1 USD = 5.43 BRL).
+type Rate struct {
+	Date  time.Time
+	From  string
+	To    string
+	Value float64
+}
+
+// Table indexa cotações por par de moedas para consulta pela data da nota.
+type Table struct {
+	pairs      map[string][]Rate // "FROM/TO" -> cotações em ordem crescente de data
+	currencies map[string]bool
+}
+
+// NewTable monta a tabela a partir das cotações. Quando há mais de uma cotação para o mesmo
+// par e data, vale a última da lista (permite que cotações do usuário sobrescrevam as globais).
+func NewTable(rates []Rate) *Table {
+	table := &Table{pairs: map[string][]Rate{}, currencies: map[string]bool{}}
+	for _, rate := range rates {
+		if rate.Value <= 0 || rate.From == rate.To {
+			continue
+		}
+		rate.Date = truncateDay(rate.Date)
+		key := pairKey(rate.From, rate.To)
+		list := table.pairs[key]
+		replaced := false
+		for i := range list {
+			if list[i].Date.Equal(rate.Date) {
+				list[i] = rate
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			list = append(list, rate)
+		}
+		table.pairs[key] = list
+		table.currencies[rate.From] = true
+		table.currencies[rate.To] = true
+	}
+	for _, list := range table.pairs {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Date.Before(list[j].Date) })
+	}
+	return table
+}
+
+// Lookup devolve a cotação de from para to válida na data: a mais recente publicada até ela.
+// Tenta o par direto, o inverso e, por último, uma moeda intermediária (ex: EUR -> USD -> BRL).
+func (t *Table) Lookup(from, to string, date time.Time) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	date = truncateDay(date)
+	if value, ok := t.pair(from, to, date); ok {
+		return value, true
+	}
+
+	// Percorre as moedas em ordem para que o resultado não dependa da ordem do map
+	pivots := make([]string, 0, len(t.currencies))
+	for code := range t.currencies {
+		if code != from && code != to {
+			pivots = append(pivots, code)
+		}
+	}
+	sort.Strings(pivots)
+	for _, pivot := range pivots {
+		first, ok := t.pair(from, pivot, date)
+		if !ok {
+			continue
+		}
+		if second, ok := t.pair(pivot, to, date); ok {
+			return first * second, true
+		}
+	}
+	return 0, false
+}
+
+// Convert converte amount de from para to com a cotação da data, arredondando para centavos.
+func (t *Table) Convert(amount float64, from, to string, date time.Time) (float64, bool) {
+	rate, ok := t.Lookup(from, to, date)
+	if !ok {
+		return 0, false
+	}
+	return math.Round(amount*rate*100) / 100, true
+}
+
+// pair busca o par direto ou, na falta dele, o inverso.
+func (t *Table) pair(from, to string, date time.Time) (float64, bool) {
+	if rate, ok := latest(t.pairs[pairKey(from, to)], date); ok {
+		return rate.Value, true
+	}
+	if rate, ok := latest(t.pairs[pairKey(to, from)], date); ok {
+		return 1 / rate.Value, true
+	}
+	return 0, false
+}
+
+// latest devolve a última cotação com data até date (a lista está em ordem crescente).
+func latest(rates []Rate, date time.Time) (Rate, bool) {
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date.After(date) })
+	if i == 0 {
+		return Rate{}, false
+	}
+	return rates[i-1], true
+}
+
+// ParseDate interpreta datas em YYYY-MM-DD (formato das notas) ou DD/MM/YYYY. Datas com
+// horário (ex: 2024-01-31T00:00:00Z, como o Postgres devolve colunas date) usam só o dia.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > 10 && value[4] == '-' {
+		value = value[:10]
+	}
+	for _, layout := range []string{"2006-01-02", "02/01/2006"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("data inválida %q: use YYYY-MM-DD ou DD/MM/YYYY", value)
+}
+
+func pairKey(from, to string) string {
+	return from + "/" + to
+}
+
+func truncateDay(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
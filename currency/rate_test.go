@@ -0,0 +1,102 @@
+package currency
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func day(value string) time.Time {
+	date, err := ParseDate(value)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
+
+func TestNormalize(t *testing.T) {
+	if got, err := Normalize(" usd "); err != nil || got != "USD" {
+		t.Fatalf("Normalize(usd) = %q, %v", got, err)
+	}
+	for _, code := range []string{"", "US", "XXX", "REAL"} {
+		if _, err := Normalize(code); err == nil {
+			t.Fatalf("Normalize(%q) should fail", code)
+		}
+	}
+	if got := NormalizeOr("R$", Default); got != "BRL" {
+		t.Fatalf("NormalizeOr(R$) = %q", got)
+	}
+}
+
+func TestTableLookup(t *testing.T) {
+	table := NewTable([]Rate{
+		{Date: day("2024-07-01"), From: "USD", To: "BRL", Value: 5.40},
+		{Date: day("2024-07-10"), From: "USD", To: "BRL", Value: 5.50},
+		{Date: day("2024-07-01"), From: "EUR", To: "USD", Value: 1.10},
+		{Date: day("2024-07-10"), From: "USD", To: "BRL", Value: 5.55}, // sobrescreve a do mesmo dia
+	})
+
+	tests := []struct {
+		name     string
+		from, to string
+		date     string
+		want     float64
+		ok       bool
+	}{
+		{"same currency", "BRL", "BRL", "2024-01-01", 1, true},
+		{"latest rate until the date", "USD", "BRL", "2024-07-05", 5.40, true},
+		{"rate of the same day", "USD", "BRL", "2024-07-10", 5.55, true},
+		{"no rate before the date", "USD", "BRL", "2024-06-30", 0, false},
+		{"inverse pair", "BRL", "USD", "2024-07-05", 1 / 5.40, true},
+		{"through a pivot currency", "EUR", "BRL", "2024-07-05", 1.10 * 5.40, true},
+		{"unknown currency", "JPY", "BRL", "2024-07-05", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := table.Lookup(tt.from, tt.to, day(tt.date))
+			if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("Lookup = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	if got, ok := table.Convert(10, "USD", "BRL", day("2024-07-05")); !ok || got != 54 {
+		t.Fatalf("Convert = %v, %v", got, ok)
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	input := "date,from,to,rate\n2024-07-01,usd,BRL,5.43\n\n02/07/2024,EUR,BRL,\"5,91\"\n"
+	rates, err := ParseCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseCSV: %v", err)
+	}
+	if len(rates) != 2 {
+		t.Fatalf("got %d rates, want 2", len(rates))
+	}
+	if rates[0].From != "USD" || rates[0].Value != 5.43 || !rates[0].Date.Equal(day("2024-07-01")) {
+		t.Fatalf("unexpected first rate: %+v", rates[0])
+	}
+	if rates[1].From != "EUR" || rates[1].Value != 5.91 || !rates[1].Date.Equal(day("2024-07-02")) {
+		t.Fatalf("unexpected second rate: %+v", rates[1])
+	}
+
+	semicolon := "data;de;para;cotacao\n01/07/2024;USD;BRL;5,43\n"
+	rates, err = ParseCSV(strings.NewReader(semicolon))
+	if err != nil || len(rates) != 1 || rates[0].Value != 5.43 {
+		t.Fatalf("semicolon CSV = %+v, %v", rates, err)
+	}
+
+	for _, invalid := range []string{
+		"2024-07-01,USD,BRL\n",
+		"2024-07-01,USD,BRL,5\n2024-13-01,USD,BRL,5\n",
+		"2024-07-01,USD,XYZ,5\n",
+		"2024-07-01,USD,BRL,-1\n",
+		"2024-07-01,BRL,BRL,1\n",
+	} {
+		if _, err := ParseCSV(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("ParseCSV(%q) should fail", invalid)
+		}
+	}
+}
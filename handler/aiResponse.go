@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+// uncategorizedCategoryName é a categoria do sistema usada quando a IA não consegue categorizar um item.
+const uncategorizedCategoryName = "Não categorizado"
+
+// aiUsage soma o modelo e os tokens de todas as chamadas feitas para obter uma resposta válida
+// (a chamada original e, se houver, a de reparo).
+type aiUsage struct {
+	Model          string
+	PromptTokens   int
+	ResponseTokens int
+	TotalTokens    int
+}
+
+func (u *aiUsage) add(response *llm.Response) {
+	u.Model = response.Model
+	u.PromptTokens += response.Usage.PromptTokens
+	u.ResponseTokens += response.Usage.ResponseTokens
+	u.TotalTokens += response.Usage.TotalTokens
+}
+
+// generateJSON chama o provedor pedindo saída estruturada, decodifica a resposta em out e a valida.
+// Se o JSON for inválido ou validate apontar problemas, faz uma única chamada de reparo mostrando ao
+// modelo a resposta anterior e os problemas. Os problemas que sobrarem são retornados para que o
+// chamador aplique o fallback; só há erro quando nenhuma resposta pôde ser decodificada.
+func generateJSON(ctx context.Context, provider llm.AIProvider, request llm.Request, out interface{}, validate func() []string) (aiUsage, []string, error) {
+	var usage aiUsage
+
+	response, err := provider.Generate(ctx, request)
+	if err != nil {
+		return usage, nil, fmt.Errorf("failed to call AI provider (%s): %w", provider.Name(), err)
+	}
+	usage.add(response)
+
+	decodeErr := decodeAIJSON(response.Text, out)
+	var problems []string
+	if decodeErr != nil {
+		problems = []string{fmt.Sprintf("o JSON é inválido: %v", decodeErr)}
+	} else if problems = validate(); len(problems) == 0 {
+		return usage, nil, nil
+	}
+
+	logger.WarnF("⚠️  AI response has %d problem(s), asking %s to repair it: %s", len(problems), provider.Name(), strings.Join(problems, "; "))
+
+	repair := request
+	repair.Prompt = buildRepairPrompt(request.Prompt, response.Text, problems)
+	repaired, err := provider.Generate(ctx, repair)
+	if err != nil {
+		if decodeErr != nil {
+			return usage, nil, fmt.Errorf("failed to call AI provider (%s) to repair the response: %w", provider.Name(), err)
+		}
+		logger.WarnF("⚠️  AI repair call failed, keeping the first response: %v", err)
+		return usage, problems, nil
+	}
+	usage.add(repaired)
+
+	// Decodifica num valor novo para não misturar campos das duas respostas
+	fresh := reflect.New(reflect.TypeOf(out).Elem())
+	if err := decodeAIJSON(repaired.Text, fresh.Interface()); err != nil {
+		if decodeErr != nil {
+			return usage, nil, fmt.Errorf("invalid JSON from AI provider, even after repair: %w - Response: %s", err, repaired.Text)
+		}
+		logger.WarnF("⚠️  AI repair returned invalid JSON, keeping the first response: %v", err)
+		return usage, problems, nil
+	}
+	reflect.ValueOf(out).Elem().Set(fresh.Elem())
+
+	return usage, validate(), nil
+}
+
+// decodeAIJSON decodifica estritamente a resposta do modelo: campos desconhecidos, tipos errados
+// ou texto depois do JSON são erros. Só o bloco ```json em volta é tolerado.
+func decodeAIJSON(text string, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(llm.StripCodeFence(text))))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected content after the JSON value")
+	}
+	return nil
+}
+
+// buildRepairPrompt repete a tarefa original com a resposta inválida e os problemas encontrados.
+func buildRepairPrompt(prompt, previous string, problems []string) string {
+	var builder strings.Builder
+	builder.WriteString(prompt)
+	builder.WriteString("\n\n⚠️ CORREÇÃO: sua resposta anterior foi rejeitada pela validação.\n")
+	builder.WriteString("Resposta anterior:\n")
+	builder.WriteString(previous)
+	builder.WriteString("\n\nProblemas encontrados:\n")
+	for _, problem := range problems {
+		builder.WriteString("- " + problem + "\n")
+	}
+	builder.WriteString("\nRetorne novamente o JSON COMPLETO, corrigindo todos os problemas acima e seguindo exatamente o formato pedido.\n")
+	return builder.String()
+}
+
+// Schemas da saída estruturada pedida aos provedores.
+var (
+	receiptAnalysisSchema = &llm.Schema{
+		Type: "object",
+		Properties: map[string]*llm.Schema{
+			"storeName": {Type: "string"},
+			"date":      {Type: "string", Description: "YYYY-MM-DD"},
+			"items": {Type: "array", Items: &llm.Schema{
+				Type: "object",
+				Properties: map[string]*llm.Schema{
+					"description": {Type: "string"},
+					"quantity":    {Type: "number"},
+					"unit":        {Type: "string"},
+					"unitPrice":   {Type: "number"},
+					"total":       {Type: "number"},
+					"discount":    {Type: "number"},
+				},
+				Required: []string{"description", "quantity", "unit", "unitPrice", "total", "discount"},
+			}},
+			"subtotal":   {Type: "number"},
+			"discount":   {Type: "number"},
+			"total":      {Type: "number"},
+			"currency":   {Type: "string", Description: "ISO 4217"},
+			"confidence": {Type: "number"},
+			"notes":      {Type: "string"},
+		},
+		Required: []string{"storeName", "date", "items", "subtotal", "discount", "total", "currency", "confidence", "notes"},
+	}
+
+	categorizationSchema = &llm.Schema{
+		Type: "array",
+		Items: &llm.Schema{
+			Type: "object",
+			Properties: map[string]*llm.Schema{
+				"description": {Type: "string"},
+				"categoryId":  {Type: "integer"},
+			},
+			Required: []string{"description", "categoryId"},
+		},
+	}
+
+	recategorizationSchema = &llm.Schema{
+		Type: "object",
+		Properties: map[string]*llm.Schema{
+			"categorizations": {Type: "array", Items: &llm.Schema{
+				Type: "object",
+				Properties: map[string]*llm.Schema{
+					"itemId":     {Type: "integer"},
+					"categoryId": {Type: "integer"},
+				},
+				Required: []string{"itemId", "categoryId"},
+			}},
+		},
+		Required: []string{"categorizations"},
+	}
+)
+
+// categoryIndex organiza as categorias do usuário para validar os IDs retornados pela IA.
+type categoryIndex struct {
+	byID          map[uint]schemas.Category
+	uncategorized uint // ID de "Não categorizado" (0 se o usuário não tiver)
+}
+
+func newCategoryIndex(categories []schemas.Category) categoryIndex {
+	index := categoryIndex{byID: make(map[uint]schemas.Category, len(categories))}
+	for _, category := range categories {
+		index.byID[category.ID] = category
+		if category.Name == uncategorizedCategoryName {
+			index.uncategorized = category.ID
+		}
+	}
+	return index
+}
+
+// valid indica se a categoria é do usuário e pode receber itens da IA (não é "Não categorizado").
+func (c categoryIndex) valid(id uint) bool {
+	_, ok := c.byID[id]
+	return ok && id != c.uncategorized
+}
+
+// validateReceiptAnalysis confere os dados lidos de uma foto de nota.
+func validateReceiptAnalysis(data *ReceiptAnalysisData) []string {
+	var problems []string
+	if len(data.Items) == 0 {
+		problems = append(problems, "items está vazio: liste todos os produtos da nota")
+	}
+	if data.Date != "" {
+		if _, err := time.Parse("2006-01-02", data.Date); err != nil {
+			problems = append(problems, fmt.Sprintf("date %q não está no formato YYYY-MM-DD", data.Date))
+		}
+	}
+	if data.Currency != "" {
+		if _, err := currency.Normalize(data.Currency); err != nil {
+			problems = append(problems, fmt.Sprintf("currency %q não é um código ISO 4217 válido", data.Currency))
+		}
+	}
+	if data.Confidence < 0 || data.Confidence > 1 {
+		problems = append(problems, "confidence deve estar entre 0 e 1")
+	}
+	if data.Subtotal < 0 || data.Discount < 0 || data.Total < 0 {
+		problems = append(problems, "subtotal, discount e total não podem ser negativos")
+	}
+
+	for i, item := range data.Items {
+		label := fmt.Sprintf("items[%d]", i)
+		if strings.TrimSpace(item.Description) == "" {
+			problems = append(problems, label+": description está vazia")
+		}
+		if item.Quantity <= 0 {
+			problems = append(problems, label+": quantity deve ser maior que zero")
+		}
+		if item.UnitPrice < 0 || item.Total < 0 {
+			problems = append(problems, label+": unitPrice e total não podem ser negativos")
+		}
+		if item.Discount < 0 || item.Discount > item.Total {
+			problems = append(problems, label+": discount deve estar entre 0 e o total do item")
+		}
+	}
+	return problems
+}
+
+// validateCategorizations confere a categorização dos itens, que deve vir na mesma ordem e quantidade.
+func validateCategorizations(items []NFCeItem, categorized []CategorizedItem, categories categoryIndex) []string {
+	var problems []string
+	if len(categorized) != len(items) {
+		problems = append(problems, fmt.Sprintf("foram enviados %d itens e retornados %d: retorne um objeto para cada item, na mesma ordem", len(items), len(categorized)))
+	}
+	for i, item := range categorized {
+		if !categories.valid(item.CategoryID) {
+			problems = append(problems, fmt.Sprintf("item %d (%s): categoryId %d não está na lista de categorias disponíveis", i+1, item.Description, item.CategoryID))
+		}
+	}
+	return problems
+}
+
+// validateRecategorizations confere as sugestões de recategorização: todo item pedido deve receber
+// uma categoria válida, e apenas ele.
+func validateRecategorizations(items []schemas.ReceiptItem, categorizations []ItemCategorization, categories categoryIndex) []string {
+	requested := make(map[uint]bool, len(items))
+	for _, item := range items {
+		requested[item.ID] = true
+	}
+
+	var problems []string
+	answered := make(map[uint]bool, len(categorizations))
+	for _, categorization := range categorizations {
+		if !requested[categorization.ItemID] {
+			problems = append(problems, fmt.Sprintf("itemId %d não está na lista de produtos", categorization.ItemID))
+			continue
+		}
+		if !categories.valid(categorization.CategoryID) {
+			problems = append(problems, fmt.Sprintf("itemId %d: categoryId %d não está na lista de categorias disponíveis", categorization.ItemID, categorization.CategoryID))
+		}
+		answered[categorization.ItemID] = true
+	}
+	for _, item := range items {
+		if !answered[item.ID] {
+			problems = append(problems, fmt.Sprintf("itemId %d não foi categorizado", item.ID))
+		}
+	}
+	return problems
+}
@@ -47,7 +47,8 @@ func GetAIWorkerPoolStatusHandler(ctx *gin.Context) {
 		"limits": gin.H{
 			"maxWorkers": 3,
 			"rateLimit":  "10 requests per minute",
-			"model":      "gemini-2.5-flash-preview-05-20",
+			"provider":   aiProvider.Name(),
+			"model":      aiProvider.Model(),
 			"tier":       "Free",
 		},
 		"recommendations": getRecommendations(workerPool),
@@ -40,18 +40,26 @@ type CategoryGraphResponse struct {
 type GraphData struct {
 	Categories []CategoryGraphResponse `json:"categories"`
 	GrandTotal float64                 `json:"grandTotal"`
+
+	BaseCurrency string                `json:"baseCurrency"`           // Moeda dos totais
+	MissingRates []MissingExchangeRate `json:"missingRates,omitempty"` // Notas sem cotação (fora dos totais)
 }
 
 // CategoryItemResponse define a estrutura para os itens de uma categoria
 type CategoryItemResponse struct {
 	ID           uint    `json:"id"`
 	Name         string  `json:"name"`
-	Total        float64 `json:"total"`
+	Total        float64 `json:"total"`    // Valor pago (já descontado)
+	Discount     float64 `json:"discount"` // Desconto do item
+	Currency     string  `json:"currency"` // Moeda da nota
 	Quantity     float64 `json:"quantity"`
 	Unit         string  `json:"unit"`
 	ReceiptID    uint    `json:"receiptId"`
 	StoreName    string  `json:"storeName"`
 	PurchaseDate string  `json:"purchaseDate"`
+
+	// Valor pago convertido para a moeda base (ausente quando não há cotação para a data da nota)
+	BaseTotal *float64 `json:"baseTotal,omitempty"`
 }
 
 // CategoryWithItemsResponse define a resposta completa da categoria com seus itens
@@ -378,6 +386,7 @@ func GetCategoryHandler(ctx *gin.Context) {
 	// Converte para o formato de resposta
 	items := make([]CategoryItemResponse, 0, len(receiptItems))
 	var totalValue float64
+	converter := newCurrencyConverter(ctx)
 
 	for _, item := range receiptItems {
 		// Pega nome e unidade do produto (OBRIGATÓRIO)
@@ -388,26 +397,33 @@ func GetCategoryHandler(ctx *gin.Context) {
 			unit = item.Product.Unity
 		}
 
-		// Pega nome da loja e data do recibo
+		// Pega nome da loja, data e moeda do recibo
 		storeName := ""
 		purchaseDate := ""
+		receiptCurrency := ""
 		if item.Receipt != nil {
 			storeName = item.Receipt.StoreName
 			purchaseDate = item.Receipt.Date
+			receiptCurrency = item.Receipt.Currency
 		}
 
-		items = append(items, CategoryItemResponse{
+		response := CategoryItemResponse{
 			ID:           item.ID,
 			Name:         name,
-			Total:        item.Total,
+			Total:        item.NetTotal,
+			Discount:     item.Discount,
+			Currency:     receiptCurrency,
 			Quantity:     item.Quantity,
 			Unit:         unit,
 			ReceiptID:    item.ReceiptID,
 			StoreName:    storeName,
 			PurchaseDate: purchaseDate,
-		})
-
-		totalValue += item.Total
+		}
+		if baseTotal, ok := converter.convert(item.NetTotal, receiptCurrency, purchaseDate); ok {
+			response.BaseTotal = &baseTotal
+			totalValue += baseTotal
+		}
+		items = append(items, response)
 	}
 
 	// Calcula informações de paginação
@@ -424,7 +440,8 @@ func GetCategoryHandler(ctx *gin.Context) {
 			"category":   category.ToResponse(),
 			"items":      items,
 			"itemCount":  len(items),
-			"totalValue": totalValue,
+			"totalValue": roundCents(totalValue), // Na moeda base, só itens da página
+			"currency":   converter.base,
 		},
 		"summary": gin.H{
 			"totalItems":  totalItems,
@@ -434,6 +451,10 @@ func GetCategoryHandler(ctx *gin.Context) {
 		},
 	}
 
+	if missing := converter.missingRates(); missing != nil {
+		response["missingRates"] = missing
+	}
+
 	// Adiciona informação de período se fornecido
 	if startDate != "" || endDate != "" {
 		response["period"] = gin.H{
@@ -519,7 +540,7 @@ func UpdateCategoryHandler(ctx *gin.Context) {
 }
 
 // @Summary Delete category
-// @Description Delete a category and move all its items to "Não categorizado". Items can be recategorized later using the /items/recategorize endpoint.
+// @Description Delete a category and move all its items to "Não categorizado". Categorization rules that target the category are removed. Items can be recategorized later using the /items/recategorize endpoint.
 // @Tags 📁 Categories
 // @Accept json
 // @Produce json
@@ -583,6 +604,15 @@ func DeleteCategoryHandler(ctx *gin.Context) {
 	itemsMoved := result.RowsAffected
 	logger.InfoF("Moved %d items from category %s to 'Não categorizado'", itemsMoved, category.Name)
 
+	// Regras de categorização que apontavam para a categoria deixam de existir
+	rules := tx.Unscoped().Where("category_id = ? AND user_id = ?", category.ID, userID).Delete(&schemas.CategoryRule{})
+	if rules.Error != nil {
+		tx.Rollback()
+		logger.ErrorF("error deleting category rules: %v", rules.Error.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao remover as regras de categorização da categoria. Operação cancelada")
+		return
+	}
+
 	// Deleta a categoria
 	if err := tx.Delete(&category).Error; err != nil {
 		tx.Rollback()
@@ -600,9 +630,10 @@ func DeleteCategoryHandler(ctx *gin.Context) {
 
 	logger.InfoF("Category %s deleted successfully, %d items moved to 'Não categorizado'", category.Name, itemsMoved)
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":    "Category deleted successfully",
-		"itemsMoved": itemsMoved,
-		"note":       "Items moved to 'Não categorizado'. Use POST /items/recategorize to recategorize them.",
+		"message":      "Category deleted successfully",
+		"itemsMoved":   itemsMoved,
+		"rulesDeleted": rules.RowsAffected,
+		"note":         "Items moved to 'Não categorizado'. Use POST /items/recategorize to recategorize them.",
 	})
 }
 
@@ -691,11 +722,19 @@ func GetCategoryGraphHandler(ctx *gin.Context) {
 		}
 	}
 
-	// 6. Agregar dados dos items por categoria
+	// 6. Agregar dados dos items por categoria, convertidos para a moeda base pela cotação da data da nota
+	converter := newCurrencyConverter(ctx)
+	receiptByID := make(map[uint]*schemas.Receipt, len(receipts))
+	for i := range receipts {
+		receiptByID[receipts[i].ID] = &receipts[i]
+	}
 	for _, item := range items {
 		if catData, exists := categoryMap[item.CategoryID]; exists {
 			catData.ItemCount++
-			catData.Total += item.Total
+			receipt := receiptByID[item.ReceiptID]
+			if total, ok := converter.convert(item.NetTotal, receipt.Currency, receipt.Date); ok {
+				catData.Total += total
+			}
 		}
 	}
 
@@ -703,6 +742,7 @@ func GetCategoryGraphHandler(ctx *gin.Context) {
 	var results []CategoryGraphResponse
 	var grandTotal float64
 	for _, catData := range categoryMap {
+		catData.Total = roundCents(catData.Total)
 		results = append(results, *catData)
 		grandTotal += catData.Total
 	}
@@ -715,8 +755,10 @@ func GetCategoryGraphHandler(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Category graph data retrieved successfully",
 		"data": GraphData{
-			Categories: results,
-			GrandTotal: grandTotal,
+			Categories:   results,
+			GrandTotal:   roundCents(grandTotal),
+			BaseCurrency: converter.base,
+			MissingRates: converter.missingRates(),
 		},
 	})
 }
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Como escolher a categoria quando o histórico tem mais de uma para o mesmo produto
+// (CATEGORY_HISTORY_MODE).
+const (
+	categoryHistoryRecent   = "recent"   // A categoria definida por último (padrão): correções do usuário valem na hora
+	categoryHistoryFrequent = "frequent" // A categoria mais usada; empate fica com a mais recente
+)
+
+// categoryHistoryRow resume quantas vezes um produto foi comprado em uma categoria pelo usuário.
+type categoryHistoryRow struct {
+	ProductID  uint
+	GTIN       *string
+	Name       string
+	Unity      string
+	CategoryID uint
+	Uses       int
+	LastUsed   time.Time // Última vez em que um item do produto foi gravado ou recategorizado
+}
+
+// categoryHistoryMode retorna a estratégia configurada em CATEGORY_HISTORY_MODE.
+func categoryHistoryMode() string {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("CATEGORY_HISTORY_MODE"))) == categoryHistoryFrequent {
+		return categoryHistoryFrequent
+	}
+	return categoryHistoryRecent
+}
+
+// normalizeDescription padroniza a descrição para comparar itens (minúsculas, espaços simples).
+func normalizeDescription(description string) string {
+	return strings.ToLower(strings.Join(strings.Fields(description), " "))
+}
+
+// resolveCategoriesFromHistory procura no histórico do usuário a categoria de cada item, para
+// que só os itens desconhecidos sejam enviados à IA. Retorna tempID -> categoria dos itens resolvidos.
+func resolveCategoriesFromHistory(userID uint, items []PreviewItem) (map[int]uint, error) {
+	if len(items) == 0 {
+		return map[int]uint{}, nil
+	}
+
+	gtins := []string{}
+	names := []string{}
+	for _, item := range items {
+		if item.GTIN != "" {
+			gtins = append(gtins, item.GTIN)
+		}
+		if name := normalizeDescription(item.Description); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	// Itens de notas fora da lixeira, em categorias que ainda existem ("Não categorizado" não conta)
+	var rows []categoryHistoryRow
+	err := db.Table("receipt_items").
+		Select(`receipt_items.product_id, products.gtin, products.name, products.unity, receipt_items.category_id,
+			COUNT(*) AS uses, MAX(receipt_items.updated_at) AS last_used`).
+		Joins("JOIN receipts ON receipts.id = receipt_items.receipt_id AND receipts.deleted_at IS NULL").
+		Joins("JOIN products ON products.id = receipt_items.product_id").
+		Joins("JOIN categories ON categories.id = receipt_items.category_id AND categories.user_id = receipts.user_id AND categories.deleted_at IS NULL AND categories.name <> ?", uncategorizedCategoryName).
+		Where("receipts.user_id = ? AND receipt_items.deleted_at IS NULL", userID).
+		Where("products.gtin IN ? OR LOWER(REGEXP_REPLACE(BTRIM(products.name), '\\s+', ' ', 'g')) IN ?", gtins, names).
+		Group("receipt_items.product_id, products.gtin, products.name, products.unity, receipt_items.category_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return matchCategoryHistory(items, rows, categoryHistoryMode()), nil
+}
+
+// matchCategoryHistory escolhe a categoria de cada item pelo histórico, na ordem: o mesmo produto
+// (mesmo GTIN, ou mesmo nome e unidade, como em findOrCreateProduct) e, na falta dele, a mesma
+// descrição normalizada em qualquer unidade. Itens sem histórico ficam fora do mapa.
+func matchCategoryHistory(items []PreviewItem, rows []categoryHistoryRow, mode string) map[int]uint {
+	resolved := make(map[int]uint)
+	for _, item := range items {
+		var sameProduct, sameDescription []categoryHistoryRow
+		description := normalizeDescription(item.Description)
+		unit := normalizeUnit(item.Unit)
+
+		for _, row := range rows {
+			switch {
+			case item.GTIN != "" && row.GTIN != nil && *row.GTIN == item.GTIN,
+				row.Name == item.Description && row.Unity == unit:
+				sameProduct = append(sameProduct, row)
+			case description != "" && normalizeDescription(row.Name) == description:
+				sameDescription = append(sameDescription, row)
+			}
+		}
+
+		candidates := sameProduct
+		if len(candidates) == 0 {
+			candidates = sameDescription
+		}
+		if categoryID := pickHistoryCategory(candidates, mode); categoryID != 0 {
+			resolved[item.TempID] = categoryID
+		}
+	}
+	return resolved
+}
+
+// pickHistoryCategory escolhe uma categoria entre as usadas para o item, somando os produtos
+// que caíram na mesma categoria. Retorna 0 quando não há histórico.
+func pickHistoryCategory(rows []categoryHistoryRow, mode string) uint {
+	type candidate struct {
+		categoryID uint
+		uses       int
+		lastUsed   time.Time
+	}
+	byCategory := make(map[uint]*candidate)
+	for _, row := range rows {
+		c, ok := byCategory[row.CategoryID]
+		if !ok {
+			c = &candidate{categoryID: row.CategoryID}
+			byCategory[row.CategoryID] = c
+		}
+		c.uses += row.Uses
+		if row.LastUsed.After(c.lastUsed) {
+			c.lastUsed = row.LastUsed
+		}
+	}
+	if len(byCategory) == 0 {
+		return 0
+	}
+
+	candidates := make([]*candidate, 0, len(byCategory))
+	for _, c := range byCategory {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if mode == categoryHistoryFrequent && a.uses != b.uses {
+			return a.uses > b.uses
+		}
+		if !a.lastUsed.Equal(b.lastUsed) {
+			return a.lastUsed.After(b.lastUsed)
+		}
+		return a.categoryID < b.categoryID
+	})
+	return candidates[0].categoryID
+}
+
+// localCategoriesForImport resolve sem IA os itens ativos sem categoria escolhida pelo usuário:
+// primeiro pelas regras de categorização do usuário e, para os que sobrarem, pelo histórico.
+func localCategoriesForImport(userID uint, data PreviewReceiptData, items []PreviewItem) (byRule, byHistory map[int]uint) {
+	byRule = ruleCategoriesForImport(userID, data, items)
+	pending := []PreviewItem{}
+	for _, item := range items {
+		if _, ok := byRule[item.TempID]; !ok {
+			pending = append(pending, item)
+		}
+	}
+	return byRule, historyCategoriesForImport(userID, pending)
+}
+
+// historyCategoriesForImport resolve pelo histórico os itens ativos sem categoria escolhida pelo
+// usuário. Falhas na consulta não impedem a importação: os itens seguem para a IA.
+func historyCategoriesForImport(userID uint, items []PreviewItem) map[int]uint {
+	pending := []PreviewItem{}
+	for _, item := range items {
+		if item.CategoryID == 0 {
+			pending = append(pending, item)
+		}
+	}
+	resolved, err := resolveCategoriesFromHistory(userID, pending)
+	if err != nil {
+		logger.WarnF("⚠️  Failed to resolve categories from history for user %d: %v", userID, err)
+		return map[int]uint{}
+	}
+	return resolved
+}
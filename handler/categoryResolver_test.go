@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchCategoryHistory(t *testing.T) {
+	gtin := "7894900011517"
+	now := time.Now()
+	rows := []categoryHistoryRow{
+		{ProductID: 1, GTIN: &gtin, Name: "REFRIG COCA COLA 2L", Unity: "un", CategoryID: 2, Uses: 3, LastUsed: now.Add(-48 * time.Hour)},
+		{ProductID: 2, Name: "PAO FRANCES", Unity: "kg", CategoryID: 3, Uses: 5, LastUsed: now.Add(-24 * time.Hour)},
+		{ProductID: 3, Name: "pao  frances", Unity: "un", CategoryID: 4, Uses: 1, LastUsed: now},
+	}
+	items := []PreviewItem{
+		{TempID: 1, Description: "Coca-Cola 2 litros", Unit: "UN", GTIN: gtin}, // Mesmo GTIN, outra descrição
+		{TempID: 2, Description: "PAO FRANCES", Unit: "kg"},                    // Mesmo produto (nome e unidade)
+		{TempID: 3, Description: "Pão Frances", Unit: "UN"},                    // Sem histórico (acento diferente)
+		{TempID: 4, Description: "Pao Frances", Unit: "PCT"},                   // Mesma descrição, outra unidade
+	}
+
+	resolved := matchCategoryHistory(items, rows, categoryHistoryRecent)
+
+	expected := map[int]uint{1: 2, 2: 3, 4: 4}
+	if len(resolved) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, resolved)
+	}
+	for tempID, categoryID := range expected {
+		if resolved[tempID] != categoryID {
+			t.Errorf("item %d: expected category %d, got %d", tempID, categoryID, resolved[tempID])
+		}
+	}
+}
+
+func TestPickHistoryCategory(t *testing.T) {
+	now := time.Now()
+	rows := []categoryHistoryRow{
+		{ProductID: 1, CategoryID: 2, Uses: 4, LastUsed: now.Add(-72 * time.Hour)},
+		{ProductID: 2, CategoryID: 2, Uses: 2, LastUsed: now.Add(-48 * time.Hour)},
+		{ProductID: 1, CategoryID: 3, Uses: 1, LastUsed: now}, // Correção mais recente do usuário
+	}
+
+	if got := pickHistoryCategory(rows, categoryHistoryRecent); got != 3 {
+		t.Errorf("recent: expected category 3, got %d", got)
+	}
+	if got := pickHistoryCategory(rows, categoryHistoryFrequent); got != 2 {
+		t.Errorf("frequent: expected category 2, got %d", got)
+	}
+	if got := pickHistoryCategory(nil, categoryHistoryRecent); got != 0 {
+		t.Errorf("empty history: expected 0, got %d", got)
+	}
+}
@@ -0,0 +1,741 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxCategoryRulePattern    = 200 // Tamanho máximo do padrão de uma regra
+	maxCategoryRuleGTINDigits = 14  // GTIN-14
+	categoryRuleCNPJLength    = 14  // CNPJ só com dígitos
+	categoryRuleTestSample    = 50  // Itens devolvidos no teste de uma regra
+)
+
+// CreateCategoryRuleRequest define uma regra de categorização.
+type CreateCategoryRuleRequest struct {
+	MatchType  string `json:"matchType" binding:"required" example:"contains"` // contains, regex, gtin ou store
+	Pattern    string `json:"pattern" binding:"required" example:"RACAO"`
+	CategoryID uint   `json:"categoryId" binding:"required" example:"9"`
+	Priority   int    `json:"priority" example:"10"` // Maior prioridade é avaliada primeiro (padrão 0)
+}
+
+// UpdateCategoryRuleRequest altera uma regra; campos ausentes não mudam.
+type UpdateCategoryRuleRequest struct {
+	MatchType  *string `json:"matchType" example:"gtin"`
+	Pattern    *string `json:"pattern" example:"7891000"`
+	CategoryID *uint   `json:"categoryId" example:"4"`
+	Priority   *int    `json:"priority" example:"20"`
+}
+
+// TestCategoryRuleRequest é a regra testada contra o histórico (não precisa estar salva).
+type TestCategoryRuleRequest struct {
+	MatchType  string `json:"matchType" binding:"required" example:"contains"`
+	Pattern    string `json:"pattern" binding:"required" example:"RACAO"`
+	CategoryID uint   `json:"categoryId" example:"9"` // Opcional: indica quais itens mudariam de categoria
+}
+
+// CategoryRuleTestItem é um item do histórico que casa com a regra testada.
+type CategoryRuleTestItem struct {
+	ItemID       uint   `json:"itemId"`
+	ReceiptID    uint   `json:"receiptId"`
+	Description  string `json:"description"`
+	GTIN         string `json:"gtin,omitempty"`
+	StoreName    string `json:"storeName"`
+	Date         string `json:"date"`
+	CategoryID   uint   `json:"categoryId"` // Categoria atual
+	CategoryName string `json:"categoryName"`
+	WouldChange  bool   `json:"wouldChange"` // A regra mudaria a categoria do item
+}
+
+// TestCategoryRuleResponse é a resposta de POST /category-rules/test.
+type TestCategoryRuleResponse struct {
+	Matched     int                    `json:"matched"`     // Itens do histórico que casam com a regra
+	WouldChange int                    `json:"wouldChange"` // Deles, quantos mudariam de categoria
+	Items       []CategoryRuleTestItem `json:"items"`       // Os mais recentes (até 50)
+}
+
+// ApplyCategoryRulesRequest é o corpo (opcional) de POST /category-rules/apply.
+type ApplyCategoryRulesRequest struct {
+	RuleIDs []uint `json:"ruleIds"` // Regras a aplicar (padrão: todas)
+	DryRun  bool   `json:"dryRun"`  // Só calcula as mudanças, sem gravar
+}
+
+// CategoryRuleApplyResult resume o efeito de uma regra sobre os itens antigos.
+type CategoryRuleApplyResult struct {
+	RuleID     uint `json:"ruleId"`
+	CategoryID uint `json:"categoryId"`
+	Matched    int  `json:"matched"` // Itens em que a regra foi a primeira a casar
+	Changed    int  `json:"changed"` // Deles, os que estavam em outra categoria
+}
+
+// ApplyCategoryRulesResponse é a resposta de POST /category-rules/apply.
+type ApplyCategoryRulesResponse struct {
+	Message string                    `json:"message"`
+	DryRun  bool                      `json:"dryRun"`
+	Matched int                       `json:"matched"`
+	Changed int                       `json:"changed"`
+	Rules   []CategoryRuleApplyResult `json:"rules"`
+}
+
+// ruleSubject é o que as regras comparam: o item e a loja da nota.
+type ruleSubject struct {
+	Description string
+	GTIN        string
+	StoreCNPJ   string
+	StoreName   string
+}
+
+// compiledCategoryRule é uma regra pronta para ser avaliada (padrão normalizado, regex compilada).
+type compiledCategoryRule struct {
+	rule  schemas.CategoryRule
+	text  string
+	regex *regexp.Regexp
+}
+
+// categoryRuleSet são as regras do usuário na ordem de avaliação: maior prioridade primeiro e,
+// no empate, a mais antiga.
+type categoryRuleSet []compiledCategoryRule
+
+// accentFolder remove os acentos do português, para "RACAO" casar com "Ração".
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+// foldText padroniza um texto para comparação: minúsculas, sem acentos e com espaços simples.
+func foldText(text string) string {
+	return accentFolder.Replace(normalizeDescription(text))
+}
+
+// ruleDigits remove a pontuação de CNPJs e códigos de barras; retorna "" se sobrar algo além de dígitos.
+func ruleDigits(value string) string {
+	var digits strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '.' || r == '-' || r == '/' || r == ' ':
+		default:
+			return ""
+		}
+	}
+	return digits.String()
+}
+
+// normalizeCategoryRule confere o tipo e o padrão de uma regra e os devolve normalizados.
+func normalizeCategoryRule(matchType, pattern string) (string, string, error) {
+	matchType = strings.ToLower(strings.TrimSpace(matchType))
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return "", "", errors.New("pattern não pode ser vazio")
+	}
+	if len(pattern) > maxCategoryRulePattern {
+		return "", "", fmt.Errorf("pattern deve ter no máximo %d caracteres", maxCategoryRulePattern)
+	}
+
+	switch matchType {
+	case schemas.CategoryRuleContains:
+	case schemas.CategoryRuleRegex:
+		if _, err := regexp.Compile("(?i)" + pattern); err != nil {
+			return "", "", fmt.Errorf("expressão regular inválida: %v", err)
+		}
+	case schemas.CategoryRuleGTIN:
+		digits := ruleDigits(pattern)
+		if digits == "" || len(digits) > maxCategoryRuleGTINDigits || strings.Trim(digits, "0") == "" {
+			return "", "", errors.New("para matchType gtin, pattern deve ter de 1 a 14 dígitos do código de barras (completo ou o prefixo da empresa)")
+		}
+		pattern = digits
+	case schemas.CategoryRuleStore:
+		// CNPJ com ou sem pontuação vira só dígitos; qualquer outro texto é parte do nome da loja
+		if digits := ruleDigits(pattern); len(digits) == categoryRuleCNPJLength {
+			pattern = digits
+		}
+	default:
+		return "", "", fmt.Errorf("matchType inválido: %q (use contains, regex, gtin ou store)", matchType)
+	}
+	return matchType, pattern, nil
+}
+
+// compileCategoryRule prepara uma regra já normalizada para ser avaliada.
+func compileCategoryRule(rule schemas.CategoryRule) (compiledCategoryRule, error) {
+	compiled := compiledCategoryRule{rule: rule}
+	switch rule.MatchType {
+	case schemas.CategoryRuleContains, schemas.CategoryRuleStore:
+		compiled.text = foldText(rule.Pattern)
+	case schemas.CategoryRuleGTIN:
+		compiled.text = strings.TrimLeft(rule.Pattern, "0")
+	case schemas.CategoryRuleRegex:
+		regex, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.regex = regex
+	default:
+		return compiled, fmt.Errorf("unknown match type %q", rule.MatchType)
+	}
+	return compiled, nil
+}
+
+// matches indica se a regra casa com o item.
+func (c compiledCategoryRule) matches(subject ruleSubject) bool {
+	switch c.rule.MatchType {
+	case schemas.CategoryRuleContains:
+		return c.text != "" && strings.Contains(foldText(subject.Description), c.text)
+	case schemas.CategoryRuleRegex:
+		return c.regex.MatchString(subject.Description) || c.regex.MatchString(foldText(subject.Description))
+	case schemas.CategoryRuleGTIN:
+		// GTINs são gravados com 14 dígitos: os zeros à esquerda não contam no prefixo
+		return subject.GTIN != "" && c.text != "" && strings.HasPrefix(strings.TrimLeft(subject.GTIN, "0"), c.text)
+	case schemas.CategoryRuleStore:
+		if len(c.rule.Pattern) == categoryRuleCNPJLength && ruleDigits(c.rule.Pattern) == c.rule.Pattern {
+			return subject.StoreCNPJ == c.rule.Pattern
+		}
+		return c.text != "" && strings.Contains(foldText(subject.StoreName), c.text)
+	}
+	return false
+}
+
+// newCategoryRuleSet compila as regras e as ordena para avaliação. Regras inválidas são ignoradas.
+func newCategoryRuleSet(rules []schemas.CategoryRule) categoryRuleSet {
+	set := make(categoryRuleSet, 0, len(rules))
+	for _, rule := range rules {
+		compiled, err := compileCategoryRule(rule)
+		if err != nil {
+			logger.WarnF("⚠️  Ignoring invalid category rule %d: %v", rule.ID, err)
+			continue
+		}
+		set = append(set, compiled)
+	}
+	sort.SliceStable(set, func(i, j int) bool {
+		if set[i].rule.Priority != set[j].rule.Priority {
+			return set[i].rule.Priority > set[j].rule.Priority
+		}
+		return set[i].rule.ID < set[j].rule.ID
+	})
+	return set
+}
+
+// match devolve a primeira regra que casa com o item, ou nil.
+func (s categoryRuleSet) match(subject ruleSubject) *schemas.CategoryRule {
+	for i := range s {
+		if s[i].matches(subject) {
+			return &s[i].rule
+		}
+	}
+	return nil
+}
+
+// loadCategoryRules carrega as regras do usuário cujas categorias ainda existem.
+func loadCategoryRules(userID uint) (categoryRuleSet, error) {
+	var rules []schemas.CategoryRule
+	err := db.Where("user_id = ? AND category_id IN (?)", userID,
+		db.Model(&schemas.Category{}).Select("id").Where("user_id = ?", userID)).
+		Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return newCategoryRuleSet(rules), nil
+}
+
+// ruleCategoriesForImport aplica as regras do usuário aos itens ativos sem categoria escolhida
+// na confirmação. Retorna tempID -> categoria dos itens que casaram com alguma regra; falhas na
+// consulta não impedem a importação (os itens seguem para o histórico e a IA).
+func ruleCategoriesForImport(userID uint, data PreviewReceiptData, items []PreviewItem) map[int]uint {
+	resolved := make(map[int]uint)
+	pending := []PreviewItem{}
+	for _, item := range items {
+		if item.CategoryID == 0 {
+			pending = append(pending, item)
+		}
+	}
+	if len(pending) == 0 {
+		return resolved
+	}
+
+	rules, err := loadCategoryRules(userID)
+	if err != nil {
+		logger.WarnF("⚠️  Failed to load category rules for user %d: %v", userID, err)
+		return resolved
+	}
+	if len(rules) == 0 {
+		return resolved
+	}
+
+	store := ruleSubject{StoreName: data.StoreName}
+	if data.Store != nil {
+		store.StoreCNPJ = data.Store.CNPJ
+		if store.StoreName == "" {
+			store.StoreName = data.Store.TradeName
+		}
+	}
+	for _, item := range pending {
+		subject := store
+		subject.Description = item.Description
+		subject.GTIN = item.GTIN
+		if rule := rules.match(subject); rule != nil {
+			resolved[item.TempID] = rule.CategoryID
+		}
+	}
+	return resolved
+}
+
+// applyCategoryRulesToItemRequests preenche, pelas regras do usuário, a categoria dos itens
+// informados manualmente sem categoryId. gtins são os códigos já normalizados de cada item.
+func applyCategoryRulesToItemRequests(userID uint, store ruleSubject, items []CreateReceiptItemRequest, gtins []string) error {
+	missing := false
+	for _, item := range items {
+		if item.CategoryID == 0 {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	rules, err := loadCategoryRules(userID)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if items[i].CategoryID != 0 {
+			continue
+		}
+		subject := store
+		subject.Description = items[i].ProductName
+		subject.GTIN = gtins[i]
+		rule := rules.match(subject)
+		if rule == nil {
+			return itemRequestError(fmt.Sprintf("Informe categoryId no item %d: nenhuma regra de categorização corresponde a ele", i+1))
+		}
+		items[i].CategoryID = rule.CategoryID
+	}
+	return nil
+}
+
+// ruleHistoryItem é um item já gravado do usuário, com os dados que as regras comparam.
+type ruleHistoryItem struct {
+	ItemID      uint
+	ReceiptID   uint
+	CategoryID  uint
+	Description string
+	GTIN        *string
+	StoreName   string
+	StoreCNPJ   *string
+	Date        string
+}
+
+func (i ruleHistoryItem) subject() ruleSubject {
+	subject := ruleSubject{Description: i.Description, StoreName: i.StoreName}
+	if i.GTIN != nil {
+		subject.GTIN = *i.GTIN
+	}
+	if i.StoreCNPJ != nil {
+		subject.StoreCNPJ = *i.StoreCNPJ
+	}
+	return subject
+}
+
+// loadRuleHistory carrega os itens do usuário fora da lixeira, dos mais recentes para os mais antigos.
+func loadRuleHistory(userID uint) ([]ruleHistoryItem, error) {
+	var items []ruleHistoryItem
+	err := db.Table("receipt_items").
+		Select(`receipt_items.id AS item_id, receipt_items.receipt_id, receipt_items.category_id,
+			products.name AS description, products.gtin, receipts.store_name, stores.cnpj AS store_cnpj, receipts.date`).
+		Joins("JOIN receipts ON receipts.id = receipt_items.receipt_id AND receipts.deleted_at IS NULL").
+		Joins("JOIN products ON products.id = receipt_items.product_id").
+		Joins("LEFT JOIN stores ON stores.id = receipts.store_id").
+		Where("receipts.user_id = ? AND receipt_items.deleted_at IS NULL", userID).
+		Order("receipts.date DESC, receipt_items.id DESC").
+		Scan(&items).Error
+	return items, err
+}
+
+// planCategoryRules calcula a regra de cada item antigo (a primeira que casar) e as mudanças de
+// categoria resultantes (itemID -> categoria), com o resumo por regra na ordem de avaliação.
+func planCategoryRules(rules categoryRuleSet, items []ruleHistoryItem) (map[uint]uint, []CategoryRuleApplyResult) {
+	results := make([]CategoryRuleApplyResult, len(rules))
+	position := make(map[uint]int, len(rules))
+	for i, compiled := range rules {
+		results[i] = CategoryRuleApplyResult{RuleID: compiled.rule.ID, CategoryID: compiled.rule.CategoryID}
+		position[compiled.rule.ID] = i
+	}
+
+	changes := make(map[uint]uint)
+	for _, item := range items {
+		rule := rules.match(item.subject())
+		if rule == nil {
+			continue
+		}
+		result := &results[position[rule.ID]]
+		result.Matched++
+		if item.CategoryID != rule.CategoryID {
+			changes[item.ItemID] = rule.CategoryID
+			result.Changed++
+		}
+	}
+	return changes, results
+}
+
+// findUserCategory busca uma categoria do usuário; responde 400 e retorna false se não existir.
+func findUserCategory(ctx *gin.Context, userID interface{}, categoryID uint) (*schemas.Category, bool) {
+	var category schemas.Category
+	if err := db.Where("id = ? AND user_id = ?", categoryID, userID).First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendError(ctx, http.StatusBadRequest, "Categoria não encontrada ou não pertence ao usuário autenticado")
+			return nil, false
+		}
+		logger.ErrorF("error finding category: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar a categoria")
+		return nil, false
+	}
+	return &category, true
+}
+
+// ListCategoryRulesHandler lista as regras de categorização do usuário
+// @Summary Listar regras de categorização
+// @Description Lista as regras do usuário na ordem em que são avaliadas (maior prioridade primeiro; no empate, a mais antiga). Na importação, vale a primeira regra que casar com o item
+// @Tags 📁 Categories
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /category-rules [get]
+func ListCategoryRulesHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var rules []schemas.CategoryRule
+	if err := db.Preload("Category").Where("user_id = ?", userID).
+		Order("priority DESC, id ASC").Find(&rules).Error; err != nil {
+		logger.ErrorF("error listing category rules: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar regras de categorização")
+		return
+	}
+
+	responses := make([]schemas.CategoryRuleResponse, len(rules))
+	for i := range rules {
+		responses[i] = rules[i].ToResponse()
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Category rules retrieved successfully",
+		"data":    responses,
+	})
+}
+
+// CreateCategoryRuleHandler cria uma regra de categorização
+// @Summary Criar regra de categorização
+// @Description Cria uma regra que define a categoria dos itens importados sem passar pela IA. matchType: contains (a descrição contém o texto, sem diferenciar maiúsculas e acentos), regex (expressão regular sobre a descrição), gtin (código de barras completo ou prefixo da empresa) ou store (CNPJ da loja, ou parte do nome). Para aplicar a regra às notas antigas, use POST /category-rules/apply
+// @Tags 📁 Categories
+// @Accept json
+// @Produce json
+// @Param request body CreateCategoryRuleRequest true "Regra"
+// @Success 201 {object} schemas.CategoryRuleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /category-rules [post]
+func CreateCategoryRuleHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var request CreateCategoryRuleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, "Dados inválidos. Informe matchType, pattern e categoryId")
+		return
+	}
+	matchType, pattern, err := normalizeCategoryRule(request.MatchType, request.Pattern)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	category, ok := findUserCategory(ctx, userID, request.CategoryID)
+	if !ok {
+		return
+	}
+
+	rule := schemas.CategoryRule{
+		UserID:     userID.(uint),
+		MatchType:  matchType,
+		Pattern:    pattern,
+		CategoryID: category.ID,
+		Priority:   request.Priority,
+	}
+	if err := db.Create(&rule).Error; err != nil {
+		logger.ErrorF("error creating category rule: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao criar regra de categorização")
+		return
+	}
+
+	logger.InfoF("📐 Category rule %d created for user %d: %s %q -> %s", rule.ID, rule.UserID, rule.MatchType, rule.Pattern, category.Name)
+	rule.Category = category
+	ctx.JSON(http.StatusCreated, rule.ToResponse())
+}
+
+// UpdateCategoryRuleHandler altera uma regra de categorização
+// @Summary Atualizar regra de categorização
+// @Description Altera o tipo, o padrão, a categoria ou a prioridade de uma regra. Itens já gravados não mudam; use POST /category-rules/apply para isso
+// @Tags 📁 Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Category rule ID"
+// @Param request body UpdateCategoryRuleRequest true "Campos a alterar"
+// @Success 200 {object} schemas.CategoryRuleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /category-rules/{id} [patch]
+func UpdateCategoryRuleHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var request UpdateCategoryRuleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, "Dados inválidos para atualizar a regra")
+		return
+	}
+
+	var rule schemas.CategoryRule
+	if err := db.Where("id = ? AND user_id = ?", ctx.Param("id"), userID).First(&rule).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Regra não encontrada ou não pertence ao usuário")
+		return
+	}
+
+	matchType, pattern := rule.MatchType, rule.Pattern
+	if request.MatchType != nil {
+		matchType = *request.MatchType
+	}
+	if request.Pattern != nil {
+		pattern = *request.Pattern
+	}
+	matchType, pattern, err := normalizeCategoryRule(matchType, pattern)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	categoryID := rule.CategoryID
+	if request.CategoryID != nil {
+		categoryID = *request.CategoryID
+	}
+	category, ok := findUserCategory(ctx, userID, categoryID)
+	if !ok {
+		return
+	}
+
+	rule.MatchType, rule.Pattern, rule.CategoryID = matchType, pattern, category.ID
+	if request.Priority != nil {
+		rule.Priority = *request.Priority
+	}
+	if err := db.Model(&rule).Select("match_type", "pattern", "category_id", "priority").Updates(&rule).Error; err != nil {
+		logger.ErrorF("error updating category rule: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao atualizar regra de categorização")
+		return
+	}
+
+	rule.Category = category
+	ctx.JSON(http.StatusOK, rule.ToResponse())
+}
+
+// DeleteCategoryRuleHandler remove uma regra de categorização
+// @Summary Remover regra de categorização
+// @Description Remove a regra. Itens já categorizados por ela não mudam
+// @Tags 📁 Categories
+// @Produce json
+// @Param id path int true "Category rule ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /category-rules/{id} [delete]
+func DeleteCategoryRuleHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	result := db.Unscoped().Where("id = ? AND user_id = ?", ctx.Param("id"), userID).Delete(&schemas.CategoryRule{})
+	if result.Error != nil {
+		logger.ErrorF("error deleting category rule: %v", result.Error.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao remover regra de categorização")
+		return
+	}
+	if result.RowsAffected == 0 {
+		sendError(ctx, http.StatusNotFound, "Regra não encontrada ou não pertence ao usuário")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Category rule deleted successfully"})
+}
+
+// TestCategoryRuleHandler mostra quais itens antigos casam com uma regra
+// @Summary Testar regra de categorização
+// @Description Avalia uma regra (salva ou não) contra os itens já gravados do usuário, fora da lixeira, e devolve quantos casam e os mais recentes. Com categoryId, indica quais mudariam de categoria. Nada é alterado
+// @Tags 📁 Categories
+// @Accept json
+// @Produce json
+// @Param request body TestCategoryRuleRequest true "Regra a testar"
+// @Success 200 {object} TestCategoryRuleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /category-rules/test [post]
+func TestCategoryRuleHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var request TestCategoryRuleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, "Dados inválidos. Informe matchType e pattern")
+		return
+	}
+	matchType, pattern, err := normalizeCategoryRule(request.MatchType, request.Pattern)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.CategoryID != 0 {
+		if _, ok := findUserCategory(ctx, userID, request.CategoryID); !ok {
+			return
+		}
+	}
+	rule, err := compileCategoryRule(schemas.CategoryRule{MatchType: matchType, Pattern: pattern, CategoryID: request.CategoryID})
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	history, err := loadRuleHistory(userID.(uint))
+	if err != nil {
+		logger.ErrorF("error loading items to test category rule: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar itens")
+		return
+	}
+	var categories []schemas.Category
+	if err := db.Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		logger.ErrorF("error loading categories: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar categorias")
+		return
+	}
+	index := newCategoryIndex(categories)
+
+	response := TestCategoryRuleResponse{Items: []CategoryRuleTestItem{}}
+	for _, item := range history {
+		subject := item.subject()
+		if !rule.matches(subject) {
+			continue
+		}
+		response.Matched++
+		wouldChange := request.CategoryID != 0 && item.CategoryID != request.CategoryID
+		if wouldChange {
+			response.WouldChange++
+		}
+		if len(response.Items) < categoryRuleTestSample {
+			date := item.Date
+			if len(date) > 10 {
+				date = date[:10]
+			}
+			response.Items = append(response.Items, CategoryRuleTestItem{
+				ItemID:       item.ItemID,
+				ReceiptID:    item.ReceiptID,
+				Description:  item.Description,
+				GTIN:         subject.GTIN,
+				StoreName:    item.StoreName,
+				Date:         date,
+				CategoryID:   item.CategoryID,
+				CategoryName: index.byID[item.CategoryID].Name,
+				WouldChange:  wouldChange,
+			})
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ApplyCategoryRulesHandler aplica as regras de categorização aos itens já gravados
+// @Summary Aplicar regras aos itens antigos
+// @Description Reaplica as regras do usuário (todas ou as de ruleIds) aos itens já gravados, fora da lixeira: cada item vai para a categoria da primeira regra que casar, na ordem de avaliação. Itens sem regra não mudam. Com dryRun, só calcula as mudanças
+// @Tags 📁 Categories
+// @Accept json
+// @Produce json
+// @Param request body ApplyCategoryRulesRequest false "Regras a aplicar e dryRun"
+// @Success 200 {object} ApplyCategoryRulesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /category-rules/apply [post]
+func ApplyCategoryRulesHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var request ApplyCategoryRulesRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		sendError(ctx, http.StatusBadRequest, "Dados inválidos. Informe ruleIds (opcional) e dryRun")
+		return
+	}
+
+	rules, err := loadCategoryRules(userID.(uint))
+	if err != nil {
+		logger.ErrorF("error loading category rules: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar regras de categorização")
+		return
+	}
+	if len(request.RuleIDs) > 0 {
+		wanted := make(map[uint]bool, len(request.RuleIDs))
+		for _, id := range request.RuleIDs {
+			wanted[id] = true
+		}
+		selected := categoryRuleSet{}
+		for _, compiled := range rules {
+			if wanted[compiled.rule.ID] {
+				selected = append(selected, compiled)
+				delete(wanted, compiled.rule.ID)
+			}
+		}
+		for id := range wanted {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Regra %d não encontrada ou não pertence ao usuário", id))
+			return
+		}
+		rules = selected
+	}
+	if len(rules) == 0 {
+		sendError(ctx, http.StatusBadRequest, "Nenhuma regra de categorização para aplicar")
+		return
+	}
+
+	history, err := loadRuleHistory(userID.(uint))
+	if err != nil {
+		logger.ErrorF("error loading items to apply category rules: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar itens")
+		return
+	}
+
+	changes, results := planCategoryRules(rules, history)
+	response := ApplyCategoryRulesResponse{DryRun: request.DryRun, Changed: len(changes), Rules: results}
+	for _, result := range results {
+		response.Matched += result.Matched
+	}
+
+	if request.DryRun {
+		response.Message = fmt.Sprintf("Simulação: %d item(s) mudariam de categoria", response.Changed)
+		ctx.JSON(http.StatusOK, response)
+		return
+	}
+
+	if len(changes) > 0 {
+		if err := saveRecategorization(changes); err != nil {
+			logger.ErrorF("error applying category rules: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Erro ao salvar as novas categorias")
+			return
+		}
+	}
+	logger.InfoF("📐 Category rules applied for user %d: %d item(s) matched, %d recategorized", userID.(uint), response.Matched, response.Changed)
+	response.Message = fmt.Sprintf("%d item(s) recategorizados pelas regras", response.Changed)
+	ctx.JSON(http.StatusOK, response)
+}
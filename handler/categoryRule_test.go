@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+func categoryRule(id uint, matchType, pattern string, categoryID uint, priority int) schemas.CategoryRule {
+	rule := schemas.CategoryRule{MatchType: matchType, Pattern: pattern, CategoryID: categoryID, Priority: priority}
+	rule.ID = id
+	return rule
+}
+
+func TestNormalizeCategoryRule(t *testing.T) {
+	tests := []struct {
+		matchType, pattern string
+		wantType, want     string
+		wantErr            bool
+	}{
+		{matchType: " Contains ", pattern: " RACAO ", wantType: "contains", want: "RACAO"},
+		{matchType: "gtin", pattern: "789-1000", wantType: "gtin", want: "7891000"},
+		{matchType: "store", pattern: "12.345.678/0001-90", wantType: "store", want: "12345678000190"},
+		{matchType: "store", pattern: "Petz", wantType: "store", want: "Petz"},
+		{matchType: "regex", pattern: "^(LEITE|IOGURTE)\\b", wantType: "regex", want: "^(LEITE|IOGURTE)\\b"},
+		{matchType: "regex", pattern: "LEITE(", wantErr: true},
+		{matchType: "gtin", pattern: "ABC", wantErr: true},
+		{matchType: "gtin", pattern: "000", wantErr: true},
+		{matchType: "prefix", pattern: "X", wantErr: true},
+		{matchType: "contains", pattern: "   ", wantErr: true},
+	}
+
+	for _, test := range tests {
+		matchType, pattern, err := normalizeCategoryRule(test.matchType, test.pattern)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s %q: expected error, got %s %q", test.matchType, test.pattern, matchType, pattern)
+			}
+			continue
+		}
+		if err != nil || matchType != test.wantType || pattern != test.want {
+			t.Errorf("%s %q: expected %s %q, got %s %q (err: %v)", test.matchType, test.pattern, test.wantType, test.want, matchType, pattern, err)
+		}
+	}
+}
+
+func TestCategoryRuleMatches(t *testing.T) {
+	racao := ruleSubject{Description: "Ração Golden Cães 15kg", StoreName: "Supermercado Silva", StoreCNPJ: "12345678000190"}
+	leite := ruleSubject{Description: "LEITE UHT INTEGRAL 1L", GTIN: "07891000100103", StoreName: "Petz Pinheiros"}
+
+	tests := []struct {
+		rule    schemas.CategoryRule
+		subject ruleSubject
+		want    bool
+	}{
+		{categoryRule(1, "contains", "RACAO", 9, 0), racao, true},
+		{categoryRule(1, "contains", "RACAO", 9, 0), leite, false},
+		{categoryRule(2, "regex", "^leite (uht|longa vida)", 4, 0), leite, true},
+		{categoryRule(2, "regex", "c[aã]es", 4, 0), racao, true},
+		{categoryRule(3, "gtin", "7891000", 4, 0), leite, true},        // Prefixo da empresa no GTIN-14
+		{categoryRule(3, "gtin", "07891000100103", 4, 0), leite, true}, // GTIN completo
+		{categoryRule(3, "gtin", "7891000", 4, 0), racao, false},       // Item sem código de barras
+		{categoryRule(4, "store", "12345678000190", 9, 0), racao, true},
+		{categoryRule(4, "store", "12345678000190", 9, 0), leite, false},
+		{categoryRule(5, "store", "petz", 9, 0), leite, true},
+	}
+
+	for _, test := range tests {
+		compiled, err := compileCategoryRule(test.rule)
+		if err != nil {
+			t.Fatalf("compileCategoryRule(%+v): %v", test.rule, err)
+		}
+		if got := compiled.matches(test.subject); got != test.want {
+			t.Errorf("%s %q on %q: expected %v, got %v", test.rule.MatchType, test.rule.Pattern, test.subject.Description, test.want, got)
+		}
+	}
+}
+
+func TestPlanCategoryRules(t *testing.T) {
+	// Avaliadas por prioridade: a regra 3 vem antes da 1, que empata com a 2 e é mais antiga
+	rules := newCategoryRuleSet([]schemas.CategoryRule{
+		categoryRule(1, "contains", "racao", 9, 0),
+		categoryRule(2, "store", "petz", 7, 0),
+		categoryRule(3, "contains", "racao gato", 8, 10),
+	})
+	gtin := "07891000100103"
+	items := []ruleHistoryItem{
+		{ItemID: 100, CategoryID: 2, Description: "RACAO GATO WHISKAS ADULTO"},
+		{ItemID: 101, CategoryID: 9, Description: "RACAO PEDIGREE"},
+		{ItemID: 102, CategoryID: 3, Description: "RACAO PEDIGREE", StoreName: "Petz"},
+		{ItemID: 103, CategoryID: 3, Description: "BRINQUEDO", StoreName: "PETZ Pinheiros"},
+		{ItemID: 104, CategoryID: 4, Description: "LEITE", GTIN: &gtin},
+	}
+
+	changes, results := planCategoryRules(rules, items)
+
+	expected := map[uint]uint{100: 8, 102: 9, 103: 7}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+	for itemID, categoryID := range expected {
+		if changes[itemID] != categoryID {
+			t.Errorf("item %d: expected category %d, got %d", itemID, categoryID, changes[itemID])
+		}
+	}
+
+	want := []CategoryRuleApplyResult{
+		{RuleID: 3, CategoryID: 8, Matched: 1, Changed: 1},
+		{RuleID: 1, CategoryID: 9, Matched: 2, Changed: 1},
+		{RuleID: 2, CategoryID: 7, Matched: 1, Changed: 1},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), results)
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result %d: expected %+v, got %+v", i, want[i], results[i])
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+// receiptDraftTTL é o tempo que um preview fica disponível para confirmação.
+const receiptDraftTTL = 2 * time.Hour
+
+// errDraftConsumed indica que outro pedido confirmou o rascunho primeiro.
+var errDraftConsumed = errors.New("draft has already been confirmed")
+
+// DraftItemEdit descreve uma alteração do usuário em um item do rascunho.
+type DraftItemEdit struct {
+	TempID      int     `json:"tempId" binding:"required"` // Item do preview a alterar
+	Description *string `json:"description,omitempty"`     // Novo nome do produto
+	CategoryID  *uint   `json:"categoryId,omitempty"`      // Categoria escolhida (dispensa a IA para o item)
+}
+
+// confirmedImport é o rascunho com as edições do usuário aplicadas. É o que o ImportJob
+// processa e guarda em Payload para novas tentativas.
+type confirmedImport struct {
+	DraftID      uint               `json:"draftId"`
+	Source       string             `json:"source"`
+	Confidence   float64            `json:"confidence"`
+	Receipt      PreviewReceiptData `json:"receipt"`
+	EditedFields []string           `json:"editedFields,omitempty"` // Ex: items[2].deleted, items[1].description
+
+	// Categorias definidas pelas regras e pelo histórico do usuário na confirmação (tempID -> categoria).
+	// Nulos nos jobs de lote e nos anteriores às regras: o job consulta regras e histórico ao rodar.
+	RuleCategories     map[int]uint `json:"ruleCategories,omitempty"`
+	ResolvedCategories map[int]uint `json:"resolvedCategories,omitempty"`
+}
+
+// saveReceiptDraft grava o resultado do preview para ser confirmado depois pelo ID.
+// Aproveita para remover os rascunhos vencidos e não confirmados do usuário.
+func saveReceiptDraft(userID uint, source string, data PreviewReceiptData, confidence float64) (*schemas.ReceiptDraft, error) {
+	purgeExpiredDraftImages(userID)
+	if err := db.Unscoped().
+		Where("user_id = ? AND consumed_at IS NULL AND expires_at < ?", userID, time.Now()).
+		Delete(&schemas.ReceiptDraft{}).Error; err != nil {
+		logger.WarnF("⚠️  Failed to purge expired drafts for user %d: %v", userID, err)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	draft := schemas.ReceiptDraft{
+		UserID:     userID,
+		Source:     source,
+		Data:       string(encoded),
+		Confidence: confidence,
+		ExpiresAt:  time.Now().Add(receiptDraftTTL),
+	}
+	if err := db.Create(&draft).Error; err != nil {
+		return nil, err
+	}
+	return &draft, nil
+}
+
+// applyDraftEdits aplica as remoções e edições do usuário sobre os dados do rascunho
+// e retorna a lista de campos alterados. Valores (quantidade, preço, total) não são editáveis.
+func applyDraftEdits(userID uint, data PreviewReceiptData, deleted []int, edits []DraftItemEdit) (PreviewReceiptData, []string, error) {
+	index := make(map[int]int, len(data.Items))
+	for i, item := range data.Items {
+		index[item.TempID] = i
+	}
+
+	var edited []string
+	for _, tempID := range deleted {
+		i, ok := index[tempID]
+		if !ok {
+			return data, nil, fmt.Errorf("item %d does not exist in this draft", tempID)
+		}
+		if !data.Items[i].Deleted {
+			data.Items[i].Deleted = true
+			edited = append(edited, fmt.Sprintf("items[%d].deleted", tempID))
+		}
+	}
+
+	categoryIDs := []uint{}
+	for _, edit := range edits {
+		i, ok := index[edit.TempID]
+		if !ok {
+			return data, nil, fmt.Errorf("item %d does not exist in this draft", edit.TempID)
+		}
+		if edit.Description != nil {
+			description := strings.TrimSpace(*edit.Description)
+			if description == "" || len(description) > 255 {
+				return data, nil, fmt.Errorf("item %d: description must have between 1 and 255 characters", edit.TempID)
+			}
+			if description != data.Items[i].Description {
+				data.Items[i].Description = description
+				edited = append(edited, fmt.Sprintf("items[%d].description", edit.TempID))
+			}
+		}
+		if edit.CategoryID != nil {
+			data.Items[i].CategoryID = *edit.CategoryID
+			categoryIDs = append(categoryIDs, *edit.CategoryID)
+			edited = append(edited, fmt.Sprintf("items[%d].category", edit.TempID))
+		}
+	}
+
+	// Categorias escolhidas precisam pertencer ao usuário
+	if len(categoryIDs) > 0 {
+		var count int64
+		unique := map[uint]bool{}
+		for _, id := range categoryIDs {
+			unique[id] = true
+		}
+		db.Model(&schemas.Category{}).Where("id IN ? AND user_id = ?", categoryIDs, userID).Count(&count)
+		if int(count) != len(unique) {
+			return data, nil, fmt.Errorf("one or more categories were not found or do not belong to the user")
+		}
+	}
+
+	return data, edited, nil
+}
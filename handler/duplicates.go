@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+// duplicateTotalTolerance é a diferença máxima de total (em valor absoluto) para considerar
+// duas notas manuais da mesma loja e data como prováveis duplicatas.
+const duplicateTotalTolerance = 0.05
+
+// DuplicateReceiptResponse é retornada com status 409 quando a nota já foi registrada.
+type DuplicateReceiptResponse struct {
+	Message           string                 `json:"message"`
+	ErrorCode         int                    `json:"errorCode"`
+	ExistingReceiptID uint                   `json:"existingReceiptId,omitempty"` // Recibo que já contém a nota
+	Candidates        []schemas.ReceiptBasic `json:"candidates,omitempty"`        // Prováveis duplicatas (criação manual)
+}
+
+// inFlightAccessKeys guarda as chaves que estão sendo confirmadas (IA + gravação em background),
+// evitando que um duplo toque no app passe pela verificação antes da primeira nota ser salva.
+var inFlightAccessKeys sync.Map
+
+func inFlightKey(userID uint, accessKey string) string {
+	return fmt.Sprintf("%d:%s", userID, accessKey)
+}
+
+// reserveAccessKey marca a chave como em processamento. Retorna false se já estiver reservada.
+func reserveAccessKey(userID uint, accessKey string) bool {
+	_, loaded := inFlightAccessKeys.LoadOrStore(inFlightKey(userID, accessKey), struct{}{})
+	return !loaded
+}
+
+// releaseAccessKey libera a chave reservada por reserveAccessKey.
+func releaseAccessKey(userID uint, accessKey string) {
+	inFlightAccessKeys.Delete(inFlightKey(userID, accessKey))
+}
+
+// findReceiptByAccessKey busca um recibo ativo do usuário com a chave de acesso informada.
+// Retorna nil quando a chave ainda não foi importada.
+func findReceiptByAccessKey(userID uint, accessKey string) (*schemas.Receipt, error) {
+	var receipts []schemas.Receipt
+	if err := db.Select("id").Where("user_id = ? AND access_key = ?", userID, accessKey).Limit(1).Find(&receipts).Error; err != nil {
+		return nil, err
+	}
+	if len(receipts) == 0 {
+		return nil, nil
+	}
+	return &receipts[0], nil
+}
+
+// findLikelyDuplicateReceipts busca recibos do usuário com a mesma loja e data e total
+// dentro de duplicateTotalTolerance.
+func findLikelyDuplicateReceipts(userID uint, storeName, date string, total float64) ([]schemas.Receipt, error) {
+	var receipts []schemas.Receipt
+	err := db.Select("id, store_name, date, total, currency").
+		Where("user_id = ? AND LOWER(TRIM(store_name)) = ? AND date = ? AND ABS(total - ?) <= ?",
+			userID, strings.ToLower(strings.TrimSpace(storeName)), date, total, duplicateTotalTolerance).
+		Order("id ASC").
+		Find(&receipts).Error
+	return receipts, err
+}
+
+// sendDuplicateReceipt responde 409 Conflict com os dados do recibo existente.
+func sendDuplicateReceipt(ctx *gin.Context, msg string, existingID uint, candidates []schemas.Receipt) {
+	response := DuplicateReceiptResponse{
+		Message:           msg,
+		ErrorCode:         http.StatusConflict,
+		ExistingReceiptID: existingID,
+	}
+	for _, receipt := range candidates {
+		basic := receipt.ToBasic()
+		var count int64
+		db.Model(&schemas.ReceiptItem{}).Where("receipt_id = ?", receipt.ID).Count(&count)
+		basic.ItemCount = int(count)
+		response.Candidates = append(response.Candidates, basic)
+	}
+	ctx.JSON(http.StatusConflict, response)
+}
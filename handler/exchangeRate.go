@@ -0,0 +1,408 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxExchangeRatesCSVSize limita o CSV de cotações enviado pelo usuário (~20 mil linhas)
+const maxExchangeRatesCSVSize = 1 << 20
+
+// CreateExchangeRateRequest define uma cotação informada manualmente: 1 fromCurrency = rate toCurrency.
+type CreateExchangeRateRequest struct {
+	Date         string  `json:"date" binding:"required" example:"2024-07-01"`
+	FromCurrency string  `json:"fromCurrency" binding:"required" example:"USD"`
+	ToCurrency   string  `json:"toCurrency" binding:"required" example:"BRL"`
+	Rate         float64 `json:"rate" binding:"required,gt=0" example:"5.43"`
+}
+
+// MissingExchangeRate aponta notas que ficaram fora dos totais por falta de cotação.
+type MissingExchangeRate struct {
+	Currency string   `json:"currency"`
+	Dates    []string `json:"dates"` // Datas das notas sem cotação (YYYY-MM-DD)
+}
+
+// ImportExchangeRatesResponse é a resposta de POST /exchange-rates/import.
+type ImportExchangeRatesResponse struct {
+	Message  string `json:"message"`
+	Imported int    `json:"imported"` // Cotações gravadas (novas ou atualizadas)
+}
+
+// currencyConverter converte valores das notas para a moeda base do usuário com a cotação da data
+// da nota. As cotações só são carregadas na primeira nota em outra moeda; notas sem cotação
+// ficam fora dos totais e são listadas em missing.
+type currencyConverter struct {
+	userID  uint
+	base    string
+	table   *currency.Table
+	missing map[string]map[string]bool // moeda -> datas sem cotação
+}
+
+// newCurrencyConverter cria o conversor para a moeda base do usuário autenticado.
+func newCurrencyConverter(ctx *gin.Context) *currencyConverter {
+	userID, _ := ctx.Get("user_id")
+	base := currency.Default
+	if user, ok := ctx.Get("user"); ok {
+		base = currency.NormalizeOr(user.(schemas.User).BaseCurrency, currency.Default)
+	}
+	return &currencyConverter{userID: userID.(uint), base: base, missing: map[string]map[string]bool{}}
+}
+
+// rate devolve o fator que converte valores da moeda from, na data da nota, para a moeda base.
+func (c *currencyConverter) rate(from, date string) (float64, bool) {
+	from = currency.NormalizeOr(from, currency.Default)
+	if from == c.base {
+		return 1, true
+	}
+	if c.table == nil {
+		c.table = loadExchangeRates(c.userID)
+	}
+
+	day, err := currency.ParseDate(date)
+	if err == nil {
+		if value, ok := c.table.Lookup(from, c.base, day); ok {
+			return value, true
+		}
+	}
+	if c.missing[from] == nil {
+		c.missing[from] = map[string]bool{}
+	}
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	c.missing[from][date] = true
+	return 0, false
+}
+
+// convert converte amount para a moeda base, arredondando para centavos.
+func (c *currencyConverter) convert(amount float64, from, date string) (float64, bool) {
+	rate, ok := c.rate(from, date)
+	if !ok {
+		return 0, false
+	}
+	return roundCents(amount * rate), true
+}
+
+// baseTotal converte o total de uma nota para a moeda base; nil quando não há cotação.
+func (c *currencyConverter) baseTotal(total float64, from, date string) *float64 {
+	if value, ok := c.convert(total, from, date); ok {
+		return &value
+	}
+	return nil
+}
+
+// summary monta o resumo da nota com o total convertido para a moeda base.
+func (c *currencyConverter) summary(receipt *schemas.Receipt) schemas.ReceiptSummary {
+	summary := receipt.ToSummary()
+	summary.BaseCurrency = c.base
+	summary.BaseTotal = c.baseTotal(receipt.Total, receipt.Currency, receipt.Date)
+	return summary
+}
+
+// missingRates lista as moedas e datas que ficaram sem cotação, em ordem.
+func (c *currencyConverter) missingRates() []MissingExchangeRate {
+	if len(c.missing) == 0 {
+		return nil
+	}
+	result := make([]MissingExchangeRate, 0, len(c.missing))
+	for code, dates := range c.missing {
+		missing := MissingExchangeRate{Currency: code}
+		for date := range dates {
+			missing.Dates = append(missing.Dates, date)
+		}
+		sort.Strings(missing.Dates)
+		result = append(result, missing)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result
+}
+
+// loadExchangeRates monta a tabela com as cotações globais e as do usuário; as do usuário vêm
+// por último para prevalecer sobre as globais do mesmo par e data.
+func loadExchangeRates(userID uint) *currency.Table {
+	var rows []schemas.ExchangeRate
+	if err := db.Where("user_id IN ?", []uint{0, userID}).Order("user_id, date").Find(&rows).Error; err != nil {
+		logger.ErrorF("error loading exchange rates: %v", err.Error())
+	}
+	rates := make([]currency.Rate, len(rows))
+	for i, row := range rows {
+		rates[i] = currency.Rate{Date: row.Date, From: row.FromCurrency, To: row.ToCurrency, Value: row.Rate}
+	}
+	return currency.NewTable(rates)
+}
+
+// saveUserExchangeRates grava cotações do usuário, substituindo as do mesmo par e data.
+func saveUserExchangeRates(userID uint, rates []currency.Rate, source string) ([]schemas.ExchangeRate, error) {
+	rows := make([]schemas.ExchangeRate, 0, len(rates))
+	seen := make(map[string]int, len(rates))
+	for _, rate := range rates {
+		row := schemas.ExchangeRate{
+			UserID:       userID,
+			Date:         rate.Date,
+			FromCurrency: rate.From,
+			ToCurrency:   rate.To,
+			Rate:         rate.Value,
+			Source:       source,
+		}
+		// ON CONFLICT não aceita a mesma linha duas vezes no mesmo comando: vale a última
+		key := fmt.Sprintf("%s/%s/%s", rate.Date.Format("2006-01-02"), rate.From, rate.To)
+		if i, ok := seen[key]; ok {
+			rows[i] = row
+			continue
+		}
+		seen[key] = len(rows)
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "date"}, {Name: "from_currency"}, {Name: "to_currency"}},
+		DoUpdates: append(clause.AssignmentColumns([]string{"rate", "source", "updated_at"}),
+			clause.Assignment{Column: clause.Column{Name: "deleted_at"}, Value: nil}),
+	}).CreateInBatches(&rows, 500).Error
+	return rows, err
+}
+
+// GetExchangeRatesHandler lista as cotações disponíveis para o usuário
+// @Summary Listar cotações de câmbio
+// @Description Lista as cotações usadas na conversão para a moeda base: as globais (carregadas do arquivo do servidor, somente leitura) e as do usuário. Filtros opcionais por moeda e período
+// @Tags exchange-rates
+// @Produce json
+// @Param currency query string false "Moeda de origem ou destino (ISO 4217)" example(USD)
+// @Param start_date query string false "Data inicial (YYYY-MM-DD)"
+// @Param end_date query string false "Data final (YYYY-MM-DD)"
+// @Param page query int false "Página (padrão: 1)"
+// @Param limit query int false "Itens por página (padrão: 100, máximo: 500)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /exchange-rates [get]
+func GetExchangeRatesHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	page, limit := 1, 100
+	if value := ctx.Query("page"); value != "" {
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 1 {
+			sendError(ctx, http.StatusBadRequest, "Parâmetro 'page' inválido. Deve ser um número maior que 0")
+			return
+		}
+		page = p
+	}
+	if value := ctx.Query("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l < 1 || l > 500 {
+			sendError(ctx, http.StatusBadRequest, "Parâmetro 'limit' inválido. Deve ser um número entre 1 e 500")
+			return
+		}
+		limit = l
+	}
+
+	query := db.Model(&schemas.ExchangeRate{}).Where("user_id IN ?", []uint{0, userID.(uint)})
+	if value := ctx.Query("currency"); value != "" {
+		code, err := currency.Normalize(value)
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		query = query.Where("(from_currency = ? OR to_currency = ?)", code, code)
+	}
+	for param, condition := range map[string]string{"start_date": "date >= ?", "end_date": "date <= ?"} {
+		if value := ctx.Query(param); value != "" {
+			date, err := currency.ParseDate(value)
+			if err != nil {
+				sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Formato de %s inválido. Use YYYY-MM-DD", param))
+				return
+			}
+			query = query.Where(condition, date)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.ErrorF("error counting exchange rates: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar cotações")
+		return
+	}
+	var rows []schemas.ExchangeRate
+	if err := query.Order("date DESC, from_currency, to_currency, user_id DESC").
+		Limit(limit).Offset((page - 1) * limit).Find(&rows).Error; err != nil {
+		logger.ErrorF("error listing exchange rates: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar cotações")
+		return
+	}
+
+	rates := make([]schemas.ExchangeRateResponse, len(rows))
+	for i := range rows {
+		rates[i] = rows[i].ToResponse()
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":      "Exchange rates retrieved successfully",
+		"baseCurrency": newCurrencyConverter(ctx).base,
+		"data":         rates,
+		"total":        total,
+		"page":         page,
+		"limit":        limit,
+	})
+}
+
+// CreateExchangeRateHandler grava uma cotação informada pelo usuário
+// @Summary Informar cotação de câmbio
+// @Description Grava quanto 1 unidade de fromCurrency vale em toCurrency na data. Substitui a cotação do usuário para o mesmo par e data e prevalece sobre a global. Vale para as notas dessa data em diante, até a próxima cotação do par
+// @Tags exchange-rates
+// @Accept json
+// @Produce json
+// @Param request body CreateExchangeRateRequest true "Cotação"
+// @Success 201 {object} schemas.ExchangeRateResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /exchange-rates [post]
+func CreateExchangeRateHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var request CreateExchangeRateRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	date, err := currency.ParseDate(request.Date)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, err := currency.Normalize(request.FromCurrency)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := currency.Normalize(request.ToCurrency)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if from == to {
+		sendError(ctx, http.StatusBadRequest, "fromCurrency e toCurrency devem ser diferentes")
+		return
+	}
+
+	rows, err := saveUserExchangeRates(userID.(uint), []currency.Rate{{Date: date, From: from, To: to, Value: request.Rate}}, schemas.ExchangeRateSourceManual)
+	if err != nil {
+		logger.ErrorF("error saving exchange rate: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao gravar cotação")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, rows[0].ToResponse())
+}
+
+// ImportExchangeRatesHandler importa cotações do usuário a partir de um CSV
+// @Summary Importar cotações de câmbio (CSV)
+// @Description Importa um CSV com as colunas date,from,to,rate (ex: 2024-07-01,USD,BRL,5.43), com cabeçalho opcional. Aceita ';' como separador com vírgula decimal e datas em DD/MM/YYYY. Cotações do mesmo par e data são substituídas. O arquivo é rejeitado inteiro se alguma linha for inválida
+// @Tags exchange-rates
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Arquivo CSV"
+// @Success 201 {object} ImportExchangeRatesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /exchange-rates/import [post]
+func ImportExchangeRatesHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, "CSV file is required in the 'file' field")
+		return
+	}
+	if fileHeader.Size > maxExchangeRatesCSVSize {
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("CSV file is too large: maximum is %d MB", maxExchangeRatesCSVSize>>20))
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.ErrorF("error opening uploaded CSV: %v", err.Error())
+		sendError(ctx, http.StatusBadRequest, "Could not read CSV file")
+		return
+	}
+	defer file.Close()
+
+	rates, err := currency.ParseCSV(file)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Error reading CSV: %v", err.Error()))
+		return
+	}
+	if len(rates) == 0 {
+		sendError(ctx, http.StatusBadRequest, "CSV has no exchange rates")
+		return
+	}
+
+	rows, err := saveUserExchangeRates(userID.(uint), rates, schemas.ExchangeRateSourceCSV)
+	if err != nil {
+		logger.ErrorF("error importing exchange rates: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao gravar cotações")
+		return
+	}
+
+	logger.InfoF("💱 %d exchange rates imported for user %d", len(rows), userID.(uint))
+	ctx.JSON(http.StatusCreated, ImportExchangeRatesResponse{
+		Message:  "Exchange rates imported successfully",
+		Imported: len(rows),
+	})
+}
+
+// DeleteExchangeRateHandler remove uma cotação do usuário
+// @Summary Remover cotação de câmbio
+// @Description Remove definitivamente uma cotação informada pelo usuário. Cotações globais não podem ser removidas pela API
+// @Tags exchange-rates
+// @Produce json
+// @Param id path int true "Exchange rate ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /exchange-rates/{id} [delete]
+func DeleteExchangeRateHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var rate schemas.ExchangeRate
+	if err := db.Where("id = ? AND user_id = ?", ctx.Param("id"), userID).First(&rate).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			sendError(ctx, http.StatusNotFound, "Cotação não encontrada ou não pertence ao usuário")
+			return
+		}
+		logger.ErrorF("error finding exchange rate: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao remover cotação")
+		return
+	}
+
+	if err := db.Unscoped().Delete(&rate).Error; err != nil {
+		logger.ErrorF("error deleting exchange rate: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao remover cotação")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted successfully"})
+}
+
+// normalizeReceiptCurrency valida a moeda enviada para uma nota; vazia usa a moeda base do usuário.
+func normalizeReceiptCurrency(ctx *gin.Context, code string) (string, error) {
+	if strings.TrimSpace(code) == "" {
+		return newCurrencyConverter(ctx).base, nil
+	}
+	return currency.Normalize(code)
+}
@@ -2,16 +2,20 @@ package handler
 
 import (
 	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
 	"gorm.io/gorm"
 )
 
 var (
 	logger *config.Logger
 	db     *gorm.DB
+	// aiProvider é o modelo de linguagem usado na leitura de fotos e na categorização.
+	aiProvider llm.AIProvider
 )
 
-// InitializerHandler inicializa o logger e a instância do banco de dados para o pacote handler.
+// InitializerHandler inicializa o logger, a instância do banco de dados e o provedor de IA para o pacote handler.
 func InitializerHandler() {
 	logger = config.GetLogger("handler")
 	db = config.GetPostgreSQL()
+	aiProvider = config.GetAIProvider()
 }
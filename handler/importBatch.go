@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// batchMaxURLs é o máximo de QR Codes aceitos em um lote.
+	batchMaxURLs = 100
+	// batchPortalConcurrency limita as consultas simultâneas a um mesmo portal da SEFAZ
+	// (somando todos os lotes em andamento), que bloqueiam IPs com muitas requisições.
+	batchPortalConcurrency = 2
+	// batchAIConcurrency limita as categorizações simultâneas de um lote no AIWorkerPool,
+	// para que um lote grande não ocupe a fila inteira nem estoure o timeout da IA.
+	batchAIConcurrency = 2
+	// batchQueueWait é o tempo máximo de espera por espaço na fila da IA antes de falhar o job.
+	batchQueueWait = 5 * time.Minute
+)
+
+// portalSemaphores guarda um semáforo por portal (UF ou host) para as consultas em lote.
+var portalSemaphores sync.Map
+
+// ScanQRCodeBatchRequest lista as URLs de QR Code a importar.
+type ScanQRCodeBatchRequest struct {
+	URLs []string `json:"urls" binding:"required,min=1,max=100"` // URLs dos QR Codes (máximo 100)
+}
+
+// batchImportItem é uma URL do lote que passou pela validação e aguarda o scraping.
+type batchImportItem struct {
+	JobID     uint
+	URL       string
+	AccessKey *nfce.AccessKey // Chave da URL, já reservada (nil quando a URL não traz a chave)
+}
+
+// portalSemaphore retorna o semáforo do portal da SEFAZ que atende a URL.
+func portalSemaphore(url string) chan struct{} {
+	portal := nfce.ParserFor(url).UF()
+	if portal == "" {
+		if parsed, err := neturl.Parse(url); err == nil {
+			portal = parsed.Host
+		}
+	}
+	sem, _ := portalSemaphores.LoadOrStore(portal, make(chan struct{}, batchPortalConcurrency))
+	return sem.(chan struct{})
+}
+
+// prepareBatchJob valida uma URL do lote e monta o job correspondente. URLs inválidas viram
+// jobs failed e duplicadas (no lote, já importadas ou em processamento) viram jobs skipped.
+// Quando a URL traz a chave de acesso, ela fica reservada para o job.
+func prepareBatchJob(userID uint, url string, seen map[string]bool) (schemas.ImportJob, *nfce.AccessKey, error) {
+	now := time.Now()
+	job := schemas.ImportJob{
+		UserID:    userID,
+		Source:    "qrcode",
+		Status:    schemas.ImportJobQueued,
+		SourceURL: url,
+	}
+	finish := func(status schemas.ImportJobStatus, message string) (schemas.ImportJob, *nfce.AccessKey, error) {
+		job.Status = status
+		job.Error = message
+		job.FinishedAt = &now
+		return job, nil, nil
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return finish(schemas.ImportJobFailed, "URL de QR Code inválida")
+	}
+
+	var accessKey *nfce.AccessKey
+	dedupe := url
+	if key := nfce.AccessKeyFromURL(url); key != "" {
+		parsed, err := nfce.ParseAccessKey(key)
+		if err != nil {
+			return finish(schemas.ImportJobFailed, fmt.Sprintf("Chave de acesso inválida no QR Code: %v", err))
+		}
+		accessKey = parsed
+		job.AccessKey = parsed.Key
+		dedupe = parsed.Key
+	}
+	if seen[dedupe] {
+		return finish(schemas.ImportJobSkipped, "Nota repetida neste lote")
+	}
+	seen[dedupe] = true
+
+	if accessKey == nil {
+		return job, nil, nil
+	}
+
+	existing, err := findReceiptByAccessKey(userID, accessKey.Key)
+	if err != nil {
+		return job, nil, err
+	}
+	if existing != nil {
+		job.ReceiptID = &existing.ID
+		return finish(schemas.ImportJobSkipped, "Nota já importada")
+	}
+	if !reserveAccessKey(userID, accessKey.Key) {
+		return finish(schemas.ImportJobSkipped, "Nota já está sendo processada")
+	}
+	return job, accessKey, nil
+}
+
+// runImportBatch processa as URLs do lote em paralelo, respeitando os limites por portal e da IA.
+func runImportBatch(batchID, userID uint, items []batchImportItem) {
+	startedAt := time.Now()
+	aiSlots := make(chan struct{}, batchAIConcurrency)
+
+	var wg sync.WaitGroup
+	for _, item := range items {
+		wg.Add(1)
+		go func(item batchImportItem) {
+			defer wg.Done()
+			runBatchImportItem(userID, item, aiSlots)
+		}(item)
+	}
+	wg.Wait()
+
+	logger.InfoF("📦 [Batch %d] Finished %d URLs in %.2fs", batchID, len(items), time.Since(startedAt).Seconds())
+}
+
+// finishImportJob grava o estado final de um job que não chegou ao runImportJob.
+func finishImportJob(jobID uint, status schemas.ImportJobStatus, message string, receiptID *uint) {
+	fields := map[string]interface{}{
+		"status":      status,
+		"error":       message,
+		"finished_at": time.Now(),
+	}
+	if receiptID != nil {
+		fields["receipt_id"] = *receiptID
+	}
+	updateImportJob(jobID, fields)
+}
+
+// runBatchImportItem faz o scraping de uma URL do lote e importa a nota lida.
+func runBatchImportItem(userID uint, item batchImportItem, aiSlots chan struct{}) {
+	defer trackImportJob(item.JobID)()
+	// 🔍 Scraping limitado por portal
+	sem := portalSemaphore(item.URL)
+	sem <- struct{}{}
+	updateImportJob(item.JobID, map[string]interface{}{"status": schemas.ImportJobScraping, "started_at": time.Now()})
+	data, err := scrapeNFCe(item.URL)
+	<-sem
+	if err != nil {
+		if item.AccessKey != nil {
+			releaseAccessKey(userID, item.AccessKey.Key)
+		}
+		logger.WarnF("⚠️  [Import %d] Scraping failed: %v", item.JobID, err)
+		// Nota ainda não autorizada (contingência): vai para a fila de notas pendentes
+		if reason, retry := pendingScanRetryReason(err, item.AccessKey != nil && item.AccessKey.Contingency()); retry && reason == schemas.PendingScanNotAuthorized {
+			scan, qerr := enqueuePendingScan(userID, item.URL, reason, err.Error())
+			if qerr == nil {
+				finishImportJob(item.JobID, schemas.ImportJobSkipped, fmt.Sprintf("Nota ainda não consta na SEFAZ; guardada na fila de notas pendentes (id %d)", scan.ID), nil)
+				return
+			}
+			logger.ErrorF("⚠️  [Import %d] Failed to queue pending scan: %v", item.JobID, qerr)
+		}
+		finishImportJob(item.JobID, schemas.ImportJobFailed, err.Error(), nil)
+		return
+	}
+
+	importScrapedNFCe(userID, item.JobID, item.URL, data, item.AccessKey, aiSlots)
+}
+
+// importScrapedNFCe entrega uma nota já lida do portal ao runImportJob, o mesmo fluxo de
+// categorização e gravação da confirmação individual. accessKey, quando informada, já está
+// reservada; a reserva é sempre liberada ao final.
+func importScrapedNFCe(userID, jobID uint, url string, data *NFCeData, accessKey *nfce.AccessKey, aiSlots chan struct{}) {
+	handedOff := false
+	defer func() {
+		if !handedOff && accessKey != nil {
+			releaseAccessKey(userID, accessKey.Key)
+		}
+	}()
+
+	// A chave lida da página confirma (ou, se a URL não a trazia, identifica) a nota
+	if data.AccessKey != "" {
+		pageKey, err := nfce.ParseAccessKey(data.AccessKey)
+		if err != nil {
+			finishImportJob(jobID, schemas.ImportJobFailed, fmt.Sprintf("Chave de acesso inválida na página da NFC-e: %v", err), nil)
+			return
+		}
+		switch {
+		case accessKey != nil && accessKey.Key != pageKey.Key:
+			finishImportJob(jobID, schemas.ImportJobFailed, "A página do portal retornou outra nota", nil)
+			return
+		case accessKey == nil:
+			existing, err := findReceiptByAccessKey(userID, pageKey.Key)
+			if err != nil {
+				finishImportJob(jobID, schemas.ImportJobFailed, err.Error(), nil)
+				return
+			}
+			if existing != nil {
+				finishImportJob(jobID, schemas.ImportJobSkipped, "Nota já importada", &existing.ID)
+				return
+			}
+			if !reserveAccessKey(userID, pageKey.Key) {
+				finishImportJob(jobID, schemas.ImportJobSkipped, "Nota já está sendo processada", nil)
+				return
+			}
+			accessKey = pageKey
+		}
+	}
+
+	confirmed := confirmedImport{
+		Source:     "qrcode",
+		Confidence: 1.0,
+		Receipt:    buildPreviewData(data, url),
+	}
+	payload, err := json.Marshal(confirmed)
+	if err != nil {
+		finishImportJob(jobID, schemas.ImportJobFailed, err.Error(), nil)
+		return
+	}
+	fields := map[string]interface{}{
+		"status":      schemas.ImportJobQueued,
+		"store_name":  data.StoreName,
+		"items_count": len(confirmed.Receipt.Items),
+		"total":       data.Total,
+		"payload":     string(payload),
+	}
+	if accessKey != nil {
+		fields["access_key"] = accessKey.Key
+	}
+	updateImportJob(jobID, fields)
+
+	// 🤖 Categorização: poucas por vez e só com espaço na fila da IA
+	aiSlots <- struct{}{}
+	defer func() { <-aiSlots }()
+
+	if workerPool := config.GetAIWorkerPool(); workerPool != nil {
+		deadline := time.Now().Add(batchQueueWait)
+		for workerPool.IsQueueFull() && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+		}
+	}
+	if err := checkAITokenLimit(userID); err != nil {
+		finishImportJob(jobID, schemas.ImportJobFailed, err.Error(), nil)
+		return
+	}
+
+	handedOff = true
+	runImportJob(jobID, userID, confirmed, accessKey)
+}
+
+// retryBatchImportJob executa novamente um job de lote que falhou antes do scraping terminar
+// (portal fora do ar, por exemplo). Retorna false quando já respondeu a requisição.
+func retryBatchImportJob(ctx *gin.Context, userID uint, job *schemas.ImportJob) bool {
+	item := batchImportItem{JobID: job.ID, URL: job.SourceURL}
+	if key := nfce.AccessKeyFromURL(job.SourceURL); key != "" {
+		accessKey, err := nfce.ParseAccessKey(key)
+		if err != nil {
+			sendError(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid access key: %v", err.Error()))
+			return false
+		}
+		if !reserveImportAccessKey(ctx, userID, accessKey) {
+			return false
+		}
+		item.AccessKey = accessKey
+	}
+
+	result := db.Model(&schemas.ImportJob{}).
+		Where("id = ? AND status = ?", job.ID, schemas.ImportJobFailed).
+		Updates(map[string]interface{}{"status": schemas.ImportJobQueued, "error": ""})
+	if result.Error != nil || result.RowsAffected == 0 {
+		if item.AccessKey != nil {
+			releaseAccessKey(userID, item.AccessKey.Key)
+		}
+		if result.Error != nil {
+			logger.ErrorF("error updating import job %d: %v", job.ID, result.Error.Error())
+			sendError(ctx, http.StatusInternalServerError, "Error retrying import")
+			return false
+		}
+		sendError(ctx, http.StatusConflict, "Import is already being retried")
+		return false
+	}
+	job.Status = schemas.ImportJobQueued
+	job.Error = ""
+
+	go runBatchImportItem(userID, item, make(chan struct{}, 1))
+	return true
+}
+
+// ScanQRCodeBatchHandler importa várias notas de uma vez a partir das URLs dos QR Codes
+// @Summary Importar QR Codes em lote
+// @Description Recebe até 100 URLs de QR Code de NFC-e e importa todas em background, sem preview: cada URL vira um job de importação (consulta ao portal, categorização com IA e gravação). As consultas são limitadas por portal da SEFAZ e as categorizações passam pelo AIWorkerPool. Notas repetidas no lote ou já importadas são marcadas como skipped. Acompanhe por GET /scan-qrcode/batch/{id}
+// @Tags receipts
+// @Accept json
+// @Produce json
+// @Param request body ScanQRCodeBatchRequest true "URLs dos QR Codes"
+// @Success 202 {object} schemas.ImportBatchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse "Limite de tokens da IA atingido"
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /scan-qrcode/batch [post]
+func ScanQRCodeBatchHandler(ctx *gin.Context) {
+	var request ScanQRCodeBatchRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Envie entre 1 e %d URLs em urls", batchMaxURLs))
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	uid := userID.(uint)
+
+	if err := checkAITokenLimit(uid); err != nil {
+		sendError(ctx, http.StatusForbidden, err.Error())
+		return
+	}
+	if config.GetAIWorkerPool() == nil {
+		logger.ErrorF("❌ Worker Pool not initialized")
+		sendError(ctx, http.StatusInternalServerError, "Sistema de IA não está disponível no momento")
+		return
+	}
+
+	jobs := make([]schemas.ImportJob, len(request.URLs))
+	keys := make([]*nfce.AccessKey, len(request.URLs))
+	releaseKeys := func() {
+		for _, key := range keys {
+			if key != nil {
+				releaseAccessKey(uid, key.Key)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, url := range request.URLs {
+		job, key, err := prepareBatchJob(uid, strings.TrimSpace(url), seen)
+		if err != nil {
+			releaseKeys()
+			logger.ErrorF("error checking duplicate receipt: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Error checking for duplicate receipts")
+			return
+		}
+		jobs[i], keys[i] = job, key
+	}
+
+	batch := schemas.ImportBatch{UserID: uid, URLCount: len(request.URLs)}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		for i := range jobs {
+			jobs[i].BatchID = &batch.ID
+		}
+		return tx.Create(&jobs).Error
+	})
+	if err != nil {
+		releaseKeys()
+		logger.ErrorF("error creating import batch: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error creating import batch")
+		return
+	}
+
+	items := []batchImportItem{}
+	for i, job := range jobs {
+		if job.Status == schemas.ImportJobQueued {
+			items = append(items, batchImportItem{JobID: job.ID, URL: job.SourceURL, AccessKey: keys[i]})
+		}
+	}
+	go runImportBatch(batch.ID, uid, items)
+
+	logger.InfoF("📦 Import batch %d queued for user %d: %d URLs (%d to import)", batch.ID, uid, len(jobs), len(items))
+	ctx.JSON(http.StatusAccepted, batch.ToResponse(jobs))
+}
+
+// GetImportBatchHandler retorna o andamento de um lote de importação
+// @Summary Obter lote de importação
+// @Description Retorna o status de cada URL do lote (queued, scraping, categorizing, saving, done, failed ou skipped), com o recibo criado ou o motivo da falha, e a contagem por status
+// @Tags receipts
+// @Produce json
+// @Param id path int true "Import batch ID"
+// @Success 200 {object} schemas.ImportBatchResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /scan-qrcode/batch/{id} [get]
+func GetImportBatchHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var batch schemas.ImportBatch
+	if err := db.Where("user_id = ?", userID).First(&batch, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Import batch not found")
+		return
+	}
+
+	var jobs []schemas.ImportJob
+	if err := db.Where("batch_id = ?", batch.ID).Order("id").Find(&jobs).Error; err != nil {
+		logger.ErrorF("error loading import batch jobs: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error loading import batch")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, batch.ToResponse(jobs))
+}
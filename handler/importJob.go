@@ -0,0 +1,639 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// importJobHeartbeatInterval é a frequência com que cada instância da API confirma que os jobs
+	// que ela está processando continuam vivos (atualizando updated_at).
+	importJobHeartbeatInterval = time.Minute
+	// importJobStaleAfter é quanto tempo um job em andamento pode ficar sem heartbeat nem progresso
+	// antes de ser considerado interrompido (a instância que o processava parou).
+	importJobStaleAfter = 5 * time.Minute
+)
+
+var (
+	importJobWatchdogOnce sync.Once
+	// runningImportJobs conta as execuções em andamento nesta instância, por job.
+	runningImportJobs   = map[uint]int{}
+	runningImportJobsMu sync.Mutex
+	// unfinishedImportJobStatuses são as etapas de um job que ainda está sendo processado.
+	unfinishedImportJobStatuses = []schemas.ImportJobStatus{schemas.ImportJobQueued, schemas.ImportJobScraping, schemas.ImportJobCategorizing, schemas.ImportJobSaving}
+)
+
+// trackImportJob registra que esta instância está processando o job, para o heartbeat.
+// A função retornada encerra o registro.
+func trackImportJob(jobID uint) func() {
+	runningImportJobsMu.Lock()
+	runningImportJobs[jobID]++
+	runningImportJobsMu.Unlock()
+	return func() {
+		runningImportJobsMu.Lock()
+		if runningImportJobs[jobID]--; runningImportJobs[jobID] <= 0 {
+			delete(runningImportJobs, jobID)
+		}
+		runningImportJobsMu.Unlock()
+	}
+}
+
+// StartImportJobWatchdog inicia (uma única vez) o worker que mantém o heartbeat dos jobs desta
+// instância e marca como falhos os jobs sem heartbeat há mais de importJobStaleAfter. Com várias
+// instâncias da API, cada uma só renova os próprios jobs, então nenhuma falha os jobs de outra
+// que continua rodando.
+func StartImportJobWatchdog() {
+	importJobWatchdogOnce.Do(func() {
+		go func() {
+			failStaleImportJobs()
+			ticker := time.NewTicker(importJobHeartbeatInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				heartbeatImportJobs()
+				failStaleImportJobs()
+			}
+		}()
+		logger.InfoF("💓 Import job watchdog started (heartbeat every %s, stale after %s)", importJobHeartbeatInterval, importJobStaleAfter)
+	})
+}
+
+// heartbeatImportJobs renova updated_at dos jobs em andamento nesta instância.
+func heartbeatImportJobs() {
+	runningImportJobsMu.Lock()
+	ids := make([]uint, 0, len(runningImportJobs))
+	for id := range runningImportJobs {
+		ids = append(ids, id)
+	}
+	runningImportJobsMu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	if err := db.Model(&schemas.ImportJob{}).Where("id IN ? AND status IN ?", ids, unfinishedImportJobStatuses).
+		UpdateColumn("updated_at", time.Now()).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to update import job heartbeats: %v", err)
+	}
+}
+
+// failStaleImportJobs marca como falhos os jobs em andamento sem heartbeat recente: a instância
+// que os processava parou. O processamento roda em goroutines, então não há como retomá-los;
+// o app pode reenviá-los por POST /imports/:id/retry.
+func failStaleImportJobs() {
+	now := time.Now()
+	result := db.Model(&schemas.ImportJob{}).
+		Where("status IN ? AND updated_at < ?", unfinishedImportJobStatuses, now.Add(-importJobStaleAfter)).
+		Updates(map[string]interface{}{
+			"status":      schemas.ImportJobFailed,
+			"error":       "Processamento interrompido: a instância do servidor que executava o job parou",
+			"finished_at": now,
+		})
+	if result.Error != nil {
+		logger.ErrorF("⚠️  Failed to fail interrupted import jobs: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		logger.WarnF("⚠️  %d interrupted import job(s) marked as failed", result.RowsAffected)
+	}
+}
+
+// activeImportItems retorna os itens não removidos pelo usuário no preview.
+func activeImportItems(items []PreviewItem) []PreviewItem {
+	active := []PreviewItem{}
+	for _, item := range items {
+		if !item.Deleted {
+			active = append(active, item)
+		}
+	}
+	return active
+}
+
+// reserveImportAccessKey verifica se a chave já foi importada ou está em processamento e,
+// se estiver livre, a reserva. Retorna false quando já respondeu a requisição (409/500).
+func reserveImportAccessKey(ctx *gin.Context, userID uint, accessKey *nfce.AccessKey) bool {
+	existing, err := findReceiptByAccessKey(userID, accessKey.Key)
+	if err != nil {
+		logger.ErrorF("error checking duplicate receipt: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error checking for duplicate receipt")
+		return false
+	}
+	if existing != nil {
+		logger.WarnF("⚠️  Duplicate NFC-e for user %d: key %s already in receipt %d", userID, accessKey.Key, existing.ID)
+		sendDuplicateReceipt(ctx, "This NFC-e has already been imported", existing.ID, nil)
+		return false
+	}
+	if !reserveAccessKey(userID, accessKey.Key) {
+		sendDuplicateReceipt(ctx, "This NFC-e is already being processed", 0, nil)
+		return false
+	}
+	return true
+}
+
+// updateImportJob grava as colunas informadas do job, registrando falhas apenas no log.
+func updateImportJob(jobID uint, fields map[string]interface{}) {
+	if err := db.Model(&schemas.ImportJob{}).Where("id = ?", jobID).Updates(fields).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to update import job %d: %v", jobID, err)
+	}
+}
+
+// runImportJob executa em background a categorização e a gravação de uma nota confirmada,
+// registrando cada etapa no ImportJob para que o app acompanhe o progresso.
+func runImportJob(jobID, userID uint, confirmed confirmedImport, accessKey *nfce.AccessKey) {
+	defer trackImportJob(jobID)()
+	if accessKey != nil {
+		defer releaseAccessKey(userID, accessKey.Key)
+	}
+
+	startedAt := time.Now()
+	updateImportJob(jobID, map[string]interface{}{
+		"status":      schemas.ImportJobCategorizing,
+		"started_at":  startedAt,
+		"finished_at": nil,
+		"error":       "",
+		"warnings":    "",
+		"attempts":    gorm.Expr("attempts + 1"),
+	})
+
+	receipt, err := processImportJob(jobID, userID, confirmed, accessKey)
+	finishedAt := time.Now()
+	if err != nil {
+		logger.ErrorF("❌ [Import %d] Failed: %v", jobID, err)
+		updateImportJob(jobID, map[string]interface{}{
+			"status":      schemas.ImportJobFailed,
+			"error":       err.Error(),
+			"finished_at": finishedAt,
+		})
+		return
+	}
+
+	updateImportJob(jobID, map[string]interface{}{
+		"status":      schemas.ImportJobDone,
+		"receipt_id":  receipt.ID,
+		"finished_at": finishedAt,
+	})
+	logger.InfoF("🎉 [Import %d] Complete! Receipt ID: %d, Items: %d, Total time: %.2fs",
+		jobID, receipt.ID, len(receipt.Items), finishedAt.Sub(startedAt).Seconds())
+}
+
+// processImportJob categoriza os itens com IA (via Worker Pool) e salva o recibo numa transação.
+// Itens cuja categoria foi escolhida pelo usuário na confirmação, vem das regras dele ou está no
+// histórico dele não passam pela IA.
+func processImportJob(jobID, userID uint, confirmed confirmedImport, accessKey *nfce.AccessKey) (*schemas.Receipt, error) {
+	activeItems := activeImportItems(confirmed.Receipt.Items)
+	if len(activeItems) == 0 {
+		return nil, fmt.Errorf("all items were deleted, cannot save empty receipt")
+	}
+
+	byRule, resolved := confirmed.RuleCategories, confirmed.ResolvedCategories
+	if byRule == nil && resolved == nil {
+		byRule, resolved = localCategoriesForImport(userID, confirmed.Receipt, activeItems)
+	}
+
+	// Monta mapa tempID -> categoryID: escolhas do usuário, depois as regras e o histórico
+	categoryMap := make(map[int]uint)
+	pendingItems := []PreviewItem{}
+	resolvedByRules, resolvedLocally := 0, 0
+	for _, item := range activeItems {
+		if item.CategoryID != 0 {
+			categoryMap[item.TempID] = item.CategoryID
+		} else if categoryID, ok := byRule[item.TempID]; ok {
+			categoryMap[item.TempID] = categoryID
+			resolvedByRules++
+		} else if categoryID, ok := resolved[item.TempID]; ok {
+			categoryMap[item.TempID] = categoryID
+			resolvedLocally++
+		} else {
+			pendingItems = append(pendingItems, item)
+		}
+	}
+	updateImportJob(jobID, map[string]interface{}{
+		"resolved_by_rules": resolvedByRules,
+		"resolved_locally":  resolvedLocally,
+		"resolved_by_ai":    0,
+	})
+
+	if len(pendingItems) > 0 {
+		logger.InfoF("📚 [Import %d] %d item(s) categorized by rules, %d from history, %d sent to the AI", jobID, resolvedByRules, resolvedLocally, len(pendingItems))
+		resolvedByAI, err := categorizeImportItems(jobID, userID, pendingItems, categoryMap)
+		if err != nil {
+			return nil, err
+		}
+		// Só conta os itens que a IA categorizou; os que caíram em "Não categorizado" ficam de fora
+		updateImportJob(jobID, map[string]interface{}{"resolved_by_ai": resolvedByAI})
+	} else {
+		logger.InfoF("⏭️  [Import %d] All items categorized by the user, rules or history, skipping AI", jobID)
+	}
+
+	// 💾 ETAPA 2: Salvar no banco de dados
+	updateImportJob(jobID, map[string]interface{}{"status": schemas.ImportJobSaving})
+	return saveImportedReceipt(userID, confirmed, activeItems, categoryMap, accessKey)
+}
+
+// categorizeImportItems envia os itens ao Worker Pool da IA e grava as categorias em categoryMap.
+// Retorna quantos itens receberam uma categoria válida da IA.
+func categorizeImportItems(jobID, userID uint, activeItems []PreviewItem, categoryMap map[int]uint) (int, error) {
+	// 🤖 ETAPA 1: Categorização com IA usando Worker Pool
+	startAI := time.Now()
+	logger.InfoF("🤖 [Import %d] Submitting AI categorization job for %d items...", jobID, len(activeItems))
+
+	// Converte para formato NFCeItem para usar a função existente
+	nfceItems := make([]NFCeItem, len(activeItems))
+	for i, item := range activeItems {
+		nfceItems[i] = NFCeItem{
+			ItemNumber:  item.TempID,
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitPrice,
+			Total:       item.Total,
+		}
+	}
+
+	workerPool := config.GetAIWorkerPool()
+	if workerPool == nil {
+		return 0, fmt.Errorf("AI worker pool is not available")
+	}
+
+	// Canal para receber resultado do Worker Pool
+	resultChan := make(chan struct {
+		result *CategorizationResult
+		err    error
+	}, 1)
+
+	jobCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	job := config.AIJob{
+		ID:      fmt.Sprintf("import-%d-%d", jobID, time.Now().Unix()),
+		UserID:  userID,
+		Items:   nfceItems,
+		Context: jobCtx,
+		Callback: func(items interface{}, err error) {
+			if err != nil {
+				resultChan <- struct {
+					result *CategorizationResult
+					err    error
+				}{nil, err}
+				return
+			}
+
+			// Processar categorização com IA
+			result, aiErr := categorizeItemsWithAI(jobCtx, items.([]NFCeItem), userID)
+			resultChan <- struct {
+				result *CategorizationResult
+				err    error
+			}{result, aiErr}
+		},
+	}
+
+	if err := workerPool.SubmitJob(job); err != nil {
+		return 0, fmt.Errorf("could not submit AI categorization: %w", err)
+	}
+
+	var categorizationResult *CategorizationResult
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			return 0, fmt.Errorf("AI categorization error: %w", result.err)
+		}
+		categorizationResult = result.result
+	case <-jobCtx.Done():
+		return 0, fmt.Errorf("AI categorization timed out")
+	}
+
+	logger.InfoF("✅ [Import %d] AI categorization completed in %.2fs", jobID, time.Since(startAI).Seconds())
+
+	// Registra uso de tokens da IA automaticamente (em background)
+	go func() {
+		err := recordAITokenUsageInternal(
+			userID,
+			categorizationResult.PromptTokens,
+			categorizationResult.ResponseTokens,
+			categorizationResult.Model,
+			"/scan-qrcode/confirm",
+		)
+		if err != nil {
+			logger.ErrorF("⚠️  Failed to record AI token usage: %v", err)
+		}
+	}()
+
+	// Itens sem categoria válida foram para "Não categorizado"; o motivo fica registrado no job
+	if len(categorizationResult.Warnings) > 0 {
+		logger.WarnF("⚠️  [Import %d] %d item(s) could not be categorized by the AI", jobID, len(categorizationResult.Warnings))
+		updateImportJob(jobID, map[string]interface{}{"warnings": strings.Join(categorizationResult.Warnings, "\n")})
+	}
+
+	for i, categorizedItem := range categorizationResult.Items {
+		if i < len(activeItems) {
+			categoryMap[activeItems[i].TempID] = categorizedItem.CategoryID
+		}
+	}
+
+	return categorizationResult.Resolved, nil
+}
+
+// saveImportedReceipt grava o recibo e seus itens (buscando ou criando os produtos) numa transação.
+func saveImportedReceipt(userID uint, confirmed confirmedImport, activeItems []PreviewItem, categoryMap map[int]uint, accessKey *nfce.AccessKey) (*schemas.Receipt, error) {
+	data := confirmed.Receipt
+
+	// Notas importadas por foto não têm chave de acesso
+	notes := ""
+	if confirmed.Source == "image" {
+		notes = "Importada por foto"
+	}
+
+	receipt := schemas.Receipt{
+		UserID:     userID,
+		StoreName:  data.StoreName,
+		Date:       data.Date,
+		Total:      data.Total,
+		Subtotal:   data.Subtotal,
+		Discount:   data.Discount,
+		Currency:   currency.NormalizeOr(data.Currency, currency.Default), // Rascunhos antigos não têm moeda
+		Confidence: confirmed.Confidence,
+		Notes:      notes,
+		QRCodeURL:  data.QRCodeURL,
+		Change:     data.Change,
+		Payments:   receiptPaymentsFromPreview(data.Payments),
+
+		Freight:      data.Freight,
+		OtherCharges: data.OtherCharges,
+	}
+	if accessKey != nil {
+		receipt.ApplyAccessKey(accessKey)
+	} else {
+		receipt.FiscalNumber = data.Number
+	}
+
+	// 🧮 Confere os valores dos itens confirmados; itens removidos pelo usuário não entram na soma
+	amounts := receiptAmounts{Subtotal: receipt.Subtotal, Discount: receipt.Discount, Charges: receipt.Charges(), Total: receipt.Total}
+	for _, item := range activeItems {
+		amounts.Items = append(amounts.Items, itemAmounts{Quantity: item.Quantity, UnitPrice: item.UnitPrice, Total: item.Total, Discount: item.Discount})
+	}
+	options := defaultValidationOptions()
+	options.PartialItems = len(activeItems) < len(data.Items)
+	receipt.ApplyValidation(validateReceiptAmounts(&amounts, options))
+	receipt.Subtotal, receipt.Discount, receipt.Total = amounts.Subtotal, amounts.Discount, amounts.Total
+	activeItems = append([]PreviewItem(nil), activeItems...)
+	for i := range activeItems {
+		activeItems[i].UnitPrice = amounts.Items[i].UnitPrice
+		activeItems[i].Total = amounts.Items[i].Total
+	}
+
+	// Rateia o desconto da nota entre todos os itens lidos (inclusive os removidos pelo usuário),
+	// assim cada item confirmado fica só com a sua parte
+	totals := make([]float64, len(data.Items))
+	discounts := make([]float64, len(data.Items))
+	for i, item := range data.Items {
+		totals[i], discounts[i] = item.Total, item.Discount
+	}
+	itemDiscounts := make(map[int]float64, len(data.Items))
+	for i, discount := range nfce.AllocateDiscount(totals, discounts, receipt.Discount) {
+		itemDiscounts[data.Items[i].TempID] = discount
+	}
+
+	if data.Taxes != nil {
+		receipt.TaxTotal = data.Taxes.Total
+		receipt.TaxFederal = data.Taxes.Federal
+		receipt.TaxState = data.Taxes.State
+		receipt.TaxMunicipal = data.Taxes.Municipal
+	}
+	if len(confirmed.EditedFields) > 0 {
+		edited, err := json.Marshal(confirmed.EditedFields)
+		if err != nil {
+			return nil, err
+		}
+		receipt.EditedFields = string(edited)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Vincula à loja pelo CNPJ do emitente
+		store, err := findOrCreateStore(tx, importStoreInfo(data, accessKey))
+		if err != nil {
+			return fmt.Errorf("error saving store: %w", err)
+		}
+		if store != nil {
+			receipt.StoreID = &store.ID
+		}
+
+		if err := tx.Create(&receipt).Error; err != nil {
+			return fmt.Errorf("error creating receipt: %w", err)
+		}
+		if confirmed.DraftID != 0 {
+			if err := attachDraftImages(tx, userID, confirmed.DraftID, receipt.ID); err != nil {
+				return fmt.Errorf("error attaching receipt images: %w", err)
+			}
+		}
+
+		for _, item := range activeItems {
+			categoryID := categoryMap[item.TempID]
+			if categoryID == 0 {
+				// Fallback para categoria "Outros" DO USUÁRIO
+				var defaultCategory schemas.Category
+				if err := tx.Where("name = ? AND user_id = ?", "Outros", userID).First(&defaultCategory).Error; err == nil {
+					categoryID = defaultCategory.ID
+					logger.InfoF("⚠️  Using default category 'Outros' (ID: %d) for item #%d", categoryID, item.TempID)
+				}
+			}
+
+			// Busca ou cria o produto (GTIN, código da loja e, por fim, nome + unidade)
+			product, err := findOrCreateProduct(tx, productMatch{
+				Name:    item.Description,
+				Unit:    normalizeUnit(item.Unit),
+				Code:    item.Code,
+				GTIN:    item.GTIN,
+				StoreID: receipt.StoreID,
+			})
+			if err != nil {
+				return err
+			}
+
+			receiptItem := schemas.ReceiptItem{
+				ReceiptID:   receipt.ID,
+				CategoryID:  categoryID,
+				ProductID:   product.ID,
+				Description: item.Description,
+				Quantity:    item.Quantity,
+				Unit:        item.Unit,
+				UnitPrice:   item.UnitPrice,
+				Total:       item.Total,
+				Code:        item.Code,
+			}
+			receiptItem.ApplyDiscount(itemDiscounts[item.TempID])
+			if err := tx.Create(&receiptItem).Error; err != nil {
+				return fmt.Errorf("error creating receipt item: %w", err)
+			}
+			receipt.Items = append(receipt.Items, receiptItem)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// GetImportJobsHandler lista os jobs de importação do usuário autenticado
+// @Summary Listar importações
+// @Description Lista as importações de notas (confirmações) do usuário, da mais recente para a mais antiga. Filtro opcional por status
+// @Tags imports
+// @Produce json
+// @Param status query string false "Filtrar por status (queued, scraping, categorizing, saving, done, failed, skipped)"
+// @Param limit query int false "Máximo de registros (padrão 50, máximo 200)"
+// @Success 200 {array} schemas.ImportJobResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /imports [get]
+func GetImportJobsHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	limit := 50
+	if value, err := strconv.Atoi(ctx.Query("limit")); err == nil && value > 0 {
+		limit = value
+		if limit > 200 {
+			limit = 200
+		}
+	}
+
+	query := db.Where("user_id = ?", userID)
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var jobs []schemas.ImportJob
+	if err := query.Order("created_at DESC").Limit(limit).Find(&jobs).Error; err != nil {
+		logger.ErrorF("error listing import jobs: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error listing imports")
+		return
+	}
+
+	responses := make([]schemas.ImportJobResponse, len(jobs))
+	for i, job := range jobs {
+		responses[i] = job.ToResponse()
+	}
+
+	ctx.JSON(http.StatusOK, responses)
+}
+
+// GetImportJobHandler retorna o estado de um job de importação
+// @Summary Obter importação
+// @Description Retorna a etapa atual de uma importação. Quando status = done, receiptId aponta para o recibo salvo; quando failed, error traz o motivo
+// @Tags imports
+// @Produce json
+// @Param id path int true "Import job ID"
+// @Success 200 {object} schemas.ImportJobResponse
+// @Failure 404 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /imports/{id} [get]
+func GetImportJobHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var job schemas.ImportJob
+	if err := db.Where("user_id = ?", userID).First(&job, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Import job not found")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job.ToResponse())
+}
+
+// RetryImportJobHandler reprocessa uma importação que falhou
+// @Summary Reprocessar importação
+// @Description Executa novamente a categorização e a gravação de uma importação com status failed, usando os dados confirmados originalmente
+// @Tags imports
+// @Produce json
+// @Param id path int true "Import job ID"
+// @Success 202 {object} schemas.ImportJobResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse
+// @Security BearerAuth
+// @Router /imports/{id}/retry [post]
+func RetryImportJobHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var job schemas.ImportJob
+	if err := db.Where("user_id = ?", userID).First(&job, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Import job not found")
+		return
+	}
+
+	if job.Status != schemas.ImportJobFailed {
+		sendError(ctx, http.StatusConflict, fmt.Sprintf("Only failed imports can be retried (current status: %s)", job.Status))
+		return
+	}
+
+	// Jobs de lote que falharam antes do scraping terminar ainda não têm os dados da nota
+	if job.Payload == "" && job.SourceURL != "" {
+		if retryBatchImportJob(ctx, userID.(uint), &job) {
+			logger.InfoF("🔁 Retrying batch import job %d from its QR Code URL", job.ID)
+			ctx.JSON(http.StatusAccepted, job.ToResponse())
+		}
+		return
+	}
+
+	// Jobs gravados antes dos rascunhos guardavam o formato antigo, sem "receipt"
+	var confirmed confirmedImport
+	if err := json.Unmarshal([]byte(job.Payload), &confirmed); err != nil || len(confirmed.Receipt.Items) == 0 {
+		logger.ErrorF("error decoding payload of import job %d: %v", job.ID, err)
+		sendError(ctx, http.StatusUnprocessableEntity, "Import data is not available for retry. Please scan the receipt again")
+		return
+	}
+
+	var accessKey *nfce.AccessKey
+	if confirmed.Receipt.AccessKey != "" {
+		key, err := nfce.ParseAccessKey(confirmed.Receipt.AccessKey)
+		if err != nil {
+			sendError(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid access key: %v", err.Error()))
+			return
+		}
+		accessKey = key
+		if !reserveImportAccessKey(ctx, userID.(uint), accessKey) {
+			return
+		}
+	}
+
+	if err := checkAITokenLimit(userID.(uint)); err != nil {
+		if accessKey != nil {
+			releaseAccessKey(userID.(uint), accessKey.Key)
+		}
+		sendError(ctx, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Só quem tira o job de failed relança: dois retries simultâneos não rodam a importação duas vezes
+	result := db.Model(&schemas.ImportJob{}).
+		Where("id = ? AND status = ?", job.ID, schemas.ImportJobFailed).
+		Updates(map[string]interface{}{"status": schemas.ImportJobQueued, "error": ""})
+	if result.Error != nil || result.RowsAffected == 0 {
+		if accessKey != nil {
+			releaseAccessKey(userID.(uint), accessKey.Key)
+		}
+		if result.Error != nil {
+			logger.ErrorF("error updating import job %d: %v", job.ID, result.Error.Error())
+			sendError(ctx, http.StatusInternalServerError, "Error retrying import")
+			return
+		}
+		sendError(ctx, http.StatusConflict, "Import is already being retried")
+		return
+	}
+	job.Status = schemas.ImportJobQueued
+	job.Error = ""
+
+	logger.InfoF("🔁 Retrying import job %d (attempt %d)", job.ID, job.Attempts+1)
+	go runImportJob(job.ID, userID.(uint), confirmed, accessKey)
+
+	ctx.JSON(http.StatusAccepted, job.ToResponse())
+}
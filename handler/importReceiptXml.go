@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/gin-gonic/gin"
+)
+
+// maxReceiptXMLSize limita o tamanho do XML enviado (2 MB é bem acima de uma NF-e típica)
+const maxReceiptXMLSize = 2 << 20
+
+// ImportReceiptXMLHandler lê o XML oficial de uma NF-e/NFC-e sem salvar no banco
+// @Summary Preview de nota via XML (Etapa 1/2)
+// @Description Lê o XML autorizado da nota (nfeProc/procNFe), enviado como multipart no campo 'file' ou como corpo application/xml. Retorna o mesmo formato do preview via QR Code, com um draftId que pode ser confirmado em /scan-qrcode/confirm. XML sem protocolo de autorização (protNFe) ou com protocolo de rejeição/denegação responde 422
+// @Tags receipts
+// @Accept multipart/form-data
+// @Accept xml
+// @Produce json
+// @Param file formData file false "Arquivo XML da nota"
+// @Success 200 {object} ScanQRCodePreviewResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /receipt/import-xml [post]
+func ImportReceiptXMLHandler(ctx *gin.Context) {
+	var reader io.Reader
+	if strings.HasPrefix(ctx.ContentType(), "multipart/") {
+		fileHeader, err := ctx.FormFile("file")
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, "XML file is required in the 'file' field")
+			return
+		}
+		if fileHeader.Size > maxReceiptXMLSize {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("XML file is too large: maximum is %d MB", maxReceiptXMLSize>>20))
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			logger.ErrorF("error opening uploaded XML: %v", err.Error())
+			sendError(ctx, http.StatusBadRequest, "Could not read XML file")
+			return
+		}
+		defer file.Close()
+		reader = file
+	} else {
+		reader = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxReceiptXMLSize)
+	}
+
+	invoice, err := nfce.ParseXML(reader)
+	if err != nil {
+		logger.ErrorF("error parsing NF-e XML: %v", err.Error())
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Error reading NF-e XML: %v", err.Error()))
+		return
+	}
+
+	// Sem o protocolo de autorização (protNFe) o XML pode ter sido montado pelo cliente com
+	// valores inventados; só aceitamos a nota processada pela SEFAZ
+	if invoice.Protocol == nil {
+		sendError(ctx, http.StatusUnprocessableEntity, "NF-e XML has no authorization protocol (protNFe): upload the authorized XML (nfeProc) issued by SEFAZ")
+		return
+	}
+
+	// XML com protocolo de rejeição/denegação não representa uma compra válida
+	if !invoice.Protocol.Authorized() {
+		sendError(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("NF-e is not authorized by SEFAZ (status %s: %s)", invoice.Protocol.Status, invoice.Protocol.Reason))
+		return
+	}
+
+	if _, err := nfce.ParseAccessKey(invoice.AccessKey); err != nil {
+		sendError(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid NF-e access key in XML: %v", err.Error()))
+		return
+	}
+
+	logger.InfoF("📄 NF-e XML read: %s - %d items - Total: R$ %.2f (model %s)",
+		invoice.StoreName(), len(invoice.Items), invoice.Totals.Total, invoice.Model)
+
+	previewData := buildPreviewData(invoice.ToData(), "")
+
+	message := fmt.Sprintf("✅ Preview ready! %d items read from XML. You can now edit, remove items, or confirm to save.", len(previewData.Items))
+
+	userID, _ := ctx.Get("user_id")
+	draft, err := saveReceiptDraft(userID.(uint), "xml", previewData, 1.0)
+	if err != nil {
+		logger.ErrorF("error saving receipt draft: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error saving preview")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, ScanQRCodePreviewResponse{
+		Message:   message,
+		DraftID:   draft.ID,
+		ExpiresAt: draft.ExpiresAt,
+		Data:      previewData,
+	})
+}
@@ -1,13 +1,18 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
 	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // UpdateItemRequest define a estrutura para atualizar um item de recibo.
@@ -18,6 +23,7 @@ type UpdateItemRequest struct {
 	Quantity   *float64 `json:"quantity"`
 	UnitPrice  *float64 `json:"unitPrice"`
 	Total      *float64 `json:"total"`
+	Discount   *float64 `json:"discount" binding:"omitempty,gte=0"` // Desconto do item
 }
 
 // GetItemsHandler lida com a requisição para listar todos os itens de recibos do usuário autenticado.
@@ -175,6 +181,12 @@ func UpdateItemHandler(ctx *gin.Context) {
 	if request.Total != nil {
 		item.Total = *request.Total
 	}
+	// O valor líquido acompanha o total; a diferença de desconto vai para o desconto da nota
+	previousDiscount, discount := item.Discount, item.Discount
+	if request.Discount != nil {
+		discount = *request.Discount
+	}
+	item.ApplyDiscount(discount)
 
 	if err := db.Save(&item).Error; err != nil {
 		logger.ErrorF("error updating item: %v", err.Error())
@@ -200,7 +212,9 @@ func UpdateItemHandler(ctx *gin.Context) {
 
 	// Atualiza totais da nota
 	receipt.Subtotal = newSubtotal
-	receipt.Total = newSubtotal - receipt.Discount
+	receipt.Discount = roundCents(math.Max(receipt.Discount+item.Discount-previousDiscount, 0))
+	receipt.Total = newSubtotal - receipt.Discount + receipt.Charges()
+	revalidateReceipt(&receipt, receipt.Items)
 
 	if err := db.Save(&receipt).Error; err != nil {
 		logger.ErrorF("error updating receipt totals: %v", err.Error())
@@ -218,6 +232,7 @@ func UpdateItemHandler(ctx *gin.Context) {
 			"discount":   receipt.Discount,
 			"total":      receipt.Total,
 			"itemsCount": len(receipt.Items),
+			"validation": receipt.Validation(),
 		},
 	})
 }
@@ -235,7 +250,7 @@ func UpdateItemHandler(ctx *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /item/{id} [delete]
 // @Summary Delete receipt item
-// @Description Soft delete a receipt item (sets deleted_at timestamp). Note: The associated product is NOT deleted as it may be referenced by other items.
+// @Description Soft delete a receipt item (sets deleted_at timestamp). Note: The associated product is NOT deleted as it may be referenced by other items. The item goes to the trash (GET /trash) and can be restored until it is purged.
 // @Tags items
 // @Accept json
 // @Produce json
@@ -292,9 +307,11 @@ func DeleteItemHandler(ctx *gin.Context) {
 		newSubtotal += receiptItem.Total
 	}
 
-	// Atualiza totais da nota
+	// Atualiza totais da nota; a parte do desconto que cabia ao item sai junto com ele
 	receipt.Subtotal = newSubtotal
-	receipt.Total = newSubtotal - receipt.Discount
+	receipt.Discount = roundCents(math.Max(receipt.Discount-item.Discount, 0))
+	receipt.Total = newSubtotal - receipt.Discount + receipt.Charges()
+	revalidateReceipt(&receipt, receipt.Items)
 
 	if err := db.Save(&receipt).Error; err != nil {
 		logger.ErrorF("error updating receipt totals after deletion: %v", err.Error())
@@ -316,19 +333,25 @@ func DeleteItemHandler(ctx *gin.Context) {
 			"discount":   receipt.Discount,
 			"total":      receipt.Total,
 			"itemsCount": len(receipt.Items),
+			"validation": receipt.Validation(),
 		},
 	})
 }
 
+// maxRecategorizeItems limita quantos itens vão em uma única chamada à IA.
+const maxRecategorizeItems = 200
+
 // RecategorizeItemsRequest define a estrutura para requisição de recategorização
 type RecategorizeItemsRequest struct {
 	ItemIDs []uint `json:"itemIds" binding:"required"` // IDs dos items a serem recategorizados
+	DryRun  bool   `json:"dryRun"`                     // Apenas retorna as categorias sugeridas, sem alterar os items
 }
 
 // RecategorizeItemsResponse define a estrutura da resposta
 type RecategorizeItemsResponse struct {
 	Message            string                       `json:"message"`
-	ItemsRecategorized int                          `json:"itemsRecategorized"`
+	DryRun             bool                         `json:"dryRun"`
+	ItemsRecategorized int                          `json:"itemsRecategorized"` // Items com categoria alterada (ou que seriam, no dry run)
 	Results            []ItemRecategorizationResult `json:"results"`
 }
 
@@ -340,10 +363,11 @@ type ItemRecategorizationResult struct {
 	NewCategoryID   uint   `json:"newCategoryId"`
 	NewCategoryName string `json:"newCategoryName"`
 	Changed         bool   `json:"changed"`
+	Reason          string `json:"reason,omitempty"` // Por que a sugestão da IA não foi aplicada
 }
 
 // @Summary Recategorize items using AI
-// @Description Use Gemini AI to recategorize items. Useful for items in "Não categorizado" or items that need recategorization.
+// @Description Use the configured AI provider to recategorize items. Useful for items in "Não categorizado" or items that need recategorization. With dryRun=true the suggested categories are returned without changing the items.
 // @Tags items
 // @Accept json
 // @Produce json
@@ -352,7 +376,11 @@ type ItemRecategorizationResult struct {
 // @Success 200 {object} RecategorizeItemsResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse "Limite de tokens da IA atingido"
+// @Failure 404 {object} ErrorResponse
+// @Failure 408 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
 // @Router /items/recategorize [post]
 func RecategorizeItemsHandler(ctx *gin.Context) {
 	var request RecategorizeItemsRequest
@@ -367,12 +395,17 @@ func RecategorizeItemsHandler(ctx *gin.Context) {
 		sendError(ctx, http.StatusBadRequest, "At least one item ID is required")
 		return
 	}
+	if len(request.ItemIDs) > maxRecategorizeItems {
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("At most %d items can be recategorized at once", maxRecategorizeItems))
+		return
+	}
 
-	// Busca todos os items do usuário com preload de product e category
+	// Busca todos os items do usuário com preload de product e category (notas na lixeira ficam de fora)
 	var items []schemas.ReceiptItem
 	err := db.Preload("Product").Preload("Category").
 		Joins("INNER JOIN receipts ON receipts.id = receipt_items.receipt_id").
-		Where("receipt_items.id IN ? AND receipts.user_id = ?", request.ItemIDs, userID).
+		Where("receipt_items.id IN ? AND receipts.user_id = ? AND receipts.deleted_at IS NULL", request.ItemIDs, userID).
+		Order("receipt_items.id").
 		Find(&items).Error
 
 	if err != nil {
@@ -394,27 +427,153 @@ func RecategorizeItemsHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Prepara o prompt para o Gemini
-	prompt := buildRecategorizationPrompt(items, categories)
+	// O dry run também consome tokens da IA, então respeita o mesmo limite
+	if err := checkAITokenLimit(userID.(uint)); err != nil {
+		logger.ErrorF("❌ Token limit exceeded for user %d: %v", userID.(uint), err)
+		sendError(ctx, http.StatusForbidden, err.Error())
+		return
+	}
 
-	// Chama o Gemini AI para recategorizar
-	response, err := callGeminiForRecategorization(prompt)
-	if err != nil {
-		logger.ErrorF("error calling Gemini: %v", err.Error())
-		sendError(ctx, http.StatusInternalServerError, "Error calling AI service")
+	workerPool := config.GetAIWorkerPool()
+	if workerPool == nil {
+		logger.ErrorF("❌ Worker Pool not initialized")
+		sendError(ctx, http.StatusInternalServerError, "Sistema de IA não está disponível no momento")
 		return
 	}
+	if workerPool.IsQueueFull() {
+		sendError(ctx, http.StatusServiceUnavailable, "Sistema de IA está processando muitas requisições. Por favor, aguarde alguns minutos e tente novamente.")
+		return
+	}
+
+	// Canal para receber resultado do Worker Pool
+	resultChan := make(chan struct {
+		result *RecategorizationResult
+		err    error
+	}, 1)
+
+	jobCtx, cancel := context.WithTimeout(ctx.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	job := config.AIJob{
+		ID:      fmt.Sprintf("recategorize-%d-%d", userID.(uint), time.Now().Unix()),
+		UserID:  userID.(uint),
+		Items:   items,
+		Context: jobCtx,
+		Callback: func(jobItems interface{}, err error) {
+			if err != nil {
+				resultChan <- struct {
+					result *RecategorizationResult
+					err    error
+				}{nil, err}
+				return
+			}
 
-	// Processa a resposta e atualiza os items
-	results, itemsUpdated := applyRecategorization(items, response, categories)
+			result, aiErr := suggestCategories(jobCtx, aiProvider, jobItems.([]schemas.ReceiptItem), categories)
+			resultChan <- struct {
+				result *RecategorizationResult
+				err    error
+			}{result, aiErr}
+		},
+	}
+
+	if err := workerPool.SubmitJob(job); err != nil {
+		logger.ErrorF("❌ Failed to submit job to Worker Pool: %v", err)
+		sendError(ctx, http.StatusServiceUnavailable, fmt.Sprintf("Não foi possível processar sua requisição: %v", err))
+		return
+	}
+
+	var suggestion *RecategorizationResult
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			logger.ErrorF("❌ AI recategorization failed: %v", result.err)
+			sendError(ctx, http.StatusInternalServerError, "Error calling AI service")
+			return
+		}
+		suggestion = result.result
+	case <-jobCtx.Done():
+		logger.ErrorF("❌ AI recategorization timeout")
+		sendError(ctx, http.StatusRequestTimeout, "Processamento da IA demorou muito. Por favor, tente novamente.")
+		return
+	}
+
+	// Registra uso de tokens da IA automaticamente (em background), inclusive no dry run
+	go func() {
+		err := recordAITokenUsageInternal(
+			userID.(uint),
+			suggestion.PromptTokens,
+			suggestion.ResponseTokens,
+			suggestion.Model,
+			"/items/recategorize",
+		)
+		if err != nil {
+			logger.ErrorF("⚠️  Failed to record AI token usage: %v", err)
+		}
+	}()
+
+	results, changes := applyRecategorization(items, suggestion.Categorizations, categories)
+
+	if !request.DryRun && len(changes) > 0 {
+		if err := saveRecategorization(changes); err != nil {
+			logger.ErrorF("error saving recategorization: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Error updating items")
+			return
+		}
+	}
+
+	message := "Items recategorized successfully"
+	if request.DryRun {
+		message = "Suggested categories (dry run, no items were changed)"
+	}
 
 	ctx.JSON(http.StatusOK, RecategorizeItemsResponse{
-		Message:            "Items recategorized successfully",
-		ItemsRecategorized: itemsUpdated,
+		Message:            message,
+		DryRun:             request.DryRun,
+		ItemsRecategorized: len(changes),
 		Results:            results,
 	})
 }
 
+// ItemCategorization é a categoria sugerida pela IA para um item.
+type ItemCategorization struct {
+	ItemID     uint `json:"itemId"`
+	CategoryID uint `json:"categoryId"`
+}
+
+// RecategorizationResult contém as categorias sugeridas e os metadados de uso de tokens
+type RecategorizationResult struct {
+	Categorizations []ItemCategorization
+	Model           string
+	PromptTokens    int
+	ResponseTokens  int
+	TotalTokens     int
+}
+
+// suggestCategories pede ao provedor de IA uma nova categoria para cada item. A resposta é
+// validada, com uma chamada de reparo quando rejeitada; o que sobrar é tratado em applyRecategorization.
+func suggestCategories(ctx context.Context, provider llm.AIProvider, items []schemas.ReceiptItem, categories []schemas.Category) (*RecategorizationResult, error) {
+	request := llm.Request{Prompt: buildRecategorizationPrompt(items, categories), Schema: recategorizationSchema}
+
+	index := newCategoryIndex(categories)
+	var parsed struct {
+		Categorizations []ItemCategorization `json:"categorizations"`
+	}
+	usage, _, err := generateJSON(ctx, provider, request, &parsed, func() []string {
+		return validateRecategorizations(items, parsed.Categorizations, index)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecategorizationResult{
+		Categorizations: parsed.Categorizations,
+		Model:           usage.Model,
+		PromptTokens:    usage.PromptTokens,
+		ResponseTokens:  usage.ResponseTokens,
+		TotalTokens:     usage.TotalTokens,
+	}, nil
+}
+
 func buildRecategorizationPrompt(items []schemas.ReceiptItem, categories []schemas.Category) string {
 	var builder strings.Builder
 
@@ -499,16 +658,87 @@ func buildRecategorizationPrompt(items []schemas.ReceiptItem, categories []schem
 	return builder.String()
 }
 
-// Funções auxiliares serão implementadas aqui
-func callGeminiForRecategorization(prompt string) (map[string]interface{}, error) {
-	// TODO: Implementar chamada real ao Gemini
-	// Por enquanto retorna um mock
-	return map[string]interface{}{
-		"categorizations": []map[string]interface{}{},
-	}, nil
+// applyRecategorization compara as sugestões da IA com a categoria atual de cada item.
+// Sugestões para items fora da lista, com categorias que não são do usuário ou para
+// "Não categorizado" são descartadas: o item mantém a categoria atual (que já é "Não categorizado"
+// para os items ainda sem categoria) e o motivo vai em Reason. Retorna o resultado de cada item,
+// na ordem recebida, e as novas categorias dos items que mudam (item ID -> categoria).
+func applyRecategorization(items []schemas.ReceiptItem, categorizations []ItemCategorization, categories []schemas.Category) ([]ItemRecategorizationResult, map[uint]uint) {
+	index := newCategoryIndex(categories)
+
+	suggested := make(map[uint]uint, len(categorizations))
+	rejected := make(map[uint]uint)
+	for _, categorization := range categorizations {
+		if _, seen := suggested[categorization.ItemID]; seen {
+			continue
+		}
+		if !index.valid(categorization.CategoryID) {
+			if _, seen := rejected[categorization.ItemID]; !seen {
+				rejected[categorization.ItemID] = categorization.CategoryID
+			}
+			continue
+		}
+		suggested[categorization.ItemID] = categorization.CategoryID
+	}
+
+	results := make([]ItemRecategorizationResult, 0, len(items))
+	changes := make(map[uint]uint)
+	for _, item := range items {
+		result := ItemRecategorizationResult{
+			ItemID:        item.ID,
+			OldCategoryID: item.CategoryID,
+			NewCategoryID: item.CategoryID,
+		}
+		if item.Product != nil {
+			result.ProductName = item.Product.Name
+		}
+		if item.Category != nil {
+			result.OldCategoryName = item.Category.Name
+		}
+		result.NewCategoryName = result.OldCategoryName
+
+		categoryID, ok := suggested[item.ID]
+		rejectedID, wasRejected := rejected[item.ID]
+		switch {
+		case ok && categoryID != item.CategoryID:
+			result.NewCategoryID = categoryID
+			result.NewCategoryName = index.byID[categoryID].Name
+			result.Changed = true
+			changes[item.ID] = categoryID
+		case ok:
+			// A IA confirmou a categoria atual
+		case wasRejected:
+			result.Reason = fmt.Sprintf("a IA sugeriu a categoria inválida %d; categoria mantida", rejectedID)
+		default:
+			result.Reason = "a IA não sugeriu uma categoria; categoria mantida"
+		}
+		results = append(results, result)
+	}
+
+	return results, changes
 }
 
-func applyRecategorization(items []schemas.ReceiptItem, response map[string]interface{}, categories []schemas.Category) ([]ItemRecategorizationResult, int) {
-	// TODO: Implementar aplicação das categorizações
-	return []ItemRecategorizationResult{}, 0
+// recategorizeUpdateChunk limita quantos IDs vão no IN de cada update, longe do limite de
+// parâmetros do Postgres quando uma regra ou a IA move milhares de itens.
+const recategorizeUpdateChunk = 1000
+
+// saveRecategorization grava as novas categorias numa única transação, com um update por categoria
+// (dividido em blocos de recategorizeUpdateChunk itens).
+func saveRecategorization(changes map[uint]uint) error {
+	itemsByCategory := make(map[uint][]uint)
+	for itemID, categoryID := range changes {
+		itemsByCategory[categoryID] = append(itemsByCategory[categoryID], itemID)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for categoryID, itemIDs := range itemsByCategory {
+			for start := 0; start < len(itemIDs); start += recategorizeUpdateChunk {
+				chunk := itemIDs[start:min(start+recategorizeUpdateChunk, len(itemIDs))]
+				if err := tx.Model(&schemas.ReceiptItem{}).Where("id IN ?", chunk).Update("category_id", categoryID).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 }
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+func recategorizationFixture() ([]schemas.ReceiptItem, []schemas.Category) {
+	categories := testCategories()
+
+	items := []schemas.ReceiptItem{
+		{CategoryID: 1, Category: &categories[0], Product: &schemas.Product{Name: "Coca-Cola 2L"}},
+		{CategoryID: 3, Category: &categories[2], Product: &schemas.Product{Name: "Pão francês"}},
+		{CategoryID: 1, Category: &categories[0], Product: &schemas.Product{Name: "Item sem sugestão"}},
+	}
+	for i := range items {
+		items[i].ID = uint(10 + i)
+	}
+	return items, categories
+}
+
+func TestSuggestCategories(t *testing.T) {
+	items, categories := recategorizationFixture()
+	// A primeira resposta esquece dois items; a chamada de reparo completa a lista
+	fake := replies(
+		`{"categorizations":[{"itemId":10,"categoryId":2}]}`,
+		`{"categorizations":[{"itemId":10,"categoryId":2},{"itemId":11,"categoryId":3},{"itemId":12,"categoryId":3}]}`,
+	)
+
+	result, err := suggestCategories(context.Background(), fake, items, categories)
+	if err != nil {
+		t.Fatalf("suggestCategories: %v", err)
+	}
+	if len(result.Categorizations) != 3 || result.Categorizations[0] != (ItemCategorization{ItemID: 10, CategoryID: 2}) {
+		t.Fatalf("unexpected categorizations: %+v", result.Categorizations)
+	}
+	if result.Model != "fake" || result.PromptTokens == 0 {
+		t.Fatalf("token usage not reported: %+v", result)
+	}
+	requests := fake.Requests()
+	if len(requests) != 2 || !strings.Contains(requests[1].Prompt, "itemId 11 não foi categorizado") {
+		t.Fatalf("expected a repair call listing the missing items, got %d calls", len(requests))
+	}
+	prompt := requests[0].Prompt
+	if !strings.Contains(prompt, "ItemID 10: Coca-Cola 2L") || strings.Contains(prompt, "ID 1: Não categorizado") || requests[0].Schema == nil {
+		t.Fatalf("unexpected request:\n%s", prompt)
+	}
+
+	fake = llm.NewFake("[]")
+	if _, err := suggestCategories(context.Background(), fake, items, categories); err == nil {
+		t.Fatal("a response without the categorizations object should fail")
+	}
+}
+
+func TestApplyRecategorization(t *testing.T) {
+	items, categories := recategorizationFixture()
+
+	results, changes := applyRecategorization(items, []ItemCategorization{
+		{ItemID: 10, CategoryID: 2},  // muda de Não categorizado para Bebidas
+		{ItemID: 10, CategoryID: 3},  // sugestão repetida: vale a primeira
+		{ItemID: 11, CategoryID: 3},  // mesma categoria: não muda
+		{ItemID: 12, CategoryID: 99}, // categoria de outro usuário: ignorada
+		{ItemID: 12, CategoryID: 1},  // Não categorizado: ignorada
+		{ItemID: 77, CategoryID: 2},  // item fora da lista: ignorado
+	}, categories)
+
+	if len(changes) != 1 || changes[10] != 2 {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+
+	want := []ItemRecategorizationResult{
+		{ItemID: 10, ProductName: "Coca-Cola 2L", OldCategoryID: 1, OldCategoryName: "Não categorizado", NewCategoryID: 2, NewCategoryName: "Bebidas", Changed: true},
+		{ItemID: 11, ProductName: "Pão francês", OldCategoryID: 3, OldCategoryName: "Padaria", NewCategoryID: 3, NewCategoryName: "Padaria"},
+		{ItemID: 12, ProductName: "Item sem sugestão", OldCategoryID: 1, OldCategoryName: "Não categorizado", NewCategoryID: 1, NewCategoryName: "Não categorizado", Reason: "a IA sugeriu a categoria inválida 99; categoria mantida"},
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Fatalf("result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+}
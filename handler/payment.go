@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+// paymentTotalTolerance é a diferença máxima aceita entre (pagamentos - troco) e o total da nota.
+const paymentTotalTolerance = 0.05
+
+// PaymentMethodAmount é o valor gasto com uma forma de pagamento no período ou mês.
+type PaymentMethodAmount struct {
+	Code         string  `json:"code"`         // Código tPag (vazio = não informado na nota)
+	Method       string  `json:"method"`       // Descrição da forma de pagamento
+	Amount       float64 `json:"amount"`       // Valor gasto (já descontado o troco)
+	ReceiptCount int     `json:"receiptCount"` // Notas pagas (total ou parcialmente) com esta forma
+	Percentage   float64 `json:"percentage"`   // Participação no total gasto
+}
+
+// PaymentMonthReport agrupa os gastos de um mês por forma de pagamento.
+type PaymentMonthReport struct {
+	Month   string                `json:"month"` // YYYY-MM
+	Total   float64               `json:"total"`
+	Methods []PaymentMethodAmount `json:"methods"`
+}
+
+// PaymentMethodReportResponse é a resposta de GET /reports/payment-methods.
+type PaymentMethodReportResponse struct {
+	Start   string                `json:"start"` // Primeiro mês (YYYY-MM)
+	End     string                `json:"end"`   // Último mês (YYYY-MM)
+	Total   float64               `json:"total"`
+	Methods []PaymentMethodAmount `json:"methods"` // Totais do período
+	Months  []PaymentMonthReport  `json:"months"`
+
+	BaseCurrency string                `json:"baseCurrency"`           // Moeda dos valores
+	MissingRates []MissingExchangeRate `json:"missingRates,omitempty"` // Notas sem cotação (ficam fora dos totais)
+}
+
+// receiptPaymentsFromPreview converte os pagamentos lidos da nota para o modelo do banco.
+func receiptPaymentsFromPreview(payments []PreviewPayment) []schemas.ReceiptPayment {
+	result := make([]schemas.ReceiptPayment, 0, len(payments))
+	for _, payment := range payments {
+		result = append(result, schemas.ReceiptPayment{
+			Code:   payment.Code,
+			Method: payment.Method,
+			Amount: payment.Amount,
+		})
+	}
+	return result
+}
+
+// receiptPaymentsFromRequest resolve as formas de pagamento informadas na criação manual
+// (por código tPag ou descrição) e confere se fecham com o total da nota.
+func receiptPaymentsFromRequest(requests []CreateReceiptPaymentRequest, change, total float64) ([]schemas.ReceiptPayment, error) {
+	if len(requests) == 0 {
+		if change > 0 {
+			return nil, fmt.Errorf("Informe os pagamentos para registrar o troco")
+		}
+		return nil, nil
+	}
+
+	payments := make([]schemas.ReceiptPayment, 0, len(requests))
+	paid := 0.0
+	for _, request := range requests {
+		payment := nfce.NewPayment(request.Method, request.Amount)
+		if request.Code != "" {
+			if nfce.PaymentMethodName(request.Code) == "Outros" && request.Code != "99" {
+				return nil, fmt.Errorf("Código de forma de pagamento inválido: %s", request.Code)
+			}
+			payment.Code = request.Code
+			payment.Method = nfce.PaymentMethodName(request.Code)
+		} else if request.Method == "" {
+			return nil, fmt.Errorf("Informe code ou method em cada pagamento")
+		}
+		paid += request.Amount
+		payments = append(payments, schemas.ReceiptPayment{Code: payment.Code, Method: payment.Method, Amount: payment.Amount})
+	}
+
+	if math.Abs(paid-change-total) > paymentTotalTolerance {
+		return nil, fmt.Errorf("A soma dos pagamentos menos o troco (R$ %.2f) não confere com o total da nota (R$ %.2f)", paid-change, total)
+	}
+	return payments, nil
+}
+
+// netPayments retorna quanto foi efetivamente gasto em cada pagamento: o troco é
+// descontado do pagamento em dinheiro ou, na falta dele, do maior pagamento.
+func netPayments(receipt *schemas.Receipt) []schemas.ReceiptPayment {
+	if len(receipt.Payments) == 0 {
+		return []schemas.ReceiptPayment{{Method: "Não informado", Amount: receipt.Total}}
+	}
+
+	payments := make([]schemas.ReceiptPayment, len(receipt.Payments))
+	copy(payments, receipt.Payments)
+	if receipt.Change > 0 {
+		target := 0
+		for i, payment := range payments {
+			if payment.Code == "01" {
+				target = i
+				break
+			}
+			if payment.Amount > payments[target].Amount {
+				target = i
+			}
+		}
+		payments[target].Amount -= receipt.Change
+	}
+	return payments
+}
+
+// sortedPaymentAmounts converte o acumulado por forma de pagamento em lista ordenada por valor.
+func sortedPaymentAmounts(amounts map[string]*PaymentMethodAmount, total float64) []PaymentMethodAmount {
+	result := make([]PaymentMethodAmount, 0, len(amounts))
+	for _, amount := range amounts {
+		amount.Amount = math.Round(amount.Amount*100) / 100
+		if total > 0 {
+			amount.Percentage = math.Round(amount.Amount/total*10000) / 100
+		}
+		result = append(result, *amount)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Amount != result[j].Amount {
+			return result[i].Amount > result[j].Amount
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result
+}
+
+// GetPaymentMethodReportHandler detalha os gastos por forma de pagamento em cada mês
+// @Summary Gastos por forma de pagamento
+// @Description Soma o valor das notas do usuário por forma de pagamento (cartão de crédito, débito, PIX, dinheiro, vales...) em cada mês, descontando o troco. Valores convertidos para a moeda base do usuário pela cotação da data da nota (notas sem cotação ficam fora). Notas sem pagamentos informados aparecem como "Não informado". Padrão: últimos 12 meses
+// @Tags reports
+// @Produce json
+// @Param start query string false "Primeiro mês (YYYY-MM)"
+// @Param end query string false "Último mês (YYYY-MM)"
+// @Success 200 {object} PaymentMethodReportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /reports/payment-methods [get]
+func GetPaymentMethodReportHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	start, end, ok := parseReportMonths(ctx)
+	if !ok {
+		return
+	}
+
+	var receipts []schemas.Receipt
+	if err := db.Preload("Payments").
+		Select("id", "date", "total", "change", "currency").
+		Where("user_id = ? AND date >= ? AND date < ?", userID, start.Format("2006-01-02"), end.AddDate(0, 1, 0).Format("2006-01-02")).
+		Order("date").
+		Find(&receipts).Error; err != nil {
+		logger.ErrorF("error loading receipts for payment report: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao gerar relatório de formas de pagamento")
+		return
+	}
+
+	periodAmounts := map[string]*PaymentMethodAmount{}
+	monthAmounts := map[string]map[string]*PaymentMethodAmount{}
+	monthTotals := map[string]float64{}
+	total := 0.0
+	converter := newCurrencyConverter(ctx)
+
+	for i := range receipts {
+		receipt := &receipts[i]
+		if len(receipt.Date) < 7 {
+			continue
+		}
+		rate, ok := converter.rate(receipt.Currency, receipt.Date)
+		if !ok {
+			continue
+		}
+		month := receipt.Date[:7]
+		if monthAmounts[month] == nil {
+			monthAmounts[month] = map[string]*PaymentMethodAmount{}
+		}
+
+		counted := map[string]bool{}
+		for _, payment := range netPayments(receipt) {
+			payment.Amount *= rate
+			// Formas "Outros" são separadas pela descrição original
+			key := payment.Code + "|" + payment.Method
+			for _, amounts := range []map[string]*PaymentMethodAmount{periodAmounts, monthAmounts[month]} {
+				if amounts[key] == nil {
+					amounts[key] = &PaymentMethodAmount{Code: payment.Code, Method: payment.Method}
+				}
+				amounts[key].Amount += payment.Amount
+				if !counted[key] {
+					amounts[key].ReceiptCount++
+				}
+			}
+			counted[key] = true
+			monthTotals[month] += payment.Amount
+			total += payment.Amount
+		}
+	}
+
+	response := PaymentMethodReportResponse{
+		Start:   start.Format("2006-01"),
+		End:     end.Format("2006-01"),
+		Total:   math.Round(total*100) / 100,
+		Methods: sortedPaymentAmounts(periodAmounts, total),
+		Months:  []PaymentMonthReport{},
+
+		BaseCurrency: converter.base,
+		MissingRates: converter.missingRates(),
+	}
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01")
+		response.Months = append(response.Months, PaymentMonthReport{
+			Month:   key,
+			Total:   math.Round(monthTotals[key]*100) / 100,
+			Methods: sortedPaymentAmounts(monthAmounts[key], monthTotals[key]),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
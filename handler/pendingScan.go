@@ -0,0 +1,479 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// pendingScanFirstDelay é a espera antes da primeira nova consulta; dobra a cada falha.
+	pendingScanFirstDelay = 5 * time.Minute
+	// pendingScanNotAuthorizedFirstDelay é a primeira espera para notas que ainda não constam na
+	// SEFAZ: notas em contingência costumam levar horas para serem transmitidas.
+	pendingScanNotAuthorizedFirstDelay = 30 * time.Minute
+	// pendingScanMaxDelay limita o intervalo entre consultas.
+	pendingScanMaxDelay = 6 * time.Hour
+	// pendingScanMaxAge é o tempo máximo que uma nota fica na fila antes de expirar.
+	pendingScanMaxAge = 7 * 24 * time.Hour
+	// pendingScanPollInterval é a frequência com que o worker procura notas a consultar.
+	pendingScanPollInterval = time.Minute
+	// pendingScanPollLimit é o máximo de notas consultadas a cada rodada do worker.
+	pendingScanPollLimit = 20
+	// pendingScanClaimTimeout devolve à fila as notas reservadas por uma instância que caiu no meio
+	// da consulta; é bem maior que o tempo de uma consulta ao portal.
+	pendingScanClaimTimeout = 15 * time.Minute
+)
+
+var (
+	pendingScanWorkerOnce sync.Once
+	// pendingScanAISlots serializa as categorizações das notas que saem da fila.
+	pendingScanAISlots = make(chan struct{}, 1)
+)
+
+// EnqueuePendingScanRequest é o corpo de POST /scan-qrcode/pending.
+type EnqueuePendingScanRequest struct {
+	QRCodeURL string `json:"qrCodeUrl" binding:"required"`
+}
+
+// PendingScanQueuedResponse é retornada quando uma URL entra na fila de notas pendentes.
+type PendingScanQueuedResponse struct {
+	Message string                      `json:"message"`
+	Pending schemas.PendingScanResponse `json:"pending"`
+}
+
+// pendingScanBackoff retorna a espera até a próxima consulta depois de attempts falhas.
+func pendingScanBackoff(reason schemas.PendingScanReason, attempts int) time.Duration {
+	delay := pendingScanFirstDelay
+	if reason == schemas.PendingScanNotAuthorized {
+		delay = pendingScanNotAuthorizedFirstDelay
+	}
+	for i := 1; i < attempts && delay < pendingScanMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > pendingScanMaxDelay {
+		delay = pendingScanMaxDelay
+	}
+	return delay
+}
+
+// pendingScanRetryReason diz se uma consulta que falhou deve voltar para a fila e por quê.
+// Notas em contingência são tentadas de novo mesmo quando o portal devolve uma página que
+// não conseguimos ler, pois é assim que alguns portais respondem antes da autorização.
+func pendingScanRetryReason(err error, contingency bool) (schemas.PendingScanReason, bool) {
+	switch {
+	case errors.Is(err, errPortalUnavailable):
+		return schemas.PendingScanPortalUnavailable, true
+	case errors.Is(err, errNFCeNotFound), contingency:
+		return schemas.PendingScanNotAuthorized, true
+	default:
+		return "", false
+	}
+}
+
+// isContingencyURL indica se a chave de acesso da URL foi emitida em contingência offline.
+func isContingencyURL(url string) bool {
+	key := nfce.AccessKeyFromURL(url)
+	if key == "" {
+		return false
+	}
+	accessKey, err := nfce.ParseAccessKey(key)
+	return err == nil && accessKey.Contingency()
+}
+
+// enqueuePendingScan coloca a URL na fila de consultas pendentes do usuário. Se a mesma nota
+// já estiver aguardando, retorna o registro existente. lastError é o erro da consulta que falhou
+// (vazio quando o usuário enfileira diretamente).
+func enqueuePendingScan(userID uint, url string, reason schemas.PendingScanReason, lastError string) (*schemas.PendingScan, error) {
+	accessKey, contingency := "", false
+	if key := nfce.AccessKeyFromURL(url); key != "" {
+		parsed, err := nfce.ParseAccessKey(key)
+		if err != nil {
+			return nil, err
+		}
+		accessKey, contingency = parsed.Key, parsed.Contingency()
+	}
+
+	var existing []schemas.PendingScan
+	query := db.Where("user_id = ? AND status IN ?", userID, []schemas.PendingScanStatus{schemas.PendingScanWaiting, schemas.PendingScanProcessing})
+	if accessKey != "" {
+		query = query.Where("access_key = ?", accessKey)
+	} else {
+		query = query.Where("url = ?", url)
+	}
+	if err := query.Limit(1).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return &existing[0], nil
+	}
+
+	attempts := 0
+	if lastError != "" {
+		attempts = 1
+	}
+	now := time.Now()
+	scan := schemas.PendingScan{
+		UserID:        userID,
+		URL:           url,
+		AccessKey:     accessKey,
+		Status:        schemas.PendingScanWaiting,
+		Attempts:      attempts,
+		LastError:     lastError,
+		NextAttemptAt: now.Add(pendingScanBackoff(reason, attempts)),
+		ExpiresAt:     now.Add(pendingScanMaxAge),
+		Reason:        reason,
+		Contingency:   contingency,
+	}
+	if err := db.Create(&scan).Error; err != nil {
+		return nil, err
+	}
+	logger.InfoF("⏳ NFC-e queued for retry (pending scan %d, user %d, %s): next attempt at %s", scan.ID, userID, reason, scan.NextAttemptAt.Format(time.RFC3339))
+	return &scan, nil
+}
+
+// StartPendingScanWorker inicia (uma única vez) o worker que consulta de novo as notas pendentes.
+// A fila é persistida, então as notas continuam sendo tentadas depois de reinicializações.
+func StartPendingScanWorker() {
+	pendingScanWorkerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(pendingScanPollInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				processDuePendingScans()
+			}
+		}()
+		logger.InfoF("⏳ Pending scan worker started (poll every %s)", pendingScanPollInterval)
+	})
+}
+
+// processDuePendingScans expira as notas antigas e consulta as que chegaram na hora. Várias
+// instâncias da API podem rodar o worker ao mesmo tempo: cada nota é reservada com um UPDATE
+// condicional (claimPendingScan) e só quem conseguiu a reserva a processa.
+func processDuePendingScans() {
+	now := time.Now()
+	released := db.Model(&schemas.PendingScan{}).
+		Where("status = ? AND updated_at < ?", schemas.PendingScanProcessing, now.Add(-pendingScanClaimTimeout)).
+		Update("status", schemas.PendingScanWaiting)
+	if released.Error != nil {
+		logger.ErrorF("⚠️  Failed to release stale pending scans: %v", released.Error)
+	} else if released.RowsAffected > 0 {
+		logger.WarnF("⏳ %d pending scan(s) claimed by a stopped instance returned to the queue", released.RowsAffected)
+	}
+
+	var expired []schemas.PendingScan
+	if err := db.Where("status = ? AND expires_at < ?", schemas.PendingScanWaiting, now).Find(&expired).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to load expired pending scans: %v", err)
+	}
+	for i := range expired {
+		claimed, err := claimPendingScan(&expired[i], schemas.PendingScanExpired)
+		if err != nil {
+			logger.ErrorF("⚠️  Failed to expire pending scan %d: %v", expired[i].ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		logger.WarnF("⌛ Pending scan %d expired without the NFC-e being available", expired[i].ID)
+		notifyPendingScanResult(&expired[i], false, "", pendingScanGiveUpMessage(&expired[i]))
+	}
+
+	var scans []schemas.PendingScan
+	if err := db.Where("status = ? AND next_attempt_at <= ?", schemas.PendingScanWaiting, now).
+		Order("next_attempt_at").Limit(pendingScanPollLimit).Find(&scans).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to load pending scans: %v", err)
+		return
+	}
+	for i := range scans {
+		claimed, err := claimPendingScan(&scans[i], schemas.PendingScanProcessing)
+		if err != nil {
+			logger.ErrorF("⚠️  Failed to claim pending scan %d: %v", scans[i].ID, err)
+			continue
+		}
+		if claimed {
+			processPendingScan(&scans[i])
+		}
+	}
+}
+
+// claimPendingScan troca a situação da nota de pending para status, desde que ninguém a tenha
+// trocado antes. Retorna false quando outra instância já reservou (ou removeu) a nota.
+func claimPendingScan(scan *schemas.PendingScan, status schemas.PendingScanStatus) (bool, error) {
+	result := db.Model(&schemas.PendingScan{}).
+		Where("id = ? AND status = ?", scan.ID, schemas.PendingScanWaiting).
+		Update("status", status)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	scan.Status = status
+	return true, nil
+}
+
+// processPendingScan consulta a nota novamente. Se o portal continuar fora (ou a nota ainda não
+// estiver autorizada), reagenda com backoff; se responder, cria o ImportJob e importa a nota pelo
+// mesmo fluxo da importação em lote. O usuário é avisado quando a nota é importada ou desistimos dela.
+func processPendingScan(scan *schemas.PendingScan) {
+	sem := portalSemaphore(scan.URL)
+	sem <- struct{}{}
+	data, err := scrapeNFCe(scan.URL)
+	<-sem
+
+	now := time.Now()
+	attempts := scan.Attempts + 1
+	if err != nil {
+		fields := map[string]interface{}{"attempts": attempts, "last_error": err.Error()}
+		reason, retry := pendingScanRetryReason(err, scan.Contingency)
+		if retry {
+			fields["reason"] = reason
+		}
+		switch next := now.Add(pendingScanBackoff(reason, attempts)); {
+		case !retry:
+			fields["status"] = schemas.PendingScanFailed
+		case next.After(scan.ExpiresAt):
+			fields["status"] = schemas.PendingScanExpired
+		default:
+			// Libera a reserva: a nota volta para a fila
+			fields["status"] = schemas.PendingScanWaiting
+			fields["next_attempt_at"] = next
+		}
+		if err := db.Model(scan).Updates(fields).Error; err != nil {
+			logger.ErrorF("⚠️  Failed to update pending scan %d: %v", scan.ID, err)
+			return
+		}
+		if status := fields["status"].(schemas.PendingScanStatus); status != schemas.PendingScanWaiting {
+			scan.Status, scan.LastError = status, err.Error()
+			if retry {
+				scan.Reason = reason
+			}
+			notifyPendingScanResult(scan, false, "", pendingScanGiveUpMessage(scan))
+		}
+		return
+	}
+
+	job := schemas.ImportJob{
+		UserID:    scan.UserID,
+		Source:    "qrcode",
+		Status:    schemas.ImportJobQueued,
+		AccessKey: scan.AccessKey,
+		SourceURL: scan.URL,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		return tx.Model(scan).Updates(map[string]interface{}{
+			"status":        schemas.PendingScanImported,
+			"attempts":      attempts,
+			"last_error":    "",
+			"import_job_id": job.ID,
+		}).Error
+	})
+	if err != nil {
+		logger.ErrorF("⚠️  Failed to create import job for pending scan %d: %v", scan.ID, err)
+		return
+	}
+
+	logger.InfoF("✅ NFC-e available: pending scan %d handed to import job %d", scan.ID, job.ID)
+	// A chave é conferida e reservada a partir da página lida
+	go func() {
+		defer trackImportJob(job.ID)()
+		importScrapedNFCe(scan.UserID, job.ID, scan.URL, data, nil, pendingScanAISlots)
+		notifyPendingScanImport(scan, job.ID)
+	}()
+}
+
+// pendingScanGiveUpMessage explica ao usuário por que a nota saiu da fila sem ser importada.
+func pendingScanGiveUpMessage(scan *schemas.PendingScan) string {
+	switch {
+	case scan.Status == schemas.PendingScanFailed:
+		return fmt.Sprintf("O portal da SEFAZ retornou um erro: %s", scan.LastError)
+	case scan.Reason == schemas.PendingScanNotAuthorized:
+		return "A nota não apareceu no portal da SEFAZ dentro do prazo. Notas emitidas em contingência precisam ser transmitidas pela loja; confira com o estabelecimento"
+	default:
+		return "O portal da SEFAZ ficou indisponível durante todo o prazo de nova consulta"
+	}
+}
+
+// notifyPendingScanImport avisa o usuário do resultado do ImportJob criado a partir da fila.
+func notifyPendingScanImport(scan *schemas.PendingScan, jobID uint) {
+	var job schemas.ImportJob
+	if err := db.First(&job, jobID).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to load import job %d for pending scan %d: %v", jobID, scan.ID, err)
+		return
+	}
+	// Nota já importada por outro caminho também conta como sucesso
+	imported := job.Status == schemas.ImportJobDone || (job.Status == schemas.ImportJobSkipped && job.ReceiptID != nil)
+	notifyPendingScanResult(scan, imported, job.StoreName, job.Error)
+}
+
+// notifyPendingScanResult envia o email com o resultado da nota pendente e registra o aviso.
+// Sem SMTP configurado o resultado continua disponível em GET /scan-qrcode/pending?status=all.
+func notifyPendingScanResult(scan *schemas.PendingScan, imported bool, storeName, detail string) {
+	emailService := config.NewEmailService()
+	if !emailService.IsConfigured() {
+		logger.WarnF("📧 Email service not configured: pending scan %d result not notified", scan.ID)
+		return
+	}
+
+	var user schemas.User
+	if err := db.First(&user, scan.UserID).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to load user %d for pending scan %d: %v", scan.UserID, scan.ID, err)
+		return
+	}
+	if err := emailService.SendPendingReceiptResultEmail(user.Email, user.Name, storeName, scan.AccessKey, imported, detail); err != nil {
+		logger.ErrorF("⚠️  Failed to notify user %d about pending scan %d: %v", scan.UserID, scan.ID, err)
+		return
+	}
+	if err := db.Model(scan).Update("notified_at", time.Now()).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to update pending scan %d: %v", scan.ID, err)
+	}
+}
+
+// EnqueuePendingScanHandler coloca uma URL de QR Code na fila de notas pendentes
+// @Summary Enfileirar nota para nova consulta
+// @Description Guarda a URL do QR Code para ser consultada de novo quando o portal da SEFAZ voltar ou a nota for autorizada (backoff exponencial a partir de 5 minutos, ou 30 minutos para notas em contingência, até 7 dias). Quando a nota aparece no portal, ela é importada automaticamente, importJobId aponta para a importação e o usuário é avisado por email do resultado
+// @Tags receipts
+// @Accept json
+// @Produce json
+// @Param request body EnqueuePendingScanRequest true "URL do QR Code"
+// @Success 202 {object} PendingScanQueuedResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /scan-qrcode/pending [post]
+func EnqueuePendingScanHandler(ctx *gin.Context) {
+	var request EnqueuePendingScanRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, "QR Code URL is required")
+		return
+	}
+	url := strings.TrimSpace(request.QRCodeURL)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		sendError(ctx, http.StatusBadRequest, "Invalid QR Code URL")
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	uid := userID.(uint)
+
+	if key := nfce.AccessKeyFromURL(url); key != "" {
+		accessKey, err := nfce.ParseAccessKey(key)
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Invalid NFC-e access key in QR Code: %v", err.Error()))
+			return
+		}
+		existing, err := findReceiptByAccessKey(uid, accessKey.Key)
+		if err != nil {
+			logger.ErrorF("error checking duplicate receipt: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Error checking for duplicate receipt")
+			return
+		}
+		if existing != nil {
+			sendDuplicateReceipt(ctx, "This NFC-e has already been imported", existing.ID, nil)
+			return
+		}
+	}
+
+	reason := schemas.PendingScanPortalUnavailable
+	if isContingencyURL(url) {
+		reason = schemas.PendingScanNotAuthorized
+	}
+	sendPendingScanQueued(ctx, uid, url, reason, "")
+}
+
+// sendPendingScanQueued enfileira a URL e responde 202 com o registro da fila.
+func sendPendingScanQueued(ctx *gin.Context, userID uint, url string, reason schemas.PendingScanReason, lastError string) {
+	scan, err := enqueuePendingScan(userID, url, reason, lastError)
+	if err != nil {
+		logger.ErrorF("error queueing pending scan: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error queueing NFC-e for retry")
+		return
+	}
+
+	message := "⏳ Nota guardada! Ela será importada automaticamente quando o portal da SEFAZ voltar."
+	if scan.Reason == schemas.PendingScanNotAuthorized {
+		message = "⏳ Esta nota ainda não consta na SEFAZ (emitida em contingência ou em processamento). Vamos consultar de novo e avisar quando ela for importada."
+	}
+	ctx.JSON(http.StatusAccepted, PendingScanQueuedResponse{
+		Message: message,
+		Pending: scan.ToResponse(),
+	})
+}
+
+// GetPendingScansHandler lista as notas aguardando o portal da SEFAZ
+// @Summary Listar notas pendentes
+// @Description Lista as URLs de QR Code na fila de nova consulta. Por padrão só as que ainda aguardam (status pending); use status=all para ver também as importadas, com falha ou expiradas
+// @Tags receipts
+// @Produce json
+// @Param status query string false "pending (padrão, inclui as notas em consulta), processing, imported, failed, expired ou all"
+// @Success 200 {array} schemas.PendingScanResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /scan-qrcode/pending [get]
+func GetPendingScansHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	query := db.Where("user_id = ?", userID)
+	switch status := ctx.DefaultQuery("status", string(schemas.PendingScanWaiting)); status {
+	case "all":
+	case string(schemas.PendingScanWaiting):
+		// Notas sendo consultadas neste momento continuam aguardando para o usuário
+		query = query.Where("status IN ?", []schemas.PendingScanStatus{schemas.PendingScanWaiting, schemas.PendingScanProcessing})
+	default:
+		query = query.Where("status = ?", status)
+	}
+
+	var scans []schemas.PendingScan
+	if err := query.Order("created_at DESC").Find(&scans).Error; err != nil {
+		logger.ErrorF("error listing pending scans: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error listing pending receipts")
+		return
+	}
+
+	responses := make([]schemas.PendingScanResponse, len(scans))
+	for i := range scans {
+		responses[i] = scans[i].ToResponse()
+	}
+	ctx.JSON(http.StatusOK, responses)
+}
+
+// DeletePendingScanHandler remove uma nota da fila de nova consulta
+// @Summary Remover nota pendente
+// @Description Tira a URL da fila; ela não será mais consultada
+// @Tags receipts
+// @Produce json
+// @Param id path int true "Pending scan ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /scan-qrcode/pending/{id} [delete]
+func DeletePendingScanHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var scan schemas.PendingScan
+	if err := db.Where("user_id = ?", userID).First(&scan, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Pending receipt not found")
+		return
+	}
+	if err := db.Delete(&scan).Error; err != nil {
+		logger.ErrorF("error deleting pending scan: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error deleting pending receipt")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Pending receipt removed"})
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+func TestPendingScanBackoff(t *testing.T) {
+	tests := []struct {
+		reason   schemas.PendingScanReason
+		attempts int
+		want     time.Duration
+	}{
+		{schemas.PendingScanPortalUnavailable, 0, 5 * time.Minute},
+		{schemas.PendingScanPortalUnavailable, 1, 5 * time.Minute},
+		{schemas.PendingScanPortalUnavailable, 2, 10 * time.Minute},
+		{schemas.PendingScanPortalUnavailable, 4, 40 * time.Minute},
+		{schemas.PendingScanPortalUnavailable, 7, 320 * time.Minute},
+		{schemas.PendingScanPortalUnavailable, 8, 6 * time.Hour},
+		{schemas.PendingScanPortalUnavailable, 50, 6 * time.Hour},
+		{schemas.PendingScanNotAuthorized, 1, 30 * time.Minute},
+		{schemas.PendingScanNotAuthorized, 3, 2 * time.Hour},
+		{schemas.PendingScanNotAuthorized, 5, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := pendingScanBackoff(tt.reason, tt.attempts); got != tt.want {
+			t.Errorf("pendingScanBackoff(%s, %d) = %s, want %s", tt.reason, tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestPendingScanRetryReason(t *testing.T) {
+	unavailable := fmt.Errorf("%w: status code 503", errPortalUnavailable)
+	other := errors.New("no items found in NFC-e")
+
+	tests := []struct {
+		name        string
+		err         error
+		contingency bool
+		wantReason  schemas.PendingScanReason
+		wantRetry   bool
+	}{
+		{"portal down", unavailable, false, schemas.PendingScanPortalUnavailable, true},
+		{"portal down in contingency", unavailable, true, schemas.PendingScanPortalUnavailable, true},
+		{"not found", errNFCeNotFound, false, schemas.PendingScanNotAuthorized, true},
+		{"unreadable page in contingency", other, true, schemas.PendingScanNotAuthorized, true},
+		{"unreadable page", other, false, "", false},
+	}
+	for _, tt := range tests {
+		reason, retry := pendingScanRetryReason(tt.err, tt.contingency)
+		if reason != tt.wantReason || retry != tt.wantRetry {
+			t.Errorf("%s: got (%q, %v), want (%q, %v)", tt.name, reason, retry, tt.wantReason, tt.wantRetry)
+		}
+	}
+}
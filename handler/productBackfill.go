@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// productBackfillDelay é a pausa entre consultas ao portal da SEFAZ durante o backfill,
+// para não sobrecarregar (nem ser bloqueado por) o portal.
+const productBackfillDelay = 2 * time.Second
+
+// productBackfillRunning guarda os usuários com backfill em andamento (um por vez).
+var productBackfillRunning sync.Map
+
+// BackfillProductCodesResponse é a resposta de POST /products/backfill-codes.
+type BackfillProductCodesResponse struct {
+	Message  string `json:"message"`
+	Receipts int    `json:"receipts"` // Notas que serão consultadas novamente
+}
+
+// matchScrapedItems associa os itens gravados de uma nota aos itens lidos novamente do portal.
+// Usa quantidade e total (que o usuário não edita) e, entre candidatos iguais, prefere o de
+// mesma descrição. Retorna índice do item gravado -> índice do item lido.
+func matchScrapedItems(items []schemas.ReceiptItem, scraped []NFCeItem) map[int]int {
+	matches := make(map[int]int)
+	used := make([]bool, len(scraped))
+	for i, item := range items {
+		best := -1
+		for j, candidate := range scraped {
+			if used[j] || math.Abs(candidate.Total-item.Total) > 0.005 || math.Abs(candidate.Quantity-item.Quantity) > 0.0005 {
+				continue
+			}
+			if best == -1 {
+				best = j
+			}
+			if item.Product != nil && strings.EqualFold(strings.TrimSpace(candidate.Description), item.Product.Name) {
+				best = j
+				break
+			}
+		}
+		if best >= 0 {
+			used[best] = true
+			matches[i] = best
+		}
+	}
+	return matches
+}
+
+// backfillReceiptProductCodes consulta a nota novamente no portal e grava o código e o GTIN
+// dos itens, revinculando-os ao produto do catálogo. Retorna quantos itens foram atualizados.
+func backfillReceiptProductCodes(receiptID uint) (int, error) {
+	var receipt schemas.Receipt
+	if err := db.Preload("Items", "code IS NULL OR code = ''").Preload("Items.Product").First(&receipt, receiptID).Error; err != nil {
+		return 0, err
+	}
+	if len(receipt.Items) == 0 {
+		return 0, nil
+	}
+
+	data, err := scrapeNFCe(receipt.QRCodeURL)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	matches := matchScrapedItems(receipt.Items, data.Items)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i, j := range matches {
+			item, scraped := receipt.Items[i], data.Items[j]
+			if scraped.Code == "" || item.Product == nil {
+				continue
+			}
+
+			product, err := findOrCreateProduct(tx, productMatch{
+				Name:    item.Product.Name,
+				Unit:    item.Product.Unity,
+				Code:    scraped.Code,
+				GTIN:    scraped.GTIN,
+				StoreID: receipt.StoreID,
+			})
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&schemas.ReceiptItem{}).Where("id = ?", item.ID).UpdateColumns(map[string]interface{}{
+				"code":       strings.TrimSpace(scraped.Code),
+				"product_id": product.ID,
+			}).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// backfillProductCodes processa as notas do usuário em sequência, em segundo plano.
+func backfillProductCodes(userID uint, receiptIDs []uint) {
+	defer productBackfillRunning.Delete(userID)
+
+	updated, failed := 0, 0
+	for i, receiptID := range receiptIDs {
+		if i > 0 {
+			time.Sleep(productBackfillDelay)
+		}
+		count, err := backfillReceiptProductCodes(receiptID)
+		if err != nil {
+			logger.WarnF("⚠️  [Backfill] Receipt %d: could not re-scrape product codes: %v", receiptID, err)
+			failed++
+			continue
+		}
+		updated += count
+	}
+	logger.InfoF("🏷️  [Backfill] User %d: %d items updated from %d receipts (%d failed)", userID, updated, len(receiptIDs), failed)
+}
+
+// BackfillProductCodesHandler consulta novamente as notas antigas para preencher códigos de produto
+// @Summary Preencher códigos de barras dos produtos
+// @Description Consulta novamente no portal da SEFAZ as notas do usuário importadas por QR Code cujos itens ainda não têm código do produto. Grava o código da loja e o GTIN/EAN dos itens e unifica os produtos pelo código de barras. Roda em segundo plano; notas importadas por XML ou foto não são consultadas
+// @Tags products
+// @Produce json
+// @Success 202 {object} BackfillProductCodesResponse
+// @Success 200 {object} BackfillProductCodesResponse "Nenhuma nota para atualizar"
+// @Failure 409 {object} ErrorResponse "Já existe um backfill em andamento"
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /products/backfill-codes [post]
+func BackfillProductCodesHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	uid := userID.(uint)
+
+	var receiptIDs []uint
+	if err := db.Model(&schemas.Receipt{}).
+		Where("user_id = ? AND qr_code_url LIKE ?", uid, "http%").
+		Where("EXISTS (SELECT 1 FROM receipt_items ri WHERE ri.receipt_id = receipts.id AND ri.deleted_at IS NULL AND (ri.code IS NULL OR ri.code = ''))").
+		Order("date DESC").
+		Pluck("id", &receiptIDs).Error; err != nil {
+		logger.ErrorF("error listing receipts for product code backfill: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar notas para atualizar")
+		return
+	}
+
+	if len(receiptIDs) == 0 {
+		ctx.JSON(http.StatusOK, BackfillProductCodesResponse{Message: "Nenhuma nota precisa ser atualizada"})
+		return
+	}
+
+	if _, running := productBackfillRunning.LoadOrStore(uid, true); running {
+		sendError(ctx, http.StatusConflict, "Já existe uma atualização de códigos em andamento")
+		return
+	}
+
+	go backfillProductCodes(uid, receiptIDs)
+
+	ctx.JSON(http.StatusAccepted, BackfillProductCodesResponse{
+		Message:  "Atualização dos códigos de produto iniciada",
+		Receipts: len(receiptIDs),
+	})
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+func TestMatchScrapedItems(t *testing.T) {
+	items := []schemas.ReceiptItem{
+		{Product: &schemas.Product{Name: "REFRIGERANTE COLA 2L"}, Quantity: 1, Total: 8.99},
+		{Product: &schemas.Product{Name: "Refrigerante Guaraná"}, Quantity: 1, Total: 8.99}, // Nome editado pelo usuário
+		{Product: &schemas.Product{Name: "ARROZ TIPO 1 5KG"}, Quantity: 2, Total: 49.80},
+		{Product: &schemas.Product{Name: "ITEM REMOVIDO DA NOTA"}, Quantity: 1, Total: 3.50},
+	}
+	scraped := []NFCeItem{
+		{Code: "000789", Description: "REFRIGERANTE GUARANA 2L", Quantity: 1, Total: 8.99},
+		{Code: "7896006752318", Description: "ARROZ TIPO 1 5KG", Quantity: 2, Total: 49.80},
+		{Code: "7894900011517", Description: "REFRIGERANTE COLA 2L", Quantity: 1, Total: 8.99},
+	}
+
+	matches := matchScrapedItems(items, scraped)
+	want := map[int]int{0: 2, 1: 0, 2: 1}
+	if len(matches) != len(want) {
+		t.Fatalf("matches = %v, want %v", matches, want)
+	}
+	for i, j := range want {
+		if matches[i] != j {
+			t.Errorf("item %d matched scraped %d, want %d", i, matches[i], j)
+		}
+	}
+}
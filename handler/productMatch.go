@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// productMatch são os dados de um item usados para encontrar o produto no catálogo.
+type productMatch struct {
+	Name    string
+	Unit    string // Já normalizada
+	Code    string // Código do produto na loja (cProd)
+	GTIN    string // GTIN-14 validado por nfce.NormalizeGTIN
+	StoreID *uint  // Loja da nota, para resolver códigos internos
+}
+
+// findOrCreateProduct resolve o produto de um item na ordem: GTIN, código interno da loja
+// e, por fim, nome + unidade. Produtos encontrados sem GTIN recebem o da nota, então a
+// mesma mercadoria comprada em outra loja (com outro nome) cai no mesmo cadastro.
+// O código interno da loja é registrado para as próximas compras.
+func findOrCreateProduct(tx *gorm.DB, match productMatch) (*schemas.Product, error) {
+	match.Code = strings.TrimSpace(match.Code)
+
+	var product schemas.Product
+	found := false
+
+	// 1. Código de barras (inclui produtos excluídos: o índice único vale para eles também)
+	if match.GTIN != "" {
+		err := tx.Unscoped().Where("gtin = ?", match.GTIN).First(&product).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("error finding product by gtin: %w", err)
+		}
+		found = err == nil
+		if found && product.DeletedAt.Valid {
+			if err := tx.Unscoped().Model(&product).UpdateColumn("deleted_at", nil).Error; err != nil {
+				return nil, fmt.Errorf("error restoring product: %w", err)
+			}
+			product.DeletedAt = gorm.DeletedAt{}
+		}
+	}
+
+	// 2. Código interno da loja
+	if !found && match.Code != "" && match.StoreID != nil {
+		err := tx.Joins("JOIN product_codes ON product_codes.product_id = products.id AND product_codes.deleted_at IS NULL").
+			Where("product_codes.store_id = ? AND product_codes.code = ?", *match.StoreID, match.Code).
+			First(&product).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("error finding product by store code: %w", err)
+		}
+		// Um código interno que aponta para outro GTIN foi reaproveitado pela loja
+		found = err == nil && (match.GTIN == "" || product.GTIN == nil)
+	}
+
+	// 3. Nome + unidade (sem GTIN conflitante)
+	if !found {
+		product = schemas.Product{}
+		query := tx.Where("name = ? AND unity = ?", match.Name, match.Unit)
+		if match.GTIN != "" {
+			query = query.Where("gtin IS NULL")
+		}
+		err := query.First(&product).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("error finding product: %w", err)
+		}
+		found = err == nil
+	}
+
+	if !found {
+		product = schemas.Product{Name: match.Name, Unity: match.Unit}
+		if match.GTIN != "" {
+			gtin := match.GTIN
+			product.GTIN = &gtin
+			// ON CONFLICT DO NOTHING evita erro quando duas importações criam o mesmo GTIN juntas
+			if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "gtin"}}, DoNothing: true}).Create(&product).Error; err != nil {
+				return nil, fmt.Errorf("error creating product: %w", err)
+			}
+			if product.ID == 0 {
+				if err := tx.Unscoped().Where("gtin = ?", match.GTIN).First(&product).Error; err != nil {
+					return nil, fmt.Errorf("error finding product by gtin: %w", err)
+				}
+			}
+		} else if err := tx.Create(&product).Error; err != nil {
+			return nil, fmt.Errorf("error creating product: %w", err)
+		}
+		logger.InfoF("Produto criado: %s (%s) - ID: %d", product.Name, product.Unity, product.ID)
+	} else if product.GTIN == nil && match.GTIN != "" {
+		// Só preenche se nenhum outro produto já tiver esse GTIN
+		result := tx.Model(&schemas.Product{}).
+			Where("id = ? AND gtin IS NULL AND NOT EXISTS (SELECT 1 FROM products p WHERE p.gtin = ?)", product.ID, match.GTIN).
+			UpdateColumn("gtin", match.GTIN)
+		if result.Error != nil {
+			return nil, fmt.Errorf("error setting product gtin: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			gtin := match.GTIN
+			product.GTIN = &gtin
+		}
+	}
+
+	if match.Code != "" && match.StoreID != nil {
+		code := schemas.ProductCode{StoreID: *match.StoreID, Code: match.Code, ProductID: product.ID}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "store_id"}, {Name: "code"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"product_id": product.ID, "updated_at": gorm.Expr("NOW()")}),
+		}).Create(&code).Error; err != nil {
+			return nil, fmt.Errorf("error saving product code: %w", err)
+		}
+	}
+
+	return &product, nil
+}
@@ -1,8 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
 	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -12,22 +17,39 @@ import (
 type CreateReceiptItemRequest struct {
 	ProductName string  `json:"productName" binding:"required" example:"Arroz Integral"`
 	ProductUnit string  `json:"productUnit" binding:"required" example:"kg"`
-	CategoryID  uint    `json:"categoryId" binding:"required" example:"1"`
+	CategoryID  uint    `json:"categoryId" example:"1"` // Opcional: sem ele, vale a regra de categorização que casar com o item
 	Quantity    float64 `json:"quantity" binding:"required,gt=0" example:"2.5"`
 	UnitPrice   float64 `json:"unitPrice" binding:"required,gt=0" example:"15.90"`
 	Total       float64 `json:"total" binding:"required,gt=0" example:"39.75"`
+	Discount    float64 `json:"discount" binding:"gte=0" example:"1.50"` // Desconto do item (opcional)
+	Code        string  `json:"code" binding:"max=60" example:"000123"`  // Código do produto na loja (opcional)
+	GTIN        string  `json:"gtin" example:"7894900011517"`            // Código de barras EAN/GTIN (opcional)
+}
+
+// CreateReceiptPaymentRequest define uma forma de pagamento na criação manual.
+// Informe code (tabela tPag da NF-e) ou method (ex: "PIX", "Cartão de Crédito").
+type CreateReceiptPaymentRequest struct {
+	Code   string  `json:"code" example:"03"`
+	Method string  `json:"method" binding:"max=60" example:"Cartão de Crédito"`
+	Amount float64 `json:"amount" binding:"required,gt=0" example:"95.00"`
 }
 
 // CreateReceiptRequest define a estrutura para criar uma nota fiscal manualmente.
 type CreateReceiptRequest struct {
-	StoreName string                     `json:"storeName" binding:"required" example:"Supermercado Silva"`
+	StoreName string                     `json:"storeName" example:"Supermercado Silva"` // Obrigatório se storeId não for informado
+	StoreID   *uint                      `json:"storeId" example:"3"`                    // Loja já cadastrada (GET /stores)
 	Date      string                     `json:"date" binding:"required" example:"2024-11-11"`
 	Items     []CreateReceiptItemRequest `json:"items" binding:"required,min=1"`
 	Subtotal  float64                    `json:"subtotal" example:"100.00"`
 	Discount  float64                    `json:"discount" example:"5.00"`
 	Total     float64                    `json:"total" binding:"required,gt=0" example:"95.00"`
-	Currency  string                     `json:"currency" example:"BRL"`
+	Currency  string                     `json:"currency" example:"BRL"` // ISO 4217; padrão: moeda base do usuário
 	Notes     string                     `json:"notes" example:"Compra mensal"`
+	// Payments lista as formas de pagamento; a soma menos o troco deve fechar com o total
+	Payments []CreateReceiptPaymentRequest `json:"payments" binding:"omitempty,dive"`
+	Change   float64                       `json:"change" binding:"gte=0" example:"0"`
+	// AllowDuplicate confirma a criação mesmo quando já existe nota da mesma loja, data e total
+	AllowDuplicate bool `json:"allowDuplicate" example:"false"`
 }
 
 // UpdateReceiptRequest define a estrutura para atualizar um recibo.
@@ -38,6 +60,7 @@ type UpdateReceiptRequest struct {
 	Subtotal  *float64 `json:"subtotal"`
 	Discount  *float64 `json:"discount"`
 	Total     *float64 `json:"total"`
+	Currency  *string  `json:"currency" example:"USD"` // ISO 4217
 }
 
 // GetReceiptsHandler lida com a requisição para listar todos os recibos do usuário autenticado.
@@ -54,12 +77,13 @@ func GetReceiptsHandler(ctx *gin.Context) {
 	// Utiliza a conexão de banco de dados global 'db' com preload otimizado para evitar queries N+1.
 	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("id ASC")
-	}).Preload("Items.Category").Preload("Items.Product").Where("user_id = ?", userID).Order("date DESC").Find(&receipts)
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").Where("user_id = ?", userID).Order("date DESC").Find(&receipts)
 
 	// Converte para uma resposta otimizada para listagens.
 	summaries := make([]schemas.ReceiptSummary, len(receipts))
+	converter := newCurrencyConverter(ctx)
 	for i, receipt := range receipts {
-		summaries[i] = receipt.ToSummary()
+		summaries[i] = converter.summary(&receipt)
 	}
 
 	ctx.JSON(http.StatusOK, summaries)
@@ -84,6 +108,7 @@ func GetReceiptsBasicHandler(ctx *gin.Context) {
 
 	// Para cada recibo, conta os itens associados para preencher o campo ItemCount.
 	basics := make([]schemas.ReceiptBasic, len(receipts))
+	converter := newCurrencyConverter(ctx)
 	for i, receipt := range receipts {
 		var count int64
 		db.Model(&schemas.ReceiptItem{}).Where("receipt_id = ?", receipt.ID).Count(&count)
@@ -95,6 +120,9 @@ func GetReceiptsBasicHandler(ctx *gin.Context) {
 			ItemCount: int(count),
 			Total:     receipt.Total,
 			Currency:  receipt.Currency,
+
+			BaseCurrency: converter.base,
+			BaseTotal:    converter.baseTotal(receipt.Total, receipt.Currency, receipt.Date),
 		}
 	}
 
@@ -111,6 +139,7 @@ func GetReceiptsBasicHandler(ctx *gin.Context) {
 // @Success 201 {object} map[string]interface{} "Receipt created successfully"
 // @Failure 400 {object} ErrorResponse "Dados inválidos | Categoria não encontrada ou não pertence ao usuário"
 // @Failure 401 {object} ErrorResponse "Unauthorized - Invalid or missing token"
+// @Failure 409 {object} DuplicateReceiptResponse "Provável nota duplicada (reenvie com allowDuplicate=true)"
 // @Failure 500 {object} ErrorResponse "Erro ao criar nota fiscal. Por favor, tente novamente"
 // @Router /receipt [post]
 func CreateReceiptHandler(ctx *gin.Context) {
@@ -119,29 +148,89 @@ func CreateReceiptHandler(ctx *gin.Context) {
 	// Valida o body da requisição
 	var request CreateReceiptRequest
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		sendError(ctx, http.StatusBadRequest, "Dados inválidos. Verifique os campos obrigatórios: storeName, date, items (com productName, productUnit, categoryId, quantity, unitPrice, total) e total")
+		sendError(ctx, http.StatusBadRequest, "Dados inválidos. Verifique os campos obrigatórios: storeName (ou storeId), date, items (com productName, productUnit, quantity, unitPrice, total) e total")
+		return
+	}
+
+	// Loja escolhida pelo usuário: precisa ser uma loja em que ele já tem notas
+	storeCNPJ := ""
+	if request.StoreID != nil {
+		var store schemas.Store
+		if err := db.First(&store, *request.StoreID).Error; err != nil {
+			sendError(ctx, http.StatusBadRequest, "Loja não encontrada")
+			return
+		}
+		var visits int64
+		db.Model(&schemas.Receipt{}).Where("user_id = ? AND store_id = ?", userID, store.ID).Count(&visits)
+		if visits == 0 {
+			sendError(ctx, http.StatusBadRequest, "Loja não encontrada")
+			return
+		}
+		if strings.TrimSpace(request.StoreName) == "" {
+			request.StoreName = store.DisplayName()
+		}
+		storeCNPJ = store.CNPJ
+	}
+	if strings.TrimSpace(request.StoreName) == "" {
+		sendError(ctx, http.StatusBadRequest, "Informe storeName ou storeId")
+		return
+	}
+
+	// Valida as categorias (precisam ser do usuário, ou vêm das regras) e os códigos de barras informados
+	gtins, err := checkItemRequests(userID.(uint), ruleSubject{StoreName: request.StoreName, StoreCNPJ: storeCNPJ}, request.Items)
+	if err != nil {
+		var invalid itemRequestError
+		if errors.As(err, &invalid) {
+			sendError(ctx, http.StatusBadRequest, invalid.Error())
+			return
+		}
+		logger.ErrorF("error checking receipt items: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao criar nota fiscal. Por favor, tente novamente")
 		return
 	}
 
-	// Valida se o usuário tem acesso às categorias fornecidas
-	categoryIDs := make([]uint, len(request.Items))
-	for i, item := range request.Items {
-		categoryIDs[i] = item.CategoryID
+	// 🧮 Confere as somas; valores zerados que podem ser derivados são preenchidos
+	amounts := receiptAmounts{Subtotal: request.Subtotal, Discount: request.Discount, Total: request.Total}
+	for _, item := range request.Items {
+		amounts.Items = append(amounts.Items, itemAmounts{Quantity: item.Quantity, UnitPrice: item.UnitPrice, Total: item.Total, Discount: item.Discount})
+	}
+	validation := validateReceiptAmounts(&amounts, defaultValidationOptions())
+	request.Subtotal, request.Discount, request.Total = amounts.Subtotal, amounts.Discount, amounts.Total
+	totals := make([]float64, len(request.Items))
+	discounts := make([]float64, len(request.Items))
+	for i := range request.Items {
+		request.Items[i].UnitPrice = amounts.Items[i].UnitPrice
+		request.Items[i].Total = amounts.Items[i].Total
+		totals[i], discounts[i] = request.Items[i].Total, request.Items[i].Discount
 	}
+	// O desconto da nota que não foi informado por item é rateado entre eles
+	discounts = nfce.AllocateDiscount(totals, discounts, request.Discount)
 
-	var categoryCount int64
-	db.Model(&schemas.Category{}).
-		Where("id IN ? AND user_id = ?", categoryIDs, userID).
-		Count(&categoryCount)
+	payments, err := receiptPaymentsFromRequest(request.Payments, request.Change, request.Total)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if int(categoryCount) != len(categoryIDs) {
-		sendError(ctx, http.StatusBadRequest, "Uma ou mais categorias não foram encontradas ou não pertencem ao usuário autenticado")
+	// Moeda da nota (ISO 4217); sem ela, vale a moeda base do usuário
+	request.Currency, err = normalizeReceiptCurrency(ctx, request.Currency)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Define valores padrão
-	if request.Currency == "" {
-		request.Currency = "BRL"
+	// Verifica prováveis duplicatas (mesma loja, data e total), a menos que o cliente confirme
+	if !request.AllowDuplicate {
+		duplicates, err := findLikelyDuplicateReceipts(userID.(uint), request.StoreName, request.Date, request.Total)
+		if err != nil {
+			logger.ErrorF("error checking duplicate receipts: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Erro ao verificar notas duplicadas. Por favor, tente novamente")
+			return
+		}
+		if len(duplicates) > 0 {
+			sendDuplicateReceipt(ctx, "Já existe uma nota desta loja com a mesma data e total. Envie allowDuplicate=true para criar mesmo assim", duplicates[0].ID, duplicates)
+			return
+		}
 	}
 
 	// Inicia transação para garantir consistência
@@ -163,7 +252,11 @@ func CreateReceiptHandler(ctx *gin.Context) {
 		Currency:   request.Currency,
 		Confidence: 1.0, // Criação manual = 100% de confiança
 		Notes:      request.Notes,
+		StoreID:    request.StoreID,
+		Change:     request.Change,
+		Payments:   payments,
 	}
+	receipt.ApplyValidation(validation)
 
 	if err := tx.Create(&receipt).Error; err != nil {
 		tx.Rollback()
@@ -172,41 +265,12 @@ func CreateReceiptHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Processa cada item
-	for _, itemReq := range request.Items {
-		// Busca ou cria o produto
-		var product schemas.Product
-		if err := tx.Where("name = ? AND unity = ?", itemReq.ProductName, itemReq.ProductUnit).
-			First(&product).Error; err != nil {
-			// Produto não existe, cria um novo
-			product = schemas.Product{
-				Name:  itemReq.ProductName,
-				Unity: itemReq.ProductUnit,
-			}
-			if err := tx.Create(&product).Error; err != nil {
-				tx.Rollback()
-				logger.ErrorF("error creating product: %v", err.Error())
-				sendError(ctx, http.StatusInternalServerError, "Erro ao criar produto. Por favor, tente novamente")
-				return
-			}
-		}
-
-		// Cria o item do recibo
-		receiptItem := schemas.ReceiptItem{
-			ReceiptID:  receipt.ID,
-			CategoryID: itemReq.CategoryID,
-			ProductID:  product.ID,
-			Quantity:   itemReq.Quantity,
-			UnitPrice:  itemReq.UnitPrice,
-			Total:      itemReq.Total,
-		}
-
-		if err := tx.Create(&receiptItem).Error; err != nil {
-			tx.Rollback()
-			logger.ErrorF("error creating receipt item: %v", err.Error())
-			sendError(ctx, http.StatusInternalServerError, "Erro ao criar item da nota fiscal. Por favor, tente novamente")
-			return
-		}
+	// Processa cada item (busca ou cria o produto pelo GTIN, código da loja e, por fim, nome + unidade)
+	if _, err := createReceiptItems(tx, receipt.ID, request.StoreID, request.Items, gtins, discounts); err != nil {
+		tx.Rollback()
+		logger.ErrorF("error creating receipt items: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao criar item da nota fiscal. Por favor, tente novamente")
+		return
 	}
 
 	// Commit da transação
@@ -221,6 +285,7 @@ func CreateReceiptHandler(ctx *gin.Context) {
 	var completeReceipt schemas.Receipt
 	db.Preload("Items.Category").
 		Preload("Items.Product").
+		Preload("Payments").
 		First(&completeReceipt, receipt.ID)
 
 	ctx.JSON(http.StatusCreated, gin.H{
@@ -242,7 +307,7 @@ func CreateReceiptHandler(ctx *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /receipt/{id} [delete]
 // @Summary Delete a receipt
-// @Description Soft delete a receipt and all its related items and products (sets deleted_at timestamp)
+// @Description Soft delete a receipt and all its related items and products (sets deleted_at timestamp). The receipt goes to the trash (GET /trash) and can be restored until it is purged
 // @Tags notasfiscais
 // @Accept json
 // @Produce json
@@ -288,7 +353,10 @@ func DeleteReceiptHandler(ctx *gin.Context) {
 
 	// 2. Soft delete dos itens do recibo
 	// NOTA: NÃO deletamos produtos pois eles podem estar sendo usados por outros items
-	if err := tx.Where("receipt_id = ?", receipt.ID).Delete(&schemas.ReceiptItem{}).Error; err != nil {
+	// Itens, pagamentos e recibo recebem o mesmo deleted_at: é assim que RestoreReceiptHandler
+	// sabe quais itens foram excluídos junto com a nota e quais já estavam na lixeira antes
+	now := time.Now()
+	if err := tx.Model(&schemas.ReceiptItem{}).Where("receipt_id = ?", receipt.ID).UpdateColumn("deleted_at", now).Error; err != nil {
 		tx.Rollback()
 		logger.ErrorF("error deleting receipt items: %v", err.Error())
 		sendError(ctx, http.StatusInternalServerError, "Error deleting receipt items")
@@ -296,8 +364,15 @@ func DeleteReceiptHandler(ctx *gin.Context) {
 	}
 	logger.InfoF("Soft deleted %d receipt items", len(receiptItems))
 
+	if err := tx.Model(&schemas.ReceiptPayment{}).Where("receipt_id = ?", receipt.ID).UpdateColumn("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		logger.ErrorF("error deleting receipt payments: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error deleting receipt payments")
+		return
+	}
+
 	// 3. Soft delete do recibo
-	if err := tx.Delete(&receipt).Error; err != nil {
+	if err := tx.Model(&receipt).UpdateColumn("deleted_at", now).Error; err != nil {
 		tx.Rollback()
 		logger.ErrorF("error deleting receipt: %v", err.Error())
 		sendError(ctx, http.StatusInternalServerError, "Error deleting receipt")
@@ -367,11 +442,52 @@ func UpdateReceiptHandler(ctx *gin.Context) {
 	if request.Discount != nil {
 		receipt.Discount = *request.Discount
 	}
+	if request.Currency != nil {
+		code, err := currency.Normalize(*request.Currency)
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		receipt.Currency = code
+	}
 	if request.Total != nil {
 		receipt.Total = *request.Total
 	}
 
-	if err := db.Save(&receipt).Error; err != nil {
+	// Confere de novo os valores editados contra os itens
+	var items []schemas.ReceiptItem
+	if err := db.Where("receipt_id = ?", receipt.ID).Find(&items).Error; err != nil {
+		logger.ErrorF("error loading receipt items: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error updating receipt")
+		return
+	}
+	// Desconto da nota editado: é rateado de novo entre os itens
+	if request.Discount != nil {
+		for i := range items {
+			items[i].Discount = 0
+		}
+		schemas.AllocateItemDiscounts(items, receipt.Discount)
+	}
+	revalidateReceipt(&receipt, items)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&receipt).Error; err != nil {
+			return err
+		}
+		if request.Discount == nil {
+			return nil
+		}
+		for _, item := range items {
+			if err := tx.Model(&item).UpdateColumns(map[string]interface{}{
+				"discount":  item.Discount,
+				"net_total": item.NetTotal,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		logger.ErrorF("error updating receipt: %v", err.Error())
 		sendError(ctx, http.StatusInternalServerError, "Error updating receipt")
 		return
@@ -380,9 +496,9 @@ func UpdateReceiptHandler(ctx *gin.Context) {
 	// Recarrega o receipt com os relacionamentos para retornar o summary completo
 	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("id ASC")
-	}).Preload("Items.Category").Preload("Items.Product").First(&receipt, id)
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").First(&receipt, id)
 
-	ctx.JSON(http.StatusOK, receipt.ToSummary())
+	ctx.JSON(http.StatusOK, newCurrencyConverter(ctx).summary(&receipt))
 }
 
 // GetReceiptsBasicByPeriodHandler lista recibos básicos por período
@@ -413,6 +529,7 @@ func GetReceiptsBasicByPeriodHandler(ctx *gin.Context) {
 
 	// Conta items para cada receipt
 	basics := make([]schemas.ReceiptBasic, len(receipts))
+	converter := newCurrencyConverter(ctx)
 	for i, receipt := range receipts {
 		var count int64
 		db.Model(&schemas.ReceiptItem{}).Where("receipt_id = ?", receipt.ID).Count(&count)
@@ -424,6 +541,9 @@ func GetReceiptsBasicByPeriodHandler(ctx *gin.Context) {
 			ItemCount: int(count),
 			Total:     receipt.Total,
 			Currency:  receipt.Currency,
+
+			BaseCurrency: converter.base,
+			BaseTotal:    converter.baseTotal(receipt.Total, receipt.Currency, receipt.Date),
 		}
 	}
 
@@ -450,6 +570,7 @@ func GetReceiptsBasicByDateHandler(ctx *gin.Context) {
 
 	// Conta items para cada receipt
 	basics := make([]schemas.ReceiptBasic, len(receipts))
+	converter := newCurrencyConverter(ctx)
 	for i, receipt := range receipts {
 		var count int64
 		db.Model(&schemas.ReceiptItem{}).Where("receipt_id = ?", receipt.ID).Count(&count)
@@ -461,6 +582,9 @@ func GetReceiptsBasicByDateHandler(ctx *gin.Context) {
 			ItemCount: int(count),
 			Total:     receipt.Total,
 			Currency:  receipt.Currency,
+
+			BaseCurrency: converter.base,
+			BaseTotal:    converter.baseTotal(receipt.Total, receipt.Currency, receipt.Date),
 		}
 	}
 
@@ -484,11 +608,13 @@ func GetReceiptByIDHandler(ctx *gin.Context) {
 	// Utiliza a conexão de banco de dados global 'db' com preload otimizado.
 	if err := db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("id ASC")
-	}).Preload("Items.Category").Preload("Items.Product").Where("id = ? AND user_id = ?", id, userID).First(&receipt).Error; err != nil {
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").Preload("Images", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC")
+	}).Where("id = ? AND user_id = ?", id, userID).First(&receipt).Error; err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "Recibo não encontrado"})
 		return
 	}
-	ctx.JSON(http.StatusOK, receipt.ToSummary())
+	ctx.JSON(http.StatusOK, newCurrencyConverter(ctx).summary(&receipt))
 }
 
 // GetReceiptsByDateHandler busca recibos por data específica
@@ -507,14 +633,15 @@ func GetReceiptsByDateHandler(ctx *gin.Context) {
 	// usa variável global db - preload otimizado
 	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("id ASC")
-	}).Preload("Items.Category").Preload("Items.Product").
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").
 		Where("user_id = ? AND date = ?", userID, date).
 		Order("date DESC").Find(&receipts)
 
 	// Converte para resposta otimizada
 	summaries := make([]schemas.ReceiptSummary, len(receipts))
+	converter := newCurrencyConverter(ctx)
 	for i, receipt := range receipts {
-		summaries[i] = receipt.ToSummary()
+		summaries[i] = converter.summary(&receipt)
 	}
 
 	ctx.JSON(http.StatusOK, summaries)
@@ -544,14 +671,15 @@ func GetReceiptsByPeriodHandler(ctx *gin.Context) {
 	// usa variável global db - preload otimizado
 	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("id ASC")
-	}).Preload("Items.Category").Preload("Items.Product").
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").
 		Where("user_id = ? AND date >= ? AND date <= ?", userID, startDate, endDate).
 		Order("date DESC").Find(&receipts)
 
 	// Converte para resposta otimizada
 	summaries := make([]schemas.ReceiptSummary, len(receipts))
+	converter := newCurrencyConverter(ctx)
 	for i, receipt := range receipts {
-		summaries[i] = receipt.ToSummary()
+		summaries[i] = converter.summary(&receipt)
 	}
 
 	ctx.JSON(http.StatusOK, summaries)
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
+)
+
+// ReceiptAnalysisData é a estrutura que a IA deve retornar após analisar um recibo.
+type ReceiptAnalysisData struct {
+	StoreName  string                `json:"storeName"`
+	Date       string                `json:"date"`
+	Items      []ReceiptAnalysisItem `json:"items"`
+	Subtotal   float64               `json:"subtotal"`
+	Discount   float64               `json:"discount"`
+	Total      float64               `json:"total"`
+	Currency   string                `json:"currency"`
+	Confidence float64               `json:"confidence"`
+	Notes      string                `json:"notes"`
+}
+
+// ReceiptAnalysisItem representa um item de recibo simplificado, conforme retornado pela IA.
+// A categoria não é pedida aqui: os itens do preview são categorizados na confirmação
+// (regras, histórico e IA), como os da importação por QR Code.
+type ReceiptAnalysisItem struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitPrice   float64 `json:"unitPrice"`
+	Total       float64 `json:"total"`
+	Discount    float64 `json:"discount"` // Desconto impresso na linha do item (0 quando não há)
+}
+
+// ReceiptAnalysisResult contém os dados extraídos das imagens e os metadados de uso de tokens
+type ReceiptAnalysisResult struct {
+	Data           *ReceiptAnalysisData
+	Model          string   // Modelo que respondeu, gravado no uso de tokens
+	Warnings       []string // Problemas que a IA não corrigiu mesmo com a chamada de reparo
+	PromptTokens   int
+	ResponseTokens int
+	TotalTokens    int
+}
+
+// analyzeReceiptImages envia as fotos da nota ao provedor de IA e valida o JSON retornado
+// (com uma chamada de reparo quando ele é rejeitado).
+func analyzeReceiptImages(ctx context.Context, provider llm.AIProvider, imagesBase64 []string, currency string, locale string, amountHint *float64) (*ReceiptAnalysisResult, error) {
+	request := llm.Request{
+		Prompt: buildReceiptPrompt(currency, locale, amountHint, len(imagesBase64)),
+		Schema: receiptAnalysisSchema,
+	}
+	for _, imageBase64 := range imagesBase64 {
+		request.Images = append(request.Images, llm.ParseDataURL(imageBase64))
+	}
+
+	var receiptData ReceiptAnalysisData
+	usage, problems, err := generateJSON(ctx, provider, request, &receiptData, func() []string {
+		return validateReceiptAnalysis(&receiptData)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReceiptAnalysisResult{
+		Data:           &receiptData,
+		Model:          usage.Model,
+		Warnings:       problems,
+		PromptTokens:   usage.PromptTokens,
+		ResponseTokens: usage.ResponseTokens,
+		TotalTokens:    usage.TotalTokens,
+	}, nil
+}
+
+// AnalyzeReceipt analisa uma ou múltiplas imagens de nota fiscal com o provedor de IA configurado
+func AnalyzeReceipt(ctx context.Context, imagesBase64 []string, currency string, locale string, amountHint *float64) (*ReceiptAnalysisResult, error) {
+	logger.InfoF("🤖 Sending %d image(s) to %s (model: %s)", len(imagesBase64), aiProvider.Name(), aiProvider.Model())
+	return analyzeReceiptImages(ctx, aiProvider, imagesBase64, currency, locale, amountHint)
+}
+
+// buildReceiptPrompt constrói o prompt de leitura da nota
+func buildReceiptPrompt(currency string, locale string, amountHint *float64, imageCount int) string {
+	var builder strings.Builder
+
+	builder.WriteString("Você é um assistente de finanças que extrai dados estruturados de notas fiscais em imagem.\n")
+
+	if imageCount > 1 {
+		builder.WriteString(fmt.Sprintf("IMPORTANTE: Você receberá %d imagens da MESMA nota fiscal. Analise TODAS as imagens e combine as informações em UM ÚNICO JSON.\n", imageCount))
+		builder.WriteString("As imagens podem conter partes diferentes da nota (topo, meio, rodapé, etc.). Junte todos os itens em uma única lista.\n")
+	}
+
+	builder.WriteString("IMPORTANTE: Retorne APENAS um JSON válido e bem formatado, sem comentários, texto adicional ou vírgulas extras.\n")
+	builder.WriteString("IDIOMA: Todas as descrições e observações devem estar em PORTUGUÊS (PT-BR). Traduza nomes de produtos se necessário.\n")
+	builder.WriteString("Formato esperado:\n")
+	builder.WriteString("{\n")
+	builder.WriteString("  \"storeName\": \"string - nome do estabelecimento\",\n")
+	builder.WriteString("  \"date\": \"YYYY-MM-DD - data da compra\",\n")
+	builder.WriteString("  \"items\": [\n")
+	builder.WriteString("    {\n")
+	builder.WriteString("      \"description\": \"string - nome do produto corrigido e legível\",\n")
+	builder.WriteString("      \"quantity\": number - quantidade ou peso,\n")
+	builder.WriteString("      \"unit\": \"string - unidade de medida: 'un', 'kg', 'g', 'l', 'ml'\",\n")
+	builder.WriteString("      \"unitPrice\": number - preço por unidade ou por kg,\n")
+	builder.WriteString("      \"total\": number - total do item, antes do desconto,\n")
+	builder.WriteString("      \"discount\": number - desconto impresso para o item (0 se não houver)\n")
+	builder.WriteString("    }\n")
+	builder.WriteString("  ],\n")
+	builder.WriteString("  \"subtotal\": number,\n")
+	builder.WriteString("  \"discount\": number,\n")
+	builder.WriteString("  \"total\": number,\n")
+	builder.WriteString("  \"currency\": \"string - código ISO 4217 da moeda da nota (ex: BRL, USD, EUR)\",\n")
+	builder.WriteString("  \"confidence\": number entre 0 e 1,\n")
+	builder.WriteString("  \"notes\": \"string - observações relevantes\"\n")
+	builder.WriteString("}\n\n")
+
+	builder.WriteString("Regras importantes:\n")
+	builder.WriteString("- NUNCA deixe vírgulas extras antes de fechar objetos } ou arrays ]\n")
+	builder.WriteString("- Garanta que o JSON seja válido e possa ser parseado sem erros\n")
+	builder.WriteString("- Identifique corretamente a unidade de medida e use somente as listadas a seguir:\n")
+	builder.WriteString("  * Use 'un' para itens vendidos por unidade (ex: refrigerante, sorvete)\n")
+	builder.WriteString("  * Use 'kg' para itens vendidos por peso em quilogramas (ex: frutas, carnes, queijos)\n")
+	builder.WriteString("  * Use 'g' para itens vendidos em gramas\n")
+	builder.WriteString("  * Use 'l' para líquidos em litros\n")
+	builder.WriteString("  * Use 'ml' para líquidos em mililitros\n")
+	builder.WriteString("- Quando o item for por peso, a quantity será o peso (ex: 0.350 kg)\n")
+	builder.WriteString("- Quando o item for por unidade, a quantity será o número de unidades (ex: 2 un)\n")
+	builder.WriteString("- O unitPrice deve ser o preço POR unidade/kg, não o preço total\n")
+	builder.WriteString("- Se algum valor não estiver presente, use null para números ou string vazia para textos.\n")
+	builder.WriteString("- Use ponto como separador decimal.\n")
+	builder.WriteString("- Se discount não for visível, use 0.\n")
+	builder.WriteString("- O discount da nota é o desconto total; o discount de cada item é apenas o desconto impresso naquela linha.\n")
+	builder.WriteString("- MOEDA: identifique a moeda pelos símbolos e textos da nota (R$ = BRL, US$ = USD, € = EUR, £ = GBP...) e informe o código ISO 4217 em currency.\n")
+	builder.WriteString(fmt.Sprintf("- Se a moeda não puder ser identificada, use %s.\n", strings.ToUpper(currency)))
+	builder.WriteString("- NÃO converta valores: informe todos na moeda impressa na nota.\n")
+	builder.WriteString("- Notas brasileiras usam datas em dd/mm/aaaa; em notas de outros países, siga o formato local. Converta sempre para YYYY-MM-DD.\n")
+	builder.WriteString("- Corrija e traduza nomes de produtos para português brasileiro (ex: 'Apple' -> 'Maçã').\n")
+	builder.WriteString("- Nomes de produtos devem estar abreviados ou com erros corrigidos e em português.\n")
+	builder.WriteString("- A soma dos totais dos items deve bater com o subtotal.\n")
+	builder.WriteString("- Total = Subtotal - Discount.\n")
+
+	if amountHint != nil && *amountHint > 0 {
+		builder.WriteString(fmt.Sprintf("- O total esperado aproximado é %.2f %s. Use isso apenas como referência para validar.\n", *amountHint, currency))
+	}
+
+	builder.WriteString("\nAnalise a imagem da nota fiscal e retorne apenas o JSON com todos os textos em português brasileiro.\n")
+
+	return builder.String()
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+func TestMain(m *testing.M) {
+	logger = config.GetLogger("test")
+	os.Exit(m.Run())
+}
+
+func testCategories() []schemas.Category {
+	categories := []schemas.Category{{Name: "Não categorizado"}, {Name: "Bebidas"}, {Name: "Padaria"}}
+	for i := range categories {
+		categories[i].ID = uint(i + 1)
+	}
+	return categories
+}
+
+// replies devolve um provedor fake que responde cada texto em sequência (o último se repete).
+func replies(texts ...string) *llm.Fake {
+	fake := &llm.Fake{}
+	fake.Respond = func(llm.Request) (string, error) {
+		text := texts[0]
+		if len(texts) > 1 {
+			texts = texts[1:]
+		}
+		return text, nil
+	}
+	return fake
+}
+
+const validReceiptJSON = `{"storeName":"Mercado X","date":"2024-07-01","items":[{"description":"Pão","quantity":2,"unit":"un","unitPrice":1.5,"total":3,"discount":0}],"subtotal":3,"discount":0,"total":3,"currency":"BRL","confidence":0.9,"notes":""}`
+
+func TestAnalyzeReceiptImages(t *testing.T) {
+	fake := llm.NewFake("```json\n" + validReceiptJSON + "\n```")
+
+	result, err := analyzeReceiptImages(context.Background(), fake, []string{"data:image/png;base64,AAAA", "BBBB"}, "BRL", "pt-BR", nil)
+	if err != nil {
+		t.Fatalf("analyzeReceiptImages: %v", err)
+	}
+	if result.Data.StoreName != "Mercado X" || len(result.Data.Items) != 1 {
+		t.Fatalf("unexpected data: %+v", result.Data)
+	}
+	if result.Model != "fake" || result.TotalTokens == 0 || len(result.Warnings) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 1 || len(requests[0].Images) != 2 || requests[0].Schema == nil {
+		t.Fatalf("expected one structured call with two images, got %+v", requests)
+	}
+	if requests[0].Images[0].MimeType != "image/png" || requests[0].Images[1].MimeType != "image/jpeg" {
+		t.Fatalf("unexpected image types: %+v", requests[0].Images)
+	}
+	if strings.Contains(requests[0].Prompt, "categoryId") {
+		t.Fatal("the photo prompt should not ask for categories; items are categorized on confirm")
+	}
+}
+
+func TestAnalyzeReceiptImagesRepair(t *testing.T) {
+	invalid := strings.Replace(validReceiptJSON, `"discount":0}]`, `"discount":0},]`, 1)
+	fake := replies(invalid, validReceiptJSON)
+
+	result, err := analyzeReceiptImages(context.Background(), fake, []string{"AAAA"}, "BRL", "pt-BR", nil)
+	if err != nil {
+		t.Fatalf("analyzeReceiptImages: %v", err)
+	}
+	requests := fake.Requests()
+	if len(requests) != 2 || !strings.Contains(requests[1].Prompt, "CORREÇÃO") || len(requests[1].Images) != 1 {
+		t.Fatalf("expected a repair call with the images, got %d calls", len(requests))
+	}
+	if len(result.Data.Items) != 1 || len(result.Warnings) != 0 {
+		t.Fatalf("unexpected repaired result: %+v", result)
+	}
+
+	// Problemas que continuam depois do reparo viram avisos
+	badDate := strings.Replace(validReceiptJSON, `"date":"2024-07-01"`, `"date":"01/07/2024"`, 1)
+	result, err = analyzeReceiptImages(context.Background(), llm.NewFake(badDate), []string{"AAAA"}, "BRL", "pt-BR", nil)
+	if err != nil {
+		t.Fatalf("analyzeReceiptImages: %v", err)
+	}
+	if len(result.Warnings) == 0 {
+		t.Fatal("expected the invalid date to be reported as a warning")
+	}
+
+	if _, err := analyzeReceiptImages(context.Background(), llm.NewFake("not json"), []string{"AAAA"}, "BRL", "pt-BR", nil); err == nil {
+		t.Fatal("invalid JSON after the repair should fail")
+	}
+}
+
+func TestCategorizeItems(t *testing.T) {
+	items := []NFCeItem{
+		{ItemNumber: 1, Description: "COCA COLA 2L", Quantity: 1, Unit: "un", UnitPrice: 9.99, Total: 9.99},
+		{ItemNumber: 2, Description: "PAO FRANCES", Quantity: 1, Unit: "kg", UnitPrice: 12, Total: 6},
+	}
+
+	fake := llm.NewFake(`[{"description":"Coca-Cola 2L","categoryId":2},{"description":"Pão francês","categoryId":3}]`)
+	result, err := categorizeItems(context.Background(), fake, items, testCategories())
+	if err != nil {
+		t.Fatalf("categorizeItems: %v", err)
+	}
+	if len(result.Items) != 2 || result.Items[0].CategoryID != 2 || result.Items[1].CategoryID != 3 || len(result.Warnings) != 0 || result.Resolved != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if requests := fake.Requests(); len(requests) != 1 || len(requests[0].Images) != 0 || requests[0].Schema == nil {
+		t.Fatalf("expected one structured text call, got %+v", requests)
+	}
+
+	// Resposta incompleta e com categoria inválida: o reparo não resolve e os itens vão para "Não categorizado"
+	fake = llm.NewFake(`[{"description":"Coca-Cola 2L","categoryId":99}]`)
+	result, err = categorizeItems(context.Background(), fake, items, testCategories())
+	if err != nil {
+		t.Fatalf("categorizeItems: %v", err)
+	}
+	if len(fake.Requests()) != 2 {
+		t.Fatalf("expected one repair call, got %d calls", len(fake.Requests()))
+	}
+	if result.Items[0].CategoryID != 1 || result.Items[1].CategoryID != 1 || len(result.Warnings) != 2 || result.Resolved != 0 {
+		t.Fatalf("expected both items uncategorized with reasons, got %+v", result)
+	}
+	if first, _ := fake.Generate(context.Background(), fake.Requests()[0]); result.PromptTokens <= first.Usage.PromptTokens {
+		t.Fatal("token usage of both calls should be reported")
+	}
+
+	fake.Respond = func(llm.Request) (string, error) { return "", errors.New("quota exceeded") }
+	if _, err := categorizeItems(context.Background(), fake, items, testCategories()); err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("provider error should be returned, got %v", err)
+	}
+}
+
+func TestDecodeAIJSON(t *testing.T) {
+	var out []CategorizedItem
+	for _, invalid := range []string{
+		`[{"description":"x","categoryId":1},]`,
+		`[{"description":"x","categoryId":1,"category":"Bebidas"}]`,
+		`[{"description":"x","categoryId":"1"}]`,
+		`[{"description":"x","categoryId":1}] obrigado!`,
+	} {
+		if err := decodeAIJSON(invalid, &out); err == nil {
+			t.Fatalf("decodeAIJSON(%q) should fail", invalid)
+		}
+	}
+	if err := decodeAIJSON("```json\n[{\"description\":\"x\",\"categoryId\":1}]\n```", &out); err != nil || len(out) != 1 {
+		t.Fatalf("decodeAIJSON with code fence = %v, %v", out, err)
+	}
+}
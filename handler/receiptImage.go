@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/Pmmvito/Golang-Api-Exemple/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// receiptImageUploadTimeout limita o envio das fotos ao BlobStore durante o preview.
+const receiptImageUploadTimeout = 30 * time.Second
+
+// uploadedReceiptImage é uma foto recebida no upload, antes de ir para o BlobStore.
+type uploadedReceiptImage struct {
+	Content     []byte
+	ContentType string
+}
+
+// saveDraftImages guarda as fotos do preview no BlobStore, vinculadas ao rascunho. Falhas são só
+// registradas: a foto é um complemento e não deve impedir a importação da nota.
+func saveDraftImages(userID, draftID uint, uploads []uploadedReceiptImage) {
+	store := config.GetBlobStore()
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), receiptImageUploadTimeout)
+	defer cancel()
+
+	for i, upload := range uploads {
+		stored, err := storage.PutImage(ctx, store, fmt.Sprintf("receipts/%d", userID), upload.Content, upload.ContentType)
+		if err != nil {
+			logger.WarnF("⚠️  Could not store image %d of draft %d: %v", i+1, draftID, err)
+			continue
+		}
+		image := schemas.ReceiptImage{
+			UserID:       userID,
+			DraftID:      &draftID,
+			Position:     i,
+			StorageKey:   stored.Key,
+			ContentType:  stored.ContentType,
+			Size:         stored.Size,
+			Width:        stored.Width,
+			Height:       stored.Height,
+			ThumbnailKey: stored.ThumbnailKey,
+		}
+		if err := db.Create(&image).Error; err != nil {
+			logger.WarnF("⚠️  Could not save image %d of draft %d: %v", i+1, draftID, err)
+			deleteReceiptImageBlobs([]schemas.ReceiptImage{image})
+		}
+	}
+}
+
+// attachDraftImages passa as fotos do rascunho confirmado para o recibo criado.
+func attachDraftImages(tx *gorm.DB, userID, draftID, receiptID uint) error {
+	return tx.Model(&schemas.ReceiptImage{}).
+		Where("user_id = ? AND draft_id = ? AND receipt_id IS NULL", userID, draftID).
+		Update("receipt_id", receiptID).Error
+}
+
+// purgeExpiredDraftImages remove as fotos de rascunhos vencidos que nunca foram confirmados.
+func purgeExpiredDraftImages(userID uint) {
+	var images []schemas.ReceiptImage
+	if err := db.Where("user_id = ? AND receipt_id IS NULL", userID).
+		Where("draft_id IN (?)", db.Model(&schemas.ReceiptDraft{}).Select("id").
+			Where("user_id = ? AND consumed_at IS NULL AND expires_at < ?", userID, time.Now())).
+		Find(&images).Error; err != nil {
+		logger.WarnF("⚠️  Failed to list expired draft images for user %d: %v", userID, err)
+		return
+	}
+	if len(images) == 0 {
+		return
+	}
+	if err := db.Unscoped().Delete(&images).Error; err != nil {
+		logger.WarnF("⚠️  Failed to delete expired draft images for user %d: %v", userID, err)
+		return
+	}
+	deleteReceiptImageBlobs(images)
+}
+
+// deleteReceiptImageBlobs apaga os originais e as miniaturas do BlobStore.
+func deleteReceiptImageBlobs(images []schemas.ReceiptImage) {
+	store := config.GetBlobStore()
+	if store == nil {
+		return
+	}
+	ctx := context.Background()
+	for _, image := range images {
+		for _, key := range []string{image.StorageKey, image.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := store.Delete(ctx, key); err != nil {
+				logger.WarnF("⚠️  Could not delete blob %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// GetReceiptImageHandler devolve uma foto da nota fiscal
+// @Summary Foto da nota fiscal
+// @Description Devolve a foto original da nota (ou a miniatura JPEG com thumbnail=true). Notas com várias fotos usam index (0 = primeira). Quando a foto não tem miniatura (WEBP/HEIC), o original é retornado
+// @Tags notasfiscais
+// @Produce image/jpeg,image/png,image/webp,image/heic
+// @Param id path int true "ID do recibo"
+// @Param index query int false "Posição da foto (padrão 0)"
+// @Param thumbnail query bool false "Retornar a miniatura (até 320px)"
+// @Success 200 {file} binary
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /receipt/{id}/image [get]
+func GetReceiptImageHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	index, err := strconv.Atoi(ctx.DefaultQuery("index", "0"))
+	if err != nil || index < 0 {
+		sendError(ctx, http.StatusBadRequest, "index must be a non-negative integer")
+		return
+	}
+	thumbnail := ctx.Query("thumbnail") == "true"
+
+	var receipt schemas.Receipt
+	if err := db.Select("id").Where("id = ? AND user_id = ?", ctx.Param("id"), userID).First(&receipt).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Receipt not found")
+		return
+	}
+
+	var images []schemas.ReceiptImage
+	if err := db.Where("receipt_id = ?", receipt.ID).Order("position ASC, id ASC").Offset(index).Limit(1).Find(&images).Error; err != nil {
+		logger.ErrorF("error loading receipt image: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error loading receipt image")
+		return
+	}
+	if len(images) == 0 {
+		sendError(ctx, http.StatusNotFound, "This receipt has no image")
+		return
+	}
+	image := images[0]
+
+	store := config.GetBlobStore()
+	if store == nil {
+		sendError(ctx, http.StatusInternalServerError, "Image storage is not configured")
+		return
+	}
+
+	key, contentType := image.StorageKey, image.ContentType
+	if thumbnail && image.ThumbnailKey != "" {
+		key, contentType = image.ThumbnailKey, "image/jpeg"
+	}
+	reader, info, err := store.Get(ctx.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.ErrorF("blob %s of receipt image %d is missing", key, image.ID)
+		sendError(ctx, http.StatusNotFound, "Image file not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorF("error reading blob %s: %v", key, err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error loading receipt image")
+		return
+	}
+	defer reader.Close()
+
+	ctx.DataFromReader(http.StatusOK, info.Size, contentType, reader, map[string]string{
+		"Cache-Control": "private, max-age=86400",
+	})
+}
@@ -0,0 +1,375 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AddReceiptItemsRequest é o corpo de POST /receipt/:id/items.
+type AddReceiptItemsRequest struct {
+	Items []CreateReceiptItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// MoveItemRequest é o corpo de POST /item/:id/move.
+type MoveItemRequest struct {
+	TargetReceiptID uint `json:"targetReceiptId" binding:"required" example:"12"`
+	// Quantity move só parte do item (divide a quantidade, o total e o desconto proporcionalmente).
+	// Vazio ou igual à quantidade do item move o item inteiro.
+	Quantity *float64 `json:"quantity" binding:"omitempty,gt=0" example:"1"`
+}
+
+// ReceiptTotalsResponse resume os valores de uma nota depois de uma alteração nos itens.
+type ReceiptTotalsResponse struct {
+	ID         uint                       `json:"id"`
+	Subtotal   float64                    `json:"subtotal"`
+	Discount   float64                    `json:"discount"`
+	Total      float64                    `json:"total"`
+	ItemsCount int                        `json:"itemsCount"`
+	Validation *schemas.ReceiptValidation `json:"validation,omitempty"`
+}
+
+// MoveItemResponse é a resposta de POST /item/:id/move.
+type MoveItemResponse struct {
+	Message string                       `json:"message"`
+	Item    schemas.ReceiptItemResponse  `json:"item"`           // Item na nota de destino
+	Source  ReceiptTotalsResponse        `json:"sourceReceipt"`  // Nota de origem recalculada
+	Target  ReceiptTotalsResponse        `json:"targetReceipt"`  // Nota de destino recalculada
+	Split   bool                         `json:"split"`          // true quando só parte do item foi movida
+	Left    *schemas.ReceiptItemResponse `json:"left,omitempty"` // O que ficou na nota de origem, na divisão
+}
+
+// itemRequestError é um problema nos itens informados pelo cliente (responde 400 com a mensagem).
+type itemRequestError string
+
+func (e itemRequestError) Error() string { return string(e) }
+
+// checkItemRequests confere os itens informados manualmente: os códigos de barras precisam ser
+// GTINs válidos e as categorias, do usuário. Itens sem categoryId recebem a categoria das regras
+// do usuário (store identifica a loja da nota). Retorna os GTINs normalizados (vazio quando o
+// item não informa); problemas nos itens são devolvidos como itemRequestError.
+func checkItemRequests(userID uint, store ruleSubject, items []CreateReceiptItemRequest) ([]string, error) {
+	gtins := make([]string, len(items))
+	for i, item := range items {
+		if strings.TrimSpace(item.GTIN) == "" {
+			continue
+		}
+		if gtins[i] = nfce.NormalizeGTIN(item.GTIN); gtins[i] == "" {
+			return nil, itemRequestError(fmt.Sprintf("GTIN inválido no item %d: %s", i+1, item.GTIN))
+		}
+	}
+
+	if err := applyCategoryRulesToItemRequests(userID, store, items, gtins); err != nil {
+		return nil, err
+	}
+
+	// Itens da mesma categoria contam uma vez só
+	seen := make(map[uint]bool)
+	var categoryIDs []uint
+	for _, item := range items {
+		if !seen[item.CategoryID] {
+			seen[item.CategoryID] = true
+			categoryIDs = append(categoryIDs, item.CategoryID)
+		}
+	}
+	var categoryCount int64
+	if err := db.Model(&schemas.Category{}).
+		Where("id IN ? AND user_id = ?", categoryIDs, userID).
+		Count(&categoryCount).Error; err != nil {
+		return nil, err
+	}
+	if int(categoryCount) != len(categoryIDs) {
+		return nil, itemRequestError("Uma ou mais categorias não foram encontradas ou não pertencem ao usuário autenticado")
+	}
+	return gtins, nil
+}
+
+// createReceiptItems grava os itens informados manualmente na nota, buscando ou criando os
+// produtos (GTIN, código da loja e, por fim, nome + unidade). discounts é o desconto de cada
+// item, já com o rateio do desconto da nota quando houver.
+func createReceiptItems(tx *gorm.DB, receiptID uint, storeID *uint, items []CreateReceiptItemRequest, gtins []string, discounts []float64) ([]schemas.ReceiptItem, error) {
+	created := make([]schemas.ReceiptItem, 0, len(items))
+	for i, itemReq := range items {
+		product, err := findOrCreateProduct(tx, productMatch{
+			Name:    itemReq.ProductName,
+			Unit:    itemReq.ProductUnit,
+			Code:    itemReq.Code,
+			GTIN:    gtins[i],
+			StoreID: storeID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		receiptItem := schemas.ReceiptItem{
+			ReceiptID:  receiptID,
+			CategoryID: itemReq.CategoryID,
+			ProductID:  product.ID,
+			Quantity:   itemReq.Quantity,
+			UnitPrice:  itemReq.UnitPrice,
+			Total:      itemReq.Total,
+			Code:       strings.TrimSpace(itemReq.Code),
+		}
+		receiptItem.ApplyDiscount(discounts[i])
+		if err := tx.Create(&receiptItem).Error; err != nil {
+			return nil, fmt.Errorf("error creating receipt item: %w", err)
+		}
+		created = append(created, receiptItem)
+	}
+	return created, nil
+}
+
+// recalculateReceiptTotals soma de novo os itens da nota, soma ao desconto da nota a variação
+// do desconto dos itens que entraram (positiva) ou saíram (negativa) e refaz a conferência.
+func recalculateReceiptTotals(tx *gorm.DB, receiptID uint, discountDelta float64) (*schemas.Receipt, error) {
+	var receipt schemas.Receipt
+	if err := tx.First(&receipt, receiptID).Error; err != nil {
+		return nil, err
+	}
+	var items []schemas.ReceiptItem
+	if err := tx.Where("receipt_id = ?", receiptID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	subtotal := 0.0
+	for _, item := range items {
+		subtotal += item.Total
+	}
+	receipt.Subtotal = roundCents(subtotal)
+	receipt.Discount = roundCents(math.Max(receipt.Discount+discountDelta, 0))
+	receipt.Total = roundCents(receipt.Subtotal - receipt.Discount + receipt.Charges())
+	revalidateReceipt(&receipt, items)
+
+	if err := tx.Model(&receipt).Select("subtotal", "discount", "total", "validation_status", "validation_issues").Updates(&receipt).Error; err != nil {
+		return nil, err
+	}
+	receipt.Items = items
+	return &receipt, nil
+}
+
+// totalsResponse resume os valores recalculados de uma nota.
+func totalsResponse(receipt *schemas.Receipt) ReceiptTotalsResponse {
+	return ReceiptTotalsResponse{
+		ID:         receipt.ID,
+		Subtotal:   receipt.Subtotal,
+		Discount:   receipt.Discount,
+		Total:      receipt.Total,
+		ItemsCount: len(receipt.Items),
+		Validation: receipt.Validation(),
+	}
+}
+
+// AddReceiptItemsHandler adiciona itens a uma nota já gravada
+// @Summary Adicionar itens à nota
+// @Description Adiciona itens que a leitura da nota não trouxe. Os produtos são buscados ou criados como na criação manual (GTIN, código da loja e nome + unidade). O subtotal e o total da nota são recalculados; o desconto dos itens adicionados entra no desconto da nota
+// @Tags items
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Receipt ID"
+// @Param request body AddReceiptItemsRequest true "Itens a adicionar"
+// @Success 201 {object} schemas.ReceiptSummary
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /receipt/{id}/items [post]
+func AddReceiptItemsHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	uid := userID.(uint)
+
+	var request AddReceiptItemsRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, "Dados inválidos. Informe items (com productName, productUnit, quantity, unitPrice e total; categoryId é opcional quando uma regra de categorização casar com o item)")
+		return
+	}
+
+	var receipt schemas.Receipt
+	if err := db.Where("user_id = ?", uid).First(&receipt, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Receipt not found")
+		return
+	}
+
+	store := ruleSubject{StoreName: receipt.StoreName}
+	if receipt.StoreID != nil {
+		var emitter schemas.Store
+		if err := db.First(&emitter, *receipt.StoreID).Error; err == nil {
+			store.StoreCNPJ = emitter.CNPJ
+		}
+	}
+	gtins, err := checkItemRequests(uid, store, request.Items)
+	if err != nil {
+		var invalid itemRequestError
+		if errors.As(err, &invalid) {
+			sendError(ctx, http.StatusBadRequest, invalid.Error())
+			return
+		}
+		logger.ErrorF("error checking receipt items: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao adicionar itens. Por favor, tente novamente")
+		return
+	}
+
+	discounts := make([]float64, len(request.Items))
+	added := 0.0
+	for i, item := range request.Items {
+		discounts[i] = item.Discount
+		added += item.Discount
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := createReceiptItems(tx, receipt.ID, receipt.StoreID, request.Items, gtins, discounts); err != nil {
+			return err
+		}
+		_, err := recalculateReceiptTotals(tx, receipt.ID, added)
+		return err
+	})
+	if err != nil {
+		logger.ErrorF("error adding items to receipt %d: %v", receipt.ID, err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao adicionar itens. Por favor, tente novamente")
+		return
+	}
+	logger.InfoF("➕ %d item(s) added to receipt %d", len(request.Items), receipt.ID)
+
+	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").First(&receipt, receipt.ID)
+
+	ctx.JSON(http.StatusCreated, newCurrencyConverter(ctx).summary(&receipt))
+}
+
+// splitAmount é a parte de value correspondente a quantity de um total de quantidade whole.
+func splitAmount(value, quantity, whole float64) float64 {
+	return roundCents(value * quantity / whole)
+}
+
+// MoveItemHandler move um item (ou parte dele) para outra nota do usuário
+// @Summary Mover ou dividir item entre notas
+// @Description Move o item para outra nota do usuário. Com quantity menor que a quantidade do item, divide o item: a quantidade informada vai para a nota de destino e o restante fica na original, com total e desconto divididos proporcionalmente. As duas notas têm subtotal, desconto e total recalculados na mesma transação. As notas precisam estar na mesma moeda
+// @Tags items
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Item ID"
+// @Param request body MoveItemRequest true "Nota de destino e quantidade"
+// @Success 200 {object} MoveItemResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /item/{id}/move [post]
+func MoveItemHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var request MoveItemRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		sendError(ctx, http.StatusBadRequest, "Informe targetReceiptId (e, para dividir o item, quantity maior que zero)")
+		return
+	}
+
+	var item schemas.ReceiptItem
+	if err := db.Joins("INNER JOIN receipts ON receipts.id = receipt_items.receipt_id").
+		Where("receipt_items.id = ? AND receipts.user_id = ?", ctx.Param("id"), userID).
+		First(&item).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Item não encontrado ou não pertence ao usuário autenticado")
+		return
+	}
+	if request.TargetReceiptID == item.ReceiptID {
+		sendError(ctx, http.StatusBadRequest, "O item já está nesta nota")
+		return
+	}
+	var target schemas.Receipt
+	if err := db.Where("user_id = ?", userID).First(&target, request.TargetReceiptID).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Nota de destino não encontrada")
+		return
+	}
+	// Os valores do item estão na moeda da nota de origem
+	var origin schemas.Receipt
+	if err := db.Select("id", "currency").First(&origin, item.ReceiptID).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Item não encontrado ou não pertence ao usuário autenticado")
+		return
+	}
+	if currency.NormalizeOr(origin.Currency, currency.Default) != currency.NormalizeOr(target.Currency, currency.Default) {
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("A nota de destino está em %s e o item em %s: só é possível mover itens entre notas da mesma moeda", target.Currency, origin.Currency))
+		return
+	}
+
+	quantity := item.Quantity
+	if request.Quantity != nil {
+		if *request.Quantity > item.Quantity+0.0005 {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("A quantidade a mover passa da quantidade do item (%.3f)", item.Quantity))
+			return
+		}
+		quantity = *request.Quantity
+	}
+	split := item.Quantity-quantity > 0.0005
+
+	moved := item
+	var left *schemas.ReceiptItem
+	var source, destination *schemas.Receipt
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if split {
+			// A parte movida vira um item novo na nota de destino
+			moved = schemas.ReceiptItem{
+				ReceiptID:  target.ID,
+				CategoryID: item.CategoryID,
+				ProductID:  item.ProductID,
+				Quantity:   quantity,
+				UnitPrice:  item.UnitPrice,
+				Total:      splitAmount(item.Total, quantity, item.Quantity),
+				Code:       item.Code,
+			}
+			moved.ApplyDiscount(splitAmount(item.Discount, quantity, item.Quantity))
+			if err := tx.Create(&moved).Error; err != nil {
+				return err
+			}
+
+			remaining := item
+			remaining.Quantity = math.Round((item.Quantity-quantity)*1000) / 1000
+			remaining.Total = roundCents(item.Total - moved.Total)
+			remaining.ApplyDiscount(item.Discount - moved.Discount)
+			if err := tx.Model(&remaining).Select("quantity", "total", "discount", "net_total").Updates(&remaining).Error; err != nil {
+				return err
+			}
+			left = &remaining
+		} else {
+			moved.ReceiptID = target.ID
+			if err := tx.Model(&moved).Update("receipt_id", target.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if source, err = recalculateReceiptTotals(tx, item.ReceiptID, -moved.Discount); err != nil {
+			return err
+		}
+		destination, err = recalculateReceiptTotals(tx, target.ID, moved.Discount)
+		return err
+	})
+	if err != nil {
+		logger.ErrorF("error moving item %d to receipt %d: %v", item.ID, target.ID, err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao mover item. Por favor, tente novamente")
+		return
+	}
+	logger.InfoF("🔀 Item %d moved from receipt %d to %d (quantity %.3f, split: %t)", item.ID, item.ReceiptID, target.ID, quantity, split)
+
+	db.Preload("Category").Preload("Product").First(&moved, moved.ID)
+	response := MoveItemResponse{
+		Message: "Item movido com sucesso",
+		Item:    moved.ToResponse(),
+		Source:  totalsResponse(source),
+		Target:  totalsResponse(destination),
+		Split:   split,
+	}
+	if left != nil {
+		leftResponse := left.ToResponse()
+		response.Left = &leftResponse
+		response.Message = "Item dividido com sucesso"
+	}
+	ctx.JSON(http.StatusOK, response)
+}
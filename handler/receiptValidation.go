@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+// defaultValidationTolerance é a diferença máxima, em reais, aceita entre um valor informado e o
+// calculado (arredondamentos de itens pesados, por exemplo).
+const defaultValidationTolerance = 0.02
+
+// receiptAmounts são os valores de um recibo conferidos pelo validador, independentes da origem
+// (criação manual, preview do QR Code, XML ou foto lida pela IA).
+type receiptAmounts struct {
+	Subtotal float64
+	Discount float64
+	Charges  float64 // Frete e outras despesas acessórias, somados ao total
+	Total    float64
+	Items    []itemAmounts
+}
+
+// itemAmounts são os valores de um item do recibo.
+type itemAmounts struct {
+	Quantity  float64
+	UnitPrice float64
+	Total     float64
+	Discount  float64 // Desconto do item; a soma não pode passar do desconto da nota
+}
+
+// validationOptions configura a conferência.
+type validationOptions struct {
+	Tolerance    float64 // Diferença máxima aceita (RECEIPT_VALIDATION_TOLERANCE)
+	AutoFix      bool    // Preenche valores zerados que podem ser derivados (RECEIPT_VALIDATION_AUTOFIX)
+	PartialItems bool    // Parte dos itens foi removida pelo usuário: a soma não precisa bater com o subtotal
+}
+
+// defaultValidationOptions lê a tolerância e a correção automática das variáveis de ambiente.
+// A correção automática é opcional e fica desligada, a menos que RECEIPT_VALIDATION_AUTOFIX=true.
+func defaultValidationOptions() validationOptions {
+	return validationOptions{
+		Tolerance: getEnvFloat("RECEIPT_VALIDATION_TOLERANCE", defaultValidationTolerance),
+		AutoFix:   os.Getenv("RECEIPT_VALIDATION_AUTOFIX") == "true",
+	}
+}
+
+// roundCents arredonda para centavos.
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// validateReceiptAmounts confere quantidade × preço unitário ≈ total de cada item, soma dos itens ≈
+// subtotal, subtotal - desconto + despesas ≈ total e se os descontos dos itens cabem no desconto da nota. Com AutoFix, valores zerados que podem ser derivados dos
+// outros são preenchidos em amounts e a divergência é marcada como corrigida; divergências entre
+// valores informados nunca são alteradas, só registradas.
+func validateReceiptAmounts(amounts *receiptAmounts, options validationOptions) schemas.ReceiptValidation {
+	tolerance := options.Tolerance
+	var issues []schemas.ValidationIssue
+	add := func(code, field, message string, expected, actual float64, fixed bool) {
+		issues = append(issues, schemas.ValidationIssue{
+			Code:     code,
+			Field:    field,
+			Message:  message,
+			Expected: roundCents(expected),
+			Actual:   roundCents(actual),
+			Fixed:    fixed,
+		})
+	}
+
+	// 1. Itens: quantidade × preço unitário ≈ total
+	sum, itemDiscounts := 0.0, 0.0
+	for i := range amounts.Items {
+		item := &amounts.Items[i]
+		field := fmt.Sprintf("items[%d]", i)
+		switch {
+		case item.Quantity <= 0:
+			add(schemas.IssueInvalidQuantity, field+".quantity", "Quantidade deve ser maior que zero", 0, item.Quantity, false)
+		case item.Total == 0 && item.UnitPrice > 0:
+			expected := roundCents(item.Quantity * item.UnitPrice)
+			add(schemas.IssueItemTotalMissing, field+".total", "Total do item não informado", expected, item.Total, options.AutoFix)
+			if options.AutoFix {
+				item.Total = expected
+			}
+		case item.UnitPrice == 0 && item.Total > 0:
+			expected := roundCents(item.Total / item.Quantity)
+			add(schemas.IssueUnitPriceMissing, field+".unitPrice", "Preço unitário não informado", expected, item.UnitPrice, options.AutoFix)
+			if options.AutoFix {
+				item.UnitPrice = expected
+			}
+		default:
+			// O preço unitário impresso é arredondado: a tolerância cresce com a quantidade
+			expected := item.Quantity * item.UnitPrice
+			if math.Abs(expected-item.Total) > tolerance+0.005*item.Quantity {
+				add(schemas.IssueItemTotalMismatch, field+".total", "Quantidade × preço unitário não bate com o total do item", expected, item.Total, false)
+			}
+		}
+		if item.Discount < 0 || item.Discount > item.Total+tolerance {
+			add(schemas.IssueInvalidDiscount, field+".discount", "Desconto do item deve estar entre zero e o total do item", item.Total, item.Discount, false)
+		}
+		sum += item.Total
+		itemDiscounts += item.Discount
+	}
+
+	// 2. Soma dos itens ≈ subtotal
+	if len(amounts.Items) > 0 {
+		switch {
+		case amounts.Subtotal == 0:
+			add(schemas.IssueSubtotalMissing, "subtotal", "Subtotal não informado", sum, amounts.Subtotal, options.AutoFix)
+			if options.AutoFix {
+				amounts.Subtotal = roundCents(sum)
+			}
+		case !options.PartialItems && math.Abs(sum-amounts.Subtotal) > tolerance:
+			add(schemas.IssueSubtotalMismatch, "subtotal", "A soma dos itens não bate com o subtotal", sum, amounts.Subtotal, false)
+		}
+	}
+
+	// 3. Subtotal - desconto + frete e outras despesas ≈ total
+	expectedTotal := amounts.Subtotal - amounts.Discount + amounts.Charges
+	switch {
+	case amounts.Discount < 0:
+		add(schemas.IssueInvalidDiscount, "discount", "Desconto não pode ser negativo", 0, amounts.Discount, false)
+	case amounts.Total == 0 && expectedTotal > 0:
+		add(schemas.IssueTotalMissing, "total", "Total não informado", expectedTotal, amounts.Total, options.AutoFix)
+		if options.AutoFix {
+			amounts.Total = roundCents(expectedTotal)
+		}
+	case math.Abs(expectedTotal-amounts.Total) <= tolerance:
+	case amounts.Discount == 0 && amounts.Total > 0 && amounts.Total < amounts.Subtotal+amounts.Charges:
+		// Desconto não lido: é a diferença entre subtotal (com as despesas) e total
+		discount := amounts.Subtotal + amounts.Charges - amounts.Total
+		add(schemas.IssueDiscountMissing, "discount", "Total menor que o subtotal sem desconto informado", discount, amounts.Discount, options.AutoFix)
+		if options.AutoFix {
+			amounts.Discount = roundCents(discount)
+		}
+	default:
+		add(schemas.IssueTotalMismatch, "total", "Subtotal menos desconto, mais frete e outras despesas, não bate com o total", expectedTotal, amounts.Total, false)
+	}
+
+	// 4. Descontos dos itens ≤ desconto da nota
+	if itemDiscounts > amounts.Discount+tolerance {
+		add(schemas.IssueItemDiscountMismatch, "discount", "A soma dos descontos dos itens passa do desconto da nota", itemDiscounts, amounts.Discount, false)
+	}
+
+	return schemas.NewReceiptValidation(issues)
+}
+
+// validatePreviewData confere os valores do preview, aplica as correções e guarda o resultado
+// em data.Validation para o app mostrar antes da confirmação.
+func validatePreviewData(data *PreviewReceiptData) {
+	amounts := receiptAmounts{Subtotal: data.Subtotal, Discount: data.Discount, Charges: data.Freight + data.OtherCharges, Total: data.Total}
+	for _, item := range data.Items {
+		amounts.Items = append(amounts.Items, itemAmounts{Quantity: item.Quantity, UnitPrice: item.UnitPrice, Total: item.Total, Discount: item.Discount})
+	}
+
+	validation := validateReceiptAmounts(&amounts, defaultValidationOptions())
+	data.Subtotal, data.Discount, data.Total = amounts.Subtotal, amounts.Discount, amounts.Total
+	for i := range data.Items {
+		data.Items[i].UnitPrice = amounts.Items[i].UnitPrice
+		data.Items[i].Total = amounts.Items[i].Total
+	}
+	data.Validation = &validation
+}
+
+// revalidateReceipt confere de novo um recibo e seus itens depois de edições do usuário.
+// Não corrige nada: valores editados pelo usuário são mantidos como estão.
+func revalidateReceipt(receipt *schemas.Receipt, items []schemas.ReceiptItem) {
+	amounts := receiptAmounts{Subtotal: receipt.Subtotal, Discount: receipt.Discount, Charges: receipt.Charges(), Total: receipt.Total}
+	for _, item := range items {
+		amounts.Items = append(amounts.Items, itemAmounts{Quantity: item.Quantity, UnitPrice: item.UnitPrice, Total: item.Total, Discount: item.Discount})
+	}
+	options := defaultValidationOptions()
+	options.AutoFix = false
+	receipt.ApplyValidation(validateReceiptAmounts(&amounts, options))
+}
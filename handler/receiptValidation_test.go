@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+)
+
+func issueCodes(validation schemas.ReceiptValidation) []string {
+	codes := make([]string, len(validation.Issues))
+	for i, issue := range validation.Issues {
+		codes[i] = issue.Code
+	}
+	return codes
+}
+
+func TestValidateReceiptAmounts(t *testing.T) {
+	options := validationOptions{Tolerance: defaultValidationTolerance, AutoFix: true}
+
+	t.Run("valid receipt with weighed item", func(t *testing.T) {
+		amounts := receiptAmounts{Subtotal: 19.80, Discount: 1.00, Total: 18.80, Items: []itemAmounts{
+			{Quantity: 2, UnitPrice: 5.00, Total: 10.00},
+			{Quantity: 0.755, UnitPrice: 12.98, Total: 9.80},
+		}}
+		validation := validateReceiptAmounts(&amounts, options)
+		if validation.Status != schemas.ReceiptValid || len(validation.Issues) != 0 {
+			t.Errorf("got %s %v, want valid without issues", validation.Status, issueCodes(validation))
+		}
+	})
+
+	t.Run("derives missing values", func(t *testing.T) {
+		amounts := receiptAmounts{Total: 15.00, Items: []itemAmounts{
+			{Quantity: 2, UnitPrice: 5.00},
+			{Quantity: 3, Total: 6.00},
+		}}
+		validation := validateReceiptAmounts(&amounts, options)
+		if validation.Status != schemas.ReceiptFixed {
+			t.Fatalf("status = %s (%v), want fixed", validation.Status, issueCodes(validation))
+		}
+		if amounts.Items[0].Total != 10.00 || amounts.Items[1].UnitPrice != 2.00 {
+			t.Errorf("items = %+v, want derived total and unit price", amounts.Items)
+		}
+		if amounts.Subtotal != 16.00 || amounts.Discount != 1.00 {
+			t.Errorf("subtotal = %.2f, discount = %.2f, want 16.00 and 1.00", amounts.Subtotal, amounts.Discount)
+		}
+	})
+
+	t.Run("freight and other charges add to the total", func(t *testing.T) {
+		amounts := receiptAmounts{Subtotal: 100.00, Discount: 5.00, Charges: 12.50, Total: 107.50, Items: []itemAmounts{
+			{Quantity: 4, UnitPrice: 25.00, Total: 100.00},
+		}}
+		validation := validateReceiptAmounts(&amounts, options)
+		if validation.Status != schemas.ReceiptValid || len(validation.Issues) != 0 {
+			t.Errorf("got %s %v, want valid without issues", validation.Status, issueCodes(validation))
+		}
+
+		amounts.Charges = 0
+		validation = validateReceiptAmounts(&amounts, validationOptions{Tolerance: defaultValidationTolerance})
+		if got := issueCodes(validation); len(got) != 1 || got[0] != schemas.IssueTotalMismatch {
+			t.Errorf("issues without charges = %v, want [%s]", got, schemas.IssueTotalMismatch)
+		}
+	})
+
+	t.Run("reports mismatches without changing them", func(t *testing.T) {
+		amounts := receiptAmounts{Subtotal: 30.00, Discount: 2.00, Total: 20.00, Items: []itemAmounts{
+			{Quantity: 2, UnitPrice: 5.00, Total: 12.00},
+			{Quantity: 1, UnitPrice: 10.00, Total: 10.00},
+		}}
+		validation := validateReceiptAmounts(&amounts, options)
+		want := []string{schemas.IssueItemTotalMismatch, schemas.IssueSubtotalMismatch, schemas.IssueTotalMismatch}
+		if got := issueCodes(validation); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Errorf("issues = %v, want %v", got, want)
+		}
+		if validation.Status != schemas.ReceiptInvalid || amounts.Subtotal != 30.00 || amounts.Total != 20.00 {
+			t.Errorf("status = %s, amounts = %+v", validation.Status, amounts)
+		}
+	})
+
+	t.Run("partial items skip the subtotal check", func(t *testing.T) {
+		amounts := receiptAmounts{Subtotal: 30.00, Total: 30.00, Items: []itemAmounts{{Quantity: 1, UnitPrice: 10.00, Total: 10.00}}}
+		partial := options
+		partial.PartialItems = true
+		if validation := validateReceiptAmounts(&amounts, partial); validation.Status != schemas.ReceiptValid {
+			t.Errorf("status = %s (%v), want valid", validation.Status, issueCodes(validation))
+		}
+	})
+
+	t.Run("item discounts must fit in the receipt discount", func(t *testing.T) {
+		amounts := receiptAmounts{Subtotal: 20.00, Discount: 1.00, Total: 19.00, Items: []itemAmounts{
+			{Quantity: 1, UnitPrice: 10.00, Total: 10.00, Discount: 1.00},
+			{Quantity: 1, UnitPrice: 10.00, Total: 10.00, Discount: 0.50},
+		}}
+		validation := validateReceiptAmounts(&amounts, options)
+		if codes := issueCodes(validation); len(codes) != 1 || codes[0] != schemas.IssueItemDiscountMismatch {
+			t.Errorf("issues = %v, want [%s]", codes, schemas.IssueItemDiscountMismatch)
+		}
+	})
+
+	t.Run("without autofix derivable values stay as issues", func(t *testing.T) {
+		amounts := receiptAmounts{Subtotal: 10.00, Total: 9.00, Items: []itemAmounts{{Quantity: 1, UnitPrice: 10.00, Total: 10.00}}}
+		validation := validateReceiptAmounts(&amounts, validationOptions{Tolerance: defaultValidationTolerance})
+		if validation.Status != schemas.ReceiptInvalid || amounts.Discount != 0 {
+			t.Errorf("status = %s, discount = %.2f, want invalid and unchanged", validation.Status, amounts.Discount)
+		}
+	})
+}
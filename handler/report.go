@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+// TaxBreakdown acumula o gasto e os tributos aproximados de um grupo de notas.
+type TaxBreakdown struct {
+	Spent      float64 `json:"spent"`      // Total das notas que informam tributos
+	Taxes      float64 `json:"taxes"`      // Tributos aproximados
+	Federal    float64 `json:"federal"`    // Parcela federal
+	State      float64 `json:"state"`      // Parcela estadual
+	Municipal  float64 `json:"municipal"`  // Parcela municipal
+	Percentage float64 `json:"percentage"` // Tributos / gasto (%)
+}
+
+// TaxMonthReport são os tributos de um mês.
+type TaxMonthReport struct {
+	Month string `json:"month"` // YYYY-MM
+	TaxBreakdown
+}
+
+// TaxStoreReport são os tributos pagos em uma loja.
+type TaxStoreReport struct {
+	StoreID   *uint  `json:"storeId,omitempty"`
+	StoreName string `json:"storeName"`
+	TaxBreakdown
+}
+
+// TaxCategoryReport é a estimativa de tributos de uma categoria, rateada pelo total dos itens.
+type TaxCategoryReport struct {
+	CategoryID   uint   `json:"categoryId"`
+	CategoryName string `json:"categoryName"`
+	TaxBreakdown
+}
+
+// TaxReportResponse é a resposta de GET /reports/taxes.
+type TaxReportResponse struct {
+	Start                string `json:"start"` // Primeiro mês (YYYY-MM)
+	End                  string `json:"end"`   // Último mês (YYYY-MM)
+	TaxBreakdown                // Totais do período
+	ReceiptsWithTaxes    int    `json:"receiptsWithTaxes"`
+	ReceiptsWithoutTaxes int    `json:"receiptsWithoutTaxes"` // Notas que não informam tributos (ficam fora dos totais)
+
+	BaseCurrency string                `json:"baseCurrency"`           // Moeda dos valores
+	MissingRates []MissingExchangeRate `json:"missingRates,omitempty"` // Notas sem cotação (ficam fora dos totais)
+
+	Months     []TaxMonthReport    `json:"months"`
+	Stores     []TaxStoreReport    `json:"stores"`
+	Categories []TaxCategoryReport `json:"categories"`
+}
+
+// parseReportMonths lê os parâmetros start/end (YYYY-MM) dos relatórios mensais.
+// O padrão são os últimos 12 meses, incluindo o atual. Retorna false quando já respondeu 400.
+func parseReportMonths(ctx *gin.Context) (time.Time, time.Time, bool) {
+	now := time.Now()
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	start := end.AddDate(0, -11, 0)
+
+	var err error
+	if value := ctx.Query("start"); value != "" {
+		if start, err = time.ParseInLocation("2006-01", value, time.Local); err != nil {
+			sendError(ctx, http.StatusBadRequest, "Formato de start inválido. Use YYYY-MM (exemplo: 2024-01)")
+			return start, end, false
+		}
+	}
+	if value := ctx.Query("end"); value != "" {
+		if end, err = time.ParseInLocation("2006-01", value, time.Local); err != nil {
+			sendError(ctx, http.StatusBadRequest, "Formato de end inválido. Use YYYY-MM (exemplo: 2024-12)")
+			return start, end, false
+		}
+	}
+	if end.Before(start) {
+		sendError(ctx, http.StatusBadRequest, "start deve ser anterior ou igual a end")
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// add soma uma parcela de uma nota ao grupo. share é a fração da nota (entre 0 e 1) já
+// multiplicada pela cotação que converte para a moeda base.
+func (b *TaxBreakdown) add(receipt *schemas.Receipt, share float64) {
+	b.Spent += receipt.Total * share
+	b.Taxes += receipt.TaxTotal * share
+	b.Federal += receipt.TaxFederal * share
+	b.State += receipt.TaxState * share
+	b.Municipal += receipt.TaxMunicipal * share
+}
+
+// round arredonda os valores para centavos e calcula a carga tributária.
+func (b TaxBreakdown) round() TaxBreakdown {
+	cents := func(v float64) float64 { return math.Round(v*100) / 100 }
+	rounded := TaxBreakdown{
+		Spent:     cents(b.Spent),
+		Taxes:     cents(b.Taxes),
+		Federal:   cents(b.Federal),
+		State:     cents(b.State),
+		Municipal: cents(b.Municipal),
+	}
+	if b.Spent > 0 {
+		rounded.Percentage = math.Round(b.Taxes/b.Spent*10000) / 100
+	}
+	return rounded
+}
+
+// GetTaxReportHandler resume os tributos aproximados pagos pelo usuário
+// @Summary Tributos aproximados (Lei 12.741)
+// @Description Soma o valor aproximado dos tributos informado nas notas (federal, estadual e municipal) por mês, por loja e por categoria. A parcela de cada categoria é estimada proporcionalmente ao total dos itens. Valores convertidos para a moeda base do usuário pela cotação da data da nota. Notas sem a informação ou sem cotação ficam fora dos totais. Padrão: últimos 12 meses
+// @Tags reports
+// @Produce json
+// @Param start query string false "Primeiro mês (YYYY-MM)"
+// @Param end query string false "Último mês (YYYY-MM)"
+// @Success 200 {object} TaxReportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /reports/taxes [get]
+func GetTaxReportHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	start, end, ok := parseReportMonths(ctx)
+	if !ok {
+		return
+	}
+
+	var receipts []schemas.Receipt
+	if err := db.Preload("Items").
+		Where("user_id = ? AND date >= ? AND date < ?", userID, start.Format("2006-01-02"), end.AddDate(0, 1, 0).Format("2006-01-02")).
+		Order("date").
+		Find(&receipts).Error; err != nil {
+		logger.ErrorF("error loading receipts for tax report: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao gerar relatório de tributos")
+		return
+	}
+
+	var categories []schemas.Category
+	if err := db.Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		logger.ErrorF("error loading categories for tax report: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao gerar relatório de tributos")
+		return
+	}
+	categoryNames := make(map[uint]string, len(categories))
+	for _, category := range categories {
+		categoryNames[category.ID] = category.Name
+	}
+
+	converter := newCurrencyConverter(ctx)
+	response := TaxReportResponse{
+		Start:        start.Format("2006-01"),
+		End:          end.Format("2006-01"),
+		BaseCurrency: converter.base,
+	}
+	var period TaxBreakdown
+	months := map[string]*TaxBreakdown{}
+	stores := map[string]*TaxStoreReport{}
+	byCategory := map[uint]*TaxBreakdown{}
+
+	for i := range receipts {
+		receipt := &receipts[i]
+		if receipt.TaxTotal <= 0 || len(receipt.Date) < 7 {
+			response.ReceiptsWithoutTaxes++
+			continue
+		}
+		rate, ok := converter.rate(receipt.Currency, receipt.Date)
+		if !ok {
+			continue
+		}
+		response.ReceiptsWithTaxes++
+		period.add(receipt, rate)
+
+		month := receipt.Date[:7]
+		if months[month] == nil {
+			months[month] = &TaxBreakdown{}
+		}
+		months[month].add(receipt, rate)
+
+		// Notas sem loja vinculada são agrupadas pelo nome
+		storeKey := "name:" + receipt.StoreName
+		if receipt.StoreID != nil {
+			storeKey = "id:" + strconv.FormatUint(uint64(*receipt.StoreID), 10)
+		}
+		if stores[storeKey] == nil {
+			stores[storeKey] = &TaxStoreReport{StoreID: receipt.StoreID, StoreName: receipt.StoreName}
+		}
+		stores[storeKey].add(receipt, rate)
+
+		// Rateio por categoria proporcional ao valor pago por item (já descontado)
+		itemsTotal := 0.0
+		for _, item := range receipt.Items {
+			itemsTotal += item.NetTotal
+		}
+		if itemsTotal <= 0 {
+			continue
+		}
+		for _, item := range receipt.Items {
+			if byCategory[item.CategoryID] == nil {
+				byCategory[item.CategoryID] = &TaxBreakdown{}
+			}
+			byCategory[item.CategoryID].add(receipt, rate*item.NetTotal/itemsTotal)
+		}
+	}
+
+	response.TaxBreakdown = period.round()
+	response.MissingRates = converter.missingRates()
+
+	response.Months = []TaxMonthReport{}
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01")
+		breakdown := TaxBreakdown{}
+		if months[key] != nil {
+			breakdown = months[key].round()
+		}
+		response.Months = append(response.Months, TaxMonthReport{Month: key, TaxBreakdown: breakdown})
+	}
+
+	response.Stores = []TaxStoreReport{}
+	for _, store := range stores {
+		store.TaxBreakdown = store.TaxBreakdown.round()
+		response.Stores = append(response.Stores, *store)
+	}
+	sort.Slice(response.Stores, func(i, j int) bool { return response.Stores[i].Taxes > response.Stores[j].Taxes })
+
+	response.Categories = []TaxCategoryReport{}
+	for categoryID, breakdown := range byCategory {
+		name, ok := categoryNames[categoryID]
+		if !ok {
+			name = "Sem categoria"
+		}
+		response.Categories = append(response.Categories, TaxCategoryReport{
+			CategoryID:   categoryID,
+			CategoryName: name,
+			TaxBreakdown: breakdown.round(),
+		})
+	}
+	sort.Slice(response.Categories, func(i, j int) bool { return response.Categories[i].Taxes > response.Categories[j].Taxes })
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -1,209 +1,92 @@
 package handler
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"math"
 	"net/http"
-	"os"
-	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Pmmvito/Golang-Api-Exemple/llm"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
 	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
 	"github.com/PuerkitoBio/goquery"
 )
 
 // NFCeData representa os dados extraídos de uma NFC-e através de scraping.
-type NFCeData struct {
-	StoreName  string
-	Date       string
-	Items      []NFCeItem
-	Subtotal   float64
-	Discount   float64
-	Total      float64
-	ItemsCount int
-	AccessKey  string
-	Number     string
-}
+type NFCeData = nfce.Data
 
 // NFCeItem representa um único item dentro de uma NFC-e.
-type NFCeItem struct {
-	ItemNumber  int
-	Code        string
-	Description string
-	Quantity    float64
-	Unit        string
-	UnitPrice   float64
-	Total       float64
-}
+type NFCeItem = nfce.Item
+
+// errPortalUnavailable indica que o portal da SEFAZ está fora do ar ou em manutenção.
+// A consulta pode ser repetida mais tarde (ver fila de notas pendentes).
+var errPortalUnavailable = errors.New("SEFAZ portal is unavailable")
+
+// errNFCeNotFound indica que o portal respondeu, mas a nota ainda não consta na SEFAZ
+// (não encontrada ou em processamento), comum em notas emitidas em contingência.
+var errNFCeNotFound = errors.New("NFC-e not found on the SEFAZ portal yet")
+
+// nfceHTTPClient consulta os portais com timeout, para que um portal travado conte como indisponível.
+var nfceHTTPClient = &http.Client{Timeout: 30 * time.Second}
 
 // scrapeNFCe faz scraping da página da NFC-e e extrai os dados
+// usando o parser do portal SEFAZ do estado (escolhido pela chave de acesso ou pelo host).
+// Erros de rede, respostas 5xx/429 e páginas de manutenção são retornados como errPortalUnavailable;
+// respostas de nota não encontrada ou em processamento, como errNFCeNotFound.
 func scrapeNFCe(url string) (*NFCeData, error) {
-	resp, err := http.Get(url)
+	resp, err := nfceHTTPClient.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch NFC-e page: %w", err)
+		return nil, fmt.Errorf("%w: failed to fetch NFC-e page: %v", errPortalUnavailable, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: failed to fetch NFC-e page: status code %d", errPortalUnavailable, resp.StatusCode)
+	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("failed to fetch NFC-e page: status code %d", resp.StatusCode)
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %w", err)
-	}
-
-	data := &NFCeData{
-		Items: []NFCeItem{},
-	}
-
-	// Tenta extrair o nome da loja do elemento específico
-	data.StoreName = strings.TrimSpace(doc.Find("#u20.txtTopo").First().Text())
-	if data.StoreName == "" {
-		// Fallback: tenta outros seletores
-		data.StoreName = strings.TrimSpace(doc.Find(".txtCenter .text").First().Text())
-	}
-	if data.StoreName == "" {
-		data.StoreName = strings.TrimSpace(doc.Find("#infos .text").First().Text())
+		return nil, fmt.Errorf("%w: failed to read NFC-e page: %v", errPortalUnavailable, err)
 	}
 
-	doc.Find("*").Each(func(i int, s *goquery.Selection) {
-		text := s.Text()
-
-		if strings.Contains(text, "Número:") && data.Number == "" {
-			re := regexp.MustCompile(`Número:\s*(\d+)`)
-			if matches := re.FindStringSubmatch(text); len(matches) > 1 {
-				data.Number = matches[1]
-			}
-		}
-
-		if strings.Contains(text, "Emissão:") && data.Date == "" {
-			re := regexp.MustCompile(`Emissão:\s*(\d{2}/\d{2}/\d{4})`)
-			if matches := re.FindStringSubmatch(text); len(matches) > 1 {
-				dateParts := strings.Split(matches[1], "/")
-				if len(dateParts) == 3 {
-					data.Date = fmt.Sprintf("%s-%s-%s", dateParts[2], dateParts[1], dateParts[0])
-				}
-			}
-		}
-	})
-
-	data.AccessKey = strings.ReplaceAll(strings.TrimSpace(doc.Find(".chave").Text()), " ", "")
-
-	itemNum := 0
-	doc.Find("table tr").Each(func(i int, s *goquery.Selection) {
-		fullText := s.Text()
-		if !strings.Contains(fullText, "Código:") {
-			return
-		}
-
-		itemNum++
-
-		description := ""
-		if idx := strings.Index(fullText, " (Código:"); idx > 0 {
-			description = strings.TrimSpace(fullText[:idx])
-		}
-
-		code := ""
-		re := regexp.MustCompile(`\(Código:\s*([^\)]+)\)`)
-		if matches := re.FindStringSubmatch(fullText); len(matches) > 1 {
-			code = strings.TrimSpace(matches[1])
-		}
-
-		quantity := 0.0
-		unit := "UN"
-		re = regexp.MustCompile(`Qtde\.:\s*([0-9,\.]+)\s+UN:\s*([A-Z]+)`)
-		if matches := re.FindStringSubmatch(fullText); len(matches) > 2 {
-			quantityStr := strings.ReplaceAll(matches[1], ",", ".")
-			quantity, _ = strconv.ParseFloat(quantityStr, 64)
-			unit = matches[2]
-		}
-
-		total := 0.0
-		totalText := strings.TrimSpace(s.Find("span.valor").First().Text())
-		totalText = strings.ReplaceAll(totalText, "\u00a0", " ")
-		if totalValue := extractNumericValue(totalText); totalValue != "" {
-			total = parseFloat(totalValue)
-		}
-
-		unitPrice := 0.0
-		unitPriceText := strings.TrimSpace(s.Find("span.RvlUnit").First().Text())
-		unitPriceText = strings.ReplaceAll(unitPriceText, "\u00a0", " ")
-		if unitPriceValue := extractNumericValue(unitPriceText); unitPriceValue != "" {
-			unitPrice = parseFloat(unitPriceValue)
-		} else if total > 0 && quantity > 0 {
-			unitPrice = total / quantity
+	parser := nfce.ParserFor(url)
+	data, err := parser.Parse(doc)
+	if err != nil {
+		if nfce.IsUnavailablePage(doc) {
+			return nil, fmt.Errorf("%w: portal returned a maintenance page", errPortalUnavailable)
 		}
-
-		unitPrice = math.Round(unitPrice*100) / 100
-
-		if description != "" {
-			data.Items = append(data.Items, NFCeItem{
-				ItemNumber:  itemNum,
-				Code:        code,
-				Description: description,
-				Quantity:    quantity,
-				Unit:        unit,
-				UnitPrice:   unitPrice,
-				Total:       total,
-			})
+		if nfce.IsNotYetAvailablePage(doc) {
+			return nil, errNFCeNotFound
 		}
-	})
-
-	fullHTML := doc.Text()
-
-	re := regexp.MustCompile(`Qtd\.\s*total\s*de\s*itens:\s*(\d+)`)
-	if matches := re.FindStringSubmatch(fullHTML); len(matches) > 1 {
-		data.ItemsCount, _ = strconv.Atoi(matches[1])
-	}
-
-	re = regexp.MustCompile(`Valor\s*total\s*R\$:\s*([0-9,.]+)`)
-	if matches := re.FindStringSubmatch(fullHTML); len(matches) > 1 {
-		data.Subtotal = parseFloat(matches[1])
-	}
-
-	re = regexp.MustCompile(`Descontos\s*R\$:\s*([0-9,.]+)`)
-	if matches := re.FindStringSubmatch(fullHTML); len(matches) > 1 {
-		data.Discount = parseFloat(matches[1])
-	}
-
-	re = regexp.MustCompile(`Valor\s*a\s*pagar\s*R\$:\s*([0-9,.]+)`)
-	if matches := re.FindStringSubmatch(fullHTML); len(matches) > 1 {
-		data.Total = parseFloat(matches[1])
+		return nil, fmt.Errorf("failed to parse NFC-e page: %w", err)
 	}
 
 	if len(data.Items) == 0 {
+		if nfce.IsUnavailablePage(doc) {
+			return nil, fmt.Errorf("%w: portal returned a maintenance page", errPortalUnavailable)
+		}
+		if nfce.IsNotYetAvailablePage(doc) {
+			return nil, errNFCeNotFound
+		}
 		return nil, fmt.Errorf("no items found in NFC-e. Please check the QR Code URL")
 	}
 
-	logger.InfoF("✅ NFC-e scraped successfully: %s - %d items - Total: R$ %.2f", data.StoreName, len(data.Items), data.Total)
+	logger.InfoF("✅ NFC-e scraped successfully (parser: %s): %s - %d items - Total: R$ %.2f", parserName(parser), data.StoreName, len(data.Items), data.Total)
 
 	return data, nil
 }
 
-// parseFloat converte string brasileira (1.683,25) para float64
-func parseFloat(s string) float64 {
-	s = strings.TrimSpace(s)
-	s = strings.ReplaceAll(s, ".", "")
-	s = strings.ReplaceAll(s, ",", ".")
-	f, _ := strconv.ParseFloat(s, 64)
-	return f
-}
-
-func extractNumericValue(raw string) string {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return ""
+// parserName retorna a UF do parser usado, ou "genérico" para o parser padrão.
+func parserName(p nfce.Parser) string {
+	if p.UF() == "" {
+		return "genérico"
 	}
-
-	raw = strings.ReplaceAll(raw, "\u00a0", " ")
-	raw = strings.ReplaceAll(raw, "R$", "")
-	clean := regexp.MustCompile(`\d[\d\.,]*`).FindString(raw)
-	return strings.TrimSpace(clean)
+	return p.UF()
 }
 
 // normalizeUnit padroniza as unidades de medida
@@ -244,41 +127,19 @@ type CategorizedItem struct {
 
 // CategorizationResult contém os itens categorizados e os metadados de uso de tokens
 type CategorizationResult struct {
-	Items          []CategorizedItem
+	Items          []CategorizedItem // Um por item enviado, na mesma ordem
+	Model          string            // Modelo que respondeu, gravado no uso de tokens
+	Warnings       []string          // Itens que a IA não categorizou e foram para "Não categorizado", com o motivo
+	Resolved       int               // Itens que receberam uma categoria válida da IA
 	PromptTokens   int
 	ResponseTokens int
 	TotalTokens    int
 }
 
-// categorizeItemsWithAI usa o Gemini para categorizar os itens extraídos do scraping
-func categorizeItemsWithAI(items []NFCeItem, userID uint) (*CategorizationResult, error) {
+// categorizeItemsWithAI usa o provedor de IA configurado para categorizar os itens extraídos do scraping
+func categorizeItemsWithAI(ctx context.Context, items []NFCeItem, userID uint) (*CategorizationResult, error) {
 	logger.InfoF("🤖 categorizeItemsWithAI called with %d items for user %d", len(items), userID)
 
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		logger.ErrorF("❌ GEMINI_API_KEY não configurada")
-		return nil, fmt.Errorf("GEMINI_API_KEY não configurada")
-	}
-	logger.InfoF("✅ GEMINI_API_KEY found (length: %d)", len(apiKey))
-
-	// Enforce gemini-2.5-flash only (ignore other env values)
-	model := os.Getenv("GEMINI_MODEL")
-	if model != "gemini-2.5-flash" {
-		if model != "" {
-			logger.WarnF("GEMINI_MODEL value '%s' is not supported. Overriding to 'gemini-2.5-flash'", model)
-		}
-		model = "gemini-2.5-flash"
-	}
-	logger.InfoF("📦 Enforced model: %s", model)
-
-	// Modelos preview/experimentais usam v1beta, modelos estáveis usam v1
-	apiVersion := "v1"
-	// Não utilizar a presença de "2.5" como indicador de preview; apenas flags explícitas
-	if strings.Contains(model, "preview") || strings.Contains(model, "exp-") {
-		apiVersion = "v1beta"
-	}
-	logger.InfoF("🔧 Using API version: %s", apiVersion)
-
 	// Busca categorias disponíveis DO USUÁRIO
 	var categories []schemas.Category
 	db.Where("user_id = ?", userID).Order("name ASC").Find(&categories)
@@ -289,121 +150,58 @@ func categorizeItemsWithAI(items []NFCeItem, userID uint) (*CategorizationResult
 	}
 	logger.InfoF("✅ Found %d categories in database", len(categories))
 
-	// Monta prompt para categorização
-	logger.InfoF("📝 Building categorization prompt...")
-	prompt := buildCategorizationPrompt(items, categories)
-	logger.InfoF("📝 Prompt built (%d chars)", len(prompt))
-
-	// Prepara request para Gemini
-	parts := []GeminiPart{
-		{Text: prompt},
-	}
-
-	reqBody := GeminiRequest{
-		Contents: []GeminiContent{
-			{Parts: parts},
-		},
-	}
-
-	jsonData, err := json.Marshal(reqBody)
+	logger.InfoF("🌐 Calling %s (model: %s)...", aiProvider.Name(), aiProvider.Model())
+	result, err := categorizeItems(ctx, aiProvider, items, categories)
 	if err != nil {
-		logger.ErrorF("❌ Failed to marshal request: %v", err)
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		logger.ErrorF("❌ AI categorization failed: %v", err)
+		return nil, err
 	}
 
-	// Faz request para Gemini — apenas uma tentativa com o modelo 'gemini-2.5-flash'
-	attemptModel := model
-	attemptAPIVersion := apiVersion
-	var resp *http.Response
-	var body []byte
-	logger.InfoF("🌐 Calling Gemini API (model: %s, apiVersion: %s)...", attemptModel, attemptAPIVersion)
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/%s/models/%s:generateContent?key=%s", attemptAPIVersion, attemptModel, apiKey)
-	resp, err = http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		logger.ErrorF("❌ Failed to call Gemini API: %v", err)
-		return nil, fmt.Errorf("failed to call Gemini API: %w", err)
-	}
-	body, err = io.ReadAll(resp.Body)
-	if err != nil {
-		resp.Body.Close()
-		logger.ErrorF("❌ Failed to read response from Gemini API: %v", err)
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-	resp.Body.Close()
-	if resp.StatusCode != 200 {
-		// If 404 - model not found, show clearer error and do not fallback.
-		if resp.StatusCode == http.StatusNotFound {
-			logger.ErrorF("❌ Gemini API 404: model not found; only 'gemini-2.5-flash' is supported: %s", string(body))
-			return nil, fmt.Errorf("gemini API error (status %d): model not found; only 'gemini-2.5-flash' is supported. Detail: %s", resp.StatusCode, string(body))
-		}
-		logger.ErrorF("❌ Gemini API error (status %d): %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("gemini API error (status %d): %s", resp.StatusCode, string(body))
-	}
-	logger.InfoF("✅ Gemini API responded with status: %d", resp.StatusCode)
-	logger.InfoF("📄 Response body length: %d bytes", len(body))
-
-	// Parse response
-	var geminiResp GeminiResponse
-	if err := json.Unmarshal(body, &geminiResp); err != nil {
-		logger.ErrorF("❌ Failed to parse response: %v", err)
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
+	logger.InfoF("✅ Successfully categorized %d items", len(result.Items))
+	logger.InfoF("📊 Token usage - Prompt: %d, Response: %d, Total: %d",
+		result.PromptTokens, result.ResponseTokens, result.TotalTokens)
 
-	if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
-		logger.ErrorF("❌ No response from Gemini")
-		return nil, fmt.Errorf("no response from Gemini")
-	}
+	return result, nil
+}
 
-	// Extrai JSON da resposta
-	responseText := geminiResp.Candidates[0].Content.Parts[0].Text
-	logger.InfoF("📝 Gemini response text length: %d chars", len(responseText))
-	logger.InfoF("📝 First 200 chars: %s", responseText[:min(200, len(responseText))])
-
-	// Remove markdown code blocks se existirem
-	responseText = strings.TrimSpace(responseText)
-	responseText = strings.TrimPrefix(responseText, "```json")
-	responseText = strings.TrimPrefix(responseText, "```")
-	responseText = strings.TrimSuffix(responseText, "```")
-	responseText = strings.TrimSpace(responseText)
-
-	// Parse JSON de categorização
-	var categorizedItems []CategorizedItem
-	if err := json.Unmarshal([]byte(responseText), &categorizedItems); err != nil {
-		logger.ErrorF("❌ Failed to parse categorization JSON: %v", err)
-		logger.ErrorF("❌ Response text: %s", responseText)
-		return nil, fmt.Errorf("failed to parse categorization JSON: %w - Response: %s", err, responseText)
-	}
+// categorizeItems pede ao provedor a categoria de cada item, entre as categorias informadas.
+// A resposta é validada (com uma chamada de reparo quando rejeitada); itens que continuarem sem
+// categoria válida vão para "Não categorizado", com o motivo em Warnings.
+func categorizeItems(ctx context.Context, provider llm.AIProvider, items []NFCeItem, categories []schemas.Category) (*CategorizationResult, error) {
+	request := llm.Request{Prompt: buildCategorizationPrompt(items, categories), Schema: categorizationSchema}
 
-	logger.InfoF("✅ Successfully categorized %d items", len(categorizedItems))
-	// Log primeiro item como exemplo
-	if len(categorizedItems) > 0 {
-		logger.InfoF("📋 Example: '%s' -> Category ID %d", categorizedItems[0].Description, categorizedItems[0].CategoryID)
+	index := newCategoryIndex(categories)
+	var categorized []CategorizedItem
+	usage, _, err := generateJSON(ctx, provider, request, &categorized, func() []string {
+		return validateCategorizations(items, categorized, index)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Extrai metadados de uso de tokens
 	result := &CategorizationResult{
-		Items: categorizedItems,
+		Items:          make([]CategorizedItem, len(items)),
+		Model:          usage.Model,
+		PromptTokens:   usage.PromptTokens,
+		ResponseTokens: usage.ResponseTokens,
+		TotalTokens:    usage.TotalTokens,
 	}
-
-	if geminiResp.UsageMetadata != nil {
-		result.PromptTokens = geminiResp.UsageMetadata.PromptTokenCount
-		result.ResponseTokens = geminiResp.UsageMetadata.CandidatesTokenCount
-		result.TotalTokens = geminiResp.UsageMetadata.TotalTokenCount
-		logger.InfoF("📊 Token usage - Prompt: %d, Response: %d, Total: %d",
-			result.PromptTokens, result.ResponseTokens, result.TotalTokens)
+	for i, item := range items {
+		result.Items[i] = CategorizedItem{Description: item.Description, CategoryID: index.uncategorized}
+		switch {
+		case i >= len(categorized):
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: a IA não retornou categoria", item.Description))
+		case !index.valid(categorized[i].CategoryID):
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: a IA retornou a categoria inválida %d", item.Description, categorized[i].CategoryID))
+		default:
+			result.Items[i].CategoryID = categorized[i].CategoryID
+			result.Resolved++
+		}
 	}
 
 	return result, nil
 }
 
-// Helper function para min
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // buildCategorizationPrompt constrói o prompt para categorização
 func buildCategorizationPrompt(items []NFCeItem, categories []schemas.Category) string {
 	var builder strings.Builder
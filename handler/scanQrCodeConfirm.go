@@ -2,39 +2,38 @@ package handler
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
 	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-// ScanQRCodeConfirmRequest define a estrutura do corpo da requisição para a confirmação de um QR code escaneado.
-// Esta estrutura é idêntica a PreviewReceiptData e contém todos os dados do recibo, possivelmente editados pelo usuário.
+// ScanQRCodeConfirmRequest define a estrutura do corpo da requisição para a confirmação de um preview.
+// Os dados da nota vêm do rascunho salvo no servidor; o app envia apenas o draftId e as edições do usuário.
 type ScanQRCodeConfirmRequest struct {
-	StoreName  string        `json:"storeName" binding:"required"` // Nome do estabelecimento
-	Date       string        `json:"date"`                         // Data da compra (opcional, usa data atual se vazio)
-	Items      []PreviewItem `json:"items" binding:"required"`     // Itens (editados) - utiliza a estrutura PreviewItem
-	ItemsCount int           `json:"itemsCount"`                   // Total de itens
-	Subtotal   float64       `json:"subtotal"`                     // Subtotal
-	Discount   float64       `json:"discount"`                     // Desconto
-	Total      float64       `json:"total"`                        // Total (opcional, calcula se zero)
-	AccessKey  string        `json:"accessKey"`                    // Chave de acesso
-	Number     string        `json:"number"`                       // Número da nota
-	QRCodeURL  string        `json:"qrCodeUrl" binding:"required"` // URL original do QR code
+	DraftID      uint            `json:"draftId" binding:"required"` // ID retornado pelo preview
+	DeletedItems []int           `json:"deletedItems"`               // TempIDs dos itens removidos
+	Edits        []DraftItemEdit `json:"edits"`                      // Nomes e categorias alterados
 }
 
 // ScanQRCodeConfirmResponse define a estrutura da resposta após a confirmação e salvamento do recibo.
 type ScanQRCodeConfirmResponse struct {
-	Message string `json:"message"`
+	Message         string                  `json:"message"`
+	JobID           uint                    `json:"jobId"`           // ID do job de importação (GET /imports/:id)
+	Status          schemas.ImportJobStatus `json:"status"`          // Estado inicial do job (queued)
+	ChosenByUser    int                     `json:"chosenByUser"`    // Itens com categoria escolhida pelo usuário
+	ResolvedByRules int                     `json:"resolvedByRules"` // Itens categorizados pelas regras do usuário
+	ResolvedLocally int                     `json:"resolvedLocally"` // Itens categorizados pelo histórico do usuário
+	SentToAI        int                     `json:"sentToAI"`        // Itens desconhecidos enviados à IA
 }
 
 // helper: convert snake_case keys to camelCase recursively
@@ -87,13 +86,16 @@ func normalizeJSONKeysToCamel(raw []byte) ([]byte, error) {
 
 // ScanQRCodeConfirmHandler confirma, categoriza com IA e salva no banco (Etapa 2/2)
 // @Summary Confirmar e salvar NFC-e (Etapa 2/2)
-// @Description Categoriza items com IA e salva nota fiscal no banco de dados. Envie APENAS o campo 'data' da resposta do preview, NÃO envie 'message'.
+// @Description Confirma o rascunho criado pelo preview (draftId), aplicando as remoções e edições do usuário, e cria um job de importação que categoriza os items e salva a nota fiscal em background. Itens que casam com as regras de categorização do usuário ou já foram comprados antes (histórico) não passam pela IA; só os desconhecidos vão para ela. Acompanhe em GET /imports/{id}. Preços e totais vêm sempre do rascunho salvo no servidor.
 // @Tags receipts
 // @Accept json
 // @Produce json
-// @Param request body ScanQRCodeConfirmRequest true "Dados da nota (envie APENAS o campo 'data' do preview, sem 'message')"
-// @Success 200 {object} ScanQRCodeConfirmResponse
+// @Param request body ScanQRCodeConfirmRequest true "draftId do preview e edições do usuário"
+// @Success 202 {object} ScanQRCodeConfirmResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse
+// @Failure 410 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Security BearerAuth
 // @Router /scan-qrcode/confirm [post]
@@ -203,8 +205,8 @@ func ScanQRCodeConfirmHandler(ctx *gin.Context) {
 	}
 
 	// Validações básicas
-	if len(request.Items) == 0 {
-		sendError(ctx, http.StatusBadRequest, "At least one item is required")
+	if request.DraftID == 0 {
+		sendError(ctx, http.StatusBadRequest, "draftId is required (returned by the preview)")
 		return
 	}
 
@@ -215,255 +217,177 @@ func ScanQRCodeConfirmHandler(ctx *gin.Context) {
 		return
 	}
 
-	// 🔒 Verifica limite de tokens antes de processar
-	if err := checkAITokenLimit(userID.(uint)); err != nil {
-		logger.ErrorF("❌ Token limit exceeded for user %d: %v", userID.(uint), err)
-		sendError(ctx, http.StatusForbidden, err.Error())
+	var draft schemas.ReceiptDraft
+	if err := db.Where("user_id = ?", userID).First(&draft, request.DraftID).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Draft not found")
 		return
 	}
-
-	logger.InfoF("📝 Confirming receipt: %s - %d items - Total: R$ %.2f",
-		request.StoreName, len(request.Items), request.Total)
-
-	// Filtra items deletados
-	activeItems := []PreviewItem{}
-	for _, item := range request.Items {
-		if !item.Deleted {
-			activeItems = append(activeItems, item)
-		}
-	}
-
-	if len(activeItems) == 0 {
-		sendError(ctx, http.StatusBadRequest, "All items were deleted. Cannot save empty receipt.")
+	if draft.ConsumedAt != nil {
+		sendError(ctx, http.StatusConflict, "This draft has already been confirmed")
 		return
 	}
-
-	logger.InfoF("✅ Active items after filtering: %d (deleted: %d)",
-		len(activeItems), len(request.Items)-len(activeItems))
-
-	// 🤖 ETAPA 1: Categorização com IA usando Worker Pool
-	startAI := time.Now()
-	logger.InfoF("🤖 Submitting AI categorization job for %d items...", len(activeItems))
-
-	// Converte para formato NFCeItem para usar a função existente
-	nfceItems := make([]NFCeItem, len(activeItems))
-	for i, item := range activeItems {
-		nfceItems[i] = NFCeItem{
-			ItemNumber:  item.TempID,
-			Description: item.Description,
-			Quantity:    item.Quantity,
-			Unit:        item.Unit,
-			UnitPrice:   item.UnitPrice,
-			Total:       item.Total,
-		}
-	}
-
-	// Verificar se Worker Pool está disponível
-	workerPool := config.GetAIWorkerPool()
-	if workerPool == nil {
-		logger.ErrorF("❌ Worker Pool not initialized")
-		sendError(ctx, http.StatusInternalServerError, "Sistema de IA não está disponível no momento")
+	if draft.Expired() {
+		sendError(ctx, http.StatusGone, "This draft has expired. Please scan the receipt again")
 		return
 	}
 
-	// Verificar se fila está cheia
-	if workerPool.IsQueueFull() {
-		queueStats := workerPool.GetStats()
-		logger.ErrorF("❌ Worker Pool queue is full: %d/%d", queueStats.CurrentInQueue, workerPool.GetQueueCapacity())
-		sendError(ctx, http.StatusServiceUnavailable, fmt.Sprintf(
-			"Sistema de IA está processando muitas requisições (%d na fila). Por favor, aguarde alguns minutos e tente novamente.",
-			queueStats.CurrentInQueue,
-		))
+	var data PreviewReceiptData
+	if err := json.Unmarshal([]byte(draft.Data), &data); err != nil {
+		logger.ErrorF("error decoding draft %d: %v", draft.ID, err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error loading draft")
 		return
 	}
 
-	// Canal para receber resultado do Worker Pool
-	resultChan := make(chan struct {
-		result *CategorizationResult
-		err    error
-	}, 1)
-
-	// Criar contexto com timeout
-	jobCtx, cancel := context.WithTimeout(ctx.Request.Context(), 60*time.Second)
-	defer cancel()
-
-	// Submeter job ao Worker Pool
-	job := config.AIJob{
-		ID:      fmt.Sprintf("scan-%d-%d", userID.(uint), time.Now().Unix()),
-		UserID:  userID.(uint),
-		Items:   nfceItems,
-		Context: jobCtx,
-		Callback: func(items interface{}, err error) {
-			if err != nil {
-				resultChan <- struct {
-					result *CategorizationResult
-					err    error
-				}{nil, err}
-				return
-			}
-
-			// Processar categorização com IA
-			result, aiErr := categorizeItemsWithAI(items.([]NFCeItem), userID.(uint))
-			resultChan <- struct {
-				result *CategorizationResult
-				err    error
-			}{result, aiErr}
-		},
+	// ✏️ Aplica as edições do usuário sobre os dados do servidor
+	data, editedFields, err := applyDraftEdits(userID.(uint), data, request.DeletedItems, request.Edits)
+	if err != nil {
+		sendError(ctx, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	if err := workerPool.SubmitJob(job); err != nil {
-		logger.ErrorF("❌ Failed to submit job to Worker Pool: %v", err)
-		sendError(ctx, http.StatusServiceUnavailable, fmt.Sprintf("Não foi possível processar sua requisição: %v", err))
+	// Filtra items deletados
+	activeItems := activeImportItems(data.Items)
+	if len(activeItems) == 0 {
+		sendError(ctx, http.StatusBadRequest, "All items were deleted. Cannot save empty receipt.")
 		return
 	}
 
-	queueSize := workerPool.GetQueueSize()
-	logger.InfoF("📥 Job submitted to Worker Pool (queue: %d/%d)", queueSize, workerPool.GetQueueCapacity())
+	logger.InfoF("📝 Confirming draft %d: %s - %d items (deleted: %d, edited fields: %d) - Total: R$ %.2f",
+		draft.ID, data.StoreName, len(activeItems), len(data.Items)-len(activeItems), len(editedFields), data.Total)
 
-	// Aguardar resultado do Worker Pool
-	var categorizationResult *CategorizationResult
-	select {
-	case result := <-resultChan:
-		if result.err != nil {
-			logger.ErrorF("❌ AI categorization failed: %v", result.err.Error())
-			sendError(ctx, http.StatusInternalServerError, fmt.Sprintf("AI categorization error: %v", result.err.Error()))
+	var accessKey *nfce.AccessKey
+	if data.AccessKey != "" {
+		accessKey, err = nfce.ParseAccessKey(data.AccessKey)
+		if err != nil {
+			sendError(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid access key: %v", err.Error()))
 			return
 		}
-		categorizationResult = result.result
-	case <-jobCtx.Done():
-		logger.ErrorF("❌ AI categorization timeout")
-		sendError(ctx, http.StatusRequestTimeout, "Processamento da IA demorou muito. Por favor, tente novamente.")
-		return
 	}
 
-	aiTime := time.Since(startAI)
-	logger.InfoF("✅ AI categorization completed in %.2fs", aiTime.Seconds())
-
-	// Registra uso de tokens da IA automaticamente (em background)
-	go func() {
-		model := os.Getenv("GEMINI_MODEL")
-		if model != "gemini-2.5-flash" {
-			if model != "" {
-				logger.WarnF("GEMINI_MODEL value '%s' is not supported here. Overriding to 'gemini-2.5-flash'", model)
-			}
-			model = "gemini-2.5-flash"
+	// 🔁 Impede que a mesma nota seja confirmada duas vezes (ex: duplo toque no app)
+	handedOff := false
+	if accessKey != nil {
+		if !reserveImportAccessKey(ctx, userID.(uint), accessKey) {
+			return
 		}
+		// Libera a reserva se a requisição terminar antes de entregar o job ao background
+		defer func() {
+			if !handedOff {
+				releaseAccessKey(userID.(uint), accessKey.Key)
+			}
+		}()
+	}
 
-		err := recordAITokenUsageInternal(
-			userID.(uint),
-			categorizationResult.PromptTokens,
-			categorizationResult.ResponseTokens,
-			model,
-			"/scan-qrcode/confirm",
-		)
-		if err != nil {
-			logger.ErrorF("⚠️  Failed to record AI token usage: %v", err)
-		} else {
-			logger.InfoF("✅ AI token usage recorded successfully")
-		}
-	}()
-
-	// Monta mapa tempID -> categoryID
-	categoryMap := make(map[int]uint)
-	for i, categorizedItem := range categorizationResult.Items {
-		if i < len(activeItems) {
-			categoryMap[activeItems[i].TempID] = categorizedItem.CategoryID
-			logger.InfoF("✓ Item #%d (%s) -> CategoryID: %d",
-				activeItems[i].TempID, activeItems[i].Description, categorizedItem.CategoryID)
+	// 📐 Regras do usuário e 📚 categorias já usadas para os mesmos produtos dispensam a IA
+	byRule, resolved := localCategoriesForImport(userID.(uint), data, activeItems)
+	chosenByUser := 0
+	for _, item := range activeItems {
+		if item.CategoryID != 0 {
+			chosenByUser++
 		}
 	}
+	sentToAI := len(activeItems) - chosenByUser - len(byRule) - len(resolved)
 
-	// 💾 ETAPA 2: Salvar no banco de dados (em background)
-	go func() {
-		startSave := time.Now()
-		logger.InfoF("💾 [Background] Saving receipt to database...")
-
-		// Cria Receipt
-		receipt := schemas.Receipt{
-			UserID:      userID.(uint),
-			StoreName:   request.StoreName,
-			Date:        request.Date,
-			Total:       request.Total,
-			Subtotal:    request.Subtotal,
-			Discount:    request.Discount,
-			Currency:    "BRL",
-			Confidence:  1.0,
-			Notes:       fmt.Sprintf("NFC-e #%s - Chave: %s", request.Number, request.AccessKey),
-			ImageBase64: request.QRCodeURL,
+	if sentToAI > 0 {
+		// 🔒 Verifica limite de tokens antes de processar
+		if err := checkAITokenLimit(userID.(uint)); err != nil {
+			logger.ErrorF("❌ Token limit exceeded for user %d: %v", userID.(uint), err)
+			sendError(ctx, http.StatusForbidden, err.Error())
+			return
 		}
 
-		// Usa transação para salvar
-		err := db.Transaction(func(tx *gorm.DB) error {
-			// Salva Receipt
-			if err := tx.Create(&receipt).Error; err != nil {
-				return fmt.Errorf("error creating receipt: %w", err)
-			}
-
-			logger.InfoF("✓ [Background] Receipt created with ID: %d", receipt.ID)
-
-			// Salva Items com categorias da IA
-			for _, item := range activeItems {
-				categoryID := categoryMap[item.TempID]
-				if categoryID == 0 {
-					// Fallback para categoria "Outros" DO USUÁRIO
-					var defaultCategory schemas.Category
-					if err := tx.Where("name = ? AND user_id = ?", "Outros", userID.(uint)).First(&defaultCategory).Error; err == nil {
-						categoryID = defaultCategory.ID
-						logger.InfoF("⚠️  [Background] Using default category 'Outros' (ID: %d) for item #%d", categoryID, item.TempID)
-					}
-				}
+		// Verificar se Worker Pool está disponível
+		workerPool := config.GetAIWorkerPool()
+		if workerPool == nil {
+			logger.ErrorF("❌ Worker Pool not initialized")
+			sendError(ctx, http.StatusInternalServerError, "Sistema de IA não está disponível no momento")
+			return
+		}
 
-				// Busca ou cria o produto
-				var product schemas.Product
-				normalizedUnit := normalizeUnit(item.Unit)
-				if err := tx.Where("name = ? AND unity = ?", item.Description, normalizedUnit).First(&product).Error; err != nil {
-					if err == gorm.ErrRecordNotFound {
-						// Cria novo produto
-						product = schemas.Product{
-							Name:  item.Description,
-							Unity: normalizedUnit,
-						}
-						if err := tx.Create(&product).Error; err != nil {
-							return fmt.Errorf("error creating product: %w", err)
-						}
-						logger.InfoF("[Background] Produto criado: %s (%s) - ID: %d", product.Name, product.Unity, product.ID)
-					} else {
-						return fmt.Errorf("error finding product: %w", err)
-					}
-				}
+		// Verificar se fila está cheia
+		if workerPool.IsQueueFull() {
+			queueStats := workerPool.GetStats()
+			logger.ErrorF("❌ Worker Pool queue is full: %d/%d", queueStats.CurrentInQueue, workerPool.GetQueueCapacity())
+			sendError(ctx, http.StatusServiceUnavailable, fmt.Sprintf(
+				"Sistema de IA está processando muitas requisições (%d na fila). Por favor, aguarde alguns minutos e tente novamente.",
+				queueStats.CurrentInQueue,
+			))
+			return
+		}
+	}
 
-				receiptItem := schemas.ReceiptItem{
-					ReceiptID:   receipt.ID,
-					CategoryID:  categoryID,
-					ProductID:   product.ID,
-					Description: item.Description,
-					Quantity:    item.Quantity,
-					Unit:        item.Unit,
-					UnitPrice:   item.UnitPrice,
-					Total:       item.Total,
-				}
+	// 📋 Registra o job antes de responder, para que falhas no background fiquem visíveis ao app
+	confirmed := confirmedImport{
+		DraftID:      draft.ID,
+		Source:       draft.Source,
+		Confidence:   draft.Confidence,
+		Receipt:      data,
+		EditedFields: editedFields,
 
-				if err := tx.Create(&receiptItem).Error; err != nil {
-					return fmt.Errorf("error creating receipt item: %w", err)
-				}
-			}
+		RuleCategories:     byRule,
+		ResolvedCategories: resolved,
+	}
+	payload, err := json.Marshal(confirmed)
+	if err != nil {
+		logger.ErrorF("error encoding import payload: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error creating import job")
+		return
+	}
 
-			return nil
-		})
+	job := schemas.ImportJob{
+		UserID:     userID.(uint),
+		Source:     draft.Source,
+		Status:     schemas.ImportJobQueued,
+		StoreName:  data.StoreName,
+		ItemsCount: len(activeItems),
+		Total:      data.Total,
+		Payload:    string(payload),
+	}
+	if accessKey != nil {
+		job.AccessKey = accessKey.Key
+	}
 
-		if err != nil {
-			logger.ErrorF("❌ [Background] Error saving receipt: %v", err.Error())
-			return
+	// O rascunho é consumido na mesma transação: duas confirmações simultâneas não criam dois jobs
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
 		}
+		result := tx.Model(&schemas.ReceiptDraft{}).
+			Where("id = ? AND consumed_at IS NULL", draft.ID).
+			Updates(map[string]interface{}{"consumed_at": time.Now(), "import_job_id": job.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errDraftConsumed
+		}
+		return nil
+	})
+	if errors.Is(err, errDraftConsumed) {
+		sendError(ctx, http.StatusConflict, "This draft has already been confirmed")
+		return
+	}
+	if err != nil {
+		logger.ErrorF("error creating import job: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error creating import job")
+		return
+	}
 
-		saveTime := time.Since(startSave)
-		totalTime := time.Since(startAI)
-		logger.InfoF("🎉 [Background] Complete! Receipt ID: %d, Items: %d, Total time: %.2fs (AI: %.2fs, Save: %.2fs)",
-			receipt.ID, len(activeItems), totalTime.Seconds(), aiTime.Seconds(), saveTime.Seconds())
-	}()
-
-	// Retorna imediatamente apenas mensagem de sucesso
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "✅ Nota fiscal processada! ",
+	// 🤖 Categorização (regras, histórico e IA) + 💾 gravação em background
+	handedOff = true
+	go runImportJob(job.ID, userID.(uint), confirmed, accessKey)
+
+	logger.InfoF("📥 Import job %d queued for user %d (draft %d): %d item(s) chosen by the user, %d by rules, %d from history, %d for the AI",
+		job.ID, userID.(uint), draft.ID, chosenByUser, len(byRule), len(resolved), sentToAI)
+
+	// Retorna imediatamente o ID do job para acompanhamento em GET /imports/:id
+	ctx.JSON(http.StatusAccepted, ScanQRCodeConfirmResponse{
+		Message:         "✅ Nota fiscal recebida! Acompanhe o processamento pelo jobId.",
+		JobID:           job.ID,
+		Status:          job.Status,
+		ChosenByUser:    chosenByUser,
+		ResolvedByRules: len(byRule),
+		ResolvedLocally: len(resolved),
+		SentToAI:        sentToAI,
 	})
 }
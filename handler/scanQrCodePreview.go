@@ -1,60 +1,122 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
 	"github.com/gin-gonic/gin"
 )
 
 // ScanQRCodePreviewRequest define a estrutura do corpo da requisição para o preview de um QR code.
 type ScanQRCodePreviewRequest struct {
 	QRCodeURL string `json:"qrCodeUrl" binding:"required"` // URL do QR Code da NFC-e
+	// QueueOnFailure guarda a URL na fila de notas pendentes se o portal da SEFAZ estiver fora do ar
+	QueueOnFailure bool `json:"queueOnFailure"`
 }
 
 // PreviewItem representa um item no preview do recibo, antes de ser salvo.
 // Inclui um ID temporário e um campo 'Deleted' para edição no frontend.
 type PreviewItem struct {
-	TempID      int     `json:"tempId"`            // ID temporário para facilitar a edição no frontend
-	Description string  `json:"description"`       // Nome do produto
-	Quantity    float64 `json:"quantity"`          // Quantidade do item
-	Unit        string  `json:"unit"`              // Unidade de medida (kg, un, ml, etc)
-	UnitPrice   float64 `json:"unitPrice"`         // Preço por unidade
-	Total       float64 `json:"total"`             // Total do item
-	Deleted     bool    `json:"deleted,omitempty"` // Se true, o item será ignorado ao confirmar
+	TempID      int     `json:"tempId"`               // ID temporário para facilitar a edição no frontend
+	Description string  `json:"description"`          // Nome do produto
+	Quantity    float64 `json:"quantity"`             // Quantidade do item
+	Unit        string  `json:"unit"`                 // Unidade de medida (kg, un, ml, etc)
+	UnitPrice   float64 `json:"unitPrice"`            // Preço por unidade
+	Total       float64 `json:"total"`                // Total do item
+	Discount    float64 `json:"discount"`             // Desconto do item (informado na nota ou rateado do desconto da nota)
+	Deleted     bool    `json:"deleted,omitempty"`    // Se true, o item será ignorado ao confirmar
+	CategoryID  uint    `json:"categoryId,omitempty"` // Categoria escolhida pelo usuário na confirmação
+	Code        string  `json:"code,omitempty"`       // Código do produto na loja (cProd)
+	GTIN        string  `json:"gtin,omitempty"`       // Código de barras (GTIN-14), quando válido
+}
+
+// PreviewStore contém os dados do emitente lidos da nota (cabeçalho da NFC-e ou XML).
+type PreviewStore struct {
+	CNPJ       string `json:"cnpj"`                 // CNPJ do emitente (apenas dígitos)
+	LegalName  string `json:"legalName"`            // Razão social
+	TradeName  string `json:"tradeName,omitempty"`  // Nome fantasia
+	Street     string `json:"street,omitempty"`     // Logradouro
+	Number     string `json:"number,omitempty"`     // Número
+	Complement string `json:"complement,omitempty"` // Complemento
+	District   string `json:"district,omitempty"`   // Bairro
+	City       string `json:"city,omitempty"`       // Município
+	UF         string `json:"uf,omitempty"`         // Estado
+	ZipCode    string `json:"zipCode,omitempty"`    // CEP
+}
+
+// PreviewPayment representa uma forma de pagamento lida da nota.
+type PreviewPayment struct {
+	Code   string  `json:"code"`   // Código tPag (ex: 03 = Cartão de Crédito, 17 = PIX)
+	Method string  `json:"method"` // Descrição da forma de pagamento
+	Amount float64 `json:"amount"` // Valor pago
+}
+
+// PreviewTaxes contém o valor aproximado dos tributos informado na nota (Lei 12.741/2012).
+type PreviewTaxes struct {
+	Total     float64 `json:"total"`
+	Federal   float64 `json:"federal"`
+	State     float64 `json:"state"`
+	Municipal float64 `json:"municipal"`
 }
 
 // PreviewReceiptData representa a estrutura completa dos dados do recibo para o preview.
 type PreviewReceiptData struct {
-	StoreName  string        `json:"storeName"`  // Nome do estabelecimento
-	Date       string        `json:"date"`       // Data da compra
-	Items      []PreviewItem `json:"items"`      // Itens extraídos do recibo
-	ItemsCount int           `json:"itemsCount"` // Total de itens
-	Subtotal   float64       `json:"subtotal"`   // Subtotal
-	Discount   float64       `json:"discount"`   // Desconto
-	Total      float64       `json:"total"`      // Total
-	AccessKey  string        `json:"accessKey"`  // Chave de acesso da NFC-e
-	Number     string        `json:"number"`     // Número da nota
-	QRCodeURL  string        `json:"qrCodeUrl"`  // URL original do QR code para confirmação
+	StoreName  string        `json:"storeName"`       // Nome do estabelecimento
+	Date       string        `json:"date"`            // Data da compra
+	Items      []PreviewItem `json:"items"`           // Itens extraídos do recibo
+	ItemsCount int           `json:"itemsCount"`      // Total de itens
+	Subtotal   float64       `json:"subtotal"`        // Subtotal
+	Discount   float64       `json:"discount"`        // Desconto
+	Total      float64       `json:"total"`           // Total = subtotal - desconto + frete + outras despesas
+	Currency   string        `json:"currency"`        // Moeda (ISO 4217); NFC-e/NF-e são sempre em BRL
+	AccessKey  string        `json:"accessKey"`       // Chave de acesso da NFC-e
+	Number     string        `json:"number"`          // Número da nota
+	QRCodeURL  string        `json:"qrCodeUrl"`       // URL original do QR code para confirmação
+	Store      *PreviewStore `json:"store,omitempty"` // Emitente, quando identificado
+
+	// Frete e outras despesas acessórias da NF-e (vFrete e vOutro); somam no total
+	Freight      float64 `json:"freight,omitempty"`
+	OtherCharges float64 `json:"otherCharges,omitempty"`
+
+	Payments []PreviewPayment `json:"payments,omitempty"` // Formas de pagamento
+	Change   float64          `json:"change,omitempty"`   // Troco
+	Taxes    *PreviewTaxes    `json:"taxes,omitempty"`    // Tributos aproximados
+
+	// Conferência dos valores; correções automáticas já aplicadas nos campos acima
+	Validation *schemas.ReceiptValidation `json:"validation,omitempty"`
+
+	// Problemas da leitura por IA que não puderam ser corrigidos (fotos)
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // ScanQRCodePreviewResponse define a estrutura da resposta da API de preview.
+// DraftID identifica o rascunho salvo no servidor, que deve ser enviado ao /scan-qrcode/confirm.
 type ScanQRCodePreviewResponse struct {
-	Message string             `json:"message"`
-	Data    PreviewReceiptData `json:"data"`
+	Message   string             `json:"message"`
+	DraftID   uint               `json:"draftId"`   // Rascunho a confirmar
+	ExpiresAt time.Time          `json:"expiresAt"` // Validade do rascunho
+	Data      PreviewReceiptData `json:"data"`
 }
 
 // ScanQRCodePreviewHandler extrai dados do QR Code sem salvar no banco
 // @Summary Preview de NFC-e via QR Code (Etapa 1/2)
-// @Description Extrai dados da NFC-e sem salvar no banco. Retorna dados para visualização/edição
+// @Description Extrai dados da NFC-e e os guarda como rascunho (válido por 2 horas), sem criar o recibo. Retorna os dados para visualização e o draftId usado na confirmação
 // @Tags receipts
 // @Accept json
 // @Produce json
 // @Param request body ScanQRCodePreviewRequest true "QR Code URL"
 // @Success 200 {object} ScanQRCodePreviewResponse
+// @Success 202 {object} PendingScanQueuedResponse "Nota em contingência/ainda não autorizada, ou portal indisponível com queueOnFailure=true: nota guardada para nova consulta"
 // @Failure 400 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse "Portal da SEFAZ indisponível"
 // @Security BearerAuth
 // @Router /scan-qrcode/preview [post]
 func ScanQRCodePreviewHandler(ctx *gin.Context) {
@@ -73,6 +135,14 @@ func ScanQRCodePreviewHandler(ctx *gin.Context) {
 		return
 	}
 
+	// 🔑 Valida a chave de acesso da URL antes de consultar a SEFAZ
+	if key := nfce.AccessKeyFromURL(request.QRCodeURL); key != "" {
+		if _, err := nfce.ParseAccessKey(key); err != nil {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Invalid NFC-e access key in QR Code: %v", err.Error()))
+			return
+		}
+	}
+
 	// ⚡ Faz scraping da NFC-e (rápido e gratuito!)
 	logger.InfoF("🔍 Preview: Scraping NFC-e from URL: %s", request.QRCodeURL)
 	startTime := time.Now()
@@ -80,6 +150,23 @@ func ScanQRCodePreviewHandler(ctx *gin.Context) {
 	receiptData, err := scrapeNFCe(request.QRCodeURL)
 	if err != nil {
 		logger.ErrorF("error scraping NFC-e: %v", err.Error())
+		userID, _ := ctx.Get("user_id")
+		url := strings.TrimSpace(request.QRCodeURL)
+		reason, retry := pendingScanRetryReason(err, isContingencyURL(url))
+		if retry && reason == schemas.PendingScanNotAuthorized {
+			// 🕐 Nota em contingência ou ainda em processamento: fica na fila até ser autorizada
+			sendPendingScanQueued(ctx, userID.(uint), url, reason, err.Error())
+			return
+		}
+		if errors.Is(err, errPortalUnavailable) {
+			// ⏳ Portal fora do ar: a nota pode ser importada automaticamente quando ele voltar
+			if request.QueueOnFailure {
+				sendPendingScanQueued(ctx, userID.(uint), url, reason, err.Error())
+				return
+			}
+			sendError(ctx, http.StatusServiceUnavailable, "O portal da SEFAZ está indisponível no momento. Envie queueOnFailure=true (ou use POST /scan-qrcode/pending) para importar a nota automaticamente quando ele voltar")
+			return
+		}
 		sendError(ctx, http.StatusInternalServerError, fmt.Sprintf("Error scraping NFC-e: %v", err.Error()))
 		return
 	}
@@ -88,7 +175,38 @@ func ScanQRCodePreviewHandler(ctx *gin.Context) {
 	logger.InfoF("✅ NFC-e scraped successfully in %.2fs: %s - %d items - Total: R$ %.2f",
 		scrapingTime.Seconds(), receiptData.StoreName, len(receiptData.Items), receiptData.Total)
 
-	// Converte para formato de preview (sem categorias ainda)
+	// A chave lida da página também precisa ser válida (ex: página de outra nota ou layout mal interpretado)
+	if receiptData.AccessKey != "" {
+		if _, err := nfce.ParseAccessKey(receiptData.AccessKey); err != nil {
+			sendError(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid access key on NFC-e page: %v", err.Error()))
+			return
+		}
+	}
+
+	previewData := buildPreviewData(receiptData, request.QRCodeURL)
+
+	// 📝 Guarda o resultado como rascunho: a confirmação usa estes dados, não os enviados pelo app
+	userID, _ := ctx.Get("user_id")
+	draft, err := saveReceiptDraft(userID.(uint), "qrcode", previewData, 1.0)
+	if err != nil {
+		logger.ErrorF("error saving receipt draft: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error saving preview")
+		return
+	}
+
+	logger.InfoF("📋 Preview ready: %d items extracted (draft %d, not saved yet)", len(previewData.Items), draft.ID)
+
+	// Retorna preview (o recibo só é salvo na confirmação)
+	ctx.JSON(http.StatusOK, ScanQRCodePreviewResponse{
+		Message:   fmt.Sprintf("✅ Preview ready! %d items extracted. You can now edit, remove items, or confirm to save.", len(previewData.Items)),
+		DraftID:   draft.ID,
+		ExpiresAt: draft.ExpiresAt,
+		Data:      previewData,
+	})
+}
+
+// buildPreviewData converte os dados extraídos da NFC-e para o formato de preview (sem categorias ainda).
+func buildPreviewData(receiptData *NFCeData, qrCodeURL string) PreviewReceiptData {
 	previewItems := make([]PreviewItem, len(receiptData.Items))
 	for i, item := range receiptData.Items {
 		previewItems[i] = PreviewItem{
@@ -98,11 +216,55 @@ func ScanQRCodePreviewHandler(ctx *gin.Context) {
 			Unit:        item.Unit,
 			UnitPrice:   item.UnitPrice,
 			Total:       item.Total,
+			Discount:    item.Discount,
+			Code:        item.Code,
+			GTIN:        item.GTIN,
+		}
+	}
+
+	// O CNPJ do emitente também está na chave de acesso, caso o cabeçalho não o exiba
+	var store *PreviewStore
+	issuer := receiptData.Issuer
+	if issuer.CNPJ == "" {
+		if key, err := nfce.ParseAccessKey(receiptData.AccessKey); err == nil {
+			issuer.CNPJ = key.CNPJ
+		}
+	}
+	if issuer.CNPJ != "" {
+		if issuer.LegalName == "" {
+			issuer.LegalName = receiptData.StoreName
+		}
+		store = &PreviewStore{
+			CNPJ:       issuer.CNPJ,
+			LegalName:  issuer.LegalName,
+			TradeName:  issuer.TradeName,
+			Street:     issuer.Address.Street,
+			Number:     issuer.Address.Number,
+			Complement: issuer.Address.Complement,
+			District:   issuer.Address.District,
+			City:       issuer.Address.City,
+			UF:         issuer.Address.UF,
+			ZipCode:    issuer.Address.ZipCode,
 		}
 	}
 
-	// Monta resposta de preview
-	previewData := PreviewReceiptData{
+	payments := make([]PreviewPayment, len(receiptData.Payments))
+	for i, payment := range receiptData.Payments {
+		payments[i] = PreviewPayment{Code: payment.Code, Method: payment.Method, Amount: payment.Amount}
+	}
+
+	var taxes *PreviewTaxes
+	if receiptData.Taxes.Total > 0 {
+		taxes = &PreviewTaxes{
+			Total:     receiptData.Taxes.Total,
+			Federal:   receiptData.Taxes.Federal,
+			State:     receiptData.Taxes.State,
+			Municipal: receiptData.Taxes.Municipal,
+		}
+	}
+
+	data := PreviewReceiptData{
+		Store:      store,
 		StoreName:  receiptData.StoreName,
 		Date:       receiptData.Date,
 		Items:      previewItems,
@@ -110,16 +272,31 @@ func ScanQRCodePreviewHandler(ctx *gin.Context) {
 		Subtotal:   receiptData.Subtotal,
 		Discount:   receiptData.Discount,
 		Total:      receiptData.Total,
+		Currency:   currency.Default,
 		AccessKey:  receiptData.AccessKey,
 		Number:     receiptData.Number,
-		QRCodeURL:  request.QRCodeURL,
-	}
+		QRCodeURL:  qrCodeURL,
+		Payments:   payments,
+		Change:     receiptData.Change,
+		Taxes:      taxes,
 
-	logger.InfoF("📋 Preview ready: %d items extracted (not saved yet)", len(previewItems))
+		Freight:      receiptData.Freight,
+		OtherCharges: receiptData.Other,
+	}
+	validatePreviewData(&data)
+	allocatePreviewDiscounts(&data)
+	return data
+}
 
-	// Retorna preview (SEM salvar no banco)
-	ctx.JSON(http.StatusOK, ScanQRCodePreviewResponse{
-		Message: fmt.Sprintf("✅ Preview ready! %d items extracted. You can now edit, remove items, or confirm to save.", len(previewItems)),
-		Data:    previewData,
-	})
+// allocatePreviewDiscounts rateia entre os itens do preview o desconto da nota que não veio
+// discriminado por item, para o app mostrar o valor pago por produto antes da confirmação.
+func allocatePreviewDiscounts(data *PreviewReceiptData) {
+	totals := make([]float64, len(data.Items))
+	discounts := make([]float64, len(data.Items))
+	for i, item := range data.Items {
+		totals[i], discounts[i] = item.Total, item.Discount
+	}
+	for i, discount := range nfce.AllocateDiscount(totals, discounts, data.Discount) {
+		data.Items[i].Discount = discount
+	}
 }
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxReceiptImages limita quantas fotos da mesma nota podem ser enviadas de uma vez
+	maxReceiptImages = 5
+	// maxReceiptImageSize limita o tamanho de cada foto (10 MB)
+	maxReceiptImageSize = 10 << 20
+)
+
+// supportedReceiptImageTypes lista os formatos de imagem aceitos pelos modelos de visão
+var supportedReceiptImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// ScanReceiptImageHandler extrai dados de uma nota fiscal a partir de fotos, sem salvar no banco
+// @Summary Preview de nota fiscal via foto (Etapa 1/2)
+// @Description Envia uma ou mais fotos da MESMA nota fiscal (multipart, campo 'images') para a IA extrair os dados. Retorna o mesmo formato do preview via QR Code, com um draftId que pode ser confirmado em /scan-qrcode/confirm
+// @Tags receipts
+// @Accept multipart/form-data
+// @Produce json
+// @Param images formData file true "Foto(s) da nota fiscal (repita o campo para várias fotos)"
+// @Param currency formData string false "Moeda esperada (ISO 4217), usada quando a IA não identifica a moeda impressa na nota (padrão: BRL)"
+// @Param amountHint formData number false "Total aproximado da nota, usado apenas como referência pela IA"
+// @Success 200 {object} ScanQRCodePreviewResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 408 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /receipt/scan-image [post]
+func ScanReceiptImageHandler(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		sendError(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		logger.ErrorF("error parsing multipart form: %v", err.Error())
+		sendError(ctx, http.StatusBadRequest, "Invalid multipart form. Send the photos in the 'images' field")
+		return
+	}
+
+	files := form.File["images"]
+	if len(files) == 0 {
+		sendError(ctx, http.StatusBadRequest, "At least one image is required in the 'images' field")
+		return
+	}
+	if len(files) > maxReceiptImages {
+		sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Too many images: maximum is %d per receipt", maxReceiptImages))
+		return
+	}
+
+	// Lê cada foto e converte para data URI base64 (com o mime type detectado)
+	images := make([]string, 0, len(files))
+	uploads := make([]uploadedReceiptImage, 0, len(files))
+	for i, fileHeader := range files {
+		if fileHeader.Size > maxReceiptImageSize {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Image %d is too large: maximum is %d MB", i+1, maxReceiptImageSize>>20))
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			logger.ErrorF("error opening uploaded image: %v", err.Error())
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Could not read image %d", i+1))
+			return
+		}
+		content, err := io.ReadAll(io.LimitReader(file, maxReceiptImageSize+1))
+		file.Close()
+		if err != nil {
+			logger.ErrorF("error reading uploaded image: %v", err.Error())
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Could not read image %d", i+1))
+			return
+		}
+
+		mimeType := http.DetectContentType(content)
+		if mimeType == "application/octet-stream" {
+			// HEIC/HEIF não é reconhecido pelo DetectContentType; confia no header enviado
+			mimeType = fileHeader.Header.Get("Content-Type")
+		}
+		if !supportedReceiptImageTypes[mimeType] {
+			sendError(ctx, http.StatusBadRequest, fmt.Sprintf("Image %d has unsupported type '%s'. Use JPEG, PNG, WEBP or HEIC", i+1, mimeType))
+			return
+		}
+
+		images = append(images, fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(content)))
+		uploads = append(uploads, uploadedReceiptImage{Content: content, ContentType: mimeType})
+	}
+
+	// Moeda esperada; a IA informa a moeda impressa na nota e usa esta só quando não consegue identificar
+	currencyHint := currency.Default
+	if value := ctx.PostForm("currency"); strings.TrimSpace(value) != "" {
+		code, err := currency.Normalize(value)
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		currencyHint = code
+	}
+
+	var amountHint *float64
+	if hint := strings.TrimSpace(ctx.PostForm("amountHint")); hint != "" {
+		value, err := strconv.ParseFloat(strings.ReplaceAll(hint, ",", "."), 64)
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, "amountHint must be a number")
+			return
+		}
+		amountHint = &value
+	}
+
+	// 🔒 Verifica limite de tokens antes de processar
+	if err := checkAITokenLimit(userID.(uint)); err != nil {
+		logger.ErrorF("❌ Token limit exceeded for user %d: %v", userID.(uint), err)
+		sendError(ctx, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Verificar se Worker Pool está disponível
+	workerPool := config.GetAIWorkerPool()
+	if workerPool == nil {
+		logger.ErrorF("❌ Worker Pool not initialized")
+		sendError(ctx, http.StatusInternalServerError, "Sistema de IA não está disponível no momento")
+		return
+	}
+
+	// Verificar se fila está cheia
+	if workerPool.IsQueueFull() {
+		queueStats := workerPool.GetStats()
+		logger.ErrorF("❌ Worker Pool queue is full: %d/%d", queueStats.CurrentInQueue, workerPool.GetQueueCapacity())
+		sendError(ctx, http.StatusServiceUnavailable, fmt.Sprintf(
+			"Sistema de IA está processando muitas requisições (%d na fila). Por favor, aguarde alguns minutos e tente novamente.",
+			queueStats.CurrentInQueue,
+		))
+		return
+	}
+
+	logger.InfoF("📸 Submitting AI image analysis job: %d image(s) for user %d", len(images), userID.(uint))
+	startAI := time.Now()
+
+	// Canal para receber resultado do Worker Pool
+	resultChan := make(chan struct {
+		result *ReceiptAnalysisResult
+		err    error
+	}, 1)
+
+	// Análise de imagem é mais lenta que categorização, então o timeout é maior
+	jobCtx, cancel := context.WithTimeout(ctx.Request.Context(), 90*time.Second)
+	defer cancel()
+
+	job := config.AIJob{
+		ID:      fmt.Sprintf("image-%d-%d", userID.(uint), time.Now().Unix()),
+		UserID:  userID.(uint),
+		Items:   images,
+		Context: jobCtx,
+		Callback: func(items interface{}, err error) {
+			if err != nil {
+				resultChan <- struct {
+					result *ReceiptAnalysisResult
+					err    error
+				}{nil, err}
+				return
+			}
+
+			result, aiErr := AnalyzeReceipt(jobCtx, items.([]string), currencyHint, "pt-BR", amountHint)
+			resultChan <- struct {
+				result *ReceiptAnalysisResult
+				err    error
+			}{result, aiErr}
+		},
+	}
+
+	if err := workerPool.SubmitJob(job); err != nil {
+		logger.ErrorF("❌ Failed to submit job to Worker Pool: %v", err)
+		sendError(ctx, http.StatusServiceUnavailable, fmt.Sprintf("Não foi possível processar sua requisição: %v", err))
+		return
+	}
+
+	// Aguardar resultado do Worker Pool
+	var analysis *ReceiptAnalysisResult
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			logger.ErrorF("❌ AI image analysis failed: %v", result.err.Error())
+			sendError(ctx, http.StatusInternalServerError, fmt.Sprintf("AI image analysis error: %v", result.err.Error()))
+			return
+		}
+		analysis = result.result
+	case <-jobCtx.Done():
+		logger.ErrorF("❌ AI image analysis timeout")
+		sendError(ctx, http.StatusRequestTimeout, "Processamento da IA demorou muito. Por favor, tente novamente.")
+		return
+	}
+
+	logger.InfoF("✅ AI image analysis completed in %.2fs", time.Since(startAI).Seconds())
+
+	// Registra uso de tokens da IA automaticamente (em background)
+	go func() {
+		err := recordAITokenUsageInternal(
+			userID.(uint),
+			analysis.PromptTokens,
+			analysis.ResponseTokens,
+			analysis.Model,
+			"/receipt/scan-image",
+		)
+		if err != nil {
+			logger.ErrorF("⚠️  Failed to record AI token usage: %v", err)
+		}
+	}()
+
+	receiptData := analysis.Data
+	if len(receiptData.Items) == 0 {
+		sendError(ctx, http.StatusUnprocessableEntity, "No items could be read from the image(s). Try a sharper photo with the whole receipt visible")
+		return
+	}
+
+	// Converte para o mesmo formato de preview do QR Code (sem categorias ainda)
+	previewItems := make([]PreviewItem, len(receiptData.Items))
+	for i, item := range receiptData.Items {
+		previewItems[i] = PreviewItem{
+			TempID:      i + 1, // ID temporário sequencial
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitPrice,
+			Total:       item.Total,
+			Discount:    item.Discount,
+		}
+	}
+
+	previewData := PreviewReceiptData{
+		StoreName:  receiptData.StoreName,
+		Date:       receiptData.Date,
+		Items:      previewItems,
+		ItemsCount: len(previewItems),
+		Subtotal:   receiptData.Subtotal,
+		Discount:   receiptData.Discount,
+		Total:      receiptData.Total,
+		Currency:   currency.NormalizeOr(receiptData.Currency, currencyHint),
+		Warnings:   analysis.Warnings,
+	}
+	if len(analysis.Warnings) > 0 {
+		logger.WarnF("⚠️  Image analysis for user %d kept %d unresolved problem(s): %s", userID.(uint), len(analysis.Warnings), strings.Join(analysis.Warnings, "; "))
+	}
+
+	// 🧮 Confere as somas lidas pela IA (o prompt pede, mas o modelo erra)
+	validatePreviewData(&previewData)
+	allocatePreviewDiscounts(&previewData)
+	if previewData.Validation.Status == schemas.ReceiptInvalid {
+		logger.WarnF("⚠️  Image preview for user %d has %d unresolved amount issue(s)", userID.(uint), len(previewData.Validation.Issues))
+	}
+
+	// 📝 Confiança informada pela IA (fotos podem ter itens mal lidos)
+	confidence := receiptData.Confidence
+	if confidence <= 0 || confidence > 1 {
+		confidence = 0.5
+	}
+	draft, err := saveReceiptDraft(userID.(uint), "image", previewData, confidence)
+	if err != nil {
+		logger.ErrorF("error saving receipt draft: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error saving preview")
+		return
+	}
+
+	// 🖼️ Guarda as fotos originais (e miniaturas) junto do rascunho; vão para o recibo na confirmação
+	saveDraftImages(userID.(uint), draft.ID, uploads)
+
+	logger.InfoF("📋 Image preview ready: %d items extracted (draft %d, not saved yet)", len(previewItems), draft.ID)
+
+	ctx.JSON(http.StatusOK, ScanQRCodePreviewResponse{
+		Message:   fmt.Sprintf("✅ Preview ready! %d items extracted from %d image(s). You can now edit, remove items, or confirm to save.", len(previewItems), len(images)),
+		DraftID:   draft.ID,
+		ExpiresAt: draft.ExpiresAt,
+		Data:      previewData,
+	})
+}
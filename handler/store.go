@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// storeStats agrega as notas de um usuário em uma loja.
+type storeStats struct {
+	StoreID    uint
+	VisitCount int64
+	TotalSpent float64 // Na moeda base; notas sem cotação ficam fora
+	FirstVisit string
+	LastVisit  string
+	Currency   string
+	converted  int64 // Visitas incluídas em TotalSpent
+}
+
+// importStoreInfo decide os dados de emitente a gravar para uma nota confirmada.
+// Quando há chave de acesso, o CNPJ dela prevalece sobre o lido do cabeçalho.
+func importStoreInfo(data PreviewReceiptData, accessKey *nfce.AccessKey) *PreviewStore {
+	info := data.Store
+	if accessKey != nil && (info == nil || info.CNPJ != accessKey.CNPJ) {
+		info = &PreviewStore{CNPJ: accessKey.CNPJ, LegalName: data.StoreName}
+	}
+	return info
+}
+
+// findOrCreateStore busca a loja pelo CNPJ ou a cria com os dados lidos da nota.
+// Lojas existentes só têm campos vazios completados, nunca sobrescritos.
+// Retorna nil quando o CNPJ não é válido.
+func findOrCreateStore(tx *gorm.DB, info *PreviewStore) (*schemas.Store, error) {
+	if info == nil {
+		return nil, nil
+	}
+	cnpj := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, info.CNPJ)
+	if len(cnpj) != 14 {
+		return nil, nil
+	}
+
+	store := schemas.Store{
+		CNPJ:       cnpj,
+		LegalName:  info.LegalName,
+		TradeName:  info.TradeName,
+		Street:     info.Street,
+		Number:     info.Number,
+		Complement: info.Complement,
+		District:   info.District,
+		City:       info.City,
+		UF:         info.UF,
+		ZipCode:    info.ZipCode,
+	}
+	// ON CONFLICT DO NOTHING evita erro quando duas importações da mesma loja rodam juntas
+	if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "cnpj"}}, DoNothing: true}).Create(&store).Error; err != nil {
+		return nil, err
+	}
+	if store.ID != 0 {
+		return &store, nil
+	}
+
+	if err := tx.Where("cnpj = ?", cnpj).First(&store).Error; err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	for column, pair := range map[string][2]string{
+		"legal_name": {store.LegalName, info.LegalName},
+		"trade_name": {store.TradeName, info.TradeName},
+		"street":     {store.Street, info.Street},
+		"number":     {store.Number, info.Number},
+		"complement": {store.Complement, info.Complement},
+		"district":   {store.District, info.District},
+		"city":       {store.City, info.City},
+		"uf":         {store.UF, info.UF},
+		"zip_code":   {store.ZipCode, info.ZipCode},
+	} {
+		if pair[0] == "" && pair[1] != "" {
+			updates[column] = pair[1]
+		}
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&store).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &store, nil
+}
+
+// userStoreStats calcula visitas e gastos do usuário por loja (apenas recibos não excluídos).
+// Os gastos são somados por dia e moeda e convertidos para a moeda base pela cotação do dia.
+func userStoreStats(converter *currencyConverter, storeID *uint) ([]storeStats, error) {
+	type dailyTotal struct {
+		StoreID  uint
+		Day      string
+		Currency string
+		Visits   int64
+		Total    float64
+	}
+	query := db.Model(&schemas.Receipt{}).
+		Select("store_id, TO_CHAR(date, 'YYYY-MM-DD') AS day, currency, COUNT(*) AS visits, COALESCE(SUM(total), 0) AS total").
+		Where("user_id = ? AND store_id IS NOT NULL", converter.userID)
+	if storeID != nil {
+		query = query.Where("store_id = ?", *storeID)
+	}
+
+	var rows []dailyTotal
+	if err := query.Group("store_id, date, currency").Order("store_id, date").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	var stats []storeStats
+	index := make(map[uint]int)
+	for _, row := range rows {
+		i, ok := index[row.StoreID]
+		if !ok {
+			i = len(stats)
+			index[row.StoreID] = i
+			stats = append(stats, storeStats{StoreID: row.StoreID, FirstVisit: row.Day, Currency: converter.base})
+		}
+		stat := &stats[i]
+		stat.VisitCount += row.Visits
+		stat.LastVisit = row.Day
+		if total, ok := converter.convert(row.Total, row.Currency, row.Day); ok {
+			stat.TotalSpent += total
+			stat.converted += row.Visits
+		}
+	}
+	return stats, nil
+}
+
+// storeResponseWithStats monta a resposta da loja com as estatísticas do usuário.
+func storeResponseWithStats(store *schemas.Store, stats storeStats) schemas.StoreResponse {
+	response := store.ToResponse()
+	response.VisitCount = stats.VisitCount
+	response.TotalSpent = roundCents(stats.TotalSpent)
+	response.Currency = stats.Currency
+	response.FirstVisit = stats.FirstVisit
+	response.LastVisit = stats.LastVisit
+	if stats.converted > 0 {
+		response.AverageTicket = roundCents(stats.TotalSpent / float64(stats.converted))
+	}
+	return response
+}
+
+// GetStoresHandler lista as lojas em que o usuário tem notas
+// @Summary Listar lojas
+// @Description Lista as lojas (identificadas pelo CNPJ) em que o usuário tem notas, com número de visitas e total gasto (na moeda base do usuário). Ordenação por visitas (padrão), gasto ou data da última visita
+// @Tags stores
+// @Produce json
+// @Param search query string false "Filtrar por nome (razão social ou fantasia) ou CNPJ"
+// @Param sort query string false "visits (padrão), spent ou recent"
+// @Success 200 {array} schemas.StoreResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /stores [get]
+func GetStoresHandler(ctx *gin.Context) {
+	stats, err := userStoreStats(newCurrencyConverter(ctx), nil)
+	if err != nil {
+		logger.ErrorF("error aggregating store stats: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error listing stores")
+		return
+	}
+
+	statsByStore := make(map[uint]storeStats, len(stats))
+	storeIDs := make([]uint, 0, len(stats))
+	for _, s := range stats {
+		statsByStore[s.StoreID] = s
+		storeIDs = append(storeIDs, s.StoreID)
+	}
+
+	responses := []schemas.StoreResponse{}
+	if len(storeIDs) > 0 {
+		query := db.Where("id IN ?", storeIDs)
+		if search := strings.TrimSpace(ctx.Query("search")); search != "" {
+			pattern := "%" + search + "%"
+			query = query.Where("legal_name ILIKE ? OR trade_name ILIKE ? OR cnpj LIKE ?", pattern, pattern, pattern)
+		}
+
+		var stores []schemas.Store
+		if err := query.Find(&stores).Error; err != nil {
+			logger.ErrorF("error listing stores: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Error listing stores")
+			return
+		}
+		for i := range stores {
+			responses = append(responses, storeResponseWithStats(&stores[i], statsByStore[stores[i].ID]))
+		}
+	}
+
+	switch ctx.DefaultQuery("sort", "visits") {
+	case "spent":
+		sort.SliceStable(responses, func(i, j int) bool { return responses[i].TotalSpent > responses[j].TotalSpent })
+	case "recent":
+		sort.SliceStable(responses, func(i, j int) bool { return responses[i].LastVisit > responses[j].LastVisit })
+	default:
+		sort.SliceStable(responses, func(i, j int) bool {
+			if responses[i].VisitCount != responses[j].VisitCount {
+				return responses[i].VisitCount > responses[j].VisitCount
+			}
+			return responses[i].TotalSpent > responses[j].TotalSpent
+		})
+	}
+
+	ctx.JSON(http.StatusOK, responses)
+}
+
+// GetStoreHandler retorna uma loja com as estatísticas do usuário
+// @Summary Obter loja
+// @Description Retorna os dados da loja e o número de visitas e total gasto pelo usuário
+// @Tags stores
+// @Produce json
+// @Param id path int true "Store ID"
+// @Success 200 {object} schemas.StoreResponse
+// @Failure 404 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /stores/{id} [get]
+func GetStoreHandler(ctx *gin.Context) {
+	var store schemas.Store
+	if err := db.First(&store, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Store not found")
+		return
+	}
+
+	stats, err := userStoreStats(newCurrencyConverter(ctx), &store.ID)
+	if err != nil {
+		logger.ErrorF("error aggregating store stats: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Error loading store")
+		return
+	}
+	// Lojas são compartilhadas, mas cada usuário só enxerga as que já visitou
+	if len(stats) == 0 {
+		sendError(ctx, http.StatusNotFound, "Store not found")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, storeResponseWithStats(&store, stats[0]))
+}
@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultTrashRetentionDays é por quanto tempo notas e itens excluídos ficam na lixeira.
+	defaultTrashRetentionDays = 30
+	// trashPurgeInterval é a frequência com que a lixeira é esvaziada.
+	trashPurgeInterval = 6 * time.Hour
+	// trashPurgeBatch é o máximo de notas removidas por transação.
+	trashPurgeBatch = 100
+)
+
+var trashPurgeWorkerOnce sync.Once
+
+// TrashReceiptResponse é uma nota na lixeira.
+type TrashReceiptResponse struct {
+	ID        uint      `json:"id"`
+	StoreName string    `json:"storeName"`
+	Date      string    `json:"date"`
+	Total     float64   `json:"total"`
+	Currency  string    `json:"currency"`
+	ItemCount int64     `json:"itemCount"` // Itens que voltam com a nota
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"` // Quando será removida definitivamente
+}
+
+// TrashItemResponse é um item excluído de uma nota que continua ativa.
+type TrashItemResponse struct {
+	ID          uint      `json:"id"`
+	ReceiptID   uint      `json:"receiptId"`
+	StoreName   string    `json:"storeName"`
+	ReceiptDate string    `json:"receiptDate"`
+	ProductName string    `json:"productName"`
+	Quantity    float64   `json:"quantity"`
+	Total       float64   `json:"total"`
+	NetTotal    float64   `json:"netTotal"`
+	DeletedAt   time.Time `json:"deletedAt"`
+	PurgeAt     time.Time `json:"purgeAt"`
+}
+
+// TrashResponse é a resposta de GET /trash.
+type TrashResponse struct {
+	RetentionDays int                    `json:"retentionDays"`
+	Receipts      []TrashReceiptResponse `json:"receipts"`
+	Items         []TrashItemResponse    `json:"items"`
+}
+
+// RestoreItemResponse é a resposta de POST /trash/items/:id/restore.
+type RestoreItemResponse struct {
+	Message string                      `json:"message"`
+	Item    schemas.ReceiptItemResponse `json:"item"`
+	Receipt ReceiptTotalsResponse       `json:"receipt"`
+}
+
+// trashRetention lê TRASH_RETENTION_DAYS (padrão 30 dias).
+func trashRetention() time.Duration {
+	days := defaultTrashRetentionDays
+	if value, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil && value > 0 {
+		days = value
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// StartTrashPurgeWorker inicia (uma única vez) o worker que remove definitivamente as notas e os
+// itens que estão na lixeira há mais de TRASH_RETENTION_DAYS.
+func StartTrashPurgeWorker() {
+	trashPurgeWorkerOnce.Do(func() {
+		go func() {
+			purgeTrash(time.Now().Add(-trashRetention()))
+			ticker := time.NewTicker(trashPurgeInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				purgeTrash(time.Now().Add(-trashRetention()))
+			}
+		}()
+		logger.InfoF("🗑️  Trash purge worker started (retention %s, every %s)", trashRetention(), trashPurgeInterval)
+	})
+}
+
+// purgeTrash remove definitivamente as notas excluídas antes de before (com itens, pagamentos e
+// fotos) e os itens e pagamentos soltos excluídos antes disso.
+func purgeTrash(before time.Time) {
+	purgedReceipts := 0
+	for {
+		var receipts []schemas.Receipt
+		if err := db.Unscoped().Select("id").Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+			Order("id").Limit(trashPurgeBatch).Find(&receipts).Error; err != nil {
+			logger.ErrorF("⚠️  Failed to load trashed receipts: %v", err)
+			return
+		}
+		if len(receipts) == 0 {
+			break
+		}
+		ids := make([]uint, len(receipts))
+		for i := range receipts {
+			ids[i] = receipts[i].ID
+		}
+
+		var images []schemas.ReceiptImage
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Importações que apontam para a nota continuam no histórico, sem o vínculo
+			if err := tx.Model(&schemas.ImportJob{}).Where("receipt_id IN ?", ids).UpdateColumn("receipt_id", nil).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("receipt_id IN ?", ids).Find(&images).Error; err != nil {
+				return err
+			}
+			for _, model := range []interface{}{&schemas.ReceiptImage{}, &schemas.ReceiptItem{}, &schemas.ReceiptPayment{}} {
+				if err := tx.Unscoped().Where("receipt_id IN ?", ids).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Unscoped().Where("id IN ?", ids).Delete(&schemas.Receipt{}).Error
+		})
+		if err != nil {
+			logger.ErrorF("⚠️  Failed to purge trashed receipts: %v", err)
+			return
+		}
+		// As fotos só saem do armazenamento depois que o banco confirmou a remoção
+		deleteReceiptImageBlobs(images)
+		purgedReceipts += len(ids)
+	}
+
+	items := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&schemas.ReceiptItem{})
+	if items.Error != nil {
+		logger.ErrorF("⚠️  Failed to purge trashed items: %v", items.Error)
+		return
+	}
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&schemas.ReceiptPayment{}).Error; err != nil {
+		logger.ErrorF("⚠️  Failed to purge trashed payments: %v", err)
+		return
+	}
+
+	if purgedReceipts > 0 || items.RowsAffected > 0 {
+		logger.InfoF("🗑️  Trash purged: %d receipts and %d items deleted before %s", purgedReceipts, items.RowsAffected, before.Format(time.RFC3339))
+	}
+}
+
+// deletedWithReceipt filtra os itens e pagamentos excluídos junto com a nota (e não antes dela):
+// DeleteReceiptHandler grava exatamente o mesmo deleted_at nos itens, nos pagamentos e na nota.
+func deletedWithReceipt(tx *gorm.DB, receipt *schemas.Receipt) *gorm.DB {
+	return tx.Unscoped().Where("receipt_id = ? AND deleted_at = ?", receipt.ID, receipt.DeletedAt.Time)
+}
+
+// GetTrashHandler lista a lixeira do usuário
+// @Summary Lixeira
+// @Description Lista as notas excluídas e os itens excluídos de notas que continuam ativas. Tudo o que está na lixeira pode ser restaurado até purgeAt; depois disso é removido definitivamente (TRASH_RETENTION_DAYS, padrão 30 dias)
+// @Tags trash
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} TrashResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /trash [get]
+func GetTrashHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	retention := trashRetention()
+
+	var receipts []schemas.Receipt
+	if err := db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").Find(&receipts).Error; err != nil {
+		logger.ErrorF("error listing trashed receipts: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar a lixeira")
+		return
+	}
+	trashedReceipts := make([]TrashReceiptResponse, len(receipts))
+	for i := range receipts {
+		receipt := &receipts[i]
+		var count int64
+		if err := deletedWithReceipt(db.Model(&schemas.ReceiptItem{}), receipt).Count(&count).Error; err != nil {
+			logger.ErrorF("error counting trashed items: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Erro ao buscar a lixeira")
+			return
+		}
+		trashedReceipts[i] = TrashReceiptResponse{
+			ID:        receipt.ID,
+			StoreName: receipt.StoreName,
+			Date:      receipt.Date,
+			Total:     receipt.Total,
+			Currency:  receipt.Currency,
+			ItemCount: count,
+			DeletedAt: receipt.DeletedAt.Time,
+			PurgeAt:   receipt.DeletedAt.Time.Add(retention),
+		}
+	}
+
+	var items []schemas.ReceiptItem
+	if err := db.Unscoped().
+		Joins("INNER JOIN receipts ON receipts.id = receipt_items.receipt_id").
+		Where("receipts.user_id = ? AND receipts.deleted_at IS NULL AND receipt_items.deleted_at IS NOT NULL", userID).
+		Preload("Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Receipt").
+		Order("receipt_items.deleted_at DESC").
+		Find(&items).Error; err != nil {
+		logger.ErrorF("error listing trashed items: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar a lixeira")
+		return
+	}
+	trashedItems := make([]TrashItemResponse, len(items))
+	for i, item := range items {
+		trashedItems[i] = TrashItemResponse{
+			ID:        item.ID,
+			ReceiptID: item.ReceiptID,
+			Quantity:  item.Quantity,
+			Total:     item.Total,
+			NetTotal:  item.NetTotal,
+			DeletedAt: item.DeletedAt.Time,
+			PurgeAt:   item.DeletedAt.Time.Add(retention),
+		}
+		if item.Product != nil {
+			trashedItems[i].ProductName = item.Product.Name
+		}
+		if item.Receipt != nil {
+			trashedItems[i].StoreName = item.Receipt.StoreName
+			trashedItems[i].ReceiptDate = item.Receipt.Date
+		}
+	}
+
+	ctx.JSON(http.StatusOK, TrashResponse{
+		RetentionDays: int(retention / (24 * time.Hour)),
+		Receipts:      trashedReceipts,
+		Items:         trashedItems,
+	})
+}
+
+// RestoreReceiptHandler tira uma nota da lixeira
+// @Summary Restaurar nota
+// @Description Restaura uma nota excluída com os itens e pagamentos excluídos junto com ela (itens que já tinham sido excluídos antes continuam na lixeira) e recalcula os totais. Se outra nota ativa já tiver a mesma chave de acesso, responde 409
+// @Tags trash
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Receipt ID"
+// @Success 200 {object} schemas.ReceiptSummary
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /trash/receipts/{id}/restore [post]
+func RestoreReceiptHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	uid := userID.(uint)
+
+	var receipt schemas.Receipt
+	if err := db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", uid).First(&receipt, ctx.Param("id")).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Nota não encontrada na lixeira")
+		return
+	}
+
+	// A chave de acesso é única entre as notas ativas (idx_receipts_user_access_key)
+	if receipt.AccessKey != "" {
+		existing, err := findReceiptByAccessKey(uid, receipt.AccessKey)
+		if err != nil {
+			logger.ErrorF("error checking duplicate receipt: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Erro ao restaurar nota. Por favor, tente novamente")
+			return
+		}
+		if existing != nil {
+			sendDuplicateReceipt(ctx, "Esta nota foi importada de novo depois de excluída; exclua a nota atual para restaurar esta", existing.ID, nil)
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := deletedWithReceipt(tx.Model(&schemas.ReceiptItem{}), &receipt).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := deletedWithReceipt(tx.Model(&schemas.ReceiptPayment{}), &receipt).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&receipt).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		_, err := recalculateReceiptTotals(tx, receipt.ID, 0)
+		return err
+	})
+	if err != nil {
+		logger.ErrorF("error restoring receipt %d: %v", receipt.ID, err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao restaurar nota. Por favor, tente novamente")
+		return
+	}
+	logger.InfoF("♻️  Receipt %d restored from trash (user %d)", receipt.ID, uid)
+
+	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("Items.Category").Preload("Items.Product").Preload("Payments").First(&receipt, receipt.ID)
+
+	ctx.JSON(http.StatusOK, newCurrencyConverter(ctx).summary(&receipt))
+}
+
+// RestoreItemHandler tira um item da lixeira
+// @Summary Restaurar item
+// @Description Restaura um item excluído de uma nota ativa e recalcula subtotal, desconto e total da nota. Itens de notas que estão na lixeira voltam restaurando a nota
+// @Tags trash
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Receipt Item ID"
+// @Success 200 {object} RestoreItemResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "A nota do item está na lixeira"
+// @Failure 500 {object} ErrorResponse
+// @Router /trash/items/{id}/restore [post]
+func RestoreItemHandler(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var item schemas.ReceiptItem
+	if err := db.Unscoped().
+		Joins("INNER JOIN receipts ON receipts.id = receipt_items.receipt_id").
+		Where("receipt_items.id = ? AND receipts.user_id = ? AND receipt_items.deleted_at IS NOT NULL", ctx.Param("id"), userID).
+		First(&item).Error; err != nil {
+		sendError(ctx, http.StatusNotFound, "Item não encontrado na lixeira")
+		return
+	}
+
+	var active int64
+	db.Model(&schemas.Receipt{}).Where("id = ?", item.ReceiptID).Count(&active)
+	if active == 0 {
+		sendError(ctx, http.StatusConflict, "A nota deste item está na lixeira; restaure a nota")
+		return
+	}
+
+	var receipt *schemas.Receipt
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&item).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		var err error
+		receipt, err = recalculateReceiptTotals(tx, item.ReceiptID, item.Discount)
+		return err
+	})
+	if err != nil {
+		logger.ErrorF("error restoring item %d: %v", item.ID, err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao restaurar item. Por favor, tente novamente")
+		return
+	}
+
+	db.Preload("Category").Preload("Product").First(&item, item.ID)
+	ctx.JSON(http.StatusOK, RestoreItemResponse{
+		Message: "Item restaurado com sucesso",
+		Item:    item.ToResponse(),
+		Receipt: totalsResponse(receipt),
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/Pmmvito/Golang-Api-Exemple/config"
+	"github.com/Pmmvito/Golang-Api-Exemple/currency"
 	"github.com/Pmmvito/Golang-Api-Exemple/schemas"
 	"github.com/gin-gonic/gin"
 )
@@ -55,7 +56,7 @@ func DeleteUserHandler(ctx *gin.Context) {
 		return
 	}
 
-	// 2. Para cada receipt, deletar apenas os items (NÃO deletamos produtos pois podem ser compartilhados)
+	// 2. Para cada receipt, deletar os items e pagamentos (NÃO deletamos produtos pois podem ser compartilhados)
 	for _, receipt := range receipts {
 		// Soft delete dos receipt items
 		result := tx.Where("receipt_id = ?", receipt.ID).Delete(&schemas.ReceiptItem{})
@@ -66,6 +67,13 @@ func DeleteUserHandler(ctx *gin.Context) {
 			return
 		}
 		itemsDeleted += result.RowsAffected
+
+		if err := tx.Where("receipt_id = ?", receipt.ID).Delete(&schemas.ReceiptPayment{}).Error; err != nil {
+			tx.Rollback()
+			config.GetLogger("handler").ErrorF("error deleting receipt payments: %v", err.Error())
+			sendError(ctx, http.StatusInternalServerError, "Erro ao deletar pagamentos das notas fiscais. Operação cancelada")
+			return
+		}
 	}
 
 	// 3. Soft delete dos receipts
@@ -133,7 +141,50 @@ func DeleteUserHandler(ctx *gin.Context) {
 	}
 	aiTokenUsageDeleted = result.RowsAffected
 
-	// 9. Soft delete do usuário
+	// 9. Deletar jobs e lotes de importação do usuário (hard delete - guardam os dados da nota em payload)
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&schemas.ImportJob{}).Error; err != nil {
+		tx.Rollback()
+		config.GetLogger("handler").ErrorF("error deleting import jobs: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao deletar histórico de importações. Operação cancelada")
+		return
+	}
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&schemas.ImportBatch{}).Error; err != nil {
+		tx.Rollback()
+		config.GetLogger("handler").ErrorF("error deleting import batches: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao deletar histórico de importações. Operação cancelada")
+		return
+	}
+
+	// 10. Deletar rascunhos de preview e notas pendentes do usuário (hard delete)
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&schemas.ReceiptDraft{}).Error; err != nil {
+		tx.Rollback()
+		config.GetLogger("handler").ErrorF("error deleting receipt drafts: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao deletar rascunhos de notas. Operação cancelada")
+		return
+	}
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&schemas.PendingScan{}).Error; err != nil {
+		tx.Rollback()
+		config.GetLogger("handler").ErrorF("error deleting pending scans: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao deletar notas pendentes. Operação cancelada")
+		return
+	}
+
+	// 11. Deletar as fotos das notas (hard delete; os arquivos saem do BlobStore depois do commit)
+	var images []schemas.ReceiptImage
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Find(&images).Error; err != nil {
+		tx.Rollback()
+		config.GetLogger("handler").ErrorF("error finding receipt images: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao buscar fotos das notas. Operação cancelada")
+		return
+	}
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&schemas.ReceiptImage{}).Error; err != nil {
+		tx.Rollback()
+		config.GetLogger("handler").ErrorF("error deleting receipt images: %v", err.Error())
+		sendError(ctx, http.StatusInternalServerError, "Erro ao deletar fotos das notas. Operação cancelada")
+		return
+	}
+
+	// 12. Soft delete do usuário
 	if err := tx.Delete(&user).Error; err != nil {
 		tx.Rollback()
 		config.GetLogger("handler").ErrorF("error deleting user: %v", err.Error())
@@ -148,6 +199,8 @@ func DeleteUserHandler(ctx *gin.Context) {
 		return
 	}
 
+	go deleteReceiptImageBlobs(images)
+
 	config.GetLogger("handler").InfoF("User %d deleted successfully with all associated data", user.ID)
 	config.GetLogger("handler").InfoF("Deleted: %d receipts, %d items, %d shopping lists, %d list items, %d token blacklist entries, %d AI token usage records",
 		receiptsDeleted, itemsDeleted, shoppingListsDeleted, listItemsDeleted, tokenBlacklistDeleted, aiTokenUsageDeleted)
@@ -166,6 +219,8 @@ func DeleteUserHandler(ctx *gin.Context) {
 type UpdateProfileRequest struct {
 	Name  *string `json:"name,omitempty" example:"João Silva"`
 	Email *string `json:"email,omitempty" example:"novo@example.com"`
+	// BaseCurrency é a moeda (ISO 4217) para a qual relatórios e totais são convertidos
+	BaseCurrency *string `json:"baseCurrency,omitempty" example:"BRL"`
 }
 
 // VerifyEmailRequest define a estrutura para solicitar verificação de email
@@ -200,14 +255,14 @@ type EmailVerification struct {
 var emailVerifications = make(map[uint]*EmailVerification)
 
 // @Summary Update user profile
-// @Description Update user name. Email changes require verification code.
+// @Description Update user name and base currency (ISO 4217, used to convert reports and totals). Email changes require verification code.
 // @Tags 👤 User
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param request body UpdateProfileRequest true "Profile data to update"
 // @Success 200 {object} map[string]interface{} "Profile updated successfully"
-// @Failure 400 {object} ErrorResponse "Dados inválidos | Nenhum campo para atualizar"
+// @Failure 400 {object} ErrorResponse "Dados inválidos | Moeda inválida | Nenhum campo para atualizar"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 500 {object} ErrorResponse "Erro ao atualizar perfil"
 // @Router /user/profile [patch]
@@ -232,6 +287,17 @@ func UpdateProfileHandler(ctx *gin.Context) {
 		updated = true
 	}
 
+	// Moeda base dos relatórios
+	if request.BaseCurrency != nil {
+		code, err := currency.Normalize(*request.BaseCurrency)
+		if err != nil {
+			sendError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		user.BaseCurrency = code
+		updated = true
+	}
+
 	// Para email, precisamos de verificação - apenas retorna instrução
 	if request.Email != nil && *request.Email != "" {
 		ctx.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,62 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake é um provedor determinístico para testes e desenvolvimento sem rede: devolve Reply
+// (ou o resultado de Respond, quando definido) e guarda as requisições recebidas.
+// O consumo de tokens é estimado pelo tamanho dos textos (1 token a cada 4 caracteres).
+type Fake struct {
+	Reply   string
+	Respond func(request Request) (string, error)
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewFake cria um provedor fake que sempre responde reply.
+func NewFake(reply string) *Fake {
+	return &Fake{Reply: reply}
+}
+
+func (f *Fake) Name() string  { return "fake" }
+func (f *Fake) Model() string { return "fake" }
+
+// Generate registra a requisição e devolve a resposta configurada.
+func (f *Fake) Generate(ctx context.Context, request Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, request)
+	f.mu.Unlock()
+
+	text := f.Reply
+	if f.Respond != nil {
+		var err error
+		if text, err = f.Respond(request); err != nil {
+			return nil, err
+		}
+	}
+	if text == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	usage := Usage{PromptTokens: estimateTokens(request.Prompt), ResponseTokens: estimateTokens(text)}
+	usage.TotalTokens = usage.PromptTokens + usage.ResponseTokens
+	return &Response{Text: text, Model: f.Model(), Usage: usage}, nil
+}
+
+// Requests devolve uma cópia das requisições recebidas, na ordem.
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+func estimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultGeminiModel é usado quando GEMINI_MODEL não é informado.
+const DefaultGeminiModel = "gemini-2.5-flash"
+
+// GeminiProvider chama a API generateContent do Google Gemini.
+type GeminiProvider struct {
+	apiKey  string
+	model   string
+	baseURL string
+}
+
+// NewGeminiProvider cria o provedor do Gemini. Sem modelo, usa DefaultGeminiModel; sem baseURL,
+// a API pública do Google.
+func NewGeminiProvider(apiKey, model, baseURL string) *GeminiProvider {
+	if model == "" {
+		model = DefaultGeminiModel
+	}
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com"
+	}
+	return &GeminiProvider{apiKey: apiKey, model: model, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (p *GeminiProvider) Name() string  { return "gemini" }
+func (p *GeminiProvider) Model() string { return p.model }
+
+type geminiRequest struct {
+	Contents         []geminiContent         `json:"contents"`
+	GenerationConfig *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	ResponseMimeType string        `json:"responseMimeType"`
+	ResponseSchema   *geminiSchema `json:"responseSchema,omitempty"`
+}
+
+// geminiSchema é o Schema no formato da API do Gemini (tipos em maiúsculas).
+type geminiSchema struct {
+	Type        string                   `json:"type"`
+	Description string                   `json:"description,omitempty"`
+	Properties  map[string]*geminiSchema `json:"properties,omitempty"`
+	Required    []string                 `json:"required,omitempty"`
+	Items       *geminiSchema            `json:"items,omitempty"`
+}
+
+func toGeminiSchema(schema *Schema) *geminiSchema {
+	if schema == nil {
+		return nil
+	}
+	converted := &geminiSchema{
+		Type:        strings.ToUpper(schema.Type),
+		Description: schema.Description,
+		Required:    schema.Required,
+		Items:       toGeminiSchema(schema.Items),
+	}
+	if len(schema.Properties) > 0 {
+		converted.Properties = make(map[string]*geminiSchema, len(schema.Properties))
+		for name, property := range schema.Properties {
+			converted.Properties[name] = toGeminiSchema(property)
+		}
+	}
+	return converted
+}
+
+type geminiContent struct {
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata,omitempty"`
+	ModelVersion string `json:"modelVersion"`
+}
+
+// apiVersion escolhe a versão da API: modelos preview/experimentais só existem na v1beta.
+func (p *GeminiProvider) apiVersion() string {
+	if strings.Contains(p.model, "preview") || strings.Contains(p.model, "exp-") {
+		return "v1beta"
+	}
+	return "v1"
+}
+
+// Generate envia o prompt seguido das imagens como partes de um único conteúdo.
+func (p *GeminiProvider) Generate(ctx context.Context, request Request) (*Response, error) {
+	if p.apiKey == "" {
+		return nil, errors.New("GEMINI_API_KEY não configurada")
+	}
+
+	parts := []geminiPart{{Text: request.Prompt}}
+	for _, image := range request.Images {
+		parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: image.MimeType, Data: image.Base64}})
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/models/%s:generateContent?key=%s", p.baseURL, p.apiVersion(), url.PathEscape(p.model), url.QueryEscape(p.apiKey))
+	payload := geminiRequest{Contents: []geminiContent{{Parts: parts}}}
+	if request.Schema != nil {
+		payload.GenerationConfig = &geminiGenerationConfig{
+			ResponseMimeType: "application/json",
+			ResponseSchema:   toGeminiSchema(request.Schema),
+		}
+	}
+
+	var out geminiResponse
+	if err := postJSON(ctx, p.Name(), endpoint, nil, payload, &out); err != nil {
+		return nil, err
+	}
+
+	if len(out.Candidates) == 0 || len(out.Candidates[0].Content.Parts) == 0 {
+		return nil, ErrEmptyResponse
+	}
+	var text strings.Builder
+	for _, part := range out.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+
+	response := &Response{Text: text.String(), Model: p.model}
+	if out.UsageMetadata != nil {
+		response.Usage = Usage{
+			PromptTokens:   out.UsageMetadata.PromptTokenCount,
+			ResponseTokens: out.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:    out.UsageMetadata.TotalTokenCount,
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// OllamaProvider chama o endpoint /api/chat de um servidor Ollama local.
+type OllamaProvider struct {
+	model   string
+	baseURL string
+}
+
+// NewOllamaProvider cria o provedor. Sem baseURL, usa o endereço padrão do Ollama.
+func NewOllamaProvider(baseURL, model string) *OllamaProvider {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &OllamaProvider{model: model, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (p *OllamaProvider) Name() string  { return "ollama" }
+func (p *OllamaProvider) Model() string { return p.model }
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   *Schema         `json:"format,omitempty"` // Saída estruturada (Ollama 0.5+)
+}
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+type ollamaResponse struct {
+	Model   string `json:"model"`
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// Generate envia o prompt com as imagens em base64 puro, sem streaming.
+// Chamadas de visão exigem um modelo multimodal (ex: llava, llama3.2-vision).
+func (p *OllamaProvider) Generate(ctx context.Context, request Request) (*Response, error) {
+	if p.model == "" {
+		return nil, errors.New("OLLAMA_MODEL não configurado")
+	}
+
+	message := ollamaMessage{Role: "user", Content: request.Prompt}
+	for _, image := range request.Images {
+		message.Images = append(message.Images, image.Base64)
+	}
+
+	var out ollamaResponse
+	payload := ollamaRequest{Model: p.model, Messages: []ollamaMessage{message}, Format: request.Schema}
+	if err := postJSON(ctx, p.Name(), p.baseURL+"/api/chat", nil, payload, &out); err != nil {
+		return nil, err
+	}
+
+	if out.Message.Content == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	return &Response{
+		Text:  out.Message.Content,
+		Model: p.model,
+		Usage: Usage{
+			PromptTokens:   out.PromptEvalCount,
+			ResponseTokens: out.EvalCount,
+			TotalTokens:    out.PromptEvalCount + out.EvalCount,
+		},
+	}, nil
+}
@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// OpenAIProvider chama o endpoint /chat/completions de qualquer API compatível com a da OpenAI
+// (OpenAI, Azure, OpenRouter, Groq, vLLM, LM Studio...).
+type OpenAIProvider struct {
+	apiKey  string
+	model   string
+	baseURL string
+}
+
+// NewOpenAIProvider cria o provedor. baseURL é a raiz da API, com a versão
+// (ex: https://api.openai.com/v1); a chave é opcional para servidores locais.
+func NewOpenAIProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	return &OpenAIProvider{apiKey: apiKey, model: model, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (p *OpenAIProvider) Name() string  { return "openai" }
+func (p *OpenAIProvider) Model() string { return p.model }
+
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string  `json:"name"`
+	Schema *Schema `json:"schema"`
+}
+
+type openAIMessage struct {
+	Role    string              `json:"role"`
+	Content []openAIContentPart `json:"content"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage,omitempty"`
+}
+
+// Generate envia o prompt e as imagens (como data URLs) numa única mensagem do usuário.
+func (p *OpenAIProvider) Generate(ctx context.Context, request Request) (*Response, error) {
+	if p.model == "" {
+		return nil, errors.New("OPENAI_MODEL não configurado")
+	}
+
+	content := []openAIContentPart{{Type: "text", Text: request.Prompt}}
+	for _, image := range request.Images {
+		content = append(content, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: image.DataURL()}})
+	}
+
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+
+	payload := openAIRequest{Model: p.model, Messages: []openAIMessage{{Role: "user", Content: content}}}
+	if request.Schema != nil {
+		payload.ResponseFormat = &openAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &openAIJSONSchema{Name: "response", Schema: request.Schema},
+		}
+	}
+	var out openAIResponse
+	if err := postJSON(ctx, p.Name(), p.baseURL+"/chat/completions", headers, payload, &out); err != nil {
+		return nil, err
+	}
+
+	if len(out.Choices) == 0 || out.Choices[0].Message.Content == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	response := &Response{Text: out.Choices[0].Message.Content, Model: p.model}
+	if out.Usage != nil {
+		response.Usage = Usage{
+			PromptTokens:   out.Usage.PromptTokens,
+			ResponseTokens: out.Usage.CompletionTokens,
+			TotalTokens:    out.Usage.TotalTokens,
+		}
+	}
+	return response, nil
+}
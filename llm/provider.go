@@ -0,0 +1,148 @@
+// Package llm abstrai os modelos de linguagem usados para ler fotos de notas e categorizar itens.
+// Cada provedor (Gemini, APIs compatíveis com a da OpenAI, Ollama local e um fake para testes)
+// implementa AIProvider; qual deles é usado vem da configuração (AI_PROVIDER).
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AIProvider é um modelo de linguagem capaz de responder a um prompt, opcionalmente com imagens.
+type AIProvider interface {
+	// Name identifica o provedor (gemini, openai, ollama, fake).
+	Name() string
+	// Model é o modelo configurado, gravado no registro de uso de tokens.
+	Model() string
+	// Generate envia o prompt (e as imagens, em chamadas de visão) e devolve o texto gerado.
+	Generate(ctx context.Context, request Request) (*Response, error)
+}
+
+// Request é uma chamada ao modelo. Sem imagens é uma chamada de texto; com imagens, de visão.
+// Com Schema, o provedor usa o modo de saída estruturada e responde JSON nesse formato.
+type Request struct {
+	Prompt string
+	Images []Image
+	Schema *Schema
+}
+
+// Schema descreve o JSON esperado na resposta. É o subconjunto de JSON Schema aceito pelos
+// modos de saída estruturada do Gemini, das APIs compatíveis com a da OpenAI e do Ollama.
+type Schema struct {
+	Type        string             `json:"type"` // object, array, string, number, integer ou boolean
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+}
+
+// Image é uma imagem enviada ao modelo, já codificada em base64.
+type Image struct {
+	MimeType string
+	Base64   string
+}
+
+// Usage é o consumo de tokens informado pelo provedor (zero quando ele não informa).
+type Usage struct {
+	PromptTokens   int
+	ResponseTokens int
+	TotalTokens    int
+}
+
+// Response é o texto gerado pelo modelo e o consumo de tokens da chamada.
+type Response struct {
+	Text  string
+	Model string
+	Usage Usage
+}
+
+// ErrEmptyResponse é retornado quando o provedor responde sem nenhum texto.
+var ErrEmptyResponse = errors.New("empty response from AI provider")
+
+// StatusError é uma resposta HTTP de erro do provedor.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s API error (status %d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// ParseDataURL converte uma imagem em data URL ("data:image/png;base64,...") ou base64 puro
+// (tratado como JPEG) em Image.
+func ParseDataURL(value string) Image {
+	image := Image{MimeType: "image/jpeg", Base64: value}
+	header, data, found := strings.Cut(value, ",")
+	if !found {
+		return image
+	}
+	if strings.HasPrefix(header, "data:") {
+		image.MimeType = strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+	}
+	image.Base64 = data
+	return image
+}
+
+// DataURL devolve a imagem no formato data URL, usado pelas APIs compatíveis com a da OpenAI.
+func (i Image) DataURL() string {
+	return "data:" + i.MimeType + ";base64," + i.Base64
+}
+
+// StripCodeFence remove o bloco de código markdown (```json ... ```) que os modelos costumam
+// colocar em volta do JSON.
+func StripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```JSON")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	return strings.TrimSpace(text)
+}
+
+// httpClient é compartilhado pelos provedores; o prazo de cada chamada vem do contexto.
+var httpClient = &http.Client{Timeout: 3 * time.Minute}
+
+// postJSON envia payload como JSON e decodifica a resposta em out. Respostas fora de 2xx
+// viram StatusError com o corpo retornado pelo provedor.
+func postJSON(ctx context.Context, provider, url string, headers map[string]string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal request: %w", provider, err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: failed to build request: %w", provider, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s: failed to call API: %w", provider, err)
+	}
+	defer response.Body.Close()
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%s: failed to read response: %w", provider, err)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &StatusError{Provider: provider, StatusCode: response.StatusCode, Body: string(content)}
+	}
+	if err := json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("%s: failed to parse response: %w", provider, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStripCodeFence(t *testing.T) {
+	for input, want := range map[string]string{
+		"```json\n[{\"a\":1}]\n```": `[{"a":1}]`,
+		"```\n{}\n```":              "{}",
+		"  {\"a\":1}  ":             `{"a":1}`,
+	} {
+		if got := StripCodeFence(input); got != want {
+			t.Fatalf("StripCodeFence(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestParseDataURL(t *testing.T) {
+	image := ParseDataURL("data:image/png;base64,AAAA")
+	if image.MimeType != "image/png" || image.Base64 != "AAAA" {
+		t.Fatalf("unexpected image: %+v", image)
+	}
+	image = ParseDataURL("BBBB")
+	if image.MimeType != "image/jpeg" || image.Base64 != "BBBB" {
+		t.Fatalf("unexpected raw image: %+v", image)
+	}
+	if got := image.DataURL(); got != "data:image/jpeg;base64,BBBB" {
+		t.Fatalf("DataURL = %q", got)
+	}
+}
+
+// serve responde a uma única chamada com body, guardando o caminho e o corpo recebidos.
+func serve(t *testing.T, body string, path *string, payload *map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+var testRequest = Request{
+	Prompt: "categorize",
+	Images: []Image{{MimeType: "image/png", Base64: "AAAA"}},
+	Schema: &Schema{Type: "array", Items: &Schema{Type: "object", Properties: map[string]*Schema{"categoryId": {Type: "integer"}}}},
+}
+
+func TestGeminiProvider(t *testing.T) {
+	var path string
+	var payload map[string]interface{}
+	server := serve(t, `{"candidates":[{"content":{"parts":[{"text":"ok"}]}}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":2,"totalTokenCount":12}}`, &path, &payload)
+
+	provider := NewGeminiProvider("key", "gemini-2.0-pro-exp-02-05", server.URL)
+	response, err := provider.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if path != "/v1beta/models/gemini-2.0-pro-exp-02-05:generateContent" {
+		t.Fatalf("unexpected path %q", path)
+	}
+	parts := payload["contents"].([]interface{})[0].(map[string]interface{})["parts"].([]interface{})
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want prompt + image", len(parts))
+	}
+	config := payload["generationConfig"].(map[string]interface{})
+	if config["responseMimeType"] != "application/json" || config["responseSchema"].(map[string]interface{})["type"] != "ARRAY" {
+		t.Fatalf("structured output not requested: %v", config)
+	}
+	if response.Text != "ok" || response.Model != "gemini-2.0-pro-exp-02-05" || response.Usage != (Usage{10, 2, 12}) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	if _, err := NewGeminiProvider("", "", server.URL).Generate(context.Background(), testRequest); err == nil {
+		t.Fatal("Generate without API key should fail")
+	}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	var path string
+	var payload map[string]interface{}
+	server := serve(t, `{"choices":[{"message":{"content":"ok"}}],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}`, &path, &payload)
+
+	response, err := NewOpenAIProvider(server.URL+"/v1/", "key", "gpt-4o-mini").Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if path != "/v1/chat/completions" || payload["model"] != "gpt-4o-mini" {
+		t.Fatalf("unexpected call %q %v", path, payload["model"])
+	}
+	content := payload["messages"].([]interface{})[0].(map[string]interface{})["content"].([]interface{})
+	imageURL := content[1].(map[string]interface{})["image_url"].(map[string]interface{})["url"]
+	if imageURL != "data:image/png;base64,AAAA" {
+		t.Fatalf("unexpected image url %v", imageURL)
+	}
+	format := payload["response_format"].(map[string]interface{})
+	if format["type"] != "json_schema" || format["json_schema"].(map[string]interface{})["schema"].(map[string]interface{})["type"] != "array" {
+		t.Fatalf("structured output not requested: %v", format)
+	}
+	if response.Text != "ok" || response.Usage != (Usage{7, 3, 10}) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	var path string
+	var payload map[string]interface{}
+	server := serve(t, `{"message":{"content":"ok"},"prompt_eval_count":5,"eval_count":4}`, &path, &payload)
+
+	response, err := NewOllamaProvider(server.URL, "llava").Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if path != "/api/chat" || payload["stream"] != false {
+		t.Fatalf("unexpected call %q %v", path, payload)
+	}
+	images := payload["messages"].([]interface{})[0].(map[string]interface{})["images"].([]interface{})
+	if len(images) != 1 || images[0] != "AAAA" {
+		t.Fatalf("unexpected images %v", images)
+	}
+	if format, ok := payload["format"].(map[string]interface{}); !ok || format["type"] != "array" {
+		t.Fatalf("structured output not requested: %v", payload["format"])
+	}
+	if response.Text != "ok" || response.Usage != (Usage{5, 4, 9}) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := NewOllamaProvider(server.URL, "missing").Generate(context.Background(), Request{Prompt: "x"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound || !strings.Contains(statusErr.Body, "model not found") {
+		t.Fatalf("expected StatusError 404, got %v", err)
+	}
+}
+
+func TestFake(t *testing.T) {
+	fake := NewFake("12345678")
+	response, err := fake.Generate(context.Background(), Request{Prompt: "abcd"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if response.Text != "12345678" || response.Usage != (Usage{1, 2, 3}) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	fake.Respond = func(request Request) (string, error) { return strings.ToUpper(request.Prompt), nil }
+	if response, _ := fake.Generate(context.Background(), Request{Prompt: "abc"}); response.Text != "ABC" {
+		t.Fatalf("Respond not used: %+v", response)
+	}
+	if got := len(fake.Requests()); got != 2 {
+		t.Fatalf("recorded %d requests, want 2", got)
+	}
+}
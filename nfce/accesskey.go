@@ -0,0 +1,127 @@
+package nfce
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AccessKey representa a chave de acesso de 44 dígitos de uma NF-e/NFC-e decodificada.
+//
+// Leiaute: cUF(2) AAMM(4) CNPJ(14) mod(2) serie(3) nNF(9) tpEmis(1) cNF(8) cDV(1).
+type AccessKey struct {
+	Key          string // Chave completa, apenas dígitos
+	UFCode       string // Código IBGE do estado (cUF)
+	UF           string // Sigla do estado
+	Year         int    // Ano de emissão (AAAA)
+	Month        int    // Mês de emissão
+	CNPJ         string // CNPJ do emitente
+	Model        string // 55 = NF-e, 65 = NFC-e
+	Series       string // Série, sem zeros à esquerda
+	Number       string // Número da nota (nNF), sem zeros à esquerda
+	EmissionType string // Tipo de emissão (tpEmis): 1 = normal, 9 = contingência offline NFC-e, ...
+	Code         string // Código numérico aleatório (cNF)
+	CheckDigit   int    // Dígito verificador (cDV)
+}
+
+// ParseAccessKey decodifica e valida uma chave de acesso. Espaços e pontuação são ignorados,
+// então a chave pode vir agrupada como impressa no DANFE ("3524 0312 ...").
+func ParseAccessKey(raw string) (*AccessKey, error) {
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '.' || r == '-' || r == '/':
+		default:
+			return nil, fmt.Errorf("chave de acesso inválida: caractere '%c' não permitido", r)
+		}
+	}
+	key := b.String()
+
+	if len(key) != 44 {
+		return nil, fmt.Errorf("chave de acesso inválida: esperado 44 dígitos, recebido %d", len(key))
+	}
+
+	uf, ok := ufByCode[key[0:2]]
+	if !ok {
+		return nil, fmt.Errorf("chave de acesso inválida: código de UF '%s' desconhecido", key[0:2])
+	}
+
+	year, _ := strconv.Atoi(key[2:4])
+	month, _ := strconv.Atoi(key[4:6])
+	if month < 1 || month > 12 {
+		return nil, fmt.Errorf("chave de acesso inválida: mês de emissão '%s' fora do intervalo", key[4:6])
+	}
+
+	model := key[20:22]
+	if model != "55" && model != "65" {
+		return nil, fmt.Errorf("chave de acesso inválida: modelo '%s' não é NF-e (55) nem NFC-e (65)", model)
+	}
+
+	dv := int(key[43] - '0')
+	if expected := AccessKeyCheckDigit(key[:43]); dv != expected {
+		return nil, fmt.Errorf("chave de acesso inválida: dígito verificador %d não confere (esperado %d)", dv, expected)
+	}
+
+	return &AccessKey{
+		Key:          key,
+		UFCode:       key[0:2],
+		UF:           uf,
+		Year:         2000 + year,
+		Month:        month,
+		CNPJ:         key[6:20],
+		Model:        model,
+		Series:       trimLeadingZeros(key[22:25]),
+		Number:       trimLeadingZeros(key[25:34]),
+		EmissionType: key[34:35],
+		Code:         key[35:43],
+		CheckDigit:   dv,
+	}, nil
+}
+
+// Contingency indica se a nota foi emitida em contingência offline (tpEmis 9). Essas notas
+// só aparecem no portal depois que o emissor as transmite, o que pode levar horas ou dias.
+func (k *AccessKey) Contingency() bool {
+	return k.EmissionType == "9"
+}
+
+// AccessKeyCheckDigit calcula o dígito verificador (módulo 11) dos 43 primeiros dígitos da chave.
+// Os pesos vão de 2 a 9, da direita para a esquerda; restos 0 e 1 resultam em dígito 0.
+func AccessKeyCheckDigit(digits string) int {
+	sum, weight := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+	remainder := sum % 11
+	if remainder < 2 {
+		return 0
+	}
+	return 11 - remainder
+}
+
+// Period retorna o ano/mês de emissão no formato AAAA-MM.
+func (k *AccessKey) Period() string {
+	return fmt.Sprintf("%04d-%02d", k.Year, k.Month)
+}
+
+// Formatted retorna a chave em grupos de 4 dígitos, como impressa no DANFE.
+func (k *AccessKey) Formatted() string {
+	groups := make([]string, 0, 11)
+	for i := 0; i < len(k.Key); i += 4 {
+		groups = append(groups, k.Key[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+func trimLeadingZeros(s string) string {
+	trimmed := strings.TrimLeft(s, "0")
+	if trimmed == "" {
+		return "0"
+	}
+	return trimmed
+}
@@ -0,0 +1,79 @@
+package nfce
+
+import "testing"
+
+func TestParseAccessKey(t *testing.T) {
+	key, err := ParseAccessKey("3524 0312 3456 7800 0190 6500 1000 1234 5611 2345 6783")
+	if err != nil {
+		t.Fatalf("ParseAccessKey: %v", err)
+	}
+
+	want := AccessKey{
+		Key:          "35240312345678000190650010001234561123456783",
+		UFCode:       "35",
+		UF:           "SP",
+		Year:         2024,
+		Month:        3,
+		CNPJ:         "12345678000190",
+		Model:        "65",
+		Series:       "1",
+		Number:       "123456",
+		EmissionType: "1",
+		Code:         "12345678",
+		CheckDigit:   3,
+	}
+	if *key != want {
+		t.Errorf("ParseAccessKey = %+v, want %+v", *key, want)
+	}
+	if key.Period() != "2024-03" {
+		t.Errorf("Period = %q, want 2024-03", key.Period())
+	}
+	if key.Formatted() != "3524 0312 3456 7800 0190 6500 1000 1234 5611 2345 6783" {
+		t.Errorf("Formatted = %q", key.Formatted())
+	}
+	if key.Contingency() {
+		t.Error("Contingency = true for tpEmis 1")
+	}
+
+	// Mesma nota emitida em contingência offline (tpEmis 9)
+	digits := "3524031234567800019065001000123456" + "9" + "12345678"
+	contingency, err := ParseAccessKey(digits + string(rune('0'+AccessKeyCheckDigit(digits))))
+	if err != nil {
+		t.Fatalf("ParseAccessKey (contingency): %v", err)
+	}
+	if !contingency.Contingency() {
+		t.Errorf("Contingency = false for tpEmis %q", contingency.EmissionType)
+	}
+}
+
+func TestParseAccessKeyRejectsInvalidKeys(t *testing.T) {
+	tests := map[string]string{
+		"short":         "3524031234567800019065001000123456112345678",
+		"letters":       "3524031234567800019065001000123456112345678X",
+		"unknown UF":    "99240312345678000190650010001234561123456783",
+		"bad month":     "35241312345678000190650010001234561123456783",
+		"bad model":     "35240312345678000190590010001234561123456783",
+		"check digit":   "35240312345678000190650010001234561123456784",
+		"empty":         "",
+		"only grouping": "    ",
+	}
+	for name, raw := range tests {
+		if _, err := ParseAccessKey(raw); err == nil {
+			t.Errorf("%s: ParseAccessKey(%q) returned no error", name, raw)
+		}
+	}
+}
+
+func TestAccessKeyCheckDigit(t *testing.T) {
+	// Chaves usadas nas fixtures de cada estado
+	for _, key := range []string{
+		"35240312345678000190650010001234561123456783",
+		"31240389012345000167650020006543211876543210",
+		"31240389012345000167550020006543211876543218",
+		"43231245678901000123650010001234561123456787",
+	} {
+		if got := AccessKeyCheckDigit(key[:43]); got != int(key[43]-'0') {
+			t.Errorf("AccessKeyCheckDigit(%s) = %d, want %c", key[:43], got, key[43])
+		}
+	}
+}
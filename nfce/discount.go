@@ -0,0 +1,69 @@
+package nfce
+
+import "math"
+
+// AllocateDiscount distribui entre os itens a parte do desconto da nota que não está
+// discriminada neles. totals são os valores brutos dos itens e discounts os descontos já
+// informados por item (pode ser nil). O restante (discount menos a soma dos descontos dos
+// itens) é rateado proporcionalmente ao valor líquido de cada item, em centavos; os centavos
+// que sobram do arredondamento vão primeiro para o item de maior valor. Retorna um novo slice com o desconto de
+// cada item, que nunca passa do valor bruto dele.
+func AllocateDiscount(totals, discounts []float64, discount float64) []float64 {
+	result := make([]float64, len(totals))
+	copy(result, discounts)
+
+	remaining := toCents(discount)
+	for i := range result {
+		result[i] = math.Min(math.Max(result[i], 0), math.Max(totals[i], 0))
+		remaining -= toCents(result[i])
+	}
+	if remaining <= 0 {
+		return result
+	}
+
+	// Base do rateio: o que ainda pode ser descontado de cada item
+	room := make([]int64, len(totals))
+	var base int64
+	largest := -1
+	for i, total := range totals {
+		room[i] = toCents(total) - toCents(result[i])
+		if room[i] <= 0 {
+			room[i] = 0
+			continue
+		}
+		base += room[i]
+		if largest == -1 || room[i] > room[largest] {
+			largest = i
+		}
+	}
+	if base == 0 {
+		return result
+	}
+	if remaining > base {
+		remaining = base
+	}
+
+	allocated := int64(0)
+	shares := make([]int64, len(totals))
+	for i := range totals {
+		shares[i] = remaining * room[i] / base
+		allocated += shares[i]
+	}
+	// Os centavos que sobraram da divisão inteira vão para os itens com folga, começando pelo maior
+	for i := largest; allocated < remaining; i = (i + 1) % len(totals) {
+		if shares[i] < room[i] {
+			shares[i]++
+			allocated++
+		}
+	}
+
+	for i := range result {
+		result[i] = float64(toCents(result[i])+shares[i]) / 100
+	}
+	return result
+}
+
+// toCents converte um valor em reais para centavos, arredondando.
+func toCents(value float64) int64 {
+	return int64(math.Round(value * 100))
+}
@@ -0,0 +1,33 @@
+package nfce
+
+import "testing"
+
+func TestAllocateDiscount(t *testing.T) {
+	tests := []struct {
+		name      string
+		totals    []float64
+		discounts []float64
+		discount  float64
+		want      []float64
+	}{
+		{"proportional", []float64{30, 10}, nil, 4, []float64{3, 1}},
+		{"rounding goes to largest", []float64{10, 10, 10}, nil, 1, []float64{0.34, 0.33, 0.33}},
+		{"item discounts already cover the receipt", []float64{49.80, 20, 14.99}, []float64{2.30, 2.49, 0}, 4.79, []float64{2.30, 2.49, 0}},
+		{"only the remainder is allocated", []float64{20, 10}, []float64{2, 0}, 5, []float64{3.93, 1.07}},
+		{"no discount", []float64{5, 7}, nil, 0, []float64{0, 0}},
+		{"capped at the item value", []float64{1, 0}, nil, 3, []float64{1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AllocateDiscount(tt.totals, tt.discounts, tt.discount)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !almostEqual(got[i], tt.want[i]) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
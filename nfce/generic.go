@@ -0,0 +1,160 @@
+package nfce
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	numberRegex     = regexp.MustCompile(`Número:\s*(\d+)`)
+	emissionRegex   = regexp.MustCompile(`Emissão:\s*(\d{2}/\d{2}/\d{4})`)
+	codeRegex       = regexp.MustCompile(`\(Código:\s*([^\)]+)\)`)
+	quantityRegex   = regexp.MustCompile(`Qtde\.:\s*([0-9,\.]+)\s+UN:\s*([A-Za-z]+)`)
+	itemsCountRegex = regexp.MustCompile(`Qtd\.\s*total\s*de\s*itens:\s*(\d+)`)
+	subtotalRegex   = regexp.MustCompile(`Valor\s*total\s*R\$:\s*([0-9,.]+)`)
+	discountRegex   = regexp.MustCompile(`Descontos\s*R\$:\s*([0-9,.]+)`)
+	totalRegex      = regexp.MustCompile(`Valor\s*a\s*pagar\s*R\$:\s*([0-9,.]+)`)
+	// itemDiscountRegex lê o desconto do item ("Vl. Desc.: 1,50" ou "Desconto: R$ 1,50"),
+	// que alguns estados exibem logo abaixo do valor unitário.
+	itemDiscountRegex = regexp.MustCompile(`(?i)(?:Vl\.?\s*)?Desc(?:onto)?\.?:\s*(?:R\$)?\s*([0-9\.,]+)`)
+)
+
+// genericParser é o parser heurístico usado quando o estado não tem parser próprio.
+// Procura linhas de tabela com "Código:" e lê os totais pelo texto da página.
+type genericParser struct{}
+
+// UF retorna vazio, pois o parser genérico não é específico de nenhum estado.
+func (genericParser) UF() string { return "" }
+
+// Parse extrai os dados da nota usando heurísticas de texto.
+func (genericParser) Parse(doc *goquery.Document) (*Data, error) {
+	data := &Data{
+		Items: []Item{},
+	}
+
+	// Tenta extrair o nome da loja do elemento específico
+	data.StoreName = strings.TrimSpace(doc.Find("#u20.txtTopo").First().Text())
+	if data.StoreName == "" {
+		// Fallback: tenta outros seletores
+		data.StoreName = strings.TrimSpace(doc.Find(".txtCenter .text").First().Text())
+	}
+	if data.StoreName == "" {
+		data.StoreName = strings.TrimSpace(doc.Find("#infos .text").First().Text())
+	}
+
+	data.Issuer = issuerFromHeader(doc.Find(".txtCenter .text"), data.StoreName)
+
+	doc.Find("*").Each(func(i int, s *goquery.Selection) {
+		text := s.Text()
+
+		if strings.Contains(text, "Número:") && data.Number == "" {
+			if matches := numberRegex.FindStringSubmatch(text); len(matches) > 1 {
+				data.Number = matches[1]
+			}
+		}
+
+		if strings.Contains(text, "Emissão:") && data.Date == "" {
+			if matches := emissionRegex.FindStringSubmatch(text); len(matches) > 1 {
+				data.Date = brDateToISO(matches[1])
+			}
+		}
+	})
+
+	data.AccessKey = strings.ReplaceAll(strings.TrimSpace(doc.Find(".chave").Text()), " ", "")
+
+	itemNum := 0
+	doc.Find("table tr").Each(func(i int, s *goquery.Selection) {
+		fullText := s.Text()
+		if !strings.Contains(fullText, "Código:") {
+			return
+		}
+
+		itemNum++
+
+		description := ""
+		if idx := strings.Index(fullText, " (Código:"); idx > 0 {
+			description = strings.TrimSpace(fullText[:idx])
+		}
+
+		code := ""
+		if matches := codeRegex.FindStringSubmatch(fullText); len(matches) > 1 {
+			code = strings.TrimSpace(matches[1])
+		}
+
+		quantity := 0.0
+		unit := "UN"
+		if matches := quantityRegex.FindStringSubmatch(fullText); len(matches) > 2 {
+			quantity = ParseDecimal(matches[1])
+			unit = matches[2]
+		}
+
+		total := 0.0
+		if totalValue := ExtractNumericValue(s.Find("span.valor").First().Text()); totalValue != "" {
+			total = ParseDecimal(totalValue)
+		}
+
+		unitPrice := 0.0
+		if unitPriceValue := ExtractNumericValue(s.Find("span.RvlUnit").First().Text()); unitPriceValue != "" {
+			unitPrice = ParseDecimal(unitPriceValue)
+		} else if total > 0 && quantity > 0 {
+			unitPrice = total / quantity
+		}
+
+		unitPrice = math.Round(unitPrice*100) / 100
+
+		discount := 0.0
+		if matches := itemDiscountRegex.FindStringSubmatch(cleanText(fullText)); len(matches) > 1 {
+			discount = ParseDecimal(matches[1])
+		}
+
+		if description != "" {
+			data.Items = append(data.Items, Item{
+				ItemNumber:  itemNum,
+				Code:        code,
+				GTIN:        NormalizeGTIN(code),
+				Description: description,
+				Quantity:    quantity,
+				Unit:        unit,
+				UnitPrice:   unitPrice,
+				Total:       total,
+				Discount:    discount,
+			})
+		}
+	})
+
+	doc.Find("[id=linhaTotal]").Each(func(i int, s *goquery.Selection) {
+		readPaymentRow(s, data)
+	})
+
+	fullHTML := doc.Text()
+
+	if matches := itemsCountRegex.FindStringSubmatch(fullHTML); len(matches) > 1 {
+		data.ItemsCount, _ = strconv.Atoi(matches[1])
+	}
+
+	if matches := subtotalRegex.FindStringSubmatch(fullHTML); len(matches) > 1 {
+		data.Subtotal = ParseDecimal(matches[1])
+	}
+
+	if matches := discountRegex.FindStringSubmatch(fullHTML); len(matches) > 1 {
+		data.Discount = ParseDecimal(matches[1])
+	}
+
+	if matches := totalRegex.FindStringSubmatch(fullHTML); len(matches) > 1 {
+		data.Total = ParseDecimal(matches[1])
+	}
+
+	// No leiaute padrão os tributos discriminados ficam em #infos; o quadro de totais
+	// traz só o valor total, que confundiria a leitura das esferas
+	taxes := ParseTaxes(cleanText(doc.Find("#infos").Text()))
+	if taxes.Total == 0 {
+		taxes = ParseTaxes(cleanText(fullHTML))
+	}
+	applyTaxes(data, taxes)
+
+	return data, nil
+}
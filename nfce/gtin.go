@@ -0,0 +1,57 @@
+package nfce
+
+import "strings"
+
+// NormalizeGTIN valida um código de barras GTIN (EAN-8, UPC-A, EAN-13 ou GTIN-14) e o
+// retorna no formato canônico de 14 dígitos, completado com zeros à esquerda.
+// Retorna "" quando o código não é um GTIN válido ("SEM GTIN", código interno da loja,
+// dígito verificador errado) ou é de circulação restrita (produtos pesados/etiquetados
+// na própria loja), que não identificam o mesmo produto em outras lojas.
+func NormalizeGTIN(code string) string {
+	code = strings.TrimSpace(code)
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+
+	switch len(code) {
+	case 8:
+		// EAN-8 iniciados em 0 ou 2 são de uso interno
+		if code[0] == '0' || code[0] == '2' {
+			return ""
+		}
+	case 12:
+		// UPC-A com sistema numérico 2 (peso variável) ou 4 (uso interno)
+		if code[0] == '2' || code[0] == '4' {
+			return ""
+		}
+	case 13:
+		// Prefixos GS1 200-299 são de circulação restrita (balança, etiqueta da loja)
+		if code[0] == '2' {
+			return ""
+		}
+	case 14:
+	default:
+		return ""
+	}
+
+	gtin := strings.Repeat("0", 14-len(code)) + code
+	if strings.Trim(gtin, "0") == "" || !validGTINCheckDigit(gtin) {
+		return ""
+	}
+	return gtin
+}
+
+// validGTINCheckDigit confere o dígito verificador (módulo 10, pesos 3 e 1 da direita para a esquerda).
+func validGTINCheckDigit(gtin string) bool {
+	sum := 0
+	for i := len(gtin) - 2; i >= 0; i-- {
+		digit := int(gtin[i] - '0')
+		if (len(gtin)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(gtin[len(gtin)-1]-'0')
+}
@@ -0,0 +1,27 @@
+package nfce
+
+import "testing"
+
+func TestNormalizeGTIN(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"7894900011517", "07894900011517"},   // EAN-13
+		{" 7894900011517 ", "07894900011517"}, // Espaços do portal
+		{"17894900011514", "17894900011514"},  // GTIN-14 (caixa)
+		{"036000291452", "00036000291452"},    // UPC-A
+		{"96385074", "00000096385074"},        // EAN-8
+		{"7894900011518", ""},                 // Dígito verificador errado
+		{"2000123", ""},                       // Código interno
+		{"2012345000015", ""},                 // Produto pesado na loja (prefixo 2)
+		{"SEM GTIN", ""},
+		{"00000000", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeGTIN(tt.code); got != tt.want {
+			t.Errorf("NormalizeGTIN(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
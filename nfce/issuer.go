@@ -0,0 +1,121 @@
+package nfce
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	cnpjRegex    = regexp.MustCompile(`CNPJ:?\s*(\d{2}\.?\d{3}\.?\d{3}/?\d{4}-?\d{2})`)
+	zipCodeRegex = regexp.MustCompile(`\b\d{5}-?\d{3}\b`)
+	ufRegex      = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// Issuer contém os dados do emitente (estabelecimento) da nota.
+type Issuer struct {
+	CNPJ      string // Apenas dígitos
+	LegalName string // Razão social (xNome)
+	TradeName string // Nome fantasia (xFant), só disponível no XML
+	Address   Address
+}
+
+// Address representa o endereço do emitente.
+type Address struct {
+	Street     string
+	Number     string
+	Complement string
+	District   string
+	City       string
+	UF         string
+	ZipCode    string
+}
+
+// ParseAddress interpreta a linha de endereço exibida no cabeçalho das páginas de consulta.
+// O portal padrão usa "logradouro, número, complemento, bairro, município, UF"; o de MG
+// omite o complemento. Campos que não puderem ser identificados ficam vazios.
+func ParseAddress(line string) Address {
+	var address Address
+	if zip := zipCodeRegex.FindString(line); zip != "" {
+		address.ZipCode = strings.ReplaceAll(zip, "-", "")
+		line = strings.Replace(line, zip, "", 1)
+	}
+
+	parts := strings.Split(line, ",")
+	for i := range parts {
+		parts[i] = cleanText(parts[i])
+	}
+	// Remove partes vazias do final (ex: vírgula sobrando após a UF)
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 0 {
+		return address
+	}
+
+	if last := strings.ToUpper(parts[len(parts)-1]); ufRegex.MatchString(last) {
+		address.UF = last
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) > 2 {
+		address.City = parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+
+	address.Street = parts[0]
+	if len(parts) > 1 {
+		address.Number = parts[1]
+	}
+	switch middle := parts[min(2, len(parts)):]; len(middle) {
+	case 0:
+	case 1:
+		address.District = middle[0]
+	default:
+		address.Complement = strings.Join(middle[:len(middle)-1], ", ")
+		address.District = middle[len(middle)-1]
+	}
+	address.Complement = strings.Trim(address.Complement, ", ")
+
+	return address
+}
+
+// parseIssuerLines extrai CNPJ e endereço das linhas de texto do cabeçalho da nota
+// (a linha com "CNPJ: ..." é seguida pela linha de endereço).
+func parseIssuerLines(lines []string) (cnpj string, address Address) {
+	for i, line := range lines {
+		matches := cnpjRegex.FindStringSubmatch(line)
+		if len(matches) < 2 {
+			continue
+		}
+		cnpj = onlyDigits(matches[1])
+		for _, next := range lines[i+1:] {
+			if strings.Contains(next, ",") {
+				address = ParseAddress(next)
+				break
+			}
+		}
+		return cnpj, address
+	}
+	return "", address
+}
+
+// issuerFromHeader lê CNPJ e endereço dos elementos de texto do cabeçalho da nota.
+func issuerFromHeader(sel *goquery.Selection, legalName string) Issuer {
+	var lines []string
+	sel.Each(func(i int, s *goquery.Selection) {
+		lines = append(lines, cleanText(s.Text()))
+	})
+	cnpj, address := parseIssuerLines(lines)
+	return Issuer{CNPJ: cnpj, LegalName: legalName, Address: address}
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
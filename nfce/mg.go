@@ -0,0 +1,149 @@
+package nfce
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	mgQuantityRegex   = regexp.MustCompile(`Qtde total de ítens:\s*([0-9\.,]+)`)
+	mgTotalRegex      = regexp.MustCompile(`Valor total R\$:\s*(?:R\$)?\s*([0-9\.,]+)`)
+	mgUnitRegex       = regexp.MustCompile(`UN:\s*(\S+)`)
+	mgItemsCountRegex = regexp.MustCompile(`Qtde\.?\s*total\s*de\s*ítens:\s*(\d+)\s`)
+	groupedKeyRegex   = regexp.MustCompile(`(?:\d{4}\s+){10}\d{4}`)
+	emissionDateRegex = regexp.MustCompile(`\d{2}/\d{2}/\d{4}`)
+)
+
+// mgParser lê o layout do portal SPED de Minas Gerais (portalsped.fazenda.mg.gov.br),
+// que usa tabelas Bootstrap: cabeçalho com o emitente, #myTable com os itens e
+// uma tabela com Modelo/Série/Número/Data Emissão.
+type mgParser struct{}
+
+// UF retorna a sigla do estado atendido pelo parser.
+func (mgParser) UF() string { return "MG" }
+
+// Parse extrai os dados da nota do layout do portal de Minas Gerais.
+func (mgParser) Parse(doc *goquery.Document) (*Data, error) {
+	data := &Data{
+		Items: []Item{},
+	}
+
+	data.StoreName = cleanText(doc.Find("table thead th h4").First().Text())
+	// Cabeçalho: linhas com CNPJ/Inscrição Estadual e endereço logo abaixo do nome
+	data.Issuer = issuerFromHeader(doc.Find("table").First().Find("tbody td"), data.StoreName)
+
+	rows := doc.Find("#myTable tbody tr")
+	if rows.Length() == 0 {
+		return nil, fmt.Errorf("layout do portal MG não reconhecido: tabela de itens (#myTable) não encontrada")
+	}
+
+	rows.Each(func(i int, s *goquery.Selection) {
+		cells := s.Find("td")
+		if cells.Length() < 4 {
+			return
+		}
+
+		first := cells.Eq(0)
+		description := cleanText(first.Find("h7").Text())
+		if description == "" {
+			return
+		}
+
+		item := Item{
+			ItemNumber:  len(data.Items) + 1,
+			Description: description,
+			Unit:        "UN",
+		}
+		if matches := codeRegex.FindStringSubmatch(cleanText(first.Text())); len(matches) > 1 {
+			item.Code = strings.TrimSpace(matches[1])
+			item.GTIN = NormalizeGTIN(item.Code)
+		}
+		if matches := mgQuantityRegex.FindStringSubmatch(cleanText(cells.Eq(1).Text())); len(matches) > 1 {
+			item.Quantity = ParseDecimal(matches[1])
+		}
+		if matches := mgUnitRegex.FindStringSubmatch(cleanText(cells.Eq(2).Text())); len(matches) > 1 {
+			item.Unit = matches[1]
+		}
+		if matches := mgTotalRegex.FindStringSubmatch(cleanText(cells.Eq(3).Text())); len(matches) > 1 {
+			item.Total = ParseDecimal(matches[1])
+		}
+		// O portal de MG não exibe o valor unitário; calcula a partir do total
+		if item.Total > 0 && item.Quantity > 0 {
+			item.UnitPrice = math.Round(item.Total/item.Quantity*100) / 100
+		}
+
+		data.Items = append(data.Items, item)
+	})
+
+	// Totais ficam fora da tabela de itens, em linhas "rótulo: valor"
+	totals := cleanText(doc.Find("#totais").Text()) + " "
+	if matches := mgItemsCountRegex.FindStringSubmatch(totals); len(matches) > 1 {
+		data.ItemsCount, _ = strconv.Atoi(matches[1])
+	}
+	if matches := subtotalRegex.FindStringSubmatch(totals); len(matches) > 1 {
+		data.Subtotal = ParseDecimal(matches[1])
+	}
+	if matches := discountRegex.FindStringSubmatch(totals); len(matches) > 1 {
+		data.Discount = ParseDecimal(matches[1])
+	}
+	if matches := totalRegex.FindStringSubmatch(totals); len(matches) > 1 {
+		data.Total = ParseDecimal(matches[1])
+	}
+
+	// Pagamentos: tabela com cabeçalho "Forma de Pagamento | Valor Pago" e linha de troco
+	doc.Find("table").Each(func(i int, table *goquery.Selection) {
+		if !strings.Contains(cleanText(table.Find("thead").Text()), "Forma de Pagamento") {
+			return
+		}
+		table.Find("tbody tr").Each(func(j int, row *goquery.Selection) {
+			cells := row.Find("td")
+			label := cleanText(cells.Eq(0).Text())
+			value := ExtractNumericValue(cells.Eq(1).Text())
+			if label == "" || value == "" {
+				return
+			}
+			if strings.HasPrefix(strings.ToLower(label), "troco") {
+				data.Change = ParseDecimal(value)
+			} else {
+				data.Payments = append(data.Payments, NewPayment(label, ParseDecimal(value)))
+			}
+		})
+	})
+
+	// Dados gerais: cabeçalhos Modelo | Série | Número | Data Emissão
+	doc.Find("table").EachWithBreak(func(i int, table *goquery.Selection) bool {
+		headers := table.Find("thead th")
+		values := table.Find("tbody tr").First().Find("td")
+		found := false
+		headers.Each(func(j int, th *goquery.Selection) {
+			value := cleanText(values.Eq(j).Text())
+			switch cleanText(th.Text()) {
+			case "Número":
+				data.Number = value
+				found = true
+			case "Data Emissão":
+				if date := emissionDateRegex.FindString(value); date != "" {
+					data.Date = brDateToISO(date)
+				}
+			}
+		})
+		return !found
+	})
+
+	applyTaxes(data, ParseTaxes(cleanText(doc.Text())))
+
+	if key := groupedKeyRegex.FindString(doc.Text()); key != "" {
+		data.AccessKey = strings.Join(strings.Fields(key), "")
+	}
+
+	if data.Total == 0 {
+		data.Total = data.Subtotal - data.Discount
+	}
+
+	return data, nil
+}
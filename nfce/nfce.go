@@ -0,0 +1,82 @@
+// Package nfce contém a extração de dados de NFC-e (Nota Fiscal de Consumidor Eletrônica)
+// a partir das páginas de consulta dos portais SEFAZ de cada estado.
+package nfce
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Data representa os dados extraídos de uma NFC-e através de scraping.
+type Data struct {
+	StoreName  string
+	Date       string
+	Items      []Item
+	Subtotal   float64
+	Discount   float64
+	Freight    float64 // Frete (vFrete), só em NF-e lidas do XML
+	Other      float64 // Outras despesas acessórias (vOutro), só em NF-e lidas do XML
+	Total      float64
+	ItemsCount int
+	AccessKey  string
+	Number     string
+	Issuer     Issuer    // CNPJ e endereço do emitente, quando exibidos no cabeçalho
+	Payments   []Payment // Formas de pagamento e valores pagos
+	Change     float64   // Troco
+	Taxes      Taxes     // Tributos aproximados (Lei 12.741/2012)
+}
+
+// Item representa um único item dentro de uma NFC-e.
+type Item struct {
+	ItemNumber  int
+	Code        string // Código do produto na loja (cProd)
+	GTIN        string // Código de barras (GTIN-14), quando o código exibido é um GTIN válido
+	Description string
+	Quantity    float64
+	Unit        string
+	UnitPrice   float64
+	Total       float64 // Valor bruto (quantidade x preço unitário)
+	Discount    float64 // Desconto do item (vDesc), quando a nota discrimina
+}
+
+var numericValueRegex = regexp.MustCompile(`\d[\d\.,]*`)
+
+// ParseDecimal converte um número no formato brasileiro (1.683,25) para float64.
+// Valores sem vírgula (ex: 2.0000, usado em quantidades por alguns portais) são lidos com ponto decimal.
+func ParseDecimal(s string) float64 {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// ExtractNumericValue extrai o primeiro número de um texto como "Vl. Unit.: R$ 5,99".
+func ExtractNumericValue(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+
+	raw = strings.ReplaceAll(raw, "\u00a0", " ")
+	raw = strings.ReplaceAll(raw, "R$", "")
+	return strings.TrimSpace(numericValueRegex.FindString(raw))
+}
+
+// cleanText remove espaços extras e non-breaking spaces de um texto extraído do HTML.
+func cleanText(s string) string {
+	s = strings.ReplaceAll(s, "\u00a0", " ")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// brDateToISO converte uma data dd/mm/aaaa para YYYY-MM-DD.
+func brDateToISO(date string) string {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[2] + "-" + parts[1] + "-" + parts[0]
+}
@@ -0,0 +1,125 @@
+package nfce
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Parser extrai os dados de uma NFC-e a partir do HTML da página de consulta de um portal SEFAZ.
+// Cada estado (UF) publica a consulta com um layout próprio, então cada portal tem seu parser.
+type Parser interface {
+	// UF retorna a sigla do estado atendido pelo parser (ex: "SP"). O parser genérico retorna "".
+	UF() string
+	// Parse lê o documento HTML e retorna os dados da nota.
+	Parse(doc *goquery.Document) (*Data, error)
+}
+
+// registration associa um parser aos hosts do portal do estado.
+type registration struct {
+	parser Parser
+	hosts  []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]registration{}
+	// fallback é usado quando não há parser registrado para o estado/host da URL.
+	fallback Parser = genericParser{}
+)
+
+// ufByCode mapeia o código IBGE do estado (2 primeiros dígitos da chave de acesso) para a sigla.
+var ufByCode = map[string]string{
+	"11": "RO", "12": "AC", "13": "AM", "14": "RR", "15": "PA", "16": "AP", "17": "TO",
+	"21": "MA", "22": "PI", "23": "CE", "24": "RN", "25": "PB", "26": "PE", "27": "AL", "28": "SE", "29": "BA",
+	"31": "MG", "32": "ES", "33": "RJ", "35": "SP",
+	"41": "PR", "42": "SC", "43": "RS",
+	"50": "MS", "51": "MT", "52": "GO", "53": "DF",
+}
+
+var accessKeyRegex = regexp.MustCompile(`\d{44}`)
+
+// Register associa um parser a um estado e aos hosts do seu portal de consulta.
+// Hosts são comparados por sufixo, então "fazenda.sp.gov.br" cobre "www.nfce.fazenda.sp.gov.br".
+func Register(p Parser, hosts ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToUpper(p.UF())] = registration{parser: p, hosts: hosts}
+}
+
+// ParserForUF retorna o parser registrado para a UF (sigla) ou o parser genérico.
+func ParserForUF(uf string) Parser {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if reg, ok := registry[strings.ToUpper(uf)]; ok {
+		return reg.parser
+	}
+	return fallback
+}
+
+// ParserFor escolhe o parser a partir da URL do QR Code.
+// Primeiro usa a UF codificada na chave de acesso; se não houver chave, usa o host do portal.
+func ParserFor(rawURL string) Parser {
+	if key := AccessKeyFromURL(rawURL); key != "" {
+		if uf, ok := ufByCode[key[:2]]; ok {
+			if p := ParserForUF(uf); p != fallback {
+				return p
+			}
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fallback
+	}
+	host := strings.ToLower(u.Hostname())
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, reg := range registry {
+		for _, h := range reg.hosts {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return reg.parser
+			}
+		}
+	}
+	return fallback
+}
+
+// AccessKeyFromURL extrai a chave de acesso (44 dígitos) da URL do QR Code.
+// O QR Code traz a chave no parâmetro "p" (chave|versão|ambiente|...) ou em "chNFe".
+func AccessKeyFromURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		query := u.Query()
+		for _, param := range []string{"p", "chNFe", "chave"} {
+			if value := query.Get(param); value != "" {
+				candidate := strings.SplitN(value, "|", 2)[0]
+				if key := accessKeyRegex.FindString(candidate); key != "" {
+					return key
+				}
+			}
+		}
+	}
+	return accessKeyRegex.FindString(rawURL)
+}
+
+// unavailablePageRegex reconhece as páginas de manutenção e de erro temporário dos portais.
+var unavailablePageRegex = regexp.MustCompile(`(?i)(em manuten[çc][ãa]o|servi[çc]o (temporariamente )?indispon[íi]vel|sistema indispon[íi]vel|tente novamente (mais tarde|em alguns minutos)|service unavailable)`)
+
+// IsUnavailablePage indica se o documento é uma página de manutenção ou de indisponibilidade
+// temporária do portal (e não uma nota). Nesses casos vale consultar a nota de novo mais tarde.
+func IsUnavailablePage(doc *goquery.Document) bool {
+	return unavailablePageRegex.MatchString(cleanText(doc.Text()))
+}
+
+// notYetAvailablePageRegex reconhece as respostas de nota inexistente ou ainda não autorizada.
+var notYetAvailablePageRegex = regexp.MustCompile(`(?i)(n[ãa]o (foi )?(encontrad[ao]|localizad[ao])|inexistente na base|ainda n[ãa]o (foi )?(autorizad[ao]|processad[ao]|transmitid[ao])|em processamento|aguardando (autoriza[çc][ãa]o|processamento))`)
+
+// IsNotYetAvailablePage indica se o portal respondeu que a nota não foi encontrada ou ainda está
+// em processamento. Acontece com notas emitidas em contingência, que chegam à SEFAZ mais tarde.
+func IsNotYetAvailablePage(doc *goquery.Document) bool {
+	return notYetAvailablePageRegex.MatchString(cleanText(doc.Text()))
+}
@@ -0,0 +1,302 @@
+package nfce
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func loadFixture(t *testing.T, name string) *goquery.Document {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("open fixture %s: %v", name, err)
+	}
+	defer f.Close()
+
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatalf("parse fixture %s: %v", name, err)
+	}
+	return doc
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
+
+// Só os estados com parser próprio têm página em testdata; os demais usam o parser genérico,
+// testado com o leiaute padrão do Portal da NFC-e em TestGenericParserReadsStandardLayout.
+func TestStateParsersAgainstFixtures(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		url       string
+		uf        string
+		storeName string
+		date      string
+		number    string
+		accessKey string
+		subtotal  float64
+		discount  float64
+		total     float64
+		items     []Item
+		payments  []Payment
+		change    float64
+	}{
+		{"mg.html", "https://portalsped.fazenda.mg.gov.br/portalnfce/sistema/qrcode.xhtml?p=31240389012345000167650020006543211876543210|2|1|1|ABC", "MG", "PADARIA E MERCEARIA MINEIRA LTDA", "2024-03-20", "654321", "31240389012345000167650020006543211876543210", 56.04, 1.04, 55.00, []Item{
+			{ItemNumber: 1, Code: "1234", Description: "PAO FRANCES KG", Quantity: 0.45, Unit: "KG", UnitPrice: 16.00, Total: 7.20},
+			{ItemNumber: 2, Code: "7891000100103", GTIN: "07891000100103", Description: "LEITE INTEGRAL 1L", Quantity: 6, Unit: "UN", UnitPrice: 4.99, Total: 29.94},
+			{ItemNumber: 3, Code: "7896005800010", GTIN: "07896005800010", Description: "CAFE TORRADO 500G", Quantity: 1, Unit: "UN", UnitPrice: 18.90, Total: 18.90},
+		}, []Payment{{Code: "01", Method: "Dinheiro", Amount: 60.00}}, 5.00},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uf, func(t *testing.T) {
+			parser := ParserFor(tt.url)
+			if parser.UF() != tt.uf {
+				t.Fatalf("ParserFor(%s) picked UF %q, want %q", tt.url, parser.UF(), tt.uf)
+			}
+
+			data, err := parser.Parse(loadFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if data.StoreName != tt.storeName {
+				t.Errorf("StoreName = %q, want %q", data.StoreName, tt.storeName)
+			}
+			if data.Date != tt.date {
+				t.Errorf("Date = %q, want %q", data.Date, tt.date)
+			}
+			if data.Number != tt.number {
+				t.Errorf("Number = %q, want %q", data.Number, tt.number)
+			}
+			if data.AccessKey != tt.accessKey {
+				t.Errorf("AccessKey = %q, want %q", data.AccessKey, tt.accessKey)
+			}
+			if data.Issuer.CNPJ != tt.accessKey[6:20] || data.Issuer.Address.UF != tt.uf || data.Issuer.Address.Street == "" {
+				t.Errorf("Issuer = %+v, want CNPJ %s and address in %s", data.Issuer, tt.accessKey[6:20], tt.uf)
+			}
+			if data.ItemsCount != len(tt.items) {
+				t.Errorf("ItemsCount = %d, want %d", data.ItemsCount, len(tt.items))
+			}
+			if !almostEqual(data.Subtotal, tt.subtotal) || !almostEqual(data.Discount, tt.discount) || !almostEqual(data.Total, tt.total) {
+				t.Errorf("totals = %.2f/%.2f/%.2f, want %.2f/%.2f/%.2f", data.Subtotal, data.Discount, data.Total, tt.subtotal, tt.discount, tt.total)
+			}
+			if len(data.Payments) != len(tt.payments) || !almostEqual(data.Change, tt.change) {
+				t.Errorf("payments = %+v, change = %.2f, want %+v, change = %.2f", data.Payments, data.Change, tt.payments, tt.change)
+			} else {
+				for i, want := range tt.payments {
+					if got := data.Payments[i]; got.Code != want.Code || got.Method != want.Method || !almostEqual(got.Amount, want.Amount) {
+						t.Errorf("payment %d = %+v, want %+v", i, got, want)
+					}
+				}
+			}
+
+			if len(data.Items) != len(tt.items) {
+				t.Fatalf("got %d items, want %d: %+v", len(data.Items), len(tt.items), data.Items)
+			}
+			for i, want := range tt.items {
+				got := data.Items[i]
+				if got.ItemNumber != want.ItemNumber || got.Code != want.Code || got.GTIN != want.GTIN || got.Description != want.Description || got.Unit != want.Unit {
+					t.Errorf("item %d = %+v, want %+v", i, got, want)
+				}
+				if !almostEqual(got.Quantity, want.Quantity) || !almostEqual(got.UnitPrice, want.UnitPrice) || !almostEqual(got.Total, want.Total) {
+					t.Errorf("item %d values = %.3f x %.2f = %.2f, want %.3f x %.2f = %.2f", i, got.Quantity, got.UnitPrice, got.Total, want.Quantity, want.UnitPrice, want.Total)
+				}
+			}
+		})
+	}
+}
+
+func TestParserForFallsBackToHostAndGeneric(t *testing.T) {
+	// Sem chave na URL: usa o host do portal
+	if uf := ParserFor("https://portalsped.fazenda.mg.gov.br/portalnfce/sistema/qrcode.xhtml").UF(); uf != "MG" {
+		t.Errorf("host lookup picked %q, want MG", uf)
+	}
+	// UF sem parser próprio (PE = 26 e SP = 35): parser genérico
+	for _, url := range []string{
+		"https://nfce.sefaz.pe.gov.br/nfce/consulta?p=26240312345678000190650010001234561123456783|2|1|1|ABC",
+		"https://www.nfce.fazenda.sp.gov.br/NFCeConsultaPublica/Paginas/ConsultaQRCode.aspx?p=35240312345678000190650010001234561123456783|2|1|1|ABC",
+	} {
+		if uf := ParserFor(url).UF(); uf != "" {
+			t.Errorf("ParserFor(%s) picked %q, want generic parser", url, uf)
+		}
+	}
+}
+
+// standard.html é uma página sintética no leiaute padrão do Portal da NFC-e (ver testdata/README.md).
+func TestGenericParserReadsStandardLayout(t *testing.T) {
+	data, err := genericParser{}.Parse(loadFixture(t, "standard.html"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if data.StoreName != "SUPERMERCADO PAULISTA LTDA" || data.Number != "123456" || data.Date != "2024-03-15" {
+		t.Errorf("header = %q, number %q, date %q", data.StoreName, data.Number, data.Date)
+	}
+	if data.AccessKey != "35240312345678000190650010001234561123456783" {
+		t.Errorf("AccessKey = %q", data.AccessKey)
+	}
+	if len(data.Items) != 3 || data.Items[0].Code != "7896006752318" || data.Items[0].GTIN != "07896006752318" {
+		t.Fatalf("unexpected items: %+v", data.Items)
+	}
+	if item := data.Items[1]; item.Unit != "KG" || !almostEqual(item.Quantity, 1.235) || !almostEqual(item.UnitPrice, 6.49) || !almostEqual(item.Total, 8.02) {
+		t.Errorf("weighed item = %+v", item)
+	}
+	if !almostEqual(data.Subtotal, 84.79) || !almostEqual(data.Discount, 4.79) || !almostEqual(data.Total, 80.00) {
+		t.Errorf("totals = %.2f/%.2f/%.2f, want 84.79/4.79/80.00", data.Subtotal, data.Discount, data.Total)
+	}
+	if len(data.Payments) != 1 || data.Payments[0].Code != "03" {
+		t.Errorf("Payments = %+v, want one credit card payment", data.Payments)
+	}
+}
+
+func TestAccessKeyFromURL(t *testing.T) {
+	tests := map[string]string{
+		"https://www.nfce.fazenda.sp.gov.br/qrcode?p=35240312345678000190650010001234561123456783|2|1|1|ABC": "35240312345678000190650010001234561123456783",
+		"https://example.com/consulta?chNFe=35240312345678000190650010001234561123456783&nVersao=100":        "35240312345678000190650010001234561123456783",
+		"https://example.com/consulta": "",
+	}
+	for url, want := range tests {
+		if got := AccessKeyFromURL(url); got != want {
+			t.Errorf("AccessKeyFromURL(%s) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := map[string]Address{
+		"RUA DAS FLORES, 100, , CENTRO, CIDADE, SP":          {Street: "RUA DAS FLORES", Number: "100", District: "CENTRO", City: "CIDADE", UF: "SP"},
+		"AV AFONSO PENA, 1000, CENTRO, BELO HORIZONTE, MG":   {Street: "AV AFONSO PENA", Number: "1000", District: "CENTRO", City: "BELO HORIZONTE", UF: "MG"},
+		"R. XV, 50, LOJA 2, CENTRO, CURITIBA, PR, 80020-310": {Street: "R. XV", Number: "50", Complement: "LOJA 2", District: "CENTRO", City: "CURITIBA", UF: "PR", ZipCode: "80020310"},
+		"RODOVIA BR 101 KM 5":                                {Street: "RODOVIA BR 101 KM 5"},
+	}
+	for line, want := range tests {
+		if got := ParseAddress(line); got != want {
+			t.Errorf("ParseAddress(%q) = %+v, want %+v", line, got, want)
+		}
+	}
+}
+
+func TestPaymentCode(t *testing.T) {
+	tests := map[string]string{
+		"Cartão de Crédito":           "03",
+		"CARTAO DE DEBITO":            "04",
+		"Pagamento Instantâneo (PIX)": "17",
+		"Vale Alimentação":            "10",
+		"Dinheiro":                    "01",
+		"05 - Crédito Loja":           "05",
+		"17 - Pagamento Instantâneo":  "17",
+		"Carteira digital":            "99",
+	}
+	for label, want := range tests {
+		if got := PaymentCode(label); got != want {
+			t.Errorf("PaymentCode(%q) = %q, want %q", label, got, want)
+		}
+	}
+
+	if p := NewPayment("Carteira digital", 10); p.Method != "Carteira digital" || p.Code != "99" {
+		t.Errorf("NewPayment kept %+v, want original description for unknown methods", p)
+	}
+	long := strings.Repeat("Vale-compra ", 10)
+	if p := NewPayment(long, 10); len([]rune(p.Method)) > MaxPaymentMethodLength || !strings.HasPrefix(long, p.Method) {
+		t.Errorf("NewPayment kept %q (%d runes), want it cut to %d", p.Method, len([]rune(p.Method)), MaxPaymentMethodLength)
+	}
+}
+
+func TestGenericParserReadsTaxes(t *testing.T) {
+	data, err := genericParser{}.Parse(loadFixture(t, "standard.html"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := Taxes{Total: 18.70, Federal: 6.10, State: 12.60}
+	if !almostEqual(data.Taxes.Total, want.Total) || !almostEqual(data.Taxes.Federal, want.Federal) || !almostEqual(data.Taxes.State, want.State) || data.Taxes.Municipal != 0 {
+		t.Errorf("Taxes = %+v, want %+v", data.Taxes, want)
+	}
+}
+
+func TestGenericParserReadsItemDiscount(t *testing.T) {
+	page := `<table id="tabResult">
+		<tr><td><span class="txtTit">ARROZ 5KG</span> <span class="RCod">(Código: 123 )</span>
+			<span class="Rqtd">Qtde.:2</span><span class="RUN">UN: UN</span>
+			<span class="RvlUnit">Vl. Unit.: 24,90</span><span class="RvlDesc">Vl. Desc.: 2,30</span></td>
+			<td><span class="valor">49,80</span></td></tr>
+		<tr><td><span class="txtTit">FEIJAO</span> <span class="RCod">(Código: 456 )</span>
+			<span class="Rqtd">Qtde.:1</span><span class="RUN">UN: UN</span></td>
+			<td><span class="valor">8,99</span></td></tr>
+	</table>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := genericParser{}.Parse(doc)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(data.Items) != 2 || !almostEqual(data.Items[0].Discount, 2.30) || data.Items[1].Discount != 0 {
+		t.Errorf("Items = %+v, want discount 2.30 only on the first item", data.Items)
+	}
+}
+
+func TestParseTaxes(t *testing.T) {
+	tests := map[string]Taxes{
+		"Trib aprox R$ 6,10 Federal, 12,60 Estadual e 1,05 Municipal. Fonte: IBPT":                {Total: 19.75, Federal: 6.10, State: 12.60, Municipal: 1.05},
+		"Trib aprox R$:6,10 (7,19%) Fed 12,60 (14,86%) Est":                                       {Total: 18.70, Federal: 6.10, State: 12.60},
+		"Tributos Totais Incidentes (Lei Federal 12.741/2012): Federal R$ 6,10 Estadual R$ 12,60": {Total: 18.70, Federal: 6.10, State: 12.60},
+		"Valor aproximado dos tributos deste cupom R$ 18,70 (Conforme Lei Fed. 12.741/2012)":      {Total: 18.70},
+		"Pedido 123 - Volte sempre!": {},
+	}
+	for text, want := range tests {
+		got := ParseTaxes(text)
+		if !almostEqual(got.Total, want.Total) || !almostEqual(got.Federal, want.Federal) || !almostEqual(got.State, want.State) || !almostEqual(got.Municipal, want.Municipal) {
+			t.Errorf("ParseTaxes(%q) = %+v, want %+v", text, got, want)
+		}
+	}
+}
+
+func TestIsUnavailablePage(t *testing.T) {
+	if IsUnavailablePage(loadFixture(t, "standard.html")) {
+		t.Error("NFC-e page detected as unavailable")
+	}
+
+	pages := []string{
+		`<html><body><h1>Sistema em manutenção</h1><p>Tente novamente mais tarde.</p></body></html>`,
+		`<html><body><h2>Serviço temporariamente indisponível</h2></body></html>`,
+		`<html><body>503 Service Unavailable</body></html>`,
+	}
+	for _, page := range pages {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsUnavailablePage(doc) {
+			t.Errorf("IsUnavailablePage(%q) = false, want true", page)
+		}
+	}
+}
+
+func TestIsNotYetAvailablePage(t *testing.T) {
+	if IsNotYetAvailablePage(loadFixture(t, "standard.html")) {
+		t.Error("NFC-e page detected as not yet available")
+	}
+
+	pages := []string{
+		`<html><body><span class="erro">NFC-e não encontrada na base de dados da SEFAZ.</span></body></html>`,
+		`<html><body><p>Nota Fiscal em processamento. Consulte novamente mais tarde.</p></body></html>`,
+		`<html><body><p>A chave de acesso informada não foi localizada.</p></body></html>`,
+		`<html><body><p>Documento ainda não autorizado</p></body></html>`,
+	}
+	for _, page := range pages {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsNotYetAvailablePage(doc) {
+			t.Errorf("IsNotYetAvailablePage(%q) = false, want true", page)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package nfce
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Payment representa uma forma de pagamento (pag/detPag no XML, "Forma de pagamento" na consulta).
+type Payment struct {
+	Code   string // tPag
+	Method string // Descrição da forma de pagamento
+	Amount float64
+}
+
+// paymentMethods mapeia os códigos tPag do leiaute da NF-e.
+var paymentMethods = map[string]string{
+	"01": "Dinheiro",
+	"02": "Cheque",
+	"03": "Cartão de Crédito",
+	"04": "Cartão de Débito",
+	"05": "Crédito Loja",
+	"10": "Vale Alimentação",
+	"11": "Vale Refeição",
+	"12": "Vale Presente",
+	"13": "Vale Combustível",
+	"15": "Boleto Bancário",
+	"16": "Depósito Bancário",
+	"17": "PIX",
+	"18": "Transferência bancária",
+	"19": "Programa de fidelidade",
+	"90": "Sem pagamento",
+	"99": "Outros",
+}
+
+// paymentKeywords associa trechos das descrições exibidas pelos portais ao código tPag.
+// A ordem importa: "crédito loja" precisa ser testado antes de "crédito".
+var paymentKeywords = []struct {
+	keyword string
+	code    string
+}{
+	{"pix", "17"},
+	{"instantaneo", "17"},
+	{"credito loja", "05"},
+	{"crediario", "05"},
+	{"credito", "03"},
+	{"debito", "04"},
+	{"dinheiro", "01"},
+	{"especie", "01"},
+	{"cheque", "02"},
+	{"alimentacao", "10"},
+	{"refeicao", "11"},
+	{"presente", "12"},
+	{"combustivel", "13"},
+	{"boleto", "15"},
+	{"deposito", "16"},
+	{"transferencia", "18"},
+	{"fidelidade", "19"},
+	{"cashback", "19"},
+	{"sem pagamento", "90"},
+}
+
+var (
+	paymentCodePrefixRegex = regexp.MustCompile(`^(\d{2})\s*-\s*`)
+	accentReplacer         = strings.NewReplacer(
+		"á", "a", "à", "a", "â", "a", "ã", "a",
+		"é", "e", "ê", "e", "í", "i",
+		"ó", "o", "ô", "o", "õ", "o", "ú", "u", "ç", "c",
+	)
+)
+
+// PaymentMethodName retorna a descrição de um código tPag.
+func PaymentMethodName(code string) string {
+	if name, ok := paymentMethods[code]; ok {
+		return name
+	}
+	return "Outros"
+}
+
+// PaymentCode identifica o código tPag a partir da descrição exibida na consulta
+// (ex: "Cartão de Crédito", "03 - Cartão de Crédito", "Pagamento Instantâneo (PIX)").
+// Descrições desconhecidas retornam "99" (Outros).
+func PaymentCode(label string) string {
+	label = strings.ToLower(cleanText(label))
+	if matches := paymentCodePrefixRegex.FindStringSubmatch(label); len(matches) > 1 {
+		if _, ok := paymentMethods[matches[1]]; ok {
+			return matches[1]
+		}
+	}
+
+	label = accentReplacer.Replace(label)
+	for _, k := range paymentKeywords {
+		if strings.Contains(label, k.keyword) {
+			return k.code
+		}
+	}
+	return "99"
+}
+
+// MaxPaymentMethodLength é o tamanho da coluna method de receipt_payments, em caracteres.
+const MaxPaymentMethodLength = 60
+
+// NewPayment monta um pagamento a partir da descrição exibida na nota.
+// Formas não reconhecidas mantêm a descrição original, cortada em MaxPaymentMethodLength caracteres.
+func NewPayment(label string, amount float64) Payment {
+	code := PaymentCode(label)
+	method := PaymentMethodName(code)
+	if code == "99" {
+		if original := strings.TrimSpace(paymentCodePrefixRegex.ReplaceAllString(cleanText(label), "")); original != "" {
+			method = original
+		}
+	}
+	if runes := []rune(method); len(runes) > MaxPaymentMethodLength {
+		method = strings.TrimSpace(string(runes[:MaxPaymentMethodLength]))
+	}
+	return Payment{Code: code, Method: method, Amount: amount}
+}
+
+// readPaymentRow lê uma linha do quadro "Forma de pagamento" do portal padrão
+// (label.tx com a forma e span.totalNumb com o valor). Retorna false se a linha não é de pagamento.
+func readPaymentRow(s *goquery.Selection, data *Data) bool {
+	label := cleanText(s.Find("label.tx").First().Text())
+	if label == "" {
+		return false
+	}
+	value := ExtractNumericValue(s.Find("span.totalNumb").First().Text())
+	if value == "" {
+		return true
+	}
+
+	if strings.HasPrefix(strings.ToLower(label), "troco") {
+		data.Change = ParseDecimal(value)
+	} else {
+		data.Payments = append(data.Payments, NewPayment(label, ParseDecimal(value)))
+	}
+	return true
+}
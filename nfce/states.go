@@ -0,0 +1,10 @@
+package nfce
+
+// Parsers de estados com layout próprio, conferidos com páginas reais do portal. Os demais estados
+// usam o parser genérico. Para adicionar um estado, registre aqui o parser e os domínios do portal
+// de consulta, junto com uma página capturada do portal em testdata; o handler escolhe o parser
+// automaticamente.
+func init() {
+	// Minas Gerais tem layout próprio (portal SPED)
+	Register(mgParser{}, "fazenda.mg.gov.br")
+}
@@ -0,0 +1,110 @@
+package nfce
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Taxes contém o valor aproximado dos tributos informado na nota (Lei 12.741/2012).
+// Nem todas as notas discriminam as esferas; nesse caso apenas Total é preenchido.
+type Taxes struct {
+	Total     float64
+	Federal   float64
+	State     float64
+	Municipal float64
+}
+
+var (
+	taxMarkerRegex = regexp.MustCompile(`(?i)(trib\w*\.?\s+aprox|tributos\s+totais|valor\s+aproximado\s+dos\s+tributos)`)
+	taxLawRegex    = regexp.MustCompile(`(?i)lei\s+(?:fed(?:eral|\.)?\s*)?(?:n[º°o.]?\s*)?12\.?741(?:/2012|/12)?`)
+	taxAmountRegex = regexp.MustCompile(`\d[\d.]*,\d{2}(\s*%)?`)
+	taxLevelRegex  = regexp.MustCompile(`(?i)\b(federa(?:l|is)|fed|estadua(?:l|is)|est|municipa(?:l|is)|mun)\b`)
+)
+
+// ParseTaxes lê os tributos aproximados de um texto livre da nota, como
+// "Trib aprox R$ 6,10 Federal, 12,60 Estadual e 0,00 Municipal. Fonte: IBPT" ou
+// "Tributos Totais Incidentes (Lei Federal 12.741/2012): Federal R$ 6,10 Estadual R$ 12,60".
+// Retorna valores zerados quando o texto não traz a informação.
+func ParseTaxes(text string) Taxes {
+	var taxes Taxes
+	loc := taxMarkerRegex.FindStringIndex(text)
+	if loc == nil {
+		return taxes
+	}
+	segment := text[loc[1]:]
+	if len(segment) > 250 {
+		segment = segment[:250]
+	}
+	// "Lei Federal 12.741" não é o valor federal
+	segment = taxLawRegex.ReplaceAllString(segment, " ")
+
+	type amount struct {
+		pos   int
+		value float64
+	}
+	var amounts []amount
+	for _, m := range taxAmountRegex.FindAllStringSubmatchIndex(segment, -1) {
+		if m[2] >= 0 {
+			continue // percentual da carga, não valor
+		}
+		amounts = append(amounts, amount{pos: m[0], value: ParseDecimal(segment[m[0]:m[1]])})
+	}
+	if len(amounts) == 0 {
+		return taxes
+	}
+
+	levels := taxLevelRegex.FindAllStringIndex(segment, -1)
+	if len(levels) == 0 {
+		taxes.Total = amounts[0].value
+		return taxes
+	}
+
+	// O valor pode vir antes ("6,10 Federal") ou depois ("Federal R$ 6,10") da esfera;
+	// a ordem do primeiro par decide a leitura do restante.
+	labelFirst := levels[0][0] < amounts[0].pos
+	for _, level := range levels {
+		value, found := 0.0, false
+		if labelFirst {
+			for _, a := range amounts {
+				if a.pos > level[1] {
+					value, found = a.value, true
+					break
+				}
+			}
+		} else {
+			for i := len(amounts) - 1; i >= 0; i-- {
+				if amounts[i].pos < level[0] {
+					value, found = amounts[i].value, true
+					break
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+
+		switch strings.ToLower(segment[level[0] : level[0]+3]) {
+		case "fed":
+			taxes.Federal = value
+		case "est":
+			taxes.State = value
+		case "mun":
+			taxes.Municipal = value
+		}
+	}
+
+	taxes.Total = math.Round((taxes.Federal+taxes.State+taxes.Municipal)*100) / 100
+	return taxes
+}
+
+// applyTaxes completa os tributos da nota com a discriminação lida do texto.
+// O total exibido no quadro de totais, quando existe, prevalece sobre a soma das esferas.
+func applyTaxes(data *Data, parsed Taxes) {
+	data.Taxes.Federal = parsed.Federal
+	data.Taxes.State = parsed.State
+	data.Taxes.Municipal = parsed.Municipal
+	if data.Taxes.Total == 0 {
+		data.Taxes.Total = parsed.Total
+	}
+}
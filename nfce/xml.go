@@ -0,0 +1,304 @@
+package nfce
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Invoice representa uma NF-e/NFC-e lida do XML oficial autorizado (nfeProc/procNFe).
+type Invoice struct {
+	AccessKey string
+	Model     string // 55 = NF-e, 65 = NFC-e
+	Series    string
+	Number    string
+	IssuedAt  string // Data/hora de emissão (dhEmi, ISO 8601)
+	Emitter   Issuer
+	Items     []InvoiceItem
+	Totals    InvoiceTotals
+	Payments  []Payment
+	Change    float64   // Troco (vTroco)
+	Notes     string    // Informações complementares (infAdic/infCpl)
+	Protocol  *Protocol // nil quando o XML não traz o protocolo de autorização
+}
+
+// InvoiceItem representa uma linha det/prod do XML.
+type InvoiceItem struct {
+	ItemNumber  int
+	Code        string  // cProd
+	EAN         string  // cEAN ("SEM GTIN" é normalizado para vazio)
+	Description string  // xProd
+	NCM         string  // NCM
+	Quantity    float64 // qCom
+	Unit        string  // uCom
+	UnitPrice   float64 // vUnCom
+	Total       float64 // vProd (bruto, antes do desconto)
+	Discount    float64 // vDesc
+}
+
+// InvoiceTotals contém os totais do grupo ICMSTot.
+type InvoiceTotals struct {
+	Products   float64 // vProd
+	Discount   float64 // vDesc
+	Freight    float64 // vFrete
+	Other      float64 // vOutro
+	Total      float64 // vNF
+	TotalTaxes float64 // vTotTrib (Lei 12.741)
+}
+
+// Protocol contém o protocolo de autorização da SEFAZ (protNFe/infProt).
+type Protocol struct {
+	Number     string // nProt
+	Status     string // cStat
+	Reason     string // xMotivo
+	ReceivedAt string // dhRecbto
+}
+
+// Authorized indica se o protocolo é de uso autorizado (cStat 100 ou 150, fora do prazo).
+func (p *Protocol) Authorized() bool {
+	return p != nil && (p.Status == "100" || p.Status == "150")
+}
+
+// Estruturas do leiaute XML. As tags usam apenas o nome local, então o
+// namespace http://www.portalfiscal.inf.br/nfe é aceito sem configuração extra.
+type xmlNFe struct {
+	InfNFe struct {
+		ID  string `xml:"Id,attr"`
+		Ide struct {
+			Model  string `xml:"mod"`
+			Series string `xml:"serie"`
+			Number string `xml:"nNF"`
+			DhEmi  string `xml:"dhEmi"`
+			DEmi   string `xml:"dEmi"` // Leiaute 2.00/3.10
+		} `xml:"ide"`
+		Emit struct {
+			CNPJ      string `xml:"CNPJ"`
+			Name      string `xml:"xNome"`
+			TradeName string `xml:"xFant"`
+			Address   struct {
+				Street     string `xml:"xLgr"`
+				Number     string `xml:"nro"`
+				Complement string `xml:"xCpl"`
+				District   string `xml:"xBairro"`
+				City       string `xml:"xMun"`
+				UF         string `xml:"UF"`
+				ZipCode    string `xml:"CEP"`
+			} `xml:"enderEmit"`
+		} `xml:"emit"`
+		Det []struct {
+			NItem int `xml:"nItem,attr"`
+			Prod  struct {
+				Code        string  `xml:"cProd"`
+				EAN         string  `xml:"cEAN"`
+				Description string  `xml:"xProd"`
+				NCM         string  `xml:"NCM"`
+				Unit        string  `xml:"uCom"`
+				Quantity    float64 `xml:"qCom"`
+				UnitPrice   float64 `xml:"vUnCom"`
+				Total       float64 `xml:"vProd"`
+				Discount    float64 `xml:"vDesc"`
+			} `xml:"prod"`
+		} `xml:"det"`
+		Total struct {
+			ICMSTot struct {
+				Products   float64 `xml:"vProd"`
+				Discount   float64 `xml:"vDesc"`
+				Freight    float64 `xml:"vFrete"`
+				Other      float64 `xml:"vOutro"`
+				Total      float64 `xml:"vNF"`
+				TotalTaxes float64 `xml:"vTotTrib"`
+			} `xml:"ICMSTot"`
+		} `xml:"total"`
+		Pag struct {
+			DetPag []struct {
+				Code   string  `xml:"tPag"`
+				Amount float64 `xml:"vPag"`
+			} `xml:"detPag"`
+			Change float64 `xml:"vTroco"`
+		} `xml:"pag"`
+		InfAdic struct {
+			Complement string `xml:"infCpl"`
+		} `xml:"infAdic"`
+	} `xml:"infNFe"`
+}
+
+type xmlProtNFe struct {
+	InfProt struct {
+		AccessKey  string `xml:"chNFe"`
+		ReceivedAt string `xml:"dhRecbto"`
+		Number     string `xml:"nProt"`
+		Status     string `xml:"cStat"`
+		Reason     string `xml:"xMotivo"`
+	} `xml:"infProt"`
+}
+
+type xmlProc struct {
+	XMLName xml.Name
+	NFe     *xmlNFe     `xml:"NFe"`
+	ProtNFe *xmlProtNFe `xml:"protNFe"`
+}
+
+// ParseXML lê um XML de NF-e/NFC-e autorizado (raiz nfeProc ou procNFe).
+// Um XML com raiz NFe (sem protocolo) também é aceito; nesse caso Protocol fica nil.
+func ParseXML(r io.Reader) (*Invoice, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler XML: %w", err)
+	}
+
+	var proc xmlProc
+	if err := xml.Unmarshal(content, &proc); err != nil {
+		return nil, fmt.Errorf("XML inválido: %w", err)
+	}
+
+	switch proc.XMLName.Local {
+	case "nfeProc", "procNFe":
+	case "NFe":
+		var nfe xmlNFe
+		if err := xml.Unmarshal(content, &nfe); err != nil {
+			return nil, fmt.Errorf("XML inválido: %w", err)
+		}
+		proc.NFe = &nfe
+	default:
+		return nil, fmt.Errorf("XML não é uma NF-e: elemento raiz '%s' (esperado nfeProc)", proc.XMLName.Local)
+	}
+
+	if proc.NFe == nil || len(proc.NFe.InfNFe.Det) == 0 {
+		return nil, fmt.Errorf("XML não contém itens da nota (infNFe/det)")
+	}
+
+	inf := proc.NFe.InfNFe
+	invoice := &Invoice{
+		AccessKey: strings.TrimPrefix(inf.ID, "NFe"),
+		Model:     inf.Ide.Model,
+		Series:    inf.Ide.Series,
+		Number:    inf.Ide.Number,
+		IssuedAt:  inf.Ide.DhEmi,
+		Emitter: Issuer{
+			CNPJ:      inf.Emit.CNPJ,
+			LegalName: strings.TrimSpace(inf.Emit.Name),
+			TradeName: strings.TrimSpace(inf.Emit.TradeName),
+			Address: Address{
+				Street:     strings.TrimSpace(inf.Emit.Address.Street),
+				Number:     strings.TrimSpace(inf.Emit.Address.Number),
+				Complement: strings.TrimSpace(inf.Emit.Address.Complement),
+				District:   strings.TrimSpace(inf.Emit.Address.District),
+				City:       strings.TrimSpace(inf.Emit.Address.City),
+				UF:         inf.Emit.Address.UF,
+				ZipCode:    inf.Emit.Address.ZipCode,
+			},
+		},
+		Totals: InvoiceTotals{
+			Products:   inf.Total.ICMSTot.Products,
+			Discount:   inf.Total.ICMSTot.Discount,
+			Freight:    inf.Total.ICMSTot.Freight,
+			Other:      inf.Total.ICMSTot.Other,
+			Total:      inf.Total.ICMSTot.Total,
+			TotalTaxes: inf.Total.ICMSTot.TotalTaxes,
+		},
+		Change: inf.Pag.Change,
+		Notes:  inf.InfAdic.Complement,
+	}
+	if invoice.IssuedAt == "" {
+		invoice.IssuedAt = inf.Ide.DEmi
+	}
+
+	for i, det := range inf.Det {
+		itemNumber := det.NItem
+		if itemNumber == 0 {
+			itemNumber = i + 1
+		}
+		ean := strings.TrimSpace(det.Prod.EAN)
+		if strings.EqualFold(ean, "SEM GTIN") {
+			ean = ""
+		}
+		invoice.Items = append(invoice.Items, InvoiceItem{
+			ItemNumber:  itemNumber,
+			Code:        strings.TrimSpace(det.Prod.Code),
+			EAN:         ean,
+			Description: strings.TrimSpace(det.Prod.Description),
+			NCM:         det.Prod.NCM,
+			Quantity:    det.Prod.Quantity,
+			Unit:        strings.TrimSpace(det.Prod.Unit),
+			UnitPrice:   det.Prod.UnitPrice,
+			Total:       det.Prod.Total,
+			Discount:    det.Prod.Discount,
+		})
+	}
+
+	for _, pag := range inf.Pag.DetPag {
+		invoice.Payments = append(invoice.Payments, Payment{
+			Code:   pag.Code,
+			Method: PaymentMethodName(pag.Code),
+			Amount: pag.Amount,
+		})
+	}
+
+	if proc.ProtNFe != nil {
+		prot := proc.ProtNFe.InfProt
+		invoice.Protocol = &Protocol{
+			Number:     prot.Number,
+			Status:     prot.Status,
+			Reason:     prot.Reason,
+			ReceivedAt: prot.ReceivedAt,
+		}
+		if invoice.AccessKey == "" {
+			invoice.AccessKey = prot.AccessKey
+		}
+	}
+
+	return invoice, nil
+}
+
+// StoreName retorna o nome fantasia do emitente ou, na falta dele, a razão social.
+func (inv *Invoice) StoreName() string {
+	if inv.Emitter.TradeName != "" {
+		return inv.Emitter.TradeName
+	}
+	return inv.Emitter.LegalName
+}
+
+// ToData converte a nota lida do XML para o mesmo formato produzido pelo scraping,
+// permitindo reaproveitar o fluxo de preview/confirmação.
+func (inv *Invoice) ToData() *Data {
+	data := &Data{
+		StoreName:  inv.StoreName(),
+		Items:      make([]Item, len(inv.Items)),
+		Subtotal:   inv.Totals.Products,
+		Discount:   inv.Totals.Discount,
+		Freight:    inv.Totals.Freight,
+		Other:      inv.Totals.Other,
+		Total:      inv.Totals.Total,
+		ItemsCount: len(inv.Items),
+		AccessKey:  inv.AccessKey,
+		Number:     inv.Number,
+		Issuer:     inv.Emitter,
+		Payments:   inv.Payments,
+		Change:     inv.Change,
+		Taxes:      ParseTaxes(inv.Notes),
+	}
+	// vTotTrib é o valor oficial; infCpl só complementa com as esferas
+	if inv.Totals.TotalTaxes > 0 {
+		data.Taxes.Total = inv.Totals.TotalTaxes
+	}
+	if len(inv.IssuedAt) >= 10 {
+		data.Date = inv.IssuedAt[:10]
+	}
+
+	for i, item := range inv.Items {
+		data.Items[i] = Item{
+			ItemNumber:  item.ItemNumber,
+			Code:        item.Code,
+			GTIN:        NormalizeGTIN(item.EAN),
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitPrice,
+			Total:       item.Total,
+			Discount:    item.Discount,
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,118 @@
+package nfce
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseXMLFixture(t *testing.T, name string) *Invoice {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("open fixture %s: %v", name, err)
+	}
+	defer f.Close()
+
+	invoice, err := ParseXML(f)
+	if err != nil {
+		t.Fatalf("ParseXML(%s): %v", name, err)
+	}
+	return invoice
+}
+
+func TestParseXMLAuthorizedNFCe(t *testing.T) {
+	inv := parseXMLFixture(t, "nfce_proc.xml")
+
+	if inv.AccessKey != "35240312345678000190650010001234561123456783" {
+		t.Errorf("AccessKey = %q", inv.AccessKey)
+	}
+	if inv.Model != "65" || inv.Series != "1" || inv.Number != "123456" {
+		t.Errorf("ide = mod %s serie %s nNF %s", inv.Model, inv.Series, inv.Number)
+	}
+	if inv.Emitter.CNPJ != "12345678000190" || inv.StoreName() != "Supermercado Paulista" || inv.Emitter.Address.City != "SAO PAULO" {
+		t.Errorf("emitter = %+v", inv.Emitter)
+	}
+	if !inv.Protocol.Authorized() || inv.Protocol.Number != "135240000123456" {
+		t.Errorf("protocol = %+v", inv.Protocol)
+	}
+
+	if len(inv.Items) != 3 {
+		t.Fatalf("got %d items, want 3", len(inv.Items))
+	}
+	arroz := inv.Items[0]
+	if arroz.Code != "000123" || arroz.EAN != "7896006752318" || arroz.NCM != "10063021" || arroz.Unit != "UN" {
+		t.Errorf("item 1 = %+v", arroz)
+	}
+	if !almostEqual(arroz.Quantity, 2) || !almostEqual(arroz.UnitPrice, 24.90) || !almostEqual(arroz.Total, 49.80) || !almostEqual(arroz.Discount, 2.30) {
+		t.Errorf("item 1 values = %+v", arroz)
+	}
+	if inv.Items[1].EAN != "" {
+		t.Errorf("'SEM GTIN' should be normalized to empty, got %q", inv.Items[1].EAN)
+	}
+
+	if !almostEqual(inv.Totals.Products, 84.79) || !almostEqual(inv.Totals.Discount, 4.79) || !almostEqual(inv.Totals.Total, 80.00) || !almostEqual(inv.Totals.TotalTaxes, 18.70) {
+		t.Errorf("totals = %+v", inv.Totals)
+	}
+
+	if len(inv.Payments) != 2 || inv.Payments[0].Method != "PIX" || !almostEqual(inv.Payments[1].Amount, 40) || !almostEqual(inv.Change, 10) {
+		t.Errorf("payments = %+v, change = %.2f", inv.Payments, inv.Change)
+	}
+
+	if taxes := inv.ToData().Taxes; !almostEqual(taxes.Total, 18.70) || !almostEqual(taxes.Federal, 6.10) || !almostEqual(taxes.State, 12.60) || taxes.Municipal != 0 {
+		t.Errorf("taxes = %+v", taxes)
+	}
+
+	data := inv.ToData()
+	if data.Date != "2024-03-15" || data.StoreName != "Supermercado Paulista" || len(data.Items) != 3 || data.Items[2].Code != "000456" {
+		t.Errorf("ToData = %+v", data)
+	}
+	if data.Items[2].GTIN != "07894900011517" || data.Items[1].GTIN != "" {
+		t.Errorf("GTINs = %q/%q, want 07894900011517 and empty", data.Items[2].GTIN, data.Items[1].GTIN)
+	}
+	if !almostEqual(data.Items[0].Discount, 2.30) || data.Items[1].Discount != 0 || !almostEqual(data.Items[2].Discount, 2.49) {
+		t.Errorf("item discounts = %.2f/%.2f/%.2f, want 2.30, 0 and 2.49", data.Items[0].Discount, data.Items[1].Discount, data.Items[2].Discount)
+	}
+}
+
+func TestParseXMLFreightAndOtherCharges(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "nfce_proc.xml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	xml := strings.NewReplacer(
+		"<vFrete>0.00</vFrete>", "<vFrete>10.00</vFrete>",
+		"<vOutro>0.00</vOutro>", "<vOutro>2.50</vOutro>",
+		"<vNF>80.00</vNF>", "<vNF>92.50</vNF>",
+	).Replace(string(raw))
+
+	inv, err := ParseXML(strings.NewReader(xml))
+	if err != nil {
+		t.Fatalf("ParseXML: %v", err)
+	}
+	data := inv.ToData()
+	if !almostEqual(data.Freight, 10.00) || !almostEqual(data.Other, 2.50) || !almostEqual(data.Total, 92.50) {
+		t.Errorf("freight = %.2f, other = %.2f, total = %.2f, want 10.00, 2.50 and 92.50", data.Freight, data.Other, data.Total)
+	}
+}
+
+func TestParseXMLDeniedNFe(t *testing.T) {
+	inv := parseXMLFixture(t, "nfe_denied.xml")
+
+	if inv.Model != "55" || inv.StoreName() != "PADARIA E MERCEARIA MINEIRA LTDA" {
+		t.Errorf("unexpected invoice: %+v", inv)
+	}
+	if inv.Protocol == nil || inv.Protocol.Authorized() || inv.Protocol.Status != "302" {
+		t.Errorf("protocol should be present and not authorized: %+v", inv.Protocol)
+	}
+}
+
+func TestParseXMLRejectsOtherDocuments(t *testing.T) {
+	if _, err := ParseXML(strings.NewReader(`<cteProc><CTe/></cteProc>`)); err == nil {
+		t.Error("expected error for non NF-e XML")
+	}
+	if _, err := ParseXML(strings.NewReader(`not xml`)); err == nil {
+		t.Error("expected error for invalid XML")
+	}
+}
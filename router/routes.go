@@ -15,6 +15,12 @@ func InitializeRoutes(router *gin.Engine) {
 
 	//initialize Handler
 	handler.InitializerHandler()
+	// ⏳ Worker da fila de notas pendentes (portais da SEFAZ fora do ar)
+	handler.StartPendingScanWorker()
+	// 💓 Heartbeat dos jobs de importação desta instância e falha dos interrompidos
+	handler.StartImportJobWatchdog()
+	// 🗑️ Remove definitivamente o que está na lixeira há mais de TRASH_RETENTION_DAYS
+	handler.StartTrashPurgeWorker()
 	basePatch := "/api/v1"
 	docs.SwaggerInfo.BasePath = basePatch
 
@@ -69,12 +75,21 @@ func InitializeRoutes(router *gin.Engine) {
 		protected.GET("/category/:id", handler.GetCategoryHandler)
 		protected.PATCH("/category/:id", handler.UpdateCategoryHandler)
 		protected.DELETE("/category/:id", handler.DeleteCategoryHandler)
+		// 📐 Regras de categorização (aplicadas nas importações antes da IA)
+		protected.GET("/category-rules", handler.ListCategoryRulesHandler)
+		protected.POST("/category-rules", handler.CreateCategoryRuleHandler)
+		protected.POST("/category-rules/test", handler.TestCategoryRuleHandler)    // Testa uma regra contra o histórico
+		protected.POST("/category-rules/apply", handler.ApplyCategoryRulesHandler) // Reaplica as regras aos itens antigos
+		protected.PATCH("/category-rules/:id", handler.UpdateCategoryRuleHandler)
+		protected.DELETE("/category-rules/:id", handler.DeleteCategoryRuleHandler)
 
 		// Rotas de produtos
 		protected.GET("/products", handler.GetProductsHandler)
 		protected.GET("/products/:id", handler.GetProductByIDHandler)
 		protected.PATCH("/products/:id", handler.UpdateProductHandler)
 		protected.DELETE("/products/:id", handler.DeleteProductHandler)
+		// Consulta novamente as notas antigas para preencher código da loja e GTIN dos itens
+		protected.POST("/products/backfill-codes", handler.BackfillProductCodesHandler)
 		// Buscar todos os produtos de uma data específica (YYYY-MM-DD)
 		protected.GET("/products/date/:date", handler.GetProductsByDateHandler)
 		// Buscar todos os produtos dentro de um período (query params: start, end)
@@ -86,7 +101,9 @@ func InitializeRoutes(router *gin.Engine) {
 		protected.GET("/receipts/date/:date", handler.GetReceiptsByDateHandler)
 		protected.GET("/receipts/period", handler.GetReceiptsByPeriodHandler)
 		protected.GET("/receipt/:id", handler.GetReceiptByIDHandler)
+		protected.GET("/receipt/:id/image", handler.GetReceiptImageHandler)
 		protected.PATCH("/receipt/:id", handler.UpdateReceiptHandler)
+		protected.POST("/receipt/:id/items", handler.AddReceiptItemsHandler)
 		protected.DELETE("/receipt/:id", handler.DeleteReceiptHandler)
 
 		// Rotas de recibos básicos (ultra-simplificados para seleção)
@@ -100,6 +117,8 @@ func InitializeRoutes(router *gin.Engine) {
 		protected.GET("/item/:id", handler.GetItemByIDHandler)
 		protected.PATCH("/item/:id", handler.UpdateItemHandler)
 		protected.DELETE("/item/:id", handler.DeleteItemHandler)
+		// Move (ou divide, com quantity) o item para outra nota
+		protected.POST("/item/:id/move", handler.MoveItemHandler)
 		// Buscar itens por período (query params: start, end)
 		protected.GET("/items/period", handler.GetItemsByPeriodHandler)
 		// 🤖 Recategorizar items usando IA
@@ -108,6 +127,41 @@ func InitializeRoutes(router *gin.Engine) {
 		// 🆕 QR Code Flow (2 etapas)
 		protected.POST("/scan-qrcode/preview", handler.ScanQRCodePreviewHandler) // Etapa 1: Preview (não salva)
 		protected.POST("/scan-qrcode/confirm", handler.ScanQRCodeConfirmHandler) // Etapa 2: Confirma e salva
+		// 📸 Preview via foto (notas sem QR Code legível) - confirma em /scan-qrcode/confirm
+		protected.POST("/receipt/scan-image", handler.ScanReceiptImageHandler)
+		// 📄 Preview via XML oficial da NF-e/NFC-e - confirma em /scan-qrcode/confirm
+		protected.POST("/receipt/import-xml", handler.ImportReceiptXMLHandler)
+		// 📦 Importação em lote de QR Codes antigos (sem preview) - acompanhe por /scan-qrcode/batch/:id
+		protected.POST("/scan-qrcode/batch", handler.ScanQRCodeBatchHandler)
+		protected.GET("/scan-qrcode/batch/:id", handler.GetImportBatchHandler)
+		// ⏳ Notas aguardando o portal da SEFAZ voltar (importadas automaticamente)
+		protected.POST("/scan-qrcode/pending", handler.EnqueuePendingScanHandler)
+		protected.GET("/scan-qrcode/pending", handler.GetPendingScansHandler)
+		protected.DELETE("/scan-qrcode/pending/:id", handler.DeletePendingScanHandler)
+
+		// 📋 Acompanhamento das importações (confirmações processadas em background)
+		protected.GET("/imports", handler.GetImportJobsHandler)
+		protected.GET("/imports/:id", handler.GetImportJobHandler)
+		protected.POST("/imports/:id/retry", handler.RetryImportJobHandler)
+
+		// 🗑️ Lixeira (notas e itens excluídos, restauráveis até a remoção definitiva)
+		protected.GET("/trash", handler.GetTrashHandler)
+		protected.POST("/trash/receipts/:id/restore", handler.RestoreReceiptHandler)
+		protected.POST("/trash/items/:id/restore", handler.RestoreItemHandler)
+
+		// 📊 Relatórios
+		protected.GET("/reports/payment-methods", handler.GetPaymentMethodReportHandler)
+		protected.GET("/reports/taxes", handler.GetTaxReportHandler)
+
+		// 💱 Cotações de câmbio (conversão das notas para a moeda base do usuário)
+		protected.GET("/exchange-rates", handler.GetExchangeRatesHandler)
+		protected.POST("/exchange-rates", handler.CreateExchangeRateHandler)
+		protected.POST("/exchange-rates/import", handler.ImportExchangeRatesHandler)
+		protected.DELETE("/exchange-rates/:id", handler.DeleteExchangeRateHandler)
+
+		// 🏪 Lojas (identificadas pelo CNPJ do emitente)
+		protected.GET("/stores", handler.GetStoresHandler)
+		protected.GET("/stores/:id", handler.GetStoreHandler)
 	}
 
 	// Swagger
@@ -0,0 +1,57 @@
+package schemas
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Tipos de regra de categorização (o que Pattern é comparado com o item).
+const (
+	CategoryRuleContains = "contains" // A descrição contém o texto (sem diferenciar maiúsculas e acentos)
+	CategoryRuleRegex    = "regex"    // A descrição casa com a expressão regular (sem diferenciar maiúsculas)
+	CategoryRuleGTIN     = "gtin"     // O código de barras começa com os dígitos (GTIN completo ou prefixo da empresa)
+	CategoryRuleStore    = "store"    // A loja tem o CNPJ (14 dígitos) ou o nome contém o texto
+)
+
+// CategoryRule é uma regra do usuário que define a categoria dos itens importados sem passar pela IA.
+// As regras são avaliadas da maior prioridade para a menor (empate: a mais antiga) e vale a primeira
+// que casar com o item.
+type CategoryRule struct {
+	gorm.Model
+	UserID     uint      `json:"userId" gorm:"not null;index"`                    // Dono da regra
+	MatchType  string    `json:"matchType" gorm:"size:10;not null"`               // contains, regex, gtin ou store
+	Pattern    string    `json:"pattern" gorm:"size:200;not null"`                // Texto, expressão, dígitos do GTIN ou CNPJ/nome da loja
+	CategoryID uint      `json:"categoryId" gorm:"not null;index"`                // Categoria aplicada aos itens
+	Category   *Category `json:"category,omitempty" gorm:"foreignKey:CategoryID"` // Relacionamento
+	Priority   int       `json:"priority" gorm:"not null;default:0"`              // Maior prioridade é avaliada primeiro
+}
+
+// CategoryRuleResponse representa uma regra nas respostas da API.
+type CategoryRuleResponse struct {
+	ID           uint      `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	MatchType    string    `json:"matchType"`
+	Pattern      string    `json:"pattern"`
+	CategoryID   uint      `json:"categoryId"`
+	CategoryName string    `json:"categoryName,omitempty"`
+	Priority     int       `json:"priority"`
+}
+
+// ToResponse converte a regra para o formato da API (com o nome da categoria, se carregada).
+func (r *CategoryRule) ToResponse() CategoryRuleResponse {
+	response := CategoryRuleResponse{
+		ID:         r.ID,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+		MatchType:  r.MatchType,
+		Pattern:    r.Pattern,
+		CategoryID: r.CategoryID,
+		Priority:   r.Priority,
+	}
+	if r.Category != nil {
+		response.CategoryName = r.Category.Name
+	}
+	return response
+}
@@ -0,0 +1,51 @@
+package schemas
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Origens de uma cotação de câmbio.
+const (
+	ExchangeRateSourceFile   = "file"   // Arquivo EXCHANGE_RATES_FILE carregado na inicialização (global)
+	ExchangeRateSourceCSV    = "csv"    // CSV enviado pelo usuário
+	ExchangeRateSourceManual = "manual" // Informada manualmente pelo usuário
+)
+
+// ExchangeRate guarda quanto 1 unidade de FromCurrency vale em ToCurrency em uma data.
+// UserID 0 marca as cotações globais (do arquivo); as do usuário têm precedência sobre elas.
+// Uma cotação vale para as notas da sua data em diante, até a próxima cotação do mesmo par.
+type ExchangeRate struct {
+	gorm.Model
+	UserID       uint      `json:"userId" gorm:"not null;default:0;uniqueIndex:idx_exchange_rates_pair,priority:1"`
+	Date         time.Time `json:"date" gorm:"type:date;not null;uniqueIndex:idx_exchange_rates_pair,priority:2"`
+	FromCurrency string    `json:"fromCurrency" gorm:"size:3;not null;uniqueIndex:idx_exchange_rates_pair,priority:3"`
+	ToCurrency   string    `json:"toCurrency" gorm:"size:3;not null;uniqueIndex:idx_exchange_rates_pair,priority:4"`
+	Rate         float64   `json:"rate" gorm:"type:decimal(18,8);not null"`
+	Source       string    `json:"source" gorm:"size:10;not null"` // file, csv ou manual
+}
+
+// ExchangeRateResponse representa uma cotação nas respostas da API.
+type ExchangeRateResponse struct {
+	ID           uint    `json:"id"`
+	Date         string  `json:"date"` // YYYY-MM-DD
+	FromCurrency string  `json:"fromCurrency"`
+	ToCurrency   string  `json:"toCurrency"`
+	Rate         float64 `json:"rate"`
+	Source       string  `json:"source"`
+	Global       bool    `json:"global"` // Carregada do arquivo do servidor (somente leitura)
+}
+
+// ToResponse converte a cotação para o formato da API.
+func (r *ExchangeRate) ToResponse() ExchangeRateResponse {
+	return ExchangeRateResponse{
+		ID:           r.ID,
+		Date:         r.Date.Format("2006-01-02"),
+		FromCurrency: r.FromCurrency,
+		ToCurrency:   r.ToCurrency,
+		Rate:         r.Rate,
+		Source:       r.Source,
+		Global:       r.UserID == 0,
+	}
+}
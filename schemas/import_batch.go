@@ -0,0 +1,46 @@
+package schemas
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImportBatch agrupa os ImportJobs criados por uma importação em lote de QR Codes.
+// Cada URL enviada vira um job; o andamento do lote é o conjunto dos estados dos jobs.
+type ImportBatch struct {
+	gorm.Model
+	UserID   uint  `json:"userId" gorm:"not null;index"`            // FK para User
+	User     *User `json:"user,omitempty" gorm:"foreignKey:UserID"` // Relacionamento
+	URLCount int   `json:"urlCount" gorm:"not null"`                // URLs enviadas
+}
+
+// ImportBatchResponse representa a resposta da API para um lote de importação.
+type ImportBatchResponse struct {
+	ID        uint                    `json:"id"`
+	CreatedAt time.Time               `json:"createdAt"`
+	URLCount  int                     `json:"urlCount"`
+	Finished  bool                    `json:"finished"` // Todos os jobs chegaram a um estado final
+	Counts    map[ImportJobStatus]int `json:"counts"`   // Quantidade de jobs por status
+	Jobs      []ImportJobResponse     `json:"jobs"`     // Um por URL, na ordem enviada
+}
+
+// ToResponse converte um ImportBatch e seus jobs para um ImportBatchResponse.
+func (b *ImportBatch) ToResponse(jobs []ImportJob) ImportBatchResponse {
+	response := ImportBatchResponse{
+		ID:        b.ID,
+		CreatedAt: b.CreatedAt,
+		URLCount:  b.URLCount,
+		Finished:  true,
+		Counts:    map[ImportJobStatus]int{},
+		Jobs:      make([]ImportJobResponse, len(jobs)),
+	}
+	for i := range jobs {
+		response.Jobs[i] = jobs[i].ToResponse()
+		response.Counts[jobs[i].Status]++
+		if !jobs[i].Status.Finished() {
+			response.Finished = false
+		}
+	}
+	return response
+}
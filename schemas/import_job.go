@@ -0,0 +1,119 @@
+package schemas
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImportJobStatus representa a etapa em que uma importação de nota se encontra.
+type ImportJobStatus string
+
+const (
+	ImportJobQueued       ImportJobStatus = "queued"       // Aguardando processamento
+	ImportJobScraping     ImportJobStatus = "scraping"     // Consultando a NFC-e no portal (importação em lote)
+	ImportJobCategorizing ImportJobStatus = "categorizing" // Itens sendo categorizados pela IA
+	ImportJobSaving       ImportJobStatus = "saving"       // Gravando recibo e itens no banco
+	ImportJobDone         ImportJobStatus = "done"         // Recibo salvo (ReceiptID preenchido)
+	ImportJobFailed       ImportJobStatus = "failed"       // Falhou; Error contém o motivo
+	ImportJobSkipped      ImportJobStatus = "skipped"      // Ignorado por ser duplicado (ReceiptID aponta para o existente, se houver)
+)
+
+// Finished indica se o job chegou a um estado final.
+func (s ImportJobStatus) Finished() bool {
+	return s == ImportJobDone || s == ImportJobFailed || s == ImportJobSkipped
+}
+
+// ImportJob rastreia a confirmação de uma nota (categorização com IA + gravação),
+// que roda em background após a resposta da API.
+type ImportJob struct {
+	gorm.Model
+	UserID     uint            `json:"userId" gorm:"not null;index"`                  // FK para User
+	User       *User           `json:"user,omitempty" gorm:"foreignKey:UserID"`       // Relacionamento
+	Source     string          `json:"source" gorm:"size:20"`                         // Origem: qrcode, image ou xml
+	Status     ImportJobStatus `json:"status" gorm:"size:20;not null;index"`          // Etapa atual
+	StoreName  string          `json:"storeName"`                                     // Loja da nota importada
+	AccessKey  string          `json:"accessKey,omitempty" gorm:"size:44;index"`      // Chave de acesso, quando houver
+	ItemsCount int             `json:"itemsCount"`                                    // Itens ativos enviados
+	Total      float64         `json:"total" gorm:"type:decimal(10,2)"`               // Total da nota
+	ReceiptID  *uint           `json:"receiptId,omitempty" gorm:"index"`              // Recibo criado (status done)
+	Receipt    *Receipt        `json:"receipt,omitempty" gorm:"foreignKey:ReceiptID"` // Relacionamento
+	Error      string          `json:"error,omitempty" gorm:"type:text"`              // Motivo da falha (status failed)
+	Warnings   string          `json:"-" gorm:"type:text"`                            // Avisos da categorização com IA, um por linha
+	Attempts   int             `json:"attempts" gorm:"not null;default:0"`            // Número de execuções
+	Payload    string          `json:"-" gorm:"type:text"`                            // Dados confirmados (JSON) para nova tentativa
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`                           // Início da última execução
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`                          // Fim da última execução
+
+	// Itens sem categoria escolhida pelo usuário: quantos vieram das regras, do histórico e da IA
+	// (só os que receberam uma categoria válida; os que caíram em "Não categorizado" não contam)
+	ResolvedByRules int `json:"resolvedByRules" gorm:"not null;default:0"`
+	ResolvedLocally int `json:"resolvedLocally" gorm:"not null;default:0"`
+	ResolvedByAI    int `json:"resolvedByAI" gorm:"not null;default:0"`
+
+	// Importação em lote: o job nasce só com a URL e o scraping acontece em background
+	BatchID   *uint  `json:"batchId,omitempty" gorm:"index"`
+	SourceURL string `json:"sourceUrl,omitempty" gorm:"type:text"`
+}
+
+// ImportJobResponse representa a resposta da API para um job de importação.
+type ImportJobResponse struct {
+	ID              uint            `json:"id"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+	Source          string          `json:"source"`
+	Status          ImportJobStatus `json:"status"`
+	StoreName       string          `json:"storeName"`
+	AccessKey       string          `json:"accessKey,omitempty"`
+	ItemsCount      int             `json:"itemsCount"`
+	Total           float64         `json:"total"`
+	ReceiptID       *uint           `json:"receiptId,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Warnings        []string        `json:"warnings,omitempty"` // Itens que a IA não categorizou e foram para "Não categorizado"
+	ResolvedByRules int             `json:"resolvedByRules"`    // Itens categorizados pelas regras do usuário
+	ResolvedLocally int             `json:"resolvedLocally"`    // Itens categorizados pelo histórico do usuário
+	ResolvedByAI    int             `json:"resolvedByAI"`       // Itens que a IA categorizou (sem os que foram para "Não categorizado")
+	Attempts        int             `json:"attempts"`
+	StartedAt       *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time      `json:"finishedAt,omitempty"`
+	BatchID         *uint           `json:"batchId,omitempty"`
+	SourceURL       string          `json:"sourceUrl,omitempty"`
+}
+
+// ToResponse converte um ImportJob para um ImportJobResponse.
+func (j *ImportJob) ToResponse() ImportJobResponse {
+	return ImportJobResponse{
+		ID:              j.ID,
+		CreatedAt:       j.CreatedAt,
+		UpdatedAt:       j.UpdatedAt,
+		Source:          j.Source,
+		Status:          j.Status,
+		StoreName:       j.StoreName,
+		AccessKey:       j.AccessKey,
+		ItemsCount:      j.ItemsCount,
+		Total:           j.Total,
+		ReceiptID:       j.ReceiptID,
+		Error:           j.Error,
+		Warnings:        splitLines(j.Warnings),
+		ResolvedByRules: j.ResolvedByRules,
+		ResolvedLocally: j.ResolvedLocally,
+		ResolvedByAI:    j.ResolvedByAI,
+		Attempts:        j.Attempts,
+		StartedAt:       j.StartedAt,
+		FinishedAt:      j.FinishedAt,
+		BatchID:         j.BatchID,
+		SourceURL:       j.SourceURL,
+	}
+}
+
+// splitLines separa um texto com um aviso por linha, ignorando linhas vazias.
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,84 @@
+package schemas
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PendingScanStatus representa a situação de uma nota aguardando o portal da SEFAZ voltar.
+type PendingScanStatus string
+
+const (
+	PendingScanWaiting    PendingScanStatus = "pending"    // Aguardando nova tentativa (NextAttemptAt)
+	PendingScanProcessing PendingScanStatus = "processing" // Reservada por uma instância da API, que está consultando o portal
+	PendingScanImported   PendingScanStatus = "imported"   // Portal respondeu; importação entregue ao ImportJob
+	PendingScanFailed     PendingScanStatus = "failed"     // O portal respondeu, mas a nota não pôde ser lida
+	PendingScanExpired    PendingScanStatus = "expired"    // Passou da idade máxima sem o portal voltar
+)
+
+// PendingScanReason indica por que a nota foi para a fila.
+type PendingScanReason string
+
+const (
+	PendingScanPortalUnavailable PendingScanReason = "portal_unavailable" // Portal fora do ar ou em manutenção
+	PendingScanNotAuthorized     PendingScanReason = "not_authorized"     // Nota ainda não consta na SEFAZ (ex: contingência)
+)
+
+// PendingScan é uma URL de QR Code cuja consulta falhou por indisponibilidade do portal ou
+// porque a nota ainda não foi autorizada (emitida em contingência). Fica em uma fila persistida e é consultada de novo com backoff exponencial até ExpiresAt;
+// quando o portal volta, a nota é importada automaticamente.
+type PendingScan struct {
+	gorm.Model
+	UserID        uint              `json:"userId" gorm:"not null;index"`             // FK para User
+	User          *User             `json:"user,omitempty" gorm:"foreignKey:UserID"`  // Relacionamento
+	URL           string            `json:"url" gorm:"type:text;not null"`            // URL do QR Code
+	AccessKey     string            `json:"accessKey,omitempty" gorm:"size:44;index"` // Chave de acesso da URL, quando houver
+	Status        PendingScanStatus `json:"status" gorm:"size:20;not null;index"`     // Situação atual
+	Attempts      int               `json:"attempts" gorm:"not null;default:0"`       // Consultas já feitas
+	LastError     string            `json:"lastError,omitempty" gorm:"type:text"`     // Erro da última consulta
+	NextAttemptAt time.Time         `json:"nextAttemptAt" gorm:"index"`               // Próxima consulta
+	ExpiresAt     time.Time         `json:"expiresAt"`                                // Idade máxima na fila
+	ImportJobID   *uint             `json:"importJobId,omitempty"`                    // Job criado quando o portal voltou
+
+	// Motivo da espera; notas em contingência (tpEmis 9) levam mais tempo para aparecer
+	Reason      PendingScanReason `json:"reason" gorm:"size:30;not null;default:portal_unavailable"`
+	Contingency bool              `json:"contingency"`
+	NotifiedAt  *time.Time        `json:"notifiedAt,omitempty"` // Quando o usuário foi avisado do resultado
+}
+
+// PendingScanResponse representa a resposta da API para uma nota pendente.
+type PendingScanResponse struct {
+	ID            uint              `json:"id"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	URL           string            `json:"url"`
+	AccessKey     string            `json:"accessKey,omitempty"`
+	Status        PendingScanStatus `json:"status"`
+	Attempts      int               `json:"attempts"`
+	LastError     string            `json:"lastError,omitempty"`
+	NextAttemptAt time.Time         `json:"nextAttemptAt"`
+	ExpiresAt     time.Time         `json:"expiresAt"`
+	ImportJobID   *uint             `json:"importJobId,omitempty"`
+	Reason        PendingScanReason `json:"reason"`
+	Contingency   bool              `json:"contingency"`
+	NotifiedAt    *time.Time        `json:"notifiedAt,omitempty"`
+}
+
+// ToResponse converte um PendingScan para um PendingScanResponse.
+func (p *PendingScan) ToResponse() PendingScanResponse {
+	return PendingScanResponse{
+		ID:            p.ID,
+		CreatedAt:     p.CreatedAt,
+		URL:           p.URL,
+		AccessKey:     p.AccessKey,
+		Status:        p.Status,
+		Attempts:      p.Attempts,
+		LastError:     p.LastError,
+		NextAttemptAt: p.NextAttemptAt,
+		ExpiresAt:     p.ExpiresAt,
+		ImportJobID:   p.ImportJobID,
+		Reason:        p.Reason,
+		Contingency:   p.Contingency,
+		NotifiedAt:    p.NotifiedAt,
+	}
+}
@@ -14,6 +14,19 @@ type Product struct {
 	Name         string        `json:"name" gorm:"not null;index"`    // Nome do produto
 	ReceiptItems []ReceiptItem `json:"-" gorm:"foreignKey:ProductID"` // Relacionamento HasMany com ReceiptItems
 	ListItems    []ListItem    `json:"-" gorm:"foreignKey:ProductID"` // Relacionamento HasMany com ListItems
+
+	// Código de barras (GTIN-14). Identifica o mesmo produto em qualquer loja
+	GTIN  *string       `json:"gtin,omitempty" gorm:"size:14;uniqueIndex"`
+	Codes []ProductCode `json:"-" gorm:"foreignKey:ProductID"` // Códigos internos das lojas
+}
+
+// ProductCode associa o código interno de uma loja (cProd da nota) a um produto do catálogo.
+// Permite reconhecer itens sem GTIN (ex: pesados na balança) nas próximas compras na mesma loja.
+type ProductCode struct {
+	gorm.Model
+	StoreID   uint   `json:"storeId" gorm:"not null;uniqueIndex:idx_product_code_store_code"`      // FK para Store
+	Code      string `json:"code" gorm:"size:60;not null;uniqueIndex:idx_product_code_store_code"` // Código do produto na loja
+	ProductID uint   `json:"productId" gorm:"not null;index"`                                      // FK para Product
 }
 
 // ProductResponse define a estrutura dos dados do produto enviados nas respostas da API.
@@ -23,6 +36,7 @@ type ProductResponse struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 	Unity     string    `json:"unity"`
 	Name      string    `json:"name"`
+	GTIN      string    `json:"gtin,omitempty"`
 }
 
 // ToResponse converte um modelo Product para o formato ProductResponse.
@@ -33,5 +47,14 @@ func (p *Product) ToResponse() ProductResponse {
 		UpdatedAt: p.UpdatedAt,
 		Unity:     p.Unity,
 		Name:      p.Name,
+		GTIN:      p.GTINValue(),
+	}
+}
+
+// GTINValue retorna o código de barras do produto ou "" quando não há.
+func (p *Product) GTINValue() string {
+	if p.GTIN == nil {
+		return ""
 	}
+	return *p.GTIN
 }
@@ -1,8 +1,11 @@
 package schemas
 
 import (
+	"encoding/json"
+	"math"
 	"time"
 
+	"github.com/Pmmvito/Golang-Api-Exemple/nfce"
 	"gorm.io/gorm"
 )
 
@@ -25,26 +28,152 @@ type ReceiptItem struct {
 	Quantity   float64   `json:"quantity" gorm:"type:decimal(10,3);not null"`     // Quantidade ou peso do item
 	UnitPrice  float64   `json:"unitPrice" gorm:"type:decimal(10,2);not null"`    // Preço unitário do item
 	Total      float64   `json:"total" gorm:"type:decimal(10,2);not null"`        // Preço total do item
+
+	// Código do produto na loja, como impresso na nota (cProd)
+	Code string `json:"code,omitempty" gorm:"size:60"`
+
+	// Desconto do item (informado na nota ou rateado do desconto da nota) e valor efetivamente pago
+	Discount float64 `json:"discount" gorm:"type:decimal(10,2);not null;default:0"`
+	NetTotal float64 `json:"netTotal" gorm:"type:decimal(10,2)"` // Total - Discount
+
 	// Campos legados para compatibilidade, a serem removidos no futuro.
 	Description string `json:"description,omitempty" gorm:"-"` // Legado: usar Product.Name
 	Unit        string `json:"unit,omitempty" gorm:"-"`        // Legado: usar Product.Unity
 }
 
+// ApplyDiscount define o desconto do item e recalcula o valor líquido.
+func (item *ReceiptItem) ApplyDiscount(discount float64) {
+	item.Discount = math.Round(discount*100) / 100
+	item.NetTotal = math.Round((item.Total-item.Discount)*100) / 100
+}
+
+// AllocateItemDiscounts rateia o desconto da nota entre os itens que ainda não têm desconto
+// próprio (ver nfce.AllocateDiscount) e atualiza o valor líquido de todos eles.
+func AllocateItemDiscounts(items []ReceiptItem, discount float64) {
+	totals := make([]float64, len(items))
+	discounts := make([]float64, len(items))
+	for i := range items {
+		totals[i], discounts[i] = items[i].Total, items[i].Discount
+	}
+	for i, value := range nfce.AllocateDiscount(totals, discounts, discount) {
+		items[i].ApplyDiscount(value)
+	}
+}
+
 // Receipt representa um recibo escaneado no banco de dados.
 type Receipt struct {
 	gorm.Model
-	UserID      uint          `json:"userId" gorm:"not null;index"`                                  // Chave estrangeira para User
-	User        *User         `json:"user,omitempty" gorm:"foreignKey:UserID"`                       // Relacionamento com User
-	StoreName   string        `json:"storeName"`                                                     // Nome da loja
-	Date        string        `json:"date" gorm:"type:date;index"`                                   // Data da compra (YYYY-MM-DD)
-	Items       []ReceiptItem `json:"items" gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE"` // Relacionamento HasMany com ReceiptItem
-	Subtotal    float64       `json:"subtotal" gorm:"type:decimal(10,2)"`                            // Subtotal do recibo
-	Discount    float64       `json:"discount" gorm:"type:decimal(10,2)"`                            // Valor do desconto
-	Total       float64       `json:"total" gorm:"type:decimal(10,2)"`                               // Total final
-	Currency    string        `json:"currency" gorm:"size:3;default:'BRL'"`                          // Moeda (ex: BRL, USD)
-	Confidence  float64       `json:"confidence" gorm:"type:decimal(3,2)"`                           // Pontuação de confiança da IA (0-1)
-	Notes       string        `json:"notes" gorm:"type:text"`                                        // Notas da IA
-	ImageBase64 string        `json:"-" gorm:"type:text"`                                            // Imagem original em base64
+	// Chave estrangeira para User; junto com AccessKey forma o índice único idx_receipts_user_access_key
+	UserID     uint          `json:"userId" gorm:"not null;index;uniqueIndex:idx_receipts_user_access_key,priority:1"`
+	User       *User         `json:"user,omitempty" gorm:"foreignKey:UserID"`                       // Relacionamento com User
+	StoreName  string        `json:"storeName"`                                                     // Nome da loja
+	StoreID    *uint         `json:"storeId,omitempty" gorm:"index"`                                // Loja (CNPJ do emitente), quando conhecida
+	Store      *Store        `json:"store,omitempty" gorm:"foreignKey:StoreID"`                     // Relacionamento com Store
+	Date       string        `json:"date" gorm:"type:date;index"`                                   // Data da compra (YYYY-MM-DD)
+	Items      []ReceiptItem `json:"items" gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE"` // Relacionamento HasMany com ReceiptItem
+	Subtotal   float64       `json:"subtotal" gorm:"type:decimal(10,2)"`                            // Subtotal do recibo
+	Discount   float64       `json:"discount" gorm:"type:decimal(10,2)"`                            // Valor do desconto
+	Total      float64       `json:"total" gorm:"type:decimal(10,2)"`                               // Total final
+	Change     float64       `json:"change" gorm:"type:decimal(10,2)"`                              // Troco
+	Currency   string        `json:"currency" gorm:"size:3;default:'BRL'"`                          // Moeda (ex: BRL, USD)
+	Confidence float64       `json:"confidence" gorm:"type:decimal(3,2)"`                           // Pontuação de confiança da IA (0-1)
+	Notes      string        `json:"notes" gorm:"type:text"`                                        // Notas da IA
+	QRCodeURL  string        `json:"qrCodeUrl,omitempty" gorm:"type:text"`                          // URL do QR Code da NFC-e
+
+	// Frete e outras despesas acessórias da NF-e (vFrete e vOutro): Total = Subtotal - Discount + Freight + OtherCharges
+	Freight      float64 `json:"freight" gorm:"type:decimal(10,2);not null;default:0"`
+	OtherCharges float64 `json:"otherCharges" gorm:"type:decimal(10,2);not null;default:0"`
+
+	// Fotos originais da nota, guardadas no BlobStore (servidas em GET /receipt/:id/image)
+	Images []ReceiptImage `json:"-" gorm:"foreignKey:ReceiptID"`
+
+	// Metadados fiscais decodificados da chave de acesso (vazios em notas importadas por foto).
+	// AccessKey (44 dígitos) é única por usuário entre os recibos não excluídos.
+	AccessKey    string `json:"accessKey,omitempty" gorm:"size:44;index;uniqueIndex:idx_receipts_user_access_key,priority:2,where:access_key <> '' AND deleted_at IS NULL"`
+	FiscalModel  string `json:"fiscalModel,omitempty" gorm:"size:2"`       // 55 = NF-e, 65 = NFC-e
+	FiscalSeries string `json:"fiscalSeries,omitempty" gorm:"size:3"`      // Série da nota
+	FiscalNumber string `json:"fiscalNumber,omitempty" gorm:"size:9"`      // Número da nota (nNF)
+	IssuerCNPJ   string `json:"issuerCnpj,omitempty" gorm:"size:14;index"` // CNPJ do emitente
+	IssuerUF     string `json:"issuerUf,omitempty" gorm:"size:2"`          // UF do emitente
+	EmissionType string `json:"emissionType,omitempty" gorm:"size:1"`      // Tipo de emissão (1 = normal, 9 = contingência)
+
+	// Código numérico (cNF) e ano/mês de emissão (AAMM) gravados na chave de acesso
+	FiscalCode    string `json:"fiscalCode,omitempty" gorm:"size:8"`
+	EmissionYear  int    `json:"emissionYear,omitempty"`
+	EmissionMonth int    `json:"emissionMonth,omitempty"`
+
+	// Valor aproximado dos tributos informado na nota (Lei 12.741/2012); zero quando ausente
+	TaxTotal     float64 `json:"taxTotal" gorm:"type:decimal(10,2)"`     // Total aproximado dos tributos
+	TaxFederal   float64 `json:"taxFederal" gorm:"type:decimal(10,2)"`   // Parcela federal
+	TaxState     float64 `json:"taxState" gorm:"type:decimal(10,2)"`     // Parcela estadual
+	TaxMunicipal float64 `json:"taxMunicipal" gorm:"type:decimal(10,2)"` // Parcela municipal
+
+	// Formas de pagamento informadas na nota (vazio em notas importadas por foto)
+	Payments []ReceiptPayment `json:"payments" gorm:"foreignKey:ReceiptID;constraint:OnDelete:CASCADE"`
+
+	// Campos do preview alterados pelo usuário na confirmação (lista JSON, ex: ["items[2].deleted"])
+	EditedFields string `json:"-" gorm:"type:text"`
+
+	// Conferência dos valores (itens, subtotal, desconto e total); vazio em recibos anteriores a ela
+	ValidationStatus ReceiptValidationStatus `json:"validationStatus,omitempty" gorm:"size:10;index"`
+	ValidationIssues string                  `json:"-" gorm:"type:text"` // Lista JSON de ValidationIssue
+}
+
+// Charges retorna o frete mais as outras despesas, que entram no total além do subtotal.
+func (r *Receipt) Charges() float64 {
+	return r.Freight + r.OtherCharges
+}
+
+// EditedFieldList decodifica a lista de campos editados pelo usuário na confirmação do preview.
+func (r *Receipt) EditedFieldList() []string {
+	if r.EditedFields == "" {
+		return nil
+	}
+	var fields []string
+	if err := json.Unmarshal([]byte(r.EditedFields), &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// ReceiptTaxes resume os tributos aproximados de um recibo nas respostas da API.
+type ReceiptTaxes struct {
+	Total      float64 `json:"total"`
+	Federal    float64 `json:"federal"`
+	State      float64 `json:"state"`
+	Municipal  float64 `json:"municipal"`
+	Percentage float64 `json:"percentage"` // Parcela do total da nota (%)
+}
+
+// Taxes retorna os tributos aproximados do recibo ou nil quando a nota não os informa.
+func (r *Receipt) Taxes() *ReceiptTaxes {
+	if r.TaxTotal <= 0 {
+		return nil
+	}
+	taxes := &ReceiptTaxes{
+		Total:     r.TaxTotal,
+		Federal:   r.TaxFederal,
+		State:     r.TaxState,
+		Municipal: r.TaxMunicipal,
+	}
+	if r.Total > 0 {
+		taxes.Percentage = math.Round(r.TaxTotal/r.Total*10000) / 100
+	}
+	return taxes
+}
+
+// ApplyAccessKey preenche os metadados fiscais do recibo a partir de uma chave de acesso decodificada.
+func (r *Receipt) ApplyAccessKey(key *nfce.AccessKey) {
+	r.AccessKey = key.Key
+	r.FiscalModel = key.Model
+	r.FiscalSeries = key.Series
+	r.FiscalNumber = key.Number
+	r.IssuerCNPJ = key.CNPJ
+	r.IssuerUF = key.UF
+	r.EmissionType = key.EmissionType
+	r.FiscalCode = key.Code
+	r.EmissionYear = key.Year
+	r.EmissionMonth = key.Month
 }
 
 // ReceiptItemSummary fornece uma visão resumida de um item de recibo, excluindo campos de auditoria.
@@ -54,11 +183,13 @@ type ReceiptItemSummary struct {
 	Category   *CategorySimple `json:"category,omitempty"` // Apenas ID e Nome
 	ProductID  uint            `json:"productId"`
 	Product    *ProductSimple  `json:"product,omitempty"` // Nome e unidade do produto
+	Code       string          `json:"code,omitempty"`    // Código do produto na loja
 	Quantity   float64         `json:"quantity"`
 	UnitPrice  float64         `json:"unitPrice"`
 	Total      float64         `json:"total"`
-	Subtotal   float64         `json:"subtotal"`
-	Discount   float64         `json:"discount"`
+	Subtotal   float64         `json:"subtotal"` // Valor bruto do item (igual a total)
+	Discount   float64         `json:"discount"` // Desconto do item
+	NetTotal   float64         `json:"netTotal"` // Valor pago pelo item
 }
 
 // ProductSimple fornece uma representação leve ede um produto, com apenas nome e unidade.
@@ -66,6 +197,7 @@ type ProductSimple struct {
 	ID    uint   `json:"id"`
 	Name  string `json:"name"`
 	Unity string `json:"unity"`
+	GTIN  string `json:"gtin,omitempty"`
 }
 
 // ReceiptBasic fornece uma versão ultra-simplificada de um recibo para listagens rápidas.
@@ -76,18 +208,35 @@ type ReceiptBasic struct {
 	ItemCount int     `json:"itemCount"` // Número de itens
 	Total     float64 `json:"total"`
 	Currency  string  `json:"currency"`
+
+	BaseCurrency string   `json:"baseCurrency,omitempty"` // Moeda base do usuário
+	BaseTotal    *float64 `json:"baseTotal,omitempty"`    // Total na moeda base (ausente sem cotação para a data)
 }
 
 // ReceiptSummary fornece uma versão leve de um recibo para listagens.
 type ReceiptSummary struct {
-	ID        uint                 `json:"id"`
-	StoreName string               `json:"storeName"`
-	Date      string               `json:"date"`
-	Items     []ReceiptItemSummary `json:"items"`
-	Subtotal  float64              `json:"subtotal"`
-	Discount  float64              `json:"discount"`
-	Total     float64              `json:"total"`
-	Currency  string               `json:"currency"`
+	ID        uint                     `json:"id"`
+	StoreName string                   `json:"storeName"`
+	Date      string                   `json:"date"`
+	Items     []ReceiptItemSummary     `json:"items"`
+	Subtotal  float64                  `json:"subtotal"`
+	Discount  float64                  `json:"discount"`
+	Total     float64                  `json:"total"`
+	Currency  string                   `json:"currency"`
+	Payments  []ReceiptPaymentResponse `json:"payments"`
+	Change    float64                  `json:"change"`
+	Taxes     *ReceiptTaxes            `json:"taxes,omitempty"` // Tributos aproximados (Lei 12.741/2012)
+
+	Freight      float64 `json:"freight,omitempty"`      // Frete (NF-e)
+	OtherCharges float64 `json:"otherCharges,omitempty"` // Outras despesas acessórias (NF-e)
+
+	// Fotos da nota (só em GET /receipt/:id); baixe em GET /receipt/:id/image?index=N
+	Images []ReceiptImageResponse `json:"images,omitempty"`
+
+	Validation *ReceiptValidation `json:"validation,omitempty"` // Conferência dos valores
+
+	BaseCurrency string   `json:"baseCurrency,omitempty"` // Moeda base do usuário
+	BaseTotal    *float64 `json:"baseTotal,omitempty"`    // Total na moeda base (ausente sem cotação para a data)
 }
 
 // ReceiptItemResponse define a estrutura de um item de recibo nas respostas da API, excluindo gorm.Model para o Swagger.
@@ -100,9 +249,12 @@ type ReceiptItemResponse struct {
 	Category   *CategorySimple `json:"category,omitempty"` // Apenas ID e Nome
 	ProductID  uint            `json:"productId"`
 	Product    *ProductSimple  `json:"product,omitempty"` // Nome e unidade do produto
+	Code       string          `json:"code,omitempty"`    // Código do produto na loja
 	Quantity   float64         `json:"quantity"`
 	UnitPrice  float64         `json:"unitPrice"`
 	Total      float64         `json:"total"`
+	Discount   float64         `json:"discount"` // Desconto do item
+	NetTotal   float64         `json:"netTotal"` // Valor pago pelo item
 }
 
 // ReceiptResponse representa a resposta para uma chamada de API de recibo escaneado.
@@ -117,9 +269,33 @@ type ReceiptResponse struct {
 	Subtotal   float64               `json:"subtotal"`
 	Discount   float64               `json:"discount"`
 	Total      float64               `json:"total"`
+	Change     float64               `json:"change"`
 	Currency   string                `json:"currency"`
 	Confidence float64               `json:"confidence"`
 	Notes      string                `json:"notes"`
+
+	Freight      float64 `json:"freight,omitempty"`      // Frete (NF-e)
+	OtherCharges float64 `json:"otherCharges,omitempty"` // Outras despesas acessórias (NF-e)
+
+	StoreID      *uint  `json:"storeId,omitempty"`
+	QRCodeURL    string `json:"qrCodeUrl,omitempty"`
+	AccessKey    string `json:"accessKey,omitempty"`
+	FiscalModel  string `json:"fiscalModel,omitempty"`
+	FiscalSeries string `json:"fiscalSeries,omitempty"`
+	FiscalNumber string `json:"fiscalNumber,omitempty"`
+	IssuerCNPJ   string `json:"issuerCnpj,omitempty"`
+	IssuerUF     string `json:"issuerUf,omitempty"`
+	EmissionType string `json:"emissionType,omitempty"`
+
+	FiscalCode    string `json:"fiscalCode,omitempty"`    // Código numérico da chave (cNF)
+	EmissionYear  int    `json:"emissionYear,omitempty"`  // Ano de emissão gravado na chave
+	EmissionMonth int    `json:"emissionMonth,omitempty"` // Mês de emissão gravado na chave
+
+	Payments []ReceiptPaymentResponse `json:"payments"`
+	Taxes    *ReceiptTaxes            `json:"taxes,omitempty"` // Tributos aproximados (Lei 12.741/2012)
+
+	EditedFields []string           `json:"editedFields,omitempty"` // Ex: items[2].deleted, items[1].description
+	Validation   *ReceiptValidation `json:"validation,omitempty"`   // Conferência dos valores
 }
 
 // ToBasic converte um Receipt para um ReceiptBasic, uma versão ultra-simplificada para listagens rápidas.
@@ -146,8 +322,10 @@ func (r *Receipt) ToSummary() ReceiptSummary {
 			Quantity:   item.Quantity,
 			UnitPrice:  item.UnitPrice,
 			Total:      item.Total,
-			Subtotal:   r.Subtotal, // usar o subtotal do receipt
-			Discount:   r.Discount, // usar o discount do receipt
+			Code:       item.Code,
+			Subtotal:   item.Total,
+			Discount:   item.Discount,
+			NetTotal:   item.NetTotal,
 		}
 
 		// Adiciona categoria se existir (APENAS ID e Nome)
@@ -164,6 +342,7 @@ func (r *Receipt) ToSummary() ReceiptSummary {
 				ID:    item.Product.ID,
 				Name:  item.Product.Name,
 				Unity: item.Product.Unity,
+				GTIN:  item.Product.GTINValue(),
 			}
 		}
 
@@ -179,9 +358,30 @@ func (r *Receipt) ToSummary() ReceiptSummary {
 		Discount:  r.Discount,
 		Total:     r.Total,
 		Currency:  r.Currency,
+		Payments:  paymentResponses(r.Payments),
+		Change:    r.Change,
+		Taxes:     r.Taxes(),
+		Images:    imageResponses(r.Images),
+
+		Freight:      r.Freight,
+		OtherCharges: r.OtherCharges,
+
+		Validation: r.Validation(),
 	}
 }
 
+// imageResponses converte as fotos carregadas do recibo; nil quando não foram carregadas.
+func imageResponses(images []ReceiptImage) []ReceiptImageResponse {
+	if len(images) == 0 {
+		return nil
+	}
+	responses := make([]ReceiptImageResponse, len(images))
+	for i := range images {
+		responses[i] = images[i].ToResponse()
+	}
+	return responses
+}
+
 // ToResponse converte um Receipt para um ReceiptResponse.
 func (r *Receipt) ToResponse() ReceiptResponse {
 	// Converte itens
@@ -197,6 +397,9 @@ func (r *Receipt) ToResponse() ReceiptResponse {
 			Quantity:   item.Quantity,
 			UnitPrice:  item.UnitPrice,
 			Total:      item.Total,
+			Discount:   item.Discount,
+			NetTotal:   item.NetTotal,
+			Code:       item.Code,
 		}
 
 		// Adiciona categoria se existir (APENAS ID e Nome - resposta leve!)
@@ -213,6 +416,7 @@ func (r *Receipt) ToResponse() ReceiptResponse {
 				ID:    item.Product.ID,
 				Name:  item.Product.Name,
 				Unity: item.Product.Unity,
+				GTIN:  item.Product.GTINValue(),
 			}
 		}
 
@@ -230,9 +434,33 @@ func (r *Receipt) ToResponse() ReceiptResponse {
 		Subtotal:   r.Subtotal,
 		Discount:   r.Discount,
 		Total:      r.Total,
+		Change:     r.Change,
 		Currency:   r.Currency,
 		Confidence: r.Confidence,
 		Notes:      r.Notes,
+
+		Freight:      r.Freight,
+		OtherCharges: r.OtherCharges,
+
+		StoreID:      r.StoreID,
+		QRCodeURL:    r.QRCodeURL,
+		AccessKey:    r.AccessKey,
+		FiscalModel:  r.FiscalModel,
+		FiscalSeries: r.FiscalSeries,
+		FiscalNumber: r.FiscalNumber,
+		IssuerCNPJ:   r.IssuerCNPJ,
+		IssuerUF:     r.IssuerUF,
+		EmissionType: r.EmissionType,
+
+		FiscalCode:    r.FiscalCode,
+		EmissionYear:  r.EmissionYear,
+		EmissionMonth: r.EmissionMonth,
+
+		Payments: paymentResponses(r.Payments),
+		Taxes:    r.Taxes(),
+
+		EditedFields: r.EditedFieldList(),
+		Validation:   r.Validation(),
 	}
 }
 
@@ -248,6 +476,9 @@ func (item *ReceiptItem) ToResponse() ReceiptItemResponse {
 		Quantity:   item.Quantity,
 		UnitPrice:  item.UnitPrice,
 		Total:      item.Total,
+		Discount:   item.Discount,
+		NetTotal:   item.NetTotal,
+		Code:       item.Code,
 	}
 
 	// Adiciona categoria se existir (APENAS ID e Nome)
@@ -264,6 +495,7 @@ func (item *ReceiptItem) ToResponse() ReceiptItemResponse {
 			ID:    item.Product.ID,
 			Name:  item.Product.Name,
 			Unity: item.Product.Unity,
+			GTIN:  item.Product.GTINValue(),
 		}
 	}
 
@@ -0,0 +1,26 @@
+package schemas
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReceiptDraft guarda no servidor o resultado de um preview (scraping da NFC-e, XML ou foto).
+// A confirmação referencia o rascunho pelo ID, então preços e totais não passam pelo cliente.
+type ReceiptDraft struct {
+	gorm.Model
+	UserID      uint       `json:"userId" gorm:"not null;index"`            // FK para User
+	User        *User      `json:"user,omitempty" gorm:"foreignKey:UserID"` // Relacionamento
+	Source      string     `json:"source" gorm:"size:20;not null"`          // Origem: qrcode, image ou xml
+	Data        string     `json:"-" gorm:"type:text;not null"`             // Dados do preview (JSON)
+	Confidence  float64    `json:"confidence" gorm:"type:decimal(3,2)"`     // Confiança da extração (1.0 para dados oficiais)
+	ExpiresAt   time.Time  `json:"expiresAt" gorm:"not null;index"`         // Depois disso o rascunho não pode ser confirmado
+	ConsumedAt  *time.Time `json:"consumedAt,omitempty"`                    // Quando foi confirmado
+	ImportJobID *uint      `json:"importJobId,omitempty"`                   // Job criado na confirmação
+}
+
+// Expired indica se o rascunho passou da validade.
+func (d *ReceiptDraft) Expired() bool {
+	return time.Now().After(d.ExpiresAt)
+}
@@ -0,0 +1,50 @@
+package schemas
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReceiptImage é uma foto de nota fiscal guardada no BlobStore. Enquanto o preview não é confirmado
+// a foto pertence ao rascunho (DraftID); na confirmação ela passa para o recibo (ReceiptID).
+type ReceiptImage struct {
+	gorm.Model
+	UserID       uint     `json:"userId" gorm:"not null;index"`                  // Dono da foto
+	ReceiptID    *uint    `json:"receiptId,omitempty" gorm:"index"`              // Recibo, depois da confirmação
+	Receipt      *Receipt `json:"receipt,omitempty" gorm:"foreignKey:ReceiptID"` // Relacionamento
+	DraftID      *uint    `json:"draftId,omitempty" gorm:"index"`                // Rascunho do preview que recebeu a foto
+	Position     int      `json:"position" gorm:"not null;default:0"`            // Ordem da foto na nota (0, 1, ...)
+	StorageKey   string   `json:"-" gorm:"size:255;not null;uniqueIndex"`        // Chave do original no BlobStore
+	ContentType  string   `json:"contentType" gorm:"size:50;not null"`           // Ex: image/jpeg
+	Size         int64    `json:"size"`                                          // Tamanho do original em bytes
+	Width        int      `json:"width"`                                         // Largura do original (0 se não decodificável)
+	Height       int      `json:"height"`                                        // Altura do original (0 se não decodificável)
+	ThumbnailKey string   `json:"-" gorm:"size:255"`                             // Miniatura JPEG; vazio para WEBP/HEIC
+}
+
+// ReceiptImageResponse descreve uma foto da nota nas respostas da API.
+type ReceiptImageResponse struct {
+	ID           uint      `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Position     int       `json:"position"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	HasThumbnail bool      `json:"hasThumbnail"`
+}
+
+// ToResponse converte um ReceiptImage para um ReceiptImageResponse.
+func (i *ReceiptImage) ToResponse() ReceiptImageResponse {
+	return ReceiptImageResponse{
+		ID:           i.ID,
+		CreatedAt:    i.CreatedAt,
+		Position:     i.Position,
+		ContentType:  i.ContentType,
+		Size:         i.Size,
+		Width:        i.Width,
+		Height:       i.Height,
+		HasThumbnail: i.ThumbnailKey != "",
+	}
+}
@@ -0,0 +1,38 @@
+package schemas
+
+import "gorm.io/gorm"
+
+// ReceiptPayment representa uma forma de pagamento informada na nota (cartão, PIX, dinheiro, vale...).
+// Code segue a tabela tPag do leiaute da NF-e (ex: 03 = Cartão de Crédito, 17 = PIX).
+type ReceiptPayment struct {
+	gorm.Model
+	ReceiptID uint    `json:"receiptId" gorm:"not null;index"`  // FK para Receipt
+	Code      string  `json:"code" gorm:"size:2;index"`         // Código tPag
+	Method    string  `json:"method" gorm:"size:60"`            // Descrição da forma de pagamento
+	Amount    float64 `json:"amount" gorm:"type:decimal(10,2)"` // Valor pago (inclui o troco, se houver)
+}
+
+// ReceiptPaymentResponse representa um pagamento nas respostas da API.
+type ReceiptPaymentResponse struct {
+	Code   string  `json:"code"`
+	Method string  `json:"method"`
+	Amount float64 `json:"amount"`
+}
+
+// ToResponse converte um ReceiptPayment para ReceiptPaymentResponse.
+func (p *ReceiptPayment) ToResponse() ReceiptPaymentResponse {
+	return ReceiptPaymentResponse{
+		Code:   p.Code,
+		Method: p.Method,
+		Amount: p.Amount,
+	}
+}
+
+// paymentResponses converte os pagamentos de um recibo para o formato de resposta.
+func paymentResponses(payments []ReceiptPayment) []ReceiptPaymentResponse {
+	responses := make([]ReceiptPaymentResponse, len(payments))
+	for i := range payments {
+		responses[i] = payments[i].ToResponse()
+	}
+	return responses
+}
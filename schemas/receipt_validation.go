@@ -0,0 +1,83 @@
+package schemas
+
+import "encoding/json"
+
+// ReceiptValidationStatus é o resultado da conferência dos valores de um recibo.
+type ReceiptValidationStatus string
+
+const (
+	ReceiptValid   ReceiptValidationStatus = "valid"   // Itens, subtotal, desconto e total batem
+	ReceiptFixed   ReceiptValidationStatus = "fixed"   // Havia valores faltando, derivados automaticamente
+	ReceiptInvalid ReceiptValidationStatus = "invalid" // Há divergências que precisam de revisão
+)
+
+// Códigos das divergências encontradas na conferência.
+const (
+	IssueItemTotalMismatch = "item_total_mismatch" // quantidade × preço unitário ≠ total do item
+	IssueItemTotalMissing  = "item_total_missing"  // Total do item zerado
+	IssueUnitPriceMissing  = "unit_price_missing"  // Preço unitário zerado
+	IssueInvalidQuantity   = "invalid_quantity"    // Quantidade zero ou negativa
+	IssueSubtotalMismatch  = "subtotal_mismatch"   // Soma dos itens ≠ subtotal
+	IssueSubtotalMissing   = "subtotal_missing"    // Subtotal zerado
+	IssueTotalMismatch     = "total_mismatch"      // subtotal - desconto ≠ total
+	IssueTotalMissing      = "total_missing"       // Total zerado
+	IssueDiscountMissing   = "discount_missing"    // Total menor que o subtotal sem desconto informado
+	IssueInvalidDiscount   = "invalid_discount"    // Desconto negativo (ou maior que o total do item)
+
+	IssueItemDiscountMismatch = "item_discount_mismatch" // Soma dos descontos dos itens > desconto da nota
+)
+
+// ValidationIssue é uma divergência encontrada nos valores do recibo.
+type ValidationIssue struct {
+	Code     string  `json:"code"`     // Ex: subtotal_mismatch
+	Field    string  `json:"field"`    // Ex: subtotal, total, items[2].total
+	Message  string  `json:"message"`  // Descrição legível
+	Expected float64 `json:"expected"` // Valor calculado a partir dos outros campos
+	Actual   float64 `json:"actual"`   // Valor informado (antes da correção, se Fixed)
+	Fixed    bool    `json:"fixed"`    // Corrigido automaticamente para Expected
+}
+
+// ReceiptValidation resume a conferência dos valores de um recibo.
+type ReceiptValidation struct {
+	Status ReceiptValidationStatus `json:"status"`
+	Issues []ValidationIssue       `json:"issues"`
+}
+
+// NewReceiptValidation calcula o status a partir das divergências encontradas.
+func NewReceiptValidation(issues []ValidationIssue) ReceiptValidation {
+	status := ReceiptValid
+	for _, issue := range issues {
+		if !issue.Fixed {
+			status = ReceiptInvalid
+			break
+		}
+		status = ReceiptFixed
+	}
+	if issues == nil {
+		issues = []ValidationIssue{}
+	}
+	return ReceiptValidation{Status: status, Issues: issues}
+}
+
+// ApplyValidation grava o resultado da conferência no recibo.
+func (r *Receipt) ApplyValidation(validation ReceiptValidation) {
+	r.ValidationStatus = validation.Status
+	r.ValidationIssues = ""
+	if len(validation.Issues) > 0 {
+		if encoded, err := json.Marshal(validation.Issues); err == nil {
+			r.ValidationIssues = string(encoded)
+		}
+	}
+}
+
+// Validation retorna a conferência gravada no recibo ou nil para recibos anteriores a ela.
+func (r *Receipt) Validation() *ReceiptValidation {
+	if r.ValidationStatus == "" {
+		return nil
+	}
+	validation := ReceiptValidation{Status: r.ValidationStatus, Issues: []ValidationIssue{}}
+	if r.ValidationIssues != "" {
+		_ = json.Unmarshal([]byte(r.ValidationIssues), &validation.Issues)
+	}
+	return &validation
+}
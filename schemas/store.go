@@ -0,0 +1,67 @@
+package schemas
+
+import "gorm.io/gorm"
+
+// Store representa um estabelecimento identificado pelo CNPJ do emitente das notas.
+// É compartilhado entre usuários (assim como Product): os dados vêm do cabeçalho da NFC-e.
+type Store struct {
+	gorm.Model
+	CNPJ       string `json:"cnpj" gorm:"size:14;not null;uniqueIndex"` // CNPJ (apenas dígitos)
+	LegalName  string `json:"legalName"`                                // Razão social
+	TradeName  string `json:"tradeName"`                                // Nome fantasia
+	Street     string `json:"street"`                                   // Logradouro
+	Number     string `json:"number" gorm:"size:20"`                    // Número
+	Complement string `json:"complement"`                               // Complemento
+	District   string `json:"district"`                                 // Bairro
+	City       string `json:"city"`                                     // Município
+	UF         string `json:"uf" gorm:"size:2;index"`                   // Estado
+	ZipCode    string `json:"zipCode" gorm:"size:8"`                    // CEP
+}
+
+// DisplayName retorna o nome fantasia ou, na falta dele, a razão social.
+func (s *Store) DisplayName() string {
+	if s.TradeName != "" {
+		return s.TradeName
+	}
+	return s.LegalName
+}
+
+// StoreResponse representa uma loja nas respostas da API, com as estatísticas do usuário.
+type StoreResponse struct {
+	ID            uint    `json:"id"`
+	CNPJ          string  `json:"cnpj"`
+	Name          string  `json:"name"` // Nome fantasia ou razão social
+	LegalName     string  `json:"legalName"`
+	TradeName     string  `json:"tradeName,omitempty"`
+	Street        string  `json:"street,omitempty"`
+	Number        string  `json:"number,omitempty"`
+	Complement    string  `json:"complement,omitempty"`
+	District      string  `json:"district,omitempty"`
+	City          string  `json:"city,omitempty"`
+	UF            string  `json:"uf,omitempty"`
+	ZipCode       string  `json:"zipCode,omitempty"`
+	VisitCount    int64   `json:"visitCount"`           // Quantidade de notas do usuário na loja
+	TotalSpent    float64 `json:"totalSpent"`           // Soma dos totais das notas, na moeda base do usuário
+	AverageTicket float64 `json:"averageTicket"`        // Gasto médio por visita
+	FirstVisit    string  `json:"firstVisit,omitempty"` // Data da primeira compra (YYYY-MM-DD)
+	LastVisit     string  `json:"lastVisit,omitempty"`  // Data da compra mais recente (YYYY-MM-DD)
+	Currency      string  `json:"currency,omitempty"`   // Moeda de totalSpent e averageTicket
+}
+
+// ToResponse converte uma Store para StoreResponse, sem estatísticas.
+func (s *Store) ToResponse() StoreResponse {
+	return StoreResponse{
+		ID:         s.ID,
+		CNPJ:       s.CNPJ,
+		Name:       s.DisplayName(),
+		LegalName:  s.LegalName,
+		TradeName:  s.TradeName,
+		Street:     s.Street,
+		Number:     s.Number,
+		Complement: s.Complement,
+		District:   s.District,
+		City:       s.City,
+		UF:         s.UF,
+		ZipCode:    s.ZipCode,
+	}
+}
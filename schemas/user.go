@@ -17,6 +17,7 @@ type User struct {
 	ActiveToken   *string        `gorm:"type:text" json:"-"`                            // Token JWT ativo atual (null após logout)
 	Receipts      []Receipt      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Relacionamento HasMany com Receipts
 	ShoppingLists []ShoppingList `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Relacionamento HasMany com ShoppingLists
+	BaseCurrency  string         `gorm:"size:3;not null;default:'BRL'"`                 // Moeda dos relatórios (ISO 4217); notas em outras moedas são convertidas
 }
 
 // UserResponse define a estrutura de dados do usuário para respostas da API, omitindo a senha..
@@ -26,6 +27,8 @@ type UserResponse struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
+
+	BaseCurrency string `json:"baseCurrency"` // Moeda dos relatórios
 }
 
 // HashPassword gera o hash da senha do usuário usando bcrypt.
@@ -58,5 +61,7 @@ func (u *User) ToResponse() UserResponse {
 		UpdatedAt: u.UpdatedAt,
 		Name:      u.Name,
 		Email:     u.Email,
+
+		BaseCurrency: u.BaseCurrency,
 	}
 }
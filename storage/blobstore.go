@@ -0,0 +1,45 @@
+// Package storage guarda arquivos binários (fotos das notas e miniaturas) fora do banco de dados.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNotFound é retornado quando a chave não existe no armazenamento.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobInfo descreve um arquivo armazenado.
+type BlobInfo struct {
+	ContentType string
+	Size        int64
+}
+
+// BlobStore é um armazenamento de arquivos por chave (ex: "receipts/12/3f9a....jpg").
+// As chaves usam "/" como separador e nunca começam com "/".
+type BlobStore interface {
+	// Put grava (ou substitui) o conteúdo da chave.
+	Put(ctx context.Context, key string, content []byte, contentType string) error
+	// Get abre o conteúdo da chave; o chamador deve fechar o reader. Retorna ErrNotFound se não existir.
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	// Delete remove a chave. Remover uma chave inexistente não é erro.
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrInvalidKey é retornado para chaves vazias ou que tentam sair da raiz do armazenamento.
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// cleanKey valida e normaliza uma chave.
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
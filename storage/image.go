@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"mime"
+	"strings"
+)
+
+// StoredImage são as chaves e dimensões de uma imagem gravada por PutImage.
+type StoredImage struct {
+	Key          string
+	ThumbnailKey string // Vazio quando o formato não permite gerar miniatura
+	ContentType  string
+	Size         int64
+	Width        int
+	Height       int
+}
+
+// imageExtensions evita depender da tabela de tipos do sistema para os formatos aceitos.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+	"image/gif":  ".gif",
+}
+
+// PutImage grava a imagem original em "<prefix>/<id>.<ext>" e, quando possível, uma miniatura
+// JPEG em "<prefix>/<id>_thumb.jpg". Se a miniatura falhar, o original é mantido sem ela.
+func PutImage(ctx context.Context, store BlobStore, prefix string, content []byte, contentType string) (*StoredImage, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	name := strings.TrimRight(prefix, "/") + "/" + hex.EncodeToString(id)
+
+	ext := imageExtensions[contentType]
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	image := &StoredImage{Key: name + ext, ContentType: contentType, Size: int64(len(content))}
+	if err := store.Put(ctx, image.Key, content, contentType); err != nil {
+		return nil, err
+	}
+
+	// WEBP/HEIC, imagens gigantes ou arquivo corrompido ficam sem miniatura; o original continua disponível
+	thumb, width, height, err := Thumbnail(content, ThumbnailMaxSize)
+	if err != nil {
+		return image, nil
+	}
+	image.Width, image.Height = width, height
+	thumbKey := name + "_thumb.jpg"
+	if err := store.Put(ctx, thumbKey, thumb, "image/jpeg"); err == nil {
+		image.ThumbnailKey = thumbKey
+	}
+	return image, nil
+}
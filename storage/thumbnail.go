@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// Decodificadores registrados para image.Decode
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ThumbnailMaxSize é o maior lado, em pixels, das miniaturas geradas.
+const ThumbnailMaxSize = 320
+
+// ThumbnailMaxPixels limita a área das imagens decodificadas: um cabeçalho com dimensões
+// enormes alocaria gigabytes em image.Decode antes de qualquer outra verificação.
+const ThumbnailMaxPixels = 50_000_000
+
+// ErrUnsupportedImage é retornado para formatos que não sabemos decodificar (WEBP, HEIC)
+// e para imagens acima de ThumbnailMaxPixels.
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// Thumbnail gera uma miniatura JPEG com o maior lado limitado a maxSize, usando média por área.
+// Também retorna as dimensões da imagem original.
+func Thumbnail(content []byte, maxSize int) (thumb []byte, width, height int, err error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, 0, 0, ErrUnsupportedImage
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > ThumbnailMaxPixels {
+		return nil, 0, 0, ErrUnsupportedImage
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	bounds := src.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, 0, 0, ErrUnsupportedImage
+	}
+
+	dstW, dstH := width, height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			dstW, dstH = maxSize, max(1, height*maxSize/width)
+		} else {
+			dstW, dstH = max(1, width*maxSize/height), maxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*height/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstH)
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*width/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstW)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), width, height, nil
+}
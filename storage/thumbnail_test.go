@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for y := 0; y < 500; y++ {
+		for x := 0; x < 1000; x++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	thumb, width, height, err := Thumbnail(buf.Bytes(), ThumbnailMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 1000 || height != 500 {
+		t.Errorf("original size = %dx%d, want 1000x500", width, height)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if got := decoded.Bounds().Size(); got != image.Pt(320, 160) {
+		t.Errorf("thumbnail size = %v, want 320x160", got)
+	}
+}
+
+func TestThumbnailUnsupported(t *testing.T) {
+	if _, _, _, err := Thumbnail([]byte("RIFF....WEBPVP8 "), ThumbnailMaxSize); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("err = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestThumbnailTooLarge(t *testing.T) {
+	// Cabeçalho GIF de 10000x10000 sem dados: só a verificação das dimensões deve rodar
+	header := []byte("GIF89a\x10\x27\x10\x27\x00\x00\x00")
+	if _, _, _, err := Thumbnail(header, ThumbnailMaxSize); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("err = %v, want ErrUnsupportedImage", err)
+	}
+}